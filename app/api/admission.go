@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/engine"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+)
+
+// admission refuses orders while the task list of the location has more tasks waiting than the workers keep up with
+// Orders are signals, they are accepted by the order workflow no matter how far behind the workers are, so
+// without it a burst keeps piling up work the tavern can not serve
+type admission struct {
+	cfg    config.Admission
+	client engine.Client
+	scope  tally.Scope
+
+	mu       sync.Mutex
+	backlogs map[string]*backlog
+}
+
+// backlog is the last backlog read of a task list
+type backlog struct {
+	tasks      int64
+	checked    time.Time
+	refreshing bool
+}
+
+// newAdmission creates the admission control of the API, a MaxBacklog of 0 admits everything
+func newAdmission(cfg config.Admission, client engine.Client, scope tally.Scope) *admission {
+	return &admission{
+		cfg:      cfg,
+		client:   client,
+		scope:    scope,
+		backlogs: make(map[string]*backlog),
+	}
+}
+
+// admit is false when the backlog of the task list is over the limit
+// The backlog is cached for the CheckInterval, a stale backlog is refreshed in the background while it is still used
+// When the backlog can not be read orders are admitted, the API should not go down with the Cadence frontend
+func (ad *admission) admit(ctx context.Context, taskList string) bool {
+	if ad.cfg.MaxBacklog <= 0 {
+		return true
+	}
+
+	ad.mu.Lock()
+	current, ok := ad.backlogs[taskList]
+	if !ok {
+		// The first order of a task list waits for the backlog
+		ad.mu.Unlock()
+		ad.refresh(ctx, taskList)
+		ad.mu.Lock()
+		current = ad.backlogs[taskList]
+	} else if time.Since(current.checked) > ad.cfg.CheckInterval && !current.refreshing {
+		current.refreshing = true
+		go ad.refresh(context.Background(), taskList)
+	}
+	tasks := current.tasks
+	ad.mu.Unlock()
+
+	if tasks > ad.cfg.MaxBacklog {
+		ad.scope.Tagged(map[string]string{"tasklist": taskList}).Counter("api_admission_rejected").Inc(1)
+		return false
+	}
+	return true
+}
+
+// refresh reads the backlog of the task list from the server
+func (ad *admission) refresh(ctx context.Context, taskList string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	status, err := ad.client.DescribeTaskList(ctx, taskList)
+
+	ad.mu.Lock()
+	defer ad.mu.Unlock()
+	current := ad.backlogs[taskList]
+	if current == nil {
+		current = &backlog{}
+		ad.backlogs[taskList] = current
+	}
+	current.refreshing = false
+	current.checked = time.Now()
+	if err != nil {
+		log.Printf("failed to read the backlog of %s, admitting orders: %v", taskList, err)
+		current.tasks = 0
+		return
+	}
+	current.tasks = status.Backlog
+	ad.scope.Tagged(map[string]string{"tasklist": taskList}).Gauge("api_tasklist_backlog").Update(float64(status.Backlog))
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+)
+
+// audit logs who did what to which resource, used by all routes that change state
+// The actor is the name of the API key of the request
+func audit(r *http.Request, action string, target string) {
+	log.Printf("audit: action=%s target=%s actor=%s remote=%s method=%s path=%s request=%s",
+		action, target, caller(r).Name, r.RemoteAddr, r.Method, r.URL.Path, r.Header.Get(apierror.RequestIDHeader))
+}
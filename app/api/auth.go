@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/config"
+	"strings"
+)
+
+// roleAdmin may start, signal, pause, cancel, terminate and reset workflows and create, change, ban and delete customers
+const roleAdmin = "admin"
+
+// anonymous is the actor of requests without an API key
+const anonymous = "anonymous"
+
+// apiKeyHeader carries the API key for callers that can not set Authorization
+const apiKeyHeader = "X-API-Key"
+
+// principal is the caller of a request
+type principal struct {
+	Name string
+	Role string
+}
+
+type principalKey struct{}
+
+// apiKey is a parsed entry of config.Auth
+type apiKey struct {
+	principal
+	key []byte
+}
+
+// parseKeys reads the name:role:key entries of the configuration
+// Configurations without keys are refused unless they are explicitly insecure
+func parseKeys(cfg config.Auth) ([]apiKey, error) {
+	if len(cfg.Keys) == 0 && !cfg.Insecure {
+		return nil, errors.New("no API keys configured, set TAVERN_AUTH_KEYS or TAVERN_AUTH_INSECURE=true for local development")
+	}
+	var keys []apiKey
+	for i, entry := range cfg.Keys {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("TAVERN_AUTH_KEYS entry %d should be name:role:key", i+1)
+		}
+		keys = append(keys, apiKey{principal: principal{Name: parts[0], Role: parts[1]}, key: []byte(parts[2])})
+	}
+	return keys, nil
+}
+
+// withAuth puts the caller of the API key in the Authorization or X-API-Key header on the request
+// Requests without a key are anonymous, the routes decide if that is enough. A key that is not known is refused
+// Without keys the API runs insecure, every caller is anonymous and has the admin role
+func withAuth(keys []apiKey, next http.Handler) http.Handler {
+	if len(keys) == 0 {
+		log.Println("Running insecure without API keys, every caller is allowed to administrate workflows")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, withPrincipal(r, principal{Name: anonymous, Role: roleAdmin}))
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(apiKeyHeader)
+		if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+			key = strings.TrimPrefix(bearer, "Bearer ")
+		}
+		if key == "" {
+			next.ServeHTTP(w, withPrincipal(r, principal{Name: anonymous}))
+			return
+		}
+		for _, known := range keys {
+			if subtle.ConstantTimeCompare(known.key, []byte(key)) == 1 {
+				next.ServeHTTP(w, withPrincipal(r, known.principal))
+				return
+			}
+		}
+		writeErrorCode(w, apierror.CodeUnauthorized, "unknown API key", http.StatusUnauthorized, nil)
+	})
+}
+
+func withPrincipal(r *http.Request, p principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// caller is the principal of the request, anonymous when it did not pass withAuth
+func caller(r *http.Request) principal {
+	if p, ok := r.Context().Value(principalKey{}).(principal); ok {
+		return p
+	}
+	return principal{Name: anonymous}
+}
+
+// requireRole only passes on requests of callers with the role, others are refused and audited
+func requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := caller(r)
+		if p.Role == role {
+			next(w, r)
+			return
+		}
+		audit(r, "denied", r.URL.Path)
+		if p.Name == anonymous {
+			writeErrorCode(w, apierror.CodeUnauthorized, "an API key with the "+role+" role is needed", http.StatusUnauthorized, nil)
+			return
+		}
+		writeErrorCode(w, apierror.CodeForbidden, p.Name+" does not have the "+role+" role", http.StatusForbidden, nil)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/workflows/orders"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"strings"
+	"testing"
+)
+
+// testKeys are an admin and a member of the staff without the admin role
+var testKeys = []string{"ops:admin:ops-key", "bar:staff:bar-key"}
+
+func TestParseKeysRefusesNoKeys(t *testing.T) {
+	if _, err := parseKeys(config.Auth{}); err == nil {
+		t.Fatal("parseKeys accepted a configuration without keys")
+	}
+	keys, err := parseKeys(config.Auth{Insecure: true})
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("parseKeys returned %v, %v for an insecure configuration, want no keys", keys, err)
+	}
+}
+
+func TestMutatingRoutesNeedAdmin(t *testing.T) {
+	keys, err := parseKeys(config.Auth{Keys: testKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The routes are mounted as in main, the handlers are never reached by callers without the role
+	cc := &CadenceClient{}
+	customers := &CustomerHandler{Repository: customer.NewMemoryCustomers()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/workflows", requireRole(roleAdmin, cc.LaunchWorkflow))
+	mux.HandleFunc("/workflows/start", requireRole(roleAdmin, cc.StartWorkflow))
+	mux.HandleFunc("/workflows/", cc.Workflows)
+	mux.HandleFunc("/tasklists", cc.TaskLists)
+	mux.HandleFunc("/customers/", customers.ServeHTTP)
+	mux.HandleFunc("/customers", customers.ServeCollection)
+	handler := withAuth(keys, mux)
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/workflows"},
+		{http.MethodPost, "/workflows/start"},
+		{http.MethodPost, "/workflows/order-workflow/signal/order"},
+		{http.MethodPost, "/workflows/order-workflow/pause"},
+		{http.MethodPost, "/workflows/order-workflow/resume"},
+		{http.MethodPut, "/tasklists"},
+		{http.MethodPost, "/customers/Percy"},
+		{http.MethodPut, "/customers/Percy"},
+		{http.MethodDelete, "/customers/Percy"},
+		{http.MethodPost, "/customers/Percy/ban"},
+		{http.MethodPost, "/customers/Percy/unban"},
+		{http.MethodPost, "/customers"},
+		{http.MethodPost, "/customers?conflict=overwrite"},
+	}
+	callers := []struct {
+		key  string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"bar-key", http.StatusForbidden},
+	}
+	for _, route := range routes {
+		for _, c := range callers {
+			r := httptest.NewRequest(route.method, route.path, nil)
+			if c.key != "" {
+				r.Header.Set(apiKeyHeader, c.key)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != c.want {
+				t.Errorf("%s %s with key %q answered %d, want %d", route.method, route.path, c.key, w.Code, c.want)
+			}
+		}
+	}
+
+	// Admins get through to the handler, which does not know the customer
+	for _, route := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodDelete, "/customers/Percy"},
+		{http.MethodPost, "/customers/Percy/unban"},
+	} {
+		r := httptest.NewRequest(route.method, route.path, nil)
+		r.Header.Set(apiKeyHeader, "ops-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("admin calling %s %s for an unknown customer answered %d, want %d", route.method, route.path, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestOnlyAdminsOverrideHours(t *testing.T) {
+	keys, err := parseKeys(config.Auth{Keys: testKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := &CadenceClient{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orders/hours/override", requireRole(roleAdmin, cc.OverrideHours))
+	mux.HandleFunc("/workflows/", cc.Workflows)
+	handler := withAuth(keys, mux)
+
+	// Neither the override route nor the generic signal route lets the staff open the tavern
+	body := `{"open":true,"until":"2030-01-01T03:00:00Z","reason":"after hours"}`
+	for _, path := range []string{"/orders/hours/override", "/workflows/" + orders.WorkflowID("") + "/signal/" + orders.SignalOverride} {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set(apiKeyHeader, "bar-key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("staff posting to %s answered %d, want %d", path, w.Code, http.StatusForbidden)
+		}
+	}
+
+	// Admins cannot send it as a generic signal either, it is not in the registry
+	r := httptest.NewRequest(http.MethodPost, "/workflows/"+orders.WorkflowID("")+"/signal/"+orders.SignalOverride, strings.NewReader(body))
+	r.Header.Set(apiKeyHeader, "ops-key")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("admin sending the override as a generic signal answered %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if _, ok := registry.LookupSignal(orders.SignalOverride); ok {
+		t.Errorf("%s is registered as a generic signal", orders.SignalOverride)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"programmingpercy/cadence-tavern/engine"
+	"time"
+)
+
+const (
+	// BatchCancel asks each workflow to cancel so it can clean up
+	BatchCancel = "cancel"
+	// BatchTerminate closes each workflow right away
+	BatchTerminate = "terminate"
+
+	// batchLimit is the default and maximum number of workflows one batch acts on
+	batchLimit = 1000
+	// batchRate is the default number of workflows acted on each second
+	batchRate = 10
+)
+
+// BatchRequest is the body of POST /workflows/batch
+type BatchRequest struct {
+	// Query is a visibility query, such as WorkflowType = 'x' AND CloseTime = missing AND StartTime < '2006-01-02T15:04:05Z'
+	Query  string `json:"query"`
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+	// DryRun only lists the workflows the batch would act on
+	DryRun bool `json:"dry_run"`
+	// Limit is the most workflows acted on, defaults to and is capped at batchLimit
+	Limit int `json:"limit"`
+	// Rate is how many workflows are acted on each second, defaults to batchRate
+	Rate float64 `json:"rate"`
+}
+
+// BatchResult is what a batch did, Failed holds the error of each workflow the action failed for by run ID
+type BatchResult struct {
+	DryRun  bool               `json:"dry_run"`
+	Matched []engine.Execution `json:"matched"`
+	Done    int                `json:"done"`
+	Failed  map[string]string  `json:"failed,omitempty"`
+}
+
+// BatchWorkflows cancels or terminates every workflow matching a visibility query, such as stale greetings
+// Only admins may run it, and every batch that is not a dry run needs a reason
+// Workflows are acted on one at a time at the rate of the request so the Cadence frontend is not flooded
+func (cc *CadenceClient) BatchWorkflows(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Query == "" {
+		writeError(w, "missing query", http.StatusBadRequest)
+		return
+	}
+	if req.Action != BatchCancel && req.Action != BatchTerminate {
+		writeError(w, fmt.Sprintf("action must be %s or %s", BatchCancel, BatchTerminate), http.StatusBadRequest)
+		return
+	}
+	// The reason is audited with every workflow acted on
+	if !req.DryRun && req.Reason == "" {
+		writeError(w, "missing reason", http.StatusBadRequest)
+		return
+	}
+	if req.Limit <= 0 || req.Limit > batchLimit {
+		req.Limit = batchLimit
+	}
+	if req.Rate <= 0 {
+		req.Rate = batchRate
+	}
+
+	executions, err := cc.client.ListWorkflows(r.Context(), req.Query, req.Limit)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result := BatchResult{DryRun: req.DryRun, Matched: executions, Failed: map[string]string{}}
+	audit(r, "batch-"+req.Action, fmt.Sprintf("query=%q matched=%d dry_run=%t reason=%q", req.Query, len(executions), req.DryRun, req.Reason))
+
+	if !req.DryRun {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / req.Rate))
+		defer ticker.Stop()
+		for i, execution := range executions {
+			if i > 0 {
+				select {
+				case <-r.Context().Done():
+					writeError(w, fmt.Sprintf("batch stopped after %d workflows: %v", result.Done+len(result.Failed), r.Context().Err()), http.StatusRequestTimeout)
+					return
+				case <-ticker.C:
+				}
+			}
+
+			if req.Action == BatchCancel {
+				err = cc.client.CancelWorkflow(r.Context(), execution.ID, execution.RunID)
+			} else {
+				err = cc.client.TerminateWorkflow(r.Context(), execution.ID, execution.RunID, req.Reason)
+			}
+			if err != nil {
+				result.Failed[execution.RunID] = err.Error()
+				continue
+			}
+			audit(r, req.Action, fmt.Sprintf("%s run=%s reason=%q", execution.ID, execution.RunID, req.Reason))
+			result.Done++
+		}
+	}
+
+	data, _ := json.Marshal(result)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"programmingpercy/cadence-tavern/httpquery"
+	"programmingpercy/cadence-tavern/location"
+	"time"
+)
+
+// The namespaces of the cached routes, the responses of a location are invalidated together
+const (
+	cacheMenu      = "menu"
+	cacheCustomers = "customers"
+	cacheTabs      = "tabs"
+	cacheTables    = "tables"
+	cacheOrders    = "orders"
+)
+
+// cachedResponse is a response as it is stored in the cache
+type cachedResponse struct {
+	ContentType string `json:"contentType"`
+	Vary        string `json:"vary,omitempty"`
+	// TotalCount and NextCursor are the page of list routes, see httpquery
+	TotalCount string `json:"totalCount,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	Body       []byte `json:"body"`
+}
+
+// responseRecorder keeps a copy of the response written through it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(data)
+	return rr.ResponseWriter.Write(data)
+}
+
+// cached serves GET requests from the cache, other methods always reach next
+// Responses are keyed by the namespace, the location and the URL, only 200 responses are stored
+func (cc *CadenceClient) cached(namespace string, ttl time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || ttl <= 0 {
+			next(w, r)
+			return
+		}
+		loc, err := location.FromRequest(r, cc.cfg.Locations)
+		if err != nil {
+			next(w, r)
+			return
+		}
+		// The format of list routes is negotiated, so the Accept header is part of the key
+		key := cacheKey(namespace, loc) + r.URL.RequestURI() + "|" + r.Header.Get("Accept")
+
+		if data, ok := cc.cache.Get(key); ok {
+			var res cachedResponse
+			if err := json.Unmarshal(data, &res); err == nil {
+				cc.metricsScope.Tagged(map[string]string{"namespace": namespace}).Counter("api_cache_hits").Inc(1)
+				if res.ContentType != "" {
+					w.Header().Set("Content-Type", res.ContentType)
+				}
+				if res.Vary != "" {
+					w.Header().Add("Vary", res.Vary)
+				}
+				if res.TotalCount != "" {
+					w.Header().Set(httpquery.TotalCountHeader, res.TotalCount)
+				}
+				if res.NextCursor != "" {
+					w.Header().Set(httpquery.NextCursorHeader, res.NextCursor)
+				}
+				w.Header().Set("X-Cache", "hit")
+				w.WriteHeader(http.StatusOK)
+				w.Write(res.Body)
+				return
+			}
+		}
+
+		cc.metricsScope.Tagged(map[string]string{"namespace": namespace}).Counter("api_cache_misses").Inc(1)
+		w.Header().Set("X-Cache", "miss")
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+		if recorder.status != http.StatusOK {
+			return
+		}
+		data, _ := json.Marshal(cachedResponse{
+			ContentType: w.Header().Get("Content-Type"),
+			Vary:        varyAccept(w.Header()),
+			TotalCount:  w.Header().Get(httpquery.TotalCountHeader),
+			NextCursor:  w.Header().Get(httpquery.NextCursorHeader),
+			Body:        recorder.body.Bytes(),
+		})
+		cc.cache.Set(key, data, ttl)
+	}
+}
+
+// varyAccept returns Accept if the handler varied the response on it, Accept-Encoding is added by withCompression
+func varyAccept(header http.Header) string {
+	for _, vary := range header.Values("Vary") {
+		if vary == "Accept" {
+			return vary
+		}
+	}
+	return ""
+}
+
+// invalidate drops the cached responses of the namespaces at the location, it is called by the routes changing them
+func (cc *CadenceClient) invalidate(loc string, namespaces ...string) {
+	for _, namespace := range namespaces {
+		cc.cache.Invalidate(cacheKey(namespace, loc))
+	}
+}
+
+// cacheKey is the prefix of the keys of a namespace at a location
+func cacheKey(namespace string, loc string) string {
+	return "api:" + namespace + ":" + loc + ":"
+}
@@ -1,17 +1,41 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/apitypes"
+	"programmingpercy/cadence-tavern/cache"
+	"programmingpercy/cadence-tavern/config"
 	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/payloads"
 	localprom "programmingpercy/cadence-tavern/prometheus"
+	"programmingpercy/cadence-tavern/retry"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/callback"
+	"programmingpercy/cadence-tavern/workflows/greetings"
 	"programmingpercy/cadence-tavern/workflows/orders"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/session"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"programmingpercy/cadence-tavern/workflows/tabs"
+	"strconv"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber-go/tally"
+
 	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
 	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
 	"go.uber.org/yarpc"
 	"go.uber.org/yarpc/transport/grpc"
 	"go.uber.org/zap"
@@ -24,9 +48,12 @@ const (
 )
 
 const (
-	// The names of the Workflows we will be using
-	OrderWorkflow     = "programmingpercy/cadence-tavern/workflows/orders.WorkflowOrder"
-	GreetingsWorkflow = "programmingpercy/cadence-tavern/workflows/greetings.workflowGreetings"
+	// The names of the Workflows we will be using, the worker checks it registered all of them
+	OrderWorkflow     = registry.OrderWorkflow
+	GreetingsWorkflow = registry.GreetingsWorkflow
+	TabWorkflow       = registry.TabWorkflow
+	TableWorkflow     = registry.TableWorkflow
+	SessionWorkflow   = registry.SessionWorkflow
 )
 
 type CadenceClient struct {
@@ -34,35 +61,23 @@ type CadenceClient struct {
 	dispatcher *yarpc.Dispatcher
 	// wfClient is the workflow Client
 	wfClient workflowserviceclient.Interface
-	// client is the client used for cadence
-	client client.Client
-	// orderWorkflowID is used to remember the workflow id
-	orderWorkflowID string
-	// orderWorkflowRunID is the run id of the order workflow
-	orderWorkflowRunID string
+	// client is the client used for the workflow engine
+	client engine.Client
+	// cfg is the configuration the client was set up with
+	cfg config.Config
+	// cache holds the responses of the read heavy routes
+	cache cache.Cache
+	// admission turns orders away while the workers are behind
+	admission *admission
+	// resetKey signs the confirmation tokens of workflow resets
+	resetKey []byte
+	// logger and metricsScope are shared with the retries done outside of requests
+	logger       *zap.Logger
+	metricsScope tally.Scope
 }
 
 // SetupCadenceClient is used to create the client we can use
-func SetupCadenceClient() (*CadenceClient, error) {
-	// Create a dispatcher used to communicate with server
-	dispatcher := yarpc.NewDispatcher(yarpc.Config{
-		Name: cadenceClientName,
-		Outbounds: yarpc.Outbounds{
-			// This shouldnt be hard coded in real app
-			// This is a map, so we store this communication channel on "cadence-frontend"
-			cadenceService: {Unary: grpc.NewTransport().NewSingleOutbound("localhost:7833")},
-		},
-	})
-	// Start dispatcher
-	if err := dispatcher.Start(); err != nil {
-		return nil, err
-	}
-	// Grab the Configurations from the Dispatcher based on cadenceService name
-	yarpConfig := dispatcher.ClientConfig(cadenceService)
-	// Build the workflowserviceClient that handles the workflows
-	wfClient := workflowserviceclient.New(yarpConfig)
-	// clientoptions used to control metrics etc
-
+func SetupCadenceClient(cfg config.Config) (*CadenceClient, error) {
 	config := zap.NewDevelopmentConfig()
 
 	config.Level.SetLevel(zapcore.InfoLevel)
@@ -74,96 +89,449 @@ func SetupCadenceClient() (*CadenceClient, error) {
 	}
 
 	// Start prom scope
-	reporter, err := localprom.NewPrometheusReporter("127.0.0.1:9099", logger)
+	reporter, err := localprom.NewReporter(cfg.MetricsAddress, cfg.Metrics, logger)
 	if err != nil {
 		return nil, err
 	}
 	// use WorkerScope
-	metricsScope := localprom.NewWorkerScope(reporter)
+	metricsScope := localprom.NewWorkerScope(reporter, cfg.Metrics)
+	// Report the calls of the customer repository next to the cadence metrics
+	customer.Metrics = metricsScope
+	customer.Logger = logger
+
+	tracer, _, err := tracing.NewTracer(cadenceClientName, cfg.Tracing)
+	if err != nil {
+		return nil, err
+	}
+	opentracing.SetGlobalTracer(tracer)
+
+	// The worker decodes both encodings, so the API can switch encoding before or after the workers
+	dataConverter, err := payloads.NewDataConverter(cfg.PayloadEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a Cadence server there is nothing to dispatch to, the workflows run in this process
+	if cfg.Standalone {
+		standalone, err := newStandaloneClient(dataConverter, metricsScope, logger)
+		if err != nil {
+			return nil, err
+		}
+		return &CadenceClient{
+			client:       standalone,
+			cfg:          cfg,
+			resetKey:     newResetKey(cfg.ResetSecret),
+			logger:       logger,
+			metricsScope: metricsScope,
+		}, nil
+	}
+
+	// Create a dispatcher used to communicate with server
+	var dispatcher *yarpc.Dispatcher
+	err = retry.NewPolicy(cfg.Startup).Do(context.Background(), metricsScope, logger, "dispatcher", func() error {
+		dispatcher = yarpc.NewDispatcher(yarpc.Config{
+			Name: cadenceClientName,
+			Outbounds: yarpc.Outbounds{
+				// This is a map, so we store this communication channel on "cadence-frontend"
+				cadenceService: {Unary: grpc.NewTransport().NewSingleOutbound(cfg.CadenceHost)},
+			},
+		})
+		// Start dispatcher
+		return dispatcher.Start()
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Grab the Configurations from the Dispatcher based on cadenceService name
+	yarpConfig := dispatcher.ClientConfig(cadenceService)
+	// Build the workflowserviceClient that handles the workflows
+	wfClient := workflowserviceclient.New(yarpConfig)
 
 	opts := &client.Options{
-		MetricsScope: metricsScope,
+		MetricsScope:  metricsScope,
+		Identity:      cfg.Identity.String(cadenceClientName),
+		Tracer:        tracer,
+		DataConverter: dataConverter,
+		ContextPropagators: []workflow.ContextPropagator{
+			tracing.NewPropagator(),
+		},
 	}
 
 	// Build the Cadence Client
-	cadenceClient := client.NewClient(wfClient, "tavern", opts)
+	cadenceClient := client.NewClient(wfClient, cfg.Domain, opts)
 
 	return &CadenceClient{
-		dispatcher: dispatcher,
-		wfClient:   wfClient,
-		client:     cadenceClient,
+		dispatcher:   dispatcher,
+		wfClient:     wfClient,
+		client:       engine.NewClient(cadenceClient, wfClient, cfg.Domain),
+		cfg:          cfg,
+		resetKey:     newResetKey(cfg.ResetSecret),
+		logger:       logger,
+		metricsScope: metricsScope,
 	}, nil
 
 }
 
-// SetOrderWorkflowIds is used to store workflows IDS in Memory
-func (cc *CadenceClient) SetOrderWorkflowIds(id, runID string) {
-	cc.orderWorkflowID = id
-	cc.orderWorkflowRunID = runID
+// greetRequest is the body of a greeting, the customer with an optional callbackUrl
+type greetRequest struct {
+	customer.Customer
+	// CallbackURL is where the greeting is POSTed to once it is done, next to the response
+	CallbackURL string `json:"callbackUrl,omitempty"`
 }
 
 // GreetUser is used to Welcome a new User into the tavern
 func (cc *CadenceClient) GreetUser(w http.ResponseWriter, r *http.Request) {
 	// Grab user info from body
-	var visitor customer.Customer
+	var req greetRequest
 
-	err := json.NewDecoder(r.Body).Decode(&visitor)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	visitor := req.Customer
+	if err := validateCustomer(visitor); err != nil {
+		writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	if req.CallbackURL != "" {
+		if err := callback.Validate(req.CallbackURL, cc.cfg.Callbacks.AllowedHosts); err != nil {
+			writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
 		return
 	}
+	visitor.Location = loc
+	audit(r, "greet", visitor.Name)
 	// Trigger Workflow here
 	log.Print(visitor)
 
+	result, err := cc.greet(r.Context(), loc, visitor)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The greeting recorded a visit, opened a tab and took a seat
+	cc.invalidate(loc, cacheCustomers, cacheTabs, cacheTables)
+	if req.CallbackURL != "" {
+		cc.deliverGreeting(r.Context(), loc, req.CallbackURL, result)
+	}
+
+	data, _ := json.Marshal(result)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// greet runs the greeting of the visitor, opens their tab and seats them, visitor needs to be validated
+func (cc *CadenceClient) greet(ctx context.Context, loc string, visitor customer.Customer) (greetings.GreetingResult, error) {
+	if cc.cfg.Sessions {
+		// The session greets, seats and opens the tab by itself
+		return cc.greetInSession(ctx, loc, visitor)
+	}
+
 	// Create workflow options, this is the same as the CLI, a task list, a timeout timer
-	opts := client.StartWorkflowOptions{
-		TaskList:                     "greetings",
-		ExecutionStartToCloseTimeout: time.Second * 10,
+	// Each tavern has its own task list so the workflows of different locations never mix
+	opts := engine.StartOptions{
+		TaskList:         location.TaskList(cc.cfg.TaskList, loc),
+		ExecutionTimeout: time.Second * 10,
 	}
 
 	log.Println("Starting workflow")
 	// This is how you Execute a Workflow and wait for it to finish
 	// This is useful if you have synchronous workflows that you want to leverage as functions
-	future, err := cc.client.ExecuteWorkflow(r.Context(), opts, GreetingsWorkflow, visitor)
-
+	future, err := cc.client.ExecuteWorkflow(ctx, opts, GreetingsWorkflow, visitor)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return greetings.GreetingResult{}, err
 	}
 	log.Println("Get Result from  workflow")
 	// Fetch result once done and marshal into
-	if err := future.Get(r.Context(), &visitor); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var result greetings.GreetingResult
+	if err := future.Get(ctx, &result); err != nil {
+		return greetings.GreetingResult{}, err
+	}
+
+	// Open a tab for the visitor so orders can be put on it
+	if err := cc.openTab(ctx, loc, result.Customer.Name); err != nil {
+		return result, err
+	}
+	// Keep track of the table the visitor was seated at
+	if result.Table != nil {
+		if err := cc.seat(ctx, loc, *result.Table, result.Customer.Name); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// sessionPollInterval is how often the API looks for the greeting of a session
+const sessionPollInterval = 100 * time.Millisecond
+
+// greetInSession greets the visitor in their session, the session is started by the first greeting of the visit
+// The session answers later greetings of the visit with the first one. The greeting is read with the state query
+// since the session only closes when the tab is settled
+func (cc *CadenceClient) greetInSession(ctx context.Context, loc string, visitor customer.Customer) (greetings.GreetingResult, error) {
+	// Names that only differ in whitespace are one visit, the session seats and opens the tab with the stored name
+	visitor.Name = customer.NormalizeName(visitor.Name)
+	opts := engine.StartOptions{
+		ID:               session.WorkflowID(loc, visitor.Name),
+		TaskList:         location.TaskList(cc.cfg.TaskList, loc),
+		ExecutionTimeout: session.Timeout,
+		AllowDuplicate:   true,
+	}
+	greet, err := signals.Wrap(session.SignalVersion, session.Greet{Customer: visitor})
+	if err != nil {
+		return greetings.GreetingResult{}, err
+	}
+	run, err := cc.client.SignalWithStartWorkflow(ctx, session.SignalGreet, greet, opts, SessionWorkflow, loc, visitor.Name)
+	if err != nil {
+		return greetings.GreetingResult{}, err
+	}
+
+	// The greeting has the same timeout as the greetings workflow started by itself
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	ticker := time.NewTicker(sessionPollInterval)
+	defer ticker.Stop()
+	for {
+		value, err := cc.client.QueryWorkflow(ctx, run.ID(), run.RunID(), session.QueryState)
+		if err != nil {
+			return greetings.GreetingResult{}, err
+		}
+		var state session.State
+		if err := value.Get(&state); err != nil {
+			return greetings.GreetingResult{}, err
+		}
+		if state.Error != "" {
+			return greetings.GreetingResult{}, errors.New(state.Error)
+		}
+		if state.Greeting != nil {
+			return *state.Greeting, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return greetings.GreetingResult{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// openTab starts the tab workflow of a customer at a location, if the tab is already open it is left as is
+func (cc *CadenceClient) openTab(ctx context.Context, loc string, name string) error {
+	opts := engine.StartOptions{
+		ID:               tabs.WorkflowID(loc, name),
+		TaskList:         location.TaskList(cc.cfg.TaskList, loc),
+		ExecutionTimeout: time.Hour * 24,
+	}
+
+	_, err := cc.client.StartWorkflow(ctx, opts, TabWorkflow, loc, name)
+	if engine.IsAlreadyStarted(err) {
+		return nil
+	}
+	return err
+}
+
+// Tab is used to look at the open tab of a customer, ?name= selects the customer
+func (cc *CadenceClient) Tab(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, "missing name", http.StatusBadRequest)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	value, err := cc.client.QueryWorkflow(r.Context(), tabs.WorkflowID(loc, name), "", tabs.QueryBalance)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	data, _ := json.Marshal(visitor)
+	var tab tabs.Tab
+	if err := value.Get(&tab); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(tab)
 	w.WriteHeader(http.StatusOK)
 	w.Write(data)
 }
 
+// SettleTab is used to signal the tab workflow of a customer to pay and close the tab
+func (cc *CadenceClient) SettleTab(w http.ResponseWriter, r *http.Request) {
+	var body apitypes.SettleTab
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	visitor := customer.Customer{Name: body.Name}
+	if err := validateCustomer(visitor); err != nil {
+		writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	settlement := body.Settlement()
+	if err := settlement.Validate(); err != nil {
+		writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	audit(r, "tab.settle", visitor.Name)
+
+	settle, err := signals.Wrap(tabs.SettleSignalVersion, settlement)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = cc.client.SignalWorkflow(r.Context(), tabs.WorkflowID(loc, visitor.Name), "", tabs.SignalSettle, settle)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Signalled tab settlement for ", visitor.Name)
+	cc.invalidate(loc, cacheTabs)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deliverGreeting starts the delivery of the greeting to the callback, the greeting already succeeded so a failure is
+// only logged. The result is only known to the API, so it starts the delivery workflow instead of the greeting
+func (cc *CadenceClient) deliverGreeting(ctx context.Context, loc string, callbackURL string, result greetings.GreetingResult) {
+	opts := engine.StartOptions{
+		ID:               location.WorkflowID(loc, "callback-greeting-"+newOrderID()),
+		TaskList:         location.TaskList(cc.cfg.TaskList, loc),
+		ExecutionTimeout: 2 * time.Hour,
+	}
+	payload := callback.Payload{
+		Type:       callback.TypeGreeting,
+		ID:         result.Customer.Name,
+		Status:     orderstore.EventCompleted,
+		Result:     result,
+		OccurredAt: time.Now(),
+	}
+	if _, err := cc.client.StartWorkflow(ctx, opts, registry.CallbackWorkflow, callbackURL, payload); err != nil {
+		log.Printf("Failed to start the greeting callback of %s: %v", result.Customer.Name, err)
+	}
+}
+
 // Order is used to send a signal to the worker
 func (cc *CadenceClient) Order(w http.ResponseWriter, r *http.Request) {
-	// Grab order info from body
-	var orderInfo orders.Order
+	// Grab order info from body, the body is mapped to the order of the workflow so the two can change apart
+	var req apitypes.Order
 
-	err := json.NewDecoder(r.Body).Decode(&orderInfo)
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	orderInfo := req.WorkflowOrder()
+	if err := validateOrder(orderInfo); err != nil {
+		writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	if orderInfo.CallbackURL != "" {
+		if err := callback.Validate(orderInfo.CallbackURL, cc.cfg.Callbacks.AllowedHosts); err != nil {
+			writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+	}
+	if orderInfo.ID == "" {
+		orderInfo.ID = newOrderID()
+	}
+	orderInfo.Location, err = location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	// Orders made with a loyalty card are grouped and limited by the name of the customer like any other order
+	if orderInfo.LoyaltyID != "" {
+		cust, err := customer.Database.GetByLoyaltyID(r.Context(), orderInfo.Location, orderInfo.LoyaltyID)
+		if err != nil {
+			writeRepositoryError(w, err)
+			return
+		}
+		if orderInfo.By != "" && !customer.SameName(orderInfo.By, cust.Name) {
+			writeErrorCode(w, apierror.CodeValidation, "loyaltyId belongs to another customer than by", http.StatusBadRequest, nil)
+			return
+		}
+		orderInfo.By = cust.Name
+	}
+	if !cc.admission.admit(r.Context(), location.TaskList(cc.cfg.TaskList, orderInfo.Location)) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(cc.cfg.Admission.RetryAfter.Seconds()))))
+		writeErrorCode(w, apierror.CodeOverloaded, "the bar is too busy to take orders, try again later", http.StatusTooManyRequests, nil)
+		return
+	}
+	audit(r, "order", orderInfo.ID)
+
+	// Each order gets its own trace, it is carried in the signal since signals have no headers
+	// Callers such as the tavernclient can send their trace along, the order continues it
+	span := tracing.StartHTTPSpan(r, "order", opentracing.Tags{
+		"order.id": orderInfo.ID,
+		"item":     orderInfo.Item,
+		"customer": orderInfo.By,
+	})
+	defer span.Finish()
+	orderInfo.Trace = tracing.Inject(opentracing.GlobalTracer(), span)
 
 	log.Print(orderInfo)
 	// Send a signal to the Workflow
-	// We need to provide a Workflow ID, the RUN ID of the workflow, and the Signal type
-	err = cc.client.SignalWorkflow(r.Context(), cc.orderWorkflowID, cc.orderWorkflowRunID, "order", orderInfo)
+	// SignalWithStart delivers to the current run, and starts a new run if the order workflow is closed
+	signal, err := orders.NewOrderSignal(orderInfo)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	opts := cc.orderWorkflowOptions(orderInfo.Location)
+	// The order is indexed before it is signalled, so the status the workflow records is never overwritten by it
+	// An order missing from the index can still be followed through its events
+	now := time.Now()
+	err = orderstore.Index.PutOrder(r.Context(), orderstore.IndexEntry{
+		OrderID:    orderInfo.ID,
+		Location:   orderInfo.Location,
+		WorkflowID: opts.ID,
+		Item:       orderInfo.Item,
+		By:         orderInfo.By,
+		Status:     orderstore.EventReceived,
+		PlacedAt:   now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		log.Printf("Failed to index order %s: %v", orderInfo.ID, err)
+	}
+	run, err := cc.client.SignalWithStartWorkflow(r.Context(), orders.SignalOrder, signal, opts, OrderWorkflow)
+	if err != nil {
+		tracing.ForceSample(span)
+		if err := orderstore.Index.UpdateOrderStatus(r.Context(), orderInfo.ID, orderstore.EventFailed, time.Now()); err != nil && !errors.Is(err, orderstore.ErrNoSuchOrder) {
+			log.Printf("Failed to fail indexed order %s: %v", orderInfo.ID, err)
+		}
+		writeError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	log.Println("Signalled system of order")
+	if err := orderstore.Index.UpdateOrderRun(r.Context(), orderInfo.ID, run.RunID()); err != nil && !errors.Is(err, orderstore.ErrNoSuchOrder) {
+		log.Printf("Failed to index the run of order %s: %v", orderInfo.ID, err)
+	}
+	cc.invalidate(orderInfo.Location, cacheTabs, cacheOrders)
 
+	// Return the order ID so the caller can follow the order at /orders/{id}
+	data, _ := json.Marshal(map[string]string{"id": orderInfo.ID})
 	w.WriteHeader(http.StatusOK)
+	w.Write(data)
 
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"programmingpercy/cadence-tavern/config"
+	"strconv"
+	"strings"
+)
+
+// withCORS answers preflight requests and adds the CORS headers for the allowed origins
+// Requests from other origins are served without the headers, so the browser keeps the response from the script
+func withCORS(cfg config.CORS, next http.Handler) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if allowedOrigin(cfg.AllowedOrigins, origin) {
+			// * can not be used together with credentials
+			if containsString(cfg.AllowedOrigins, "*") && !cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if preflight {
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				if cfg.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+				}
+			} else if exposed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposed)
+			}
+		}
+
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin is true when the origin is listed or * is
+func allowedOrigin(allowed []string, origin string) bool {
+	return containsString(allowed, "*") || containsString(allowed, origin)
+}
+
+// containsString is true when s is one of values
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/httpquery"
+	"programmingpercy/cadence-tavern/location"
+	"strings"
+)
+
+// CustomerHandler exposes the customer repository for administrative management
+// It works directly on the repository and does not run any workflows
+// Requests only reach the customers of the location they are sent to
+type CustomerHandler struct {
+	Repository customer.Repository
+	// Locations are the locations served besides the default location
+	Locations []string
+	// OnChange is called with the location after customers are changed, it can be nil
+	OnChange func(loc string)
+}
+
+// ServeHTTP routes /customers/{name} to the handler for the method, deleting a customer is admin only
+// POST /customers/{name}/ban and /customers/{name}/unban changes the ban of the customer
+func (ch *CustomerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/customers/"), "/")
+	name := parts[0]
+	if name == "" || len(parts) > 2 {
+		writeError(w, "expected /customers/{name}", http.StatusNotFound)
+		return
+	}
+	loc, err := location.FromRequest(r, ch.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	if len(parts) == 2 {
+		if r.Method != http.MethodPost {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch parts[1] {
+		case "ban":
+			requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+				ch.setBanned(w, r, loc, name, true)
+			})(w, r)
+		case "unban":
+			requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+				ch.setBanned(w, r, loc, name, false)
+			})(w, r)
+		default:
+			writeError(w, "no such action", http.StatusNotFound)
+		}
+		return
+	}
+
+	// Only admins change customers, everyone may look them up
+	switch r.Method {
+	case http.MethodGet:
+		ch.get(w, r, loc, name)
+	case http.MethodPost:
+		requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			ch.create(w, r, loc, name)
+		})(w, r)
+	case http.MethodPut:
+		requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			ch.update(w, r, loc, name)
+		})(w, r)
+	case http.MethodDelete:
+		requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			ch.delete(w, r, loc, name)
+		})(w, r)
+	default:
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// get returns the stored customer
+func (ch *CustomerHandler) get(w http.ResponseWriter, r *http.Request, loc, name string) {
+	cust, err := ch.Repository.Get(r.Context(), loc, name)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	data, _ := json.Marshal(cust)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// create stores a new customer, it fails if the customer already exists
+func (ch *CustomerHandler) create(w http.ResponseWriter, r *http.Request, loc, name string) {
+	cust, ok := decodeCustomer(w, r, loc, name)
+	if !ok {
+		return
+	}
+
+	if _, err := ch.Repository.Get(r.Context(), loc, name); err == nil {
+		writeError(w, "customer already exists", http.StatusConflict)
+		return
+	}
+	if err := ch.Repository.Update(r.Context(), cust); err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	audit(r, "customer.create", name)
+	ch.changed(loc)
+
+	data, _ := json.Marshal(cust)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
+
+// update overrides an existing customer
+func (ch *CustomerHandler) update(w http.ResponseWriter, r *http.Request, loc, name string) {
+	cust, ok := decodeCustomer(w, r, loc, name)
+	if !ok {
+		return
+	}
+
+	if _, err := ch.Repository.Get(r.Context(), loc, name); err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	if err := ch.Repository.Update(r.Context(), cust); err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	audit(r, "customer.update", name)
+	ch.changed(loc)
+
+	data, _ := json.Marshal(cust)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// delete removes the customer
+func (ch *CustomerHandler) delete(w http.ResponseWriter, r *http.Request, loc, name string) {
+	if err := ch.Repository.Delete(r.Context(), loc, name); err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	audit(r, "customer.delete", name)
+	ch.changed(loc)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setBanned bans or unbans the customer
+func (ch *CustomerHandler) setBanned(w http.ResponseWriter, r *http.Request, loc, name string, banned bool) {
+	cust, err := ch.Repository.Get(r.Context(), loc, name)
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	cust.Banned = banned
+	if err := ch.Repository.Update(r.Context(), cust); err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+	if banned {
+		audit(r, "customer.ban", name)
+	} else {
+		audit(r, "customer.unban", name)
+	}
+	ch.changed(loc)
+
+	data, _ := json.Marshal(cust)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// changed calls OnChange
+func (ch *CustomerHandler) changed(loc string) {
+	if ch.OnChange != nil {
+		ch.OnChange(loc)
+	}
+}
+
+// customerListOptions are the parameters of GET /customers
+var customerListOptions = httpquery.Options{
+	DefaultSort: "name",
+	Sortable:    []string{"name", "age", "timesVisited", "lastVisit"},
+	Filterable:  []string{"name", "loyalty", "loyaltyId", "banned"},
+}
+
+// customerField is the value of a field of GET /customers
+func customerField(cust customer.Customer, field string) interface{} {
+	switch field {
+	case "name":
+		return cust.Name
+	case "age":
+		return cust.Age
+	case "timesVisited":
+		return cust.TimesVisited
+	case "lastVisit":
+		return cust.LastVisit
+	case "loyalty":
+		return cust.Loyalty()
+	case "loyaltyId":
+		return cust.LoyaltyID
+	case "banned":
+		return cust.Banned
+	}
+	return nil
+}
+
+// decodeCustomer reads and validates the customer in the body, the name is always taken from the path
+// and the location from the request
+func decodeCustomer(w http.ResponseWriter, r *http.Request, loc, name string) (customer.Customer, bool) {
+	var cust customer.Customer
+	if err := json.NewDecoder(r.Body).Decode(&cust); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return customer.Customer{}, false
+	}
+	cust.Name = name
+	cust.Location = loc
+	// Deleting is only done through DELETE
+	cust.DeletedAt = nil
+
+	if err := validateCustomer(cust); err != nil {
+		writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+		return customer.Customer{}, false
+	}
+	return cust, true
+}
+
+// writeRepositoryError maps repository errors to status codes
+func writeRepositoryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, customer.ErrNoSuchCustomer) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, customer.ErrLoyaltyIDTaken) {
+		writeError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeError(w, err.Error(), http.StatusInternalServerError)
+}
+
+// ServeCollection handles /customers, GET exports the customers and POST imports customers, only admins may import
+// GET returns every customer unless ?limit= is given, ?sort= and the filters are in customerListOptions, see httpquery
+// ?format= is json or csv, without it the Accept header picks the export and the Content-Type the import
+// ?conflict= is skip, overwrite or merge
+// Both only work on the customers of the location of the request
+func (ch *CustomerHandler) ServeCollection(w http.ResponseWriter, r *http.Request) {
+	loc, err := location.FromRequest(r, ch.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		format, ok := negotiateFormat(w, r, formatJSON, formatCSV)
+		if !ok {
+			return
+		}
+		query, err := httpquery.Parse(r.URL.Query(), customerListOptions)
+		if err != nil {
+			writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+		customers, err := ch.Repository.List(r.Context(), loc)
+		if err != nil {
+			writeRepositoryError(w, err)
+			return
+		}
+		indexes, page := query.Apply(len(customers), func(i int, field string) interface{} {
+			return customerField(customers[i], field)
+		})
+		paged := make([]customer.Customer, len(indexes))
+		for i, index := range indexes {
+			paged[i] = customers[index]
+		}
+
+		w.Header().Set("Content-Type", mediaTypes[format])
+		httpquery.WriteHeaders(w, page)
+		if err := customer.Encode(w, format, paged); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			ch.importCustomers(w, r, loc)
+		})(w, r)
+	default:
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// importCustomers imports the customers of the body into the location, only admins may import
+func (ch *CustomerHandler) importCustomers(w http.ResponseWriter, r *http.Request, loc string) {
+	customers, err := customer.Decode(r.Body, requestFormat(r))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, cust := range customers {
+		if err := validateCustomer(cust); err != nil {
+			writeErrorCode(w, apierror.CodeValidation, cust.Name+": "+err.Error(), http.StatusBadRequest, nil)
+			return
+		}
+	}
+
+	mode := customer.ConflictMode(r.URL.Query().Get("conflict"))
+	if mode == "" {
+		mode = customer.ConflictSkip
+	}
+	result, err := customer.Import(r.Context(), ch.Repository, loc, customers, mode)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	audit(r, "customer.import", string(mode))
+	ch.changed(loc)
+
+	data, _ := json.Marshal(result)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
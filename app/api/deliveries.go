@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"programmingpercy/cadence-tavern/workflows/stock"
+	"strconv"
+	"strings"
+)
+
+// Deliveries serves the deliveries of the supplier orders of a location
+//
+//	GET /deliveries/{reference}
+//	POST /deliveries/{reference}/status, signed by the supplier
+//
+// The reference is the one the supplier answered when the order was placed, the status repeats it in its signed body
+func (cc *CadenceClient) Deliveries(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/deliveries/")
+	if reference := strings.TrimSuffix(path, "/status"); reference != path && reference != "" {
+		withSignature(cc.cfg.Deliveries.Secrets, cc.cfg.Deliveries.SignatureTolerance, cc.cfg.Deliveries.Insecure, func(w http.ResponseWriter, r *http.Request) {
+			cc.deliveryStatus(w, r, reference)
+		})(w, r)
+		return
+	}
+	if path == "" || strings.Contains(path, "/") {
+		writeError(w, "expected /deliveries/{reference}", http.StatusNotFound)
+		return
+	}
+	cc.delivery(w, r, path)
+}
+
+// delivery answers the state of the delivery
+func (cc *CadenceClient) delivery(w http.ResponseWriter, r *http.Request, reference string) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	value, err := cc.client.QueryWorkflow(r.Context(), stock.DeliveryWorkflowID(loc, reference), "", stock.QueryStatus)
+	if engine.IsNotFound(err) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var state stock.DeliveryState
+	if err := value.Get(&state); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(state)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// deliveryStatus is called by the supplier when the status of the delivery changes, it signals the delivery workflow
+func (cc *CadenceClient) deliveryStatus(w http.ResponseWriter, r *http.Request, reference string) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var status stock.DeliveryStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := status.Validate(); err != nil {
+		writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	// Only the body is signed, a status signed for another delivery is a replay
+	if status.Reference != reference {
+		writeErrorCode(w, apierror.CodeUnauthorized, "the status is signed for delivery "+strconv.Quote(status.Reference), http.StatusUnauthorized, nil)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	envelope, err := signals.Wrap(stock.SignalVersion, status)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = cc.client.SignalWorkflow(r.Context(), stock.DeliveryWorkflowID(loc, reference), "", stock.SignalStatus, envelope)
+	if engine.IsNotFound(err) {
+		// The delivery was already stocked in or was never confirmed
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(r, "delivery."+status.Status, reference)
+	w.WriteHeader(http.StatusOK)
+}
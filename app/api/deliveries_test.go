@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/workflows/callback"
+	"programmingpercy/cadence-tavern/workflows/stock"
+	"testing"
+	"time"
+)
+
+// signalRecorder records the workflows signalled, the other calls of the client are not used by the tests
+type signalRecorder struct {
+	engine.Client
+	signalled []string
+}
+
+func (sr *signalRecorder) SignalWorkflow(ctx context.Context, id, runID, signal string, arg interface{}) error {
+	sr.signalled = append(sr.signalled, id)
+	return nil
+}
+
+func TestDeliveryStatusIsOnlyTakenForItsReference(t *testing.T) {
+	secret := "supplier-secret"
+	client := &signalRecorder{}
+	cc := &CadenceClient{
+		client: client,
+		cfg:    config.Config{Deliveries: config.Deliveries{Secrets: []string{secret}, SignatureTolerance: time.Minute}},
+	}
+	post := func(path string, body string) int {
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		r.Header.Set(callback.SignatureHeader, callback.Sign([]byte(secret), time.Now().Unix(), []byte(body)))
+		w := httptest.NewRecorder()
+		cc.Deliveries(w, r)
+		return w.Code
+	}
+
+	// A status captured for delivery-1 is replayed against delivery-2, or was signed without a reference
+	if code := post("/deliveries/delivery-2/status", `{"reference":"delivery-1","status":"delivered"}`); code != http.StatusUnauthorized {
+		t.Errorf("replayed status answered %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := post("/deliveries/delivery-2/status", `{"status":"delivered"}`); code != http.StatusUnauthorized {
+		t.Errorf("status without a reference answered %d, want %d", code, http.StatusUnauthorized)
+	}
+	if len(client.signalled) != 0 {
+		t.Fatalf("signalled %v for statuses of other deliveries", client.signalled)
+	}
+
+	if code := post("/deliveries/delivery-2/status", `{"reference":"delivery-2","status":"delivered"}`); code != http.StatusOK {
+		t.Fatalf("status of delivery-2 answered %d, want %d", code, http.StatusOK)
+	}
+	if want := stock.DeliveryWorkflowID("", "delivery-2"); len(client.signalled) != 1 || client.signalled[0] != want {
+		t.Fatalf("signalled %v, want %s", client.signalled, want)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/equipment"
+	"programmingpercy/cadence-tavern/workflows/pouring"
+	"time"
+)
+
+// EquipmentDone is called by the bar equipment once a pour is done, it completes the pour activity of the order
+func (cc *CadenceClient) EquipmentDone(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var done equipment.Done
+	if err := json.NewDecoder(r.Body).Decode(&done); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pour, err := equipment.Pours.Take(r.Context(), done.ID)
+	if errors.Is(err, equipment.ErrNoSuchPour) {
+		// The pour timed out or was already reported
+		writeError(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var pourErr error
+	if done.Error != "" {
+		pourErr = engine.NewApplicationError(pouring.ErrReasonFailed, pour.Machine, done.Error)
+	}
+	result := equipment.Result{Machine: pour.Machine, PouredAt: time.Now()}
+	if err := cc.client.CompleteActivity(r.Context(), pour.Token, result, pourErr); err != nil && !engine.IsNotFound(err) {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(r, "pour-done", pour.OrderID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// reconcilePours fails the pours the equipment never reported back on every interval until ctx is done
+// The activity would time out by itself, failing it right away stops the round from retrying the pour
+func (cc *CadenceClient) reconcilePours(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cc.failExpiredPours(ctx); err != nil {
+				log.Println("Failed to reconcile pours: ", err)
+			}
+		}
+	}
+}
+
+// failExpiredPours completes every pour past its deadline with ErrReasonTimeout
+func (cc *CadenceClient) failExpiredPours(ctx context.Context) error {
+	expired, err := equipment.Pours.Expired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, pour := range expired {
+		if _, err := equipment.Pours.Take(ctx, pour.ID); err != nil {
+			// Reported while we were looking
+			continue
+		}
+		err := cc.client.CompleteActivity(ctx, pour.Token, nil, engine.NewApplicationError(pouring.ErrReasonTimeout, pour.Machine))
+		// Activities that already timed out are gone, nothing is left to fail
+		if err != nil && !engine.IsNotFound(err) {
+			return err
+		}
+		log.Printf("Failed pour %s of order %s, the %s never reported back", pour.ID, pour.OrderID, pour.Machine)
+	}
+	return nil
+}
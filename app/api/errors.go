@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+)
+
+// writeError responds with the error envelope, the code is picked from the status
+func writeError(w http.ResponseWriter, message string, status int) {
+	apierror.Write(w, status, apierror.Error{Message: message})
+}
+
+// writeErrorCode responds with the error envelope using a code of its own, details can be nil
+func writeErrorCode(w http.ResponseWriter, code string, message string, status int, details interface{}) {
+	apierror.Write(w, status, apierror.Error{Code: code, Message: message, Details: details})
+}
+
+// withRequestID gives every request an ID, a ID sent by the caller is kept so it can follow the request through the logs
+// The ID is set on both the request and the response
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(apierror.RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+			r.Header.Set(apierror.RequestIDHeader, id)
+		}
+		w.Header().Set(apierror.RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRequestID creates a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/workflows/callback"
+	"programmingpercy/cadence-tavern/workflows/greetings"
+	"sync"
+)
+
+const (
+	// greetBatchLimit is the most customers greeted in one batch
+	greetBatchLimit = 50
+	// greetBatchParallelism is how many greetings of a batch run at the same time
+	greetBatchParallelism = 5
+)
+
+// GreetBatchRequest is the body of POST /greetings/batch, a tour group arriving together
+type GreetBatchRequest struct {
+	Customers []greetRequest `json:"customers"`
+}
+
+// GreetBatchItem is the outcome of greeting one customer of the batch, Error is set when the greeting failed
+type GreetBatchItem struct {
+	Name     string                    `json:"name"`
+	Greeting *greetings.GreetingResult `json:"greeting,omitempty"`
+	Error    *apierror.Error           `json:"error,omitempty"`
+}
+
+// GreetBatchResult is what a batch did, Customers is in the order of the request
+type GreetBatchResult struct {
+	Greeted   int              `json:"greeted"`
+	Failed    int              `json:"failed"`
+	Customers []GreetBatchItem `json:"customers"`
+}
+
+// GreetBatch greets every customer of a tour group, the greetings run at the same time up to greetBatchParallelism
+// A customer that fails does not stop the others, the failure is in its item of the result
+func (cc *CadenceClient) GreetBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req GreetBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Customers) == 0 {
+		writeErrorCode(w, apierror.CodeValidation, "customers is required", http.StatusBadRequest, nil)
+		return
+	}
+	if len(req.Customers) > greetBatchLimit {
+		writeErrorCode(w, apierror.CodeValidation, fmt.Sprintf("at most %d customers can be greeted at once", greetBatchLimit), http.StatusBadRequest, nil)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	result := GreetBatchResult{Customers: make([]GreetBatchItem, len(req.Customers))}
+	slots := make(chan struct{}, greetBatchParallelism)
+	var wg sync.WaitGroup
+	for i, greet := range req.Customers {
+		result.Customers[i].Name = greet.Name
+		if err := cc.validateBatchGreeting(req.Customers[:i], greet); err != nil {
+			result.Customers[i].Error = &apierror.Error{Code: apierror.CodeValidation, Message: err.Error()}
+			continue
+		}
+
+		visitor := greet.Customer
+		visitor.Location = loc
+		audit(r, "greet", visitor.Name)
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(item *GreetBatchItem, callbackURL string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			greeting, err := cc.greet(r.Context(), loc, visitor)
+			if err != nil {
+				item.Error = &apierror.Error{Code: apierror.CodeInternal, Message: err.Error()}
+				return
+			}
+			item.Greeting = &greeting
+			if callbackURL != "" {
+				cc.deliverGreeting(r.Context(), loc, callbackURL, greeting)
+			}
+		}(&result.Customers[i], greet.CallbackURL)
+	}
+	wg.Wait()
+
+	for _, item := range result.Customers {
+		if item.Error != nil {
+			result.Failed++
+		} else {
+			result.Greeted++
+		}
+	}
+	// The greetings recorded visits, opened tabs and took seats
+	if result.Greeted > 0 {
+		cc.invalidate(loc, cacheCustomers, cacheTabs, cacheTables)
+	}
+
+	data, _ := json.Marshal(result)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// validateBatchGreeting checks the greeting like GreetUser does, a customer already in the batch is refused
+// since greeting the same customer twice at once would race for their tab
+func (cc *CadenceClient) validateBatchGreeting(earlier []greetRequest, greet greetRequest) error {
+	if err := validateCustomer(greet.Customer); err != nil {
+		return err
+	}
+	if greet.CallbackURL != "" {
+		if err := callback.Validate(greet.CallbackURL, cc.cfg.Callbacks.AllowedHosts); err != nil {
+			return err
+		}
+	}
+	for _, other := range earlier {
+		if customer.SameName(other.Name, greet.Name) {
+			return fmt.Errorf("%s is already in the batch", greet.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/workflows/leaderboard"
+)
+
+// Leaderboard is used to look at the top customers of a location by spend, GET /leaderboard
+// A location without an open leaderboard has no completed orders yet, it is answered with an empty list
+func (cc *CadenceClient) Leaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	entries := []customer.RankingEntry{}
+	value, err := cc.client.QueryWorkflow(r.Context(), leaderboard.WorkflowID(loc), "", leaderboard.QueryTop)
+	if err != nil && !engine.IsNotFound(err) {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		if err := value.Get(&entries); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data, _ := json.Marshal(entries)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
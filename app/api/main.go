@@ -4,41 +4,137 @@ import (
 	"context"
 	"log"
 	"net/http"
-	"time"
-
-	"go.uber.org/cadence/client"
+	"programmingpercy/cadence-tavern/cache"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/equipment"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/migrations"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/ops"
+	"programmingpercy/cadence-tavern/orderstore"
+	localprom "programmingpercy/cadence-tavern/prometheus"
+	"programmingpercy/cadence-tavern/retry"
+	"programmingpercy/cadence-tavern/tracing"
 )
 
 func main() {
 
 	rootCtx := context.Background()
-	cc, err := SetupCadenceClient()
+	// Load the configuration, defaults can be overridden by the environment
+	cfg, err := config.Load(config.APIDefaults())
+	if err != nil {
+		panic(err)
+	}
+	// Apply the customer repository used by the admin routes
+	customer.Database, err = customer.NewRepository(cfg.Repository)
+	if err != nil {
+		panic(err)
+	}
+	orderstore.Events, err = orderstore.NewStore(cfg.Repository)
+	if err != nil {
+		panic(err)
+	}
+	if letters, ok := orderstore.Events.(orderstore.DeadLetterStore); ok {
+		orderstore.DeadLetters = letters
+	}
+	if index, ok := orderstore.Events.(orderstore.IndexStore); ok {
+		orderstore.Index = index
+	}
+	// Apply the pours the bar equipment reports back on
+	equipment.Pours, err = equipment.NewStore(cfg.Repository)
+	if err != nil {
+		panic(err)
+	}
+	// Apply the task list version new workflows are started on
+	location.SetVersion(cfg.TaskListVersion)
+	// Apply the currency of the prices written without one, it is the currency the Worker charges in
+	if cfg.Payments.Currency != "" {
+		models.Currency = models.NormalizeCurrency(cfg.Payments.Currency)
+	}
+	// Apply the API keys of the callers
+	keys, err := parseKeys(cfg.Auth)
+	if err != nil {
+		panic(err)
+	}
+	// Apply the secrets the webhooks of the equipment and the suppliers are signed with
+	if err := checkSecrets("TAVERN_EQUIPMENT_SECRETS", cfg.Equipment.Secrets, cfg.Equipment.Insecure); err != nil {
+		panic(err)
+	}
+	if err := checkSecrets("TAVERN_DELIVERY_SECRETS", cfg.Deliveries.Secrets, cfg.Deliveries.Insecure); err != nil {
+		panic(err)
+	}
+	cc, err := SetupCadenceClient(cfg)
+	if err != nil {
+		panic(err)
+	}
+	// Apply the backpressure on orders
+	cc.admission = newAdmission(cfg.Admission, cc.client, cc.metricsScope)
+	// Apply the cache of the read heavy routes
+	cc.cache, err = cache.New(cfg.Cache)
 	if err != nil {
 		panic(err)
 	}
 
-	// Start long running workflow
-	opts := client.StartWorkflowOptions{
-		TaskList:                     "greetings",
-		ExecutionStartToCloseTimeout: time.Hour * 1, // Wait 1 hours, make sure you use a high enough time
-		// to make sure that the workflow does not timeout before 3 singals are recieved
+	// Start the ops listener used for diagnosing the API
+	if cfg.OpsAddress != "" {
+		opsServer := ops.NewServer(cfg.OpsAddress, cfg)
+		opsServer.Handle("/debug/sampling", tracing.Sampling)
+		opsServer.Handle("/debug/metrics", localprom.Cardinality)
+		opsServer.HandleFunc("/debug/migrations", migrations.ServeHTTP)
+		go func() {
+			log.Println("ops listener stopped: ", opsServer.ListenAndServe())
+		}()
 	}
 
-	// We use Start here since we want to start it but not wait for it to return
-	// Execution contains information about the execution such as Workflow ID etc
-	// In production, make sure you check if the WOrkflows are already running to avoid  booting up multiple unless wanted
-	execution, err := cc.client.StartWorkflow(rootCtx, opts, OrderWorkflow)
+	// Start long running workflow, the supervisor restarts it if it ever closes
+	// The first call to the Cadence server is retried since it might still be booting
+	err = retry.NewPolicy(cfg.Startup).Do(rootCtx, cc.metricsScope, cc.logger, "order-workflow", func() error {
+		return cc.ensureOrderWorkflows(rootCtx)
+	})
 	if err != nil {
 		panic(err)
 	}
-
-	log.Println("Workflow ID: ", execution.ID)
-	// Apply Workflows IDs
-	cc.SetOrderWorkflowIds(execution.ID, execution.RunID)
+	go cc.superviseOrders(rootCtx, cfg.OrderSupervisorInterval)
+	go cc.reconcilePours(rootCtx, cfg.Equipment.ReconcileInterval)
+	go cc.reconcileOrderIndex(rootCtx, cfg.OrderIndexInterval)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/greetings", cc.GreetUser)
+	mux.HandleFunc("/greetings/batch", cc.GreetBatch)
 	mux.HandleFunc("/order", cc.Order)
+	mux.HandleFunc("/menu", cc.cached(cacheMenu, cfg.Cache.TTL, Menu))
+	mux.HandleFunc("/schemas", Schemas)
+	mux.HandleFunc("/orders", cc.cached(cacheOrders, cfg.Cache.StatusTTL, cc.ListOrders))
+	mux.HandleFunc("/orders/", cc.cached(cacheOrders, cfg.Cache.StatusTTL, OrderStatus))
+	mux.HandleFunc("/orders/dead-letters", DeadLetters)
+	mux.HandleFunc("/orders/stats", cc.cached(cacheOrders, cfg.Cache.StatusTTL, cc.OrderStats))
+	mux.HandleFunc("/orders/hours/override", requireRole(roleAdmin, cc.OverrideHours))
+	mux.HandleFunc("/leaderboard", cc.cached(cacheOrders, cfg.Cache.StatusTTL, cc.Leaderboard))
+	mux.HandleFunc("/tab", cc.cached(cacheTabs, cfg.Cache.StatusTTL, cc.Tab))
+	mux.HandleFunc("/tab/settle", cc.SettleTab)
+	mux.HandleFunc("/tables", cc.cached(cacheTables, cfg.Cache.StatusTTL, cc.Tables))
+	mux.HandleFunc("/equipment/done", withSignature(cfg.Equipment.Secrets, cfg.Equipment.SignatureTolerance, cfg.Equipment.Insecure, cc.EquipmentDone))
+	mux.HandleFunc("/deliveries/", cc.Deliveries)
+	mux.HandleFunc("/workflows/batch", requireRole(roleAdmin, cc.BatchWorkflows))
+	mux.HandleFunc("/workflows", requireRole(roleAdmin, cc.LaunchWorkflow))
+	mux.HandleFunc("/workflows/start", requireRole(roleAdmin, cc.StartWorkflow))
+	mux.HandleFunc("/workflows/", cc.Workflows)
+	mux.HandleFunc("/tasklists", cc.TaskLists)
+	customers := &CustomerHandler{
+		Repository: customer.Database,
+		Locations:  cfg.Locations,
+		OnChange: func(loc string) {
+			cc.invalidate(loc, cacheCustomers)
+		},
+	}
+	mux.Handle("/customers/", cc.cached(cacheCustomers, cfg.Cache.TTL, customers.ServeHTTP))
+	mux.HandleFunc("/customers", cc.cached(cacheCustomers, cfg.Cache.TTL, customers.ServeCollection))
+
+	// Routes that do not exist answer with the error envelope as well
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, "no such route: "+r.URL.Path, http.StatusNotFound)
+	})
 
-	log.Fatal(http.ListenAndServe("localhost:8080", mux))
+	log.Fatal(http.ListenAndServe(cfg.ListenAddress, withRequestID(withCORS(cfg.CORS, withAuth(keys, withCompression(mux))))))
 }
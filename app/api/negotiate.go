@@ -0,0 +1,167 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"strconv"
+	"strings"
+)
+
+// The formats of the list routes and their media types
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+var mediaTypes = map[string]string{
+	formatJSON: "application/json",
+	formatCSV:  "text/csv",
+}
+
+// negotiateFormat picks the format of a list route from ?format= or else the Accept header, offers are in order of preference
+// When none of the offers are acceptable a 406 is written and ok is false
+func negotiateFormat(w http.ResponseWriter, r *http.Request, offers ...string) (format string, ok bool) {
+	w.Header().Add("Vary", "Accept")
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, offer := range offers {
+			if offer == format {
+				return format, true
+			}
+		}
+		writeError(w, "unknown format: "+format, http.StatusBadRequest)
+		return "", false
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0], true
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(raw, 64); err != nil {
+				continue
+			}
+		}
+		for _, offer := range offers {
+			if q > bestQ && matchMediaType(mediaType, mediaTypes[offer]) {
+				best, bestQ = offer, q
+			}
+		}
+	}
+	if best == "" {
+		writeErrorCode(w, apierror.CodeNotAcceptable, "none of the accepted types are offered: "+accept, http.StatusNotAcceptable,
+			map[string][]string{"offers": offerTypes(offers)})
+		return "", false
+	}
+	return best, true
+}
+
+// matchMediaType is true when the accepted type, which can be a wildcard such as text/*, covers the offered type
+func matchMediaType(accepted string, offered string) bool {
+	if accepted == "*/*" || accepted == offered {
+		return true
+	}
+	return strings.HasSuffix(accepted, "/*") && strings.HasPrefix(offered, strings.TrimSuffix(accepted, "*"))
+}
+
+// offerTypes are the media types of the offers
+func offerTypes(offers []string) []string {
+	types := make([]string, len(offers))
+	for i, offer := range offers {
+		types[i] = mediaTypes[offer]
+	}
+	return types
+}
+
+// requestFormat is the format of a request body from ?format= or else the Content-Type header
+func requestFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	for format, t := range mediaTypes {
+		if t == mediaType {
+			return format
+		}
+	}
+	return formatJSON
+}
+
+// gzipResponseWriter compresses the body once it knows the response has one
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	started bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if !gw.started {
+		gw.started = true
+		// Responses without a body are sent as they are
+		if status != http.StatusNoContent && status != http.StatusNotModified && gw.Header().Get("Content-Encoding") == "" {
+			gw.Header().Set("Content-Encoding", "gzip")
+			gw.Header().Del("Content-Length")
+			gw.gz = gzip.NewWriter(gw.ResponseWriter)
+		}
+	}
+	gw.ResponseWriter.WriteHeader(status)
+}
+
+func (gw *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !gw.started {
+		if gw.Header().Get("Content-Type") == "" {
+			// Sniff before compressing, like the ResponseWriter would
+			gw.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.gz == nil {
+		return gw.ResponseWriter.Write(data)
+	}
+	return gw.gz.Write(data)
+}
+
+// withCompression gzips the responses of callers that accept it, so large exports are smaller on the wire
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer func() {
+			if gw.gz != nil {
+				gw.gz.Close()
+			}
+		}()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip is true when Accept-Encoding lists gzip without q=0
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(fields[0]) != "gzip" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+//go:build !standalone
+// +build !standalone
+
+package main
+
+import (
+	"errors"
+	"programmingpercy/cadence-tavern/engine"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/encoded"
+	"go.uber.org/zap"
+)
+
+// newStandaloneClient refuses to run the workflows in the API, the standalone engine runs them in the test environment
+// of the cadence SDK and is only linked when built with -tags standalone
+func newStandaloneClient(dataConverter encoded.DataConverter, scope tally.Scope, logger *zap.Logger) (engine.Client, error) {
+	return nil, errors.New("TAVERN_STANDALONE is set but the API was built without -tags standalone")
+}
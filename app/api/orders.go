@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/httpquery"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/workflows/orders"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"strings"
+	"time"
+)
+
+// OrderStatus is used to look at an order, GET /orders/{id}
+// The status is projected from the order events so it does not depend on Cadence visibility retention
+func OrderStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/orders/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, "expected /orders/{id}", http.StatusNotFound)
+		return
+	}
+
+	events, err := orderstore.Events.Events(r.Context(), id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entry, indexErr := orderstore.Index.LookupOrder(r.Context(), id)
+	if indexErr != nil && !errors.Is(indexErr, orderstore.ErrNoSuchOrder) {
+		writeError(w, indexErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	status, err := orderstore.Project(events)
+	if errors.Is(err, orderstore.ErrNoSuchOrder) && indexErr == nil {
+		// The order was sent but the workflow has not recorded anything yet
+		status = orderstore.Status{ID: entry.OrderID, Status: entry.Status, Item: entry.Item, By: entry.By, UpdatedAt: entry.UpdatedAt}
+		err = nil
+	}
+	if errors.Is(err, orderstore.ErrNoSuchOrder) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if indexErr == nil {
+		status.WorkflowID = entry.WorkflowID
+		status.RunID = entry.RunID
+	}
+
+	data, _ := json.Marshal(status)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// ListOrders lists the orders sent by the API at the location, GET /orders
+// ?status=, ?customer=, ?item= and ?pending=true filter them, ?sort= takes placedAt, updatedAt, item, customer and status
+// Pages are 100 orders by default and at most 1000, see httpquery, sorting only looks at the newest 1000 matching orders
+// They are read from the order index, newest first, in JSON or CSV picked with ?format= or the Accept header
+func (cc *CadenceClient) ListOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	query, err := httpquery.Parse(r.URL.Query(), orderListOptions)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	// The filters the index understands are left to it, so the newest orders are the matching ones
+	filter := orderstore.IndexFilter{
+		Location: loc,
+		Status:   query.Filter("status"),
+		By:       query.Filter("customer"),
+		Pending:  query.Filter("pending") == "true",
+		Limit:    maxOrderListLimit,
+	}
+	if len(query.Filters["status"]) == 1 {
+		delete(query.Filters, "status")
+	} else {
+		filter.Status = ""
+	}
+	if len(query.Filters["customer"]) == 1 {
+		delete(query.Filters, "customer")
+	} else {
+		filter.By = ""
+	}
+	delete(query.Filters, "pending")
+
+	format, ok := negotiateFormat(w, r, formatJSON, formatCSV)
+	if !ok {
+		return
+	}
+	entries, err := orderstore.Index.ListOrders(r.Context(), filter)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	indexes, page := query.Apply(len(entries), func(i int, field string) interface{} {
+		return orderField(entries[i], field)
+	})
+	paged := make([]orderstore.IndexEntry, len(indexes))
+	for i, index := range indexes {
+		paged[i] = entries[index]
+	}
+
+	w.Header().Set("Content-Type", mediaTypes[format])
+	httpquery.WriteHeaders(w, page)
+	if err := orderstore.EncodeIndex(w, format, paged); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// maxOrderListLimit is the most orders GET /orders returns
+const maxOrderListLimit = 1000
+
+// orderListOptions are the parameters of GET /orders
+var orderListOptions = httpquery.Options{
+	DefaultLimit: 100,
+	MaxLimit:     maxOrderListLimit,
+	DefaultSort:  "-placedAt",
+	Sortable:     []string{"placedAt", "updatedAt", "item", "customer", "status"},
+	Filterable:   []string{"status", "customer", "item", "pending"},
+}
+
+// orderField is the value of a field of GET /orders
+func orderField(entry orderstore.IndexEntry, field string) interface{} {
+	switch field {
+	case "placedAt":
+		return entry.PlacedAt
+	case "updatedAt":
+		return entry.UpdatedAt
+	case "item":
+		return entry.Item
+	case "customer":
+		return entry.By
+	case "status":
+		return entry.Status
+	}
+	return nil
+}
+
+// reconcileOrderIndex catches the indexed orders that are not done up with their events on every interval until
+// ctx is done. The worker updates the index itself when it shares the store, this covers the updates it failed
+func (cc *CadenceClient) reconcileOrderIndex(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, loc := range append([]string{""}, cc.cfg.Locations...) {
+				if err := updateOrderIndex(ctx, loc); err != nil {
+					log.Println("Failed to reconcile the order index: ", err)
+				}
+			}
+		}
+	}
+}
+
+// updateOrderIndex sets the status of the pending orders of the location to the latest of their events
+func updateOrderIndex(ctx context.Context, loc string) error {
+	pending, err := orderstore.Index.ListOrders(ctx, orderstore.IndexFilter{Location: loc, Pending: true, Limit: maxOrderListLimit})
+	if err != nil {
+		return err
+	}
+	for _, entry := range pending {
+		events, err := orderstore.Events.Events(ctx, entry.OrderID)
+		if err != nil {
+			return err
+		}
+		status, err := orderstore.Project(events)
+		if err != nil || status.Status == entry.Status {
+			continue
+		}
+		if err := orderstore.Index.UpdateOrderStatus(ctx, entry.OrderID, status.Status, status.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeadLetters is used to look at the orders that failed after all retries, GET /orders/dead-letters
+// They are JSON or CSV, picked with ?format= or the Accept header
+func DeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format, ok := negotiateFormat(w, r, formatJSON, formatCSV)
+	if !ok {
+		return
+	}
+
+	letters, err := orderstore.DeadLetters.DeadLetters(r.Context())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaTypes[format])
+	if err := orderstore.EncodeDeadLetters(w, format, letters); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// OrderStats is used to look at what the order workflow of a location served, GET /orders/stats
+// The stats cover every run of the workflow since the first one started
+func (cc *CadenceClient) OrderStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	value, err := cc.client.QueryWorkflow(r.Context(), orders.WorkflowID(loc), "", orders.QueryStats)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var stats orders.Stats
+	if err := value.Get(&stats); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(stats)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// OverrideHours opens or closes the tavern of a location regardless of its opening hours, POST /orders/hours/override
+// The body is an orders.Override, such as {"open": true, "until": "2006-01-02T03:00:00Z", "reason": "midsummer"}
+// An until that already passed clears the override
+func (cc *CadenceClient) OverrideHours(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var override orders.Override
+	if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	envelope, err := signals.Wrap(orders.OverrideSignalVersion, override)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = cc.client.SignalWorkflow(r.Context(), orders.WorkflowID(loc), "", orders.SignalOverride, envelope)
+	if engine.IsNotFound(err) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(r, "hours.override", loc)
+	w.WriteHeader(http.StatusOK)
+}
+
+// newOrderID generates a random ID for orders that did not bring their own
+func newOrderID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
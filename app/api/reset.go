@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"programmingpercy/cadence-tavern/engine"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resetTokenTTL is how long an operator has to confirm a reset
+const resetTokenTTL = 5 * time.Minute
+
+// ResetRequest is the body of POST /workflows/{id}/reset
+type ResetRequest struct {
+	// RunID defaults to the current run
+	RunID string `json:"run_id"`
+	// EventID is the decision completed event to reset to, 0 picks the last good decision
+	EventID int64  `json:"event_id"`
+	Reason  string `json:"reason"`
+	// Token is the confirmation token of the first request, without it the reset is only planned
+	Token string `json:"token"`
+}
+
+// ResetPlan is what a reset will do, it is returned until the reset is confirmed with the token
+type ResetPlan struct {
+	ID      string    `json:"id"`
+	RunID   string    `json:"run_id"`
+	EventID int64     `json:"event_id"`
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// newResetKey is the secret, or a random key when there is none
+func newResetKey(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// reset resets a workflow to a decision, used to recover the order loop after a non-deterministic deploy
+// The first request returns a ResetPlan, the reset is done when the request is sent again with its token
+func (cc *CadenceClient) reset(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		writeError(w, "missing reason", http.StatusBadRequest)
+		return
+	}
+
+	// Pin the run and event so the token confirms exactly what the operator was shown
+	if req.RunID == "" {
+		description, err := cc.client.DescribeWorkflow(r.Context(), id, "")
+		if engine.IsNotFound(err) {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.RunID = description.RunID
+	}
+	if req.EventID == 0 {
+		eventID, err := cc.client.LastGoodDecision(r.Context(), id, req.RunID)
+		if err == engine.ErrNoDecision {
+			writeError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.EventID = eventID
+	}
+
+	target := fmt.Sprintf("%s run=%s event=%d", id, req.RunID, req.EventID)
+	if req.Token == "" {
+		expires := time.Now().Add(resetTokenTTL).UTC().Truncate(time.Second)
+		plan := ResetPlan{
+			ID:      id,
+			RunID:   req.RunID,
+			EventID: req.EventID,
+			Token:   cc.resetToken(id, req.RunID, req.EventID, expires),
+			Expires: expires,
+		}
+		audit(r, "reset-planned", target+" reason="+strconv.Quote(req.Reason))
+
+		data, _ := json.Marshal(plan)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(data)
+		return
+	}
+	if err := cc.checkResetToken(req.Token, id, req.RunID, req.EventID); err != nil {
+		audit(r, "reset-rejected", target+" reason="+strconv.Quote(req.Reason))
+		writeError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	audit(r, "reset", target+" reason="+strconv.Quote(req.Reason))
+	runID, err := cc.client.ResetWorkflow(r.Context(), id, req.RunID, req.EventID, req.Reason)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(map[string]string{"id": id, "run_id": runID})
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// resetToken signs the reset with its expiry, written as expiry.signature
+func (cc *CadenceClient) resetToken(id, runID string, eventID int64, expires time.Time) string {
+	mac := hmac.New(sha256.New, cc.resetKey)
+	fmt.Fprintf(mac, "%s\x00%s\x00%d\x00%d", id, runID, eventID, expires.Unix())
+	return strconv.FormatInt(expires.Unix(), 10) + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkResetToken fails unless the token was issued for this reset and has not expired
+func (cc *CadenceClient) checkResetToken(token, id, runID string, eventID int64) error {
+	parts := strings.SplitN(token, ".", 2)
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) != 2 {
+		return fmt.Errorf("malformed confirmation token")
+	}
+	expires := time.Unix(unix, 0)
+	if time.Now().After(expires) {
+		return fmt.Errorf("confirmation token expired at %s", expires.UTC().Format(time.RFC3339))
+	}
+	if !hmac.Equal([]byte(token), []byte(cc.resetToken(id, runID, eventID, expires))) {
+		return fmt.Errorf("confirmation token does not match the reset")
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"programmingpercy/cadence-tavern/models"
+)
+
+// Schemas serves the JSON schemas of the models, they can be referenced from an OpenAPI spec or used to generate clients
+func Schemas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, _ := json.Marshal(models.Schemas())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/workflows/callback"
+	"time"
+)
+
+// maxSignedBody is the largest body a signed webhook may have
+const maxSignedBody = 1 << 20
+
+// checkSecrets refuses webhooks configured without secrets unless they are explicitly insecure, like parseKeys
+func checkSecrets(env string, secrets []string, insecure bool) error {
+	if len(secrets) == 0 && !insecure {
+		return fmt.Errorf("no webhook secrets configured, set %s or mark the webhook insecure for local development", env)
+	}
+	return nil
+}
+
+// withSignature only passes on webhooks signed with one of the secrets, in the format of the callbacks the tavern sends
+// Anyone who can reach the API could otherwise complete activities of other orders, so forged calls are refused
+// before they reach the handler. Without secrets every call is refused, unless the webhook is insecure
+func withSignature(secrets []string, tolerance time.Duration, insecure bool, next http.HandlerFunc) http.HandlerFunc {
+	if len(secrets) == 0 {
+		if insecure {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			writeErrorCode(w, apierror.CodeUnauthorized, "no secrets are configured to verify the signature", http.StatusUnauthorized, nil)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxSignedBody {
+			writeError(w, "body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		header := r.Header.Get(callback.SignatureHeader)
+		if header == "" {
+			writeErrorCode(w, apierror.CodeUnauthorized, "missing "+callback.SignatureHeader+" header", http.StatusUnauthorized, nil)
+			return
+		}
+		// Every secret is tried so the senders can move to a new secret one at a time
+		for _, secret := range secrets {
+			if err = callback.Verify([]byte(secret), header, body, tolerance); err == nil {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				next(w, r)
+				return
+			}
+		}
+		log.Printf("Refused webhook to %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+		writeErrorCode(w, apierror.CodeUnauthorized, err.Error(), http.StatusUnauthorized, nil)
+	}
+}
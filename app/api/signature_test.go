@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhooksWithoutSecretsFailClosed(t *testing.T) {
+	if err := checkSecrets("TAVERN_EQUIPMENT_SECRETS", nil, false); err == nil {
+		t.Fatal("checkSecrets accepted a webhook without secrets")
+	}
+	if err := checkSecrets("TAVERN_EQUIPMENT_SECRETS", nil, true); err != nil {
+		t.Fatalf("checkSecrets refused an insecure webhook: %v", err)
+	}
+
+	for _, c := range []struct {
+		insecure bool
+		want     int
+	}{
+		{false, http.StatusUnauthorized},
+		{true, http.StatusOK},
+	} {
+		var reached bool
+		handler := withSignature(nil, time.Minute, c.insecure, func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+			w.WriteHeader(http.StatusOK)
+		})
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/equipment/done", nil))
+		if w.Code != c.want || reached != (c.want == http.StatusOK) {
+			t.Errorf("unsigned call to an insecure=%t webhook answered %d, want %d", c.insecure, w.Code, c.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+//go:build standalone
+// +build standalone
+
+package main
+
+import (
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/setup"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/encoded"
+	"go.uber.org/zap"
+)
+
+// newStandaloneClient runs the workflows in the API, see engine.NewStandaloneClient for what is left out
+// The workflows are set up from the config of the Worker, the Worker defaults with the same environment applied
+// The repositories are the ones of the API, the payments, receipts and notifications keep their in memory defaults
+func newStandaloneClient(dataConverter encoded.DataConverter, scope tally.Scope, logger *zap.Logger) (engine.Client, error) {
+	cfg, err := config.Load(config.WorkerDefaults())
+	if err != nil {
+		return nil, err
+	}
+	if err := setup.Apply(cfg); err != nil {
+		return nil, err
+	}
+	if missing := registry.Missing(registry.Manifest); len(missing) > 0 {
+		return nil, fmt.Errorf("standalone API is missing workflows: %v", missing)
+	}
+
+	logger.Info("Running the workflows in the API, nothing is kept once it stops")
+	return engine.NewStandaloneClient(setup.Options(dataConverter, scope, logger)), nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/httpquery"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"strings"
+	"time"
+)
+
+// defaultStartTimeout is how long a workflow started over HTTP may run when the request does not say
+const defaultStartTimeout = time.Hour
+
+// LaunchOptions are the optional start options of a workflow started over HTTP
+type LaunchOptions struct {
+	// WorkflowID defaults to a random ID
+	WorkflowID string `json:"workflowID"`
+	// Timeout is how long each run may take such as 30m, defaults to an hour
+	Timeout string `json:"timeout"`
+	// CronSchedule starts a new run on the schedule, such as 0 3 * * * for the nightly report
+	CronSchedule string `json:"cronSchedule"`
+	// DelayStart is how long to wait before starting such as 2h, an easy way to begin happy hour later
+	DelayStart string `json:"delayStart"`
+}
+
+// StartRequest is the body of POST /workflows/start, ?location= selects the task list
+type StartRequest struct {
+	// Workflow is the registered name of the workflow, or the end of it such as orders.WorkflowOrder
+	Workflow string `json:"workflow"`
+	// Args are the arguments of the workflow, each is decoded into the parameter in the same position
+	Args []json.RawMessage `json:"args"`
+	LaunchOptions
+}
+
+// LaunchRequest is the body of POST /workflows
+type LaunchRequest struct {
+	// WorkflowType is the registered name of the workflow, or the end of it such as orders.WorkflowOrder
+	WorkflowType string `json:"workflowType"`
+	// TaskList is one of the task lists served by this deployment, defaults to the one of the default location
+	TaskList string `json:"taskList"`
+	// Input are the arguments of the workflow, each is decoded into the parameter in the same position
+	Input   []json.RawMessage `json:"input"`
+	Options LaunchOptions     `json:"options"`
+}
+
+// Execution is what is returned for a started workflow
+type Execution struct {
+	WorkflowType string `json:"workflowType"`
+	WorkflowID   string `json:"workflowID"`
+	RunID        string `json:"runID"`
+	TaskList     string `json:"taskList"`
+}
+
+// StartWorkflow starts an allowlisted workflow, so operators do not have to restart a binary to run one
+func (cc *CadenceClient) StartWorkflow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	var req StartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cc.launch(w, r, req.Workflow, location.TaskList(cc.cfg.TaskList, loc), req.Args, req.LaunchOptions)
+}
+
+// LaunchWorkflow is the workflow gateway, POST /workflows starts an allowlisted workflow on a served task list
+// GET /workflows lists the open workflows, see listWorkflows
+func (cc *CadenceClient) LaunchWorkflow(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		cc.listWorkflows(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req LaunchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	taskList := req.TaskList
+	if taskList == "" {
+		taskList = cc.cfg.TaskList
+	}
+	if !cc.servesTaskList(taskList) {
+		writeError(w, fmt.Sprintf("task list %q is not served by this deployment", taskList), http.StatusBadRequest)
+		return
+	}
+	cc.launch(w, r, req.WorkflowType, taskList, req.Input, req.Options)
+}
+
+// launch starts the workflow if it is allowlisted and writes its Execution
+func (cc *CadenceClient) launch(w http.ResponseWriter, r *http.Request, workflow, taskList string, input []json.RawMessage, options LaunchOptions) {
+	name, ok := registry.Resolve(workflow)
+	if !ok || !cc.allowed(name) {
+		// Do not tell which workflows exist, only which may be started
+		writeErrorCode(w, apierror.CodeWorkflowNotAllowed, fmt.Sprintf("workflow %q is not allowed, it should be one of %v", workflow, cc.cfg.WorkflowAllowlist), http.StatusForbidden, nil)
+		return
+	}
+	opts := engine.StartOptions{
+		ID:               options.WorkflowID,
+		TaskList:         taskList,
+		ExecutionTimeout: defaultStartTimeout,
+		CronSchedule:     options.CronSchedule,
+	}
+	var err error
+	if options.Timeout != "" {
+		if opts.ExecutionTimeout, err = parsePositiveDuration("timeout", options.Timeout); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if options.DelayStart != "" {
+		if opts.DelayStart, err = parsePositiveDuration("delayStart", options.DelayStart); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	args := make([]interface{}, len(input))
+	for i, arg := range input {
+		args[i] = arg
+	}
+	run, err := cc.client.StartWorkflow(r.Context(), opts, name, args...)
+	if engine.IsAlreadyStarted(err) {
+		writeErrorCode(w, apierror.CodeAlreadyStarted, err.Error(), http.StatusConflict, nil)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(r, "start", fmt.Sprintf("%s workflow=%s tasklist=%s cron=%q delay=%s", run.ID(), name, taskList, options.CronSchedule, opts.DelayStart))
+
+	data, _ := json.Marshal(Execution{WorkflowType: name, WorkflowID: run.ID(), RunID: run.RunID(), TaskList: taskList})
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// allowed is true when the registered workflow is on the allowlist
+func (cc *CadenceClient) allowed(name string) bool {
+	for _, entry := range cc.cfg.WorkflowAllowlist {
+		if resolved, ok := registry.Resolve(entry); ok && resolved == name {
+			return true
+		}
+	}
+	return false
+}
+
+// servesTaskList is true for the task lists of the default location and the locations of this deployment
+func (cc *CadenceClient) servesTaskList(taskList string) bool {
+	for _, loc := range append([]string{""}, cc.cfg.Locations...) {
+		if location.TaskList(cc.cfg.TaskList, loc) == taskList {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePositiveDuration parses the duration of the field, such as 10m
+func parsePositiveDuration(field, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s should be a positive duration such as 10m, got %q", field, value)
+	}
+	return d, nil
+}
+
+// maxWorkflowListLimit is the most workflows GET /workflows returns, and reads from visibility
+const maxWorkflowListLimit = 1000
+
+// workflowListOptions are the parameters of GET /workflows
+var workflowListOptions = httpquery.Options{
+	DefaultLimit: 100,
+	MaxLimit:     maxWorkflowListLimit,
+	DefaultSort:  "-started",
+	Sortable:     []string{"started", "id", "type"},
+	Filterable:   []string{"type"},
+}
+
+// listWorkflows lists the open workflows of the domain, ?type= takes registered names or the end of them
+// Sorting only looks at the newest maxWorkflowListLimit open workflows
+func (cc *CadenceClient) listWorkflows(w http.ResponseWriter, r *http.Request) {
+	query, err := httpquery.Parse(r.URL.Query(), workflowListOptions)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	// The types are resolved to their registered names, so only known names reach the visibility query
+	visibility := "CloseTime = missing"
+	if types := query.Filters["type"]; len(types) > 0 {
+		clauses := make([]string, len(types))
+		for i, name := range types {
+			resolved, ok := registry.Resolve(name)
+			if !ok {
+				writeErrorCode(w, apierror.CodeValidation, "unknown workflow type: "+name, http.StatusBadRequest, nil)
+				return
+			}
+			types[i] = resolved
+			clauses[i] = fmt.Sprintf("WorkflowType = '%s'", resolved)
+		}
+		visibility += " AND (" + strings.Join(clauses, " OR ") + ")"
+	}
+
+	executions, err := cc.client.ListWorkflows(r.Context(), visibility, maxWorkflowListLimit)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	indexes, page := query.Apply(len(executions), func(i int, field string) interface{} {
+		switch field {
+		case "started":
+			return executions[i].Started
+		case "id":
+			return executions[i].ID
+		case "type":
+			return executions[i].Type
+		}
+		return nil
+	})
+	paged := make([]engine.Execution, len(indexes))
+	for i, index := range indexes {
+		paged[i] = executions[index]
+	}
+
+	httpquery.WriteHeaders(w, page)
+	data, _ := json.Marshal(paged)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/workflows/orders"
+	"time"
+)
+
+// orderWorkflowOptions are used every time the order workflow of a location is started
+// The ID is fixed so there is only ever one order workflow open in each tavern
+func (cc *CadenceClient) orderWorkflowOptions(loc string) engine.StartOptions {
+	return engine.StartOptions{
+		ID:               orders.WorkflowID(loc),
+		TaskList:         location.TaskList(cc.cfg.TaskList, loc),
+		ExecutionTimeout: time.Hour * 1, // Wait 1 hours, make sure you use a high enough time
+		// to make sure that the workflow does not timeout before 3 singals are recieved
+		AllowDuplicate: true,
+	}
+}
+
+// ensureOrderWorkflows makes sure the order workflow of every location served is open
+func (cc *CadenceClient) ensureOrderWorkflows(ctx context.Context) error {
+	// The default location is always served
+	for _, loc := range append([]string{""}, cc.cfg.Locations...) {
+		if err := cc.ensureOrderWorkflow(ctx, loc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureOrderWorkflow starts the order workflow of the location unless it is already open
+// Workflows that timed out, failed or were terminated are started again with a new run
+func (cc *CadenceClient) ensureOrderWorkflow(ctx context.Context, loc string) error {
+	description, err := cc.client.DescribeWorkflow(ctx, orders.WorkflowID(loc), "")
+	if err == nil && description.Open {
+		return nil
+	}
+	if err != nil && !engine.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		log.Printf("Order workflow is closed with status %s, restarting it", description.Status)
+	}
+
+	run, err := cc.client.StartWorkflow(ctx, cc.orderWorkflowOptions(loc), OrderWorkflow)
+	if engine.IsAlreadyStarted(err) {
+		// Another API replica was faster
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Println("Started order workflow, Run ID: ", run.RunID())
+	return nil
+}
+
+// superviseOrders checks that the order workflow is open every interval until ctx is done
+func (cc *CadenceClient) superviseOrders(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cc.ensureOrderWorkflows(ctx); err != nil {
+				log.Println("Failed to supervise order workflow: ", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/tables"
+	"programmingpercy/cadence-tavern/workflows/seating"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"time"
+)
+
+// seat signals the workflow of the table that the customer sat down, the workflow is started for the first customer
+func (cc *CadenceClient) seat(ctx context.Context, loc string, table tables.Table, name string) error {
+	opts := engine.StartOptions{
+		ID:               seating.WorkflowID(loc, table.ID),
+		TaskList:         location.TaskList(cc.cfg.TaskList, loc),
+		ExecutionTimeout: time.Hour * 24,
+		AllowDuplicate:   true,
+	}
+	signal, err := signals.Wrap(seating.SignalVersion, name)
+	if err != nil {
+		return err
+	}
+	_, err = cc.client.SignalWithStartWorkflow(ctx, seating.SignalSeat, signal, opts, TableWorkflow, table)
+	return err
+}
+
+// Tables is used to look at the occupancy of the tables of a location
+// Tables without an open workflow are free
+func (cc *CadenceClient) Tables(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	loc, err := location.FromRequest(r, cc.cfg.Locations)
+	if err != nil {
+		writeErrorCode(w, apierror.CodeUnknownLocation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	layout, err := tables.ParseLayout(cc.cfg.Tables)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	occupancy := make([]tables.Table, 0, len(layout))
+	for _, table := range layout {
+		table.Location = loc
+		table.Seated = []string{}
+
+		value, err := cc.client.QueryWorkflow(r.Context(), seating.WorkflowID(loc, table.ID), "", seating.QueryOccupancy)
+		if err == nil {
+			var taken tables.Table
+			if err := value.Get(&taken); err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			table.Seated = taken.Seated
+		}
+		occupancy = append(occupancy, table)
+	}
+
+	data, _ := json.Marshal(occupancy)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
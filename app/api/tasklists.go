@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/location"
+)
+
+// taskListState is the task list version new workflows are started on and the task lists of each location
+type taskListState struct {
+	Version   string            `json:"version"`
+	TaskLists map[string]string `json:"taskLists"`
+}
+
+// taskListSwitch is the body used to start new workflows on another task list version
+type taskListSwitch struct {
+	Version string `json:"version"`
+	// Force switches even when no worker polls the new task lists yet
+	Force bool `json:"force,omitempty"`
+}
+
+// TaskLists shows and switches the task list version new workflows are started on
+// Workflows that are already open stay on their task list and are drained by the workers of the old version
+// The switch only applies to this replica until it restarts, set TAVERN_TASKLIST_VERSION to keep it
+// Only admins may switch, it moves every workflow started after it
+func (cc *CadenceClient) TaskLists(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cc.writeTaskLists(w)
+	case http.MethodPut:
+		requireRole(roleAdmin, cc.switchTaskLists)(w, r)
+	default:
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// switchTaskLists starts new workflows on the task list version of the taskListSwitch
+func (cc *CadenceClient) switchTaskLists(w http.ResponseWriter, r *http.Request) {
+	var req taskListSwitch
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !req.Force {
+		// Workflows started on a task list without workers would wait until one polls it
+		var missing []string
+		for _, taskList := range cc.taskLists(req.Version) {
+			status, err := cc.client.DescribeTaskList(r.Context(), taskList)
+			if err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if status.Pollers == 0 {
+				missing = append(missing, taskList)
+			}
+		}
+		if len(missing) > 0 {
+			writeErrorCode(w, apierror.CodeConflict, fmt.Sprintf("no workers poll the task lists of version %q", req.Version), http.StatusConflict, missing)
+			return
+		}
+	}
+	previous := location.Version()
+	location.SetVersion(req.Version)
+	audit(r, "tasklist.switch", fmt.Sprintf("from=%q to=%q force=%t", previous, req.Version, req.Force))
+	cc.writeTaskLists(w)
+}
+
+// writeTaskLists writes the task list version new workflows are started on
+func (cc *CadenceClient) writeTaskLists(w http.ResponseWriter) {
+	version := location.Version()
+	data, _ := json.Marshal(taskListState{Version: version, TaskLists: cc.taskLists(version)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// taskLists are the task lists of the default location and the locations of this deployment at the version
+func (cc *CadenceClient) taskLists(version string) map[string]string {
+	taskLists := make(map[string]string)
+	for _, loc := range append([]string{""}, cc.cfg.Locations...) {
+		name := loc
+		if name == "" {
+			name = "default"
+		}
+		taskLists[name] = location.VersionedTaskList(cc.cfg.TaskList, loc, version)
+	}
+	return taskLists
+}
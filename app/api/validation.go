@@ -0,0 +1,17 @@
+package main
+
+import (
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/workflows/orders"
+)
+
+// validateCustomer is used to reject customer input before it reaches workflows or the repository
+// The greetings workflow runs the same checks, rejecting here saves starting it
+func validateCustomer(visitor customer.Customer) error {
+	return visitor.Validate()
+}
+
+// validateOrder is used to reject order input before it is signalled
+func validateOrder(order orders.Order) error {
+	return order.Validate()
+}
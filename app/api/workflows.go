@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/workflows/pause"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"strings"
+)
+
+// Workflows serves the debugging and recovery routes of a single workflow
+//
+//	GET /workflows/{id}/stack
+//	POST /workflows/{id}/reset, admin only
+//	POST /workflows/{id}/signal/{name}, admin only
+//	POST /workflows/{id}/pause, admin only
+//	POST /workflows/{id}/resume, admin only
+//	GET /workflows/{id}/paused
+//
+// Workflow IDs of locations contain a slash, so the ID is everything between /workflows/ and the action
+func (cc *CadenceClient) Workflows(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/workflows/")
+	if i := strings.LastIndex(path, "/signal/"); i > 0 {
+		requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			cc.signal(w, r, path[:i], path[i+len("/signal/"):])
+		})(w, r)
+		return
+	}
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		writeError(w, "expected /workflows/{id}/{action}", http.StatusNotFound)
+		return
+	}
+	id, action := path[:i], path[i+1:]
+
+	switch action {
+	case "stack":
+		cc.stack(w, r, id)
+	case "reset":
+		requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			cc.reset(w, r, id)
+		})(w, r)
+	case "pause":
+		requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			cc.pause(w, r, id)
+		})(w, r)
+	case "resume":
+		requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			cc.resume(w, r, id)
+		})(w, r)
+	case "paused":
+		cc.paused(w, r, id)
+	default:
+		writeError(w, "unknown workflow action: "+action, http.StatusNotFound)
+	}
+}
+
+// signal sends any registered signal to a workflow, the body is the payload and ?run_id= selects a run
+// The payload is validated against the contract of the signal and wrapped in the envelope of its version
+func (cc *CadenceClient) signal(w http.ResponseWriter, r *http.Request, id string, name string) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	contract, ok := registry.LookupSignal(name)
+	if !ok {
+		writeError(w, "unknown signal: "+name, http.StatusNotFound)
+		return
+	}
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := contract.Validate(payload); err != nil {
+		writeErrorCode(w, apierror.CodeValidation, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+
+	var value interface{}
+	if len(bytes.TrimSpace(payload)) > 0 {
+		value = json.RawMessage(payload)
+	}
+	envelope, err := signals.Wrap(contract.Version, value)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = cc.client.SignalWorkflow(r.Context(), id, r.URL.Query().Get("run_id"), name, envelope)
+	if engine.IsNotFound(err) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(r, "signal."+name, id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// stack returns the goroutine dump of a workflow, it shows where a workflow that appears hung is blocked
+// ?run_id= selects a run other than the current
+func (cc *CadenceClient) stack(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	value, err := cc.client.QueryWorkflow(r.Context(), id, r.URL.Query().Get("run_id"), engine.QueryStackTrace)
+	if engine.IsNotFound(err) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var trace string
+	if err := value.Get(&trace); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(trace))
+}
+
+// pause stops the workflow from starting new work until it is resumed, the body can give a reason
+// Only the order and tab workflows listen to it
+func (cc *CadenceClient) pause(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req pause.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cc.sendPauseSignal(w, r, id, pause.SignalPause, req)
+}
+
+// resume lets a paused workflow carry on
+func (cc *CadenceClient) resume(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cc.sendPauseSignal(w, r, id, pause.SignalResume, nil)
+}
+
+// sendPauseSignal sends the pause or resume signal to the current run of the workflow
+func (cc *CadenceClient) sendPauseSignal(w http.ResponseWriter, r *http.Request, id string, name string, payload interface{}) {
+	envelope, err := signals.Wrap(pause.SignalVersion, payload)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = cc.client.SignalWorkflow(r.Context(), id, "", name, envelope)
+	if engine.IsNotFound(err) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(r, name, id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// paused returns whether the workflow is paused, ?run_id= selects a run other than the current
+func (cc *CadenceClient) paused(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	value, err := cc.client.QueryWorkflow(r.Context(), id, r.URL.Query().Get("run_id"), pause.QueryPaused)
+	if engine.IsNotFound(err) {
+		writeError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var state pause.State
+	if err := value.Get(&state); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(state)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
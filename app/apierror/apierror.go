@@ -0,0 +1,101 @@
+// Package apierror is the error envelope of the tavern API, it is shared by the API and the tavernclient
+package apierror
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// RequestIDHeader carries the ID of a request, the API echoes a given ID and picks one when it is missing
+const RequestIDHeader = "X-Request-ID"
+
+// The codes of the errors, unlike the messages they are stable and can be matched on
+const (
+	// CodeBadRequest is a body or parameter that can not be read
+	CodeBadRequest = "bad_request"
+	// CodeValidation is a request that was read but breaks a rule, such as a customer that is too young
+	CodeValidation = "validation_failed"
+	// CodeUnknownLocation is a location that is not served by the deployment
+	CodeUnknownLocation = "unknown_location"
+	// CodeNotFound is a route, workflow, customer or order that does not exist
+	CodeNotFound = "not_found"
+	// CodeMethodNotAllowed is a route that does not take the method
+	CodeMethodNotAllowed = "method_not_allowed"
+	// CodeNotAcceptable is a Accept header that none of the formats of the route match
+	CodeNotAcceptable = "not_acceptable"
+	// CodeConflict is a change that clashes with the current state, such as a customer that already exists
+	CodeConflict = "conflict"
+	// CodeAlreadyStarted is a workflow ID that is already running
+	CodeAlreadyStarted = "already_started"
+	// CodeUnauthorized is a request that could not prove who sent it, such as a webhook with a bad signature
+	CodeUnauthorized = "unauthorized"
+	// CodeForbidden is a request that is refused, such as a reset without a valid confirmation token
+	CodeForbidden = "forbidden"
+	// CodeWorkflowNotAllowed is a workflow that is not on the allowlist of the API
+	CodeWorkflowNotAllowed = "workflow_not_allowed"
+	// CodeGone is something that existed but is over, such as a pour that timed out
+	CodeGone = "gone"
+	// CodeOverloaded is a request turned away while the workers are behind, it can be retried after the Retry-After header
+	CodeOverloaded = "overloaded"
+	// CodeTimeout is a request that ran out of time before it was done
+	CodeTimeout = "timeout"
+	// CodeInternal is a failure of the API or the Cadence server, retrying can help
+	CodeInternal = "internal"
+)
+
+// Error is the body of every response that is not successful
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details are extra information of some codes, such as the plan of a reset
+	Details interface{} `json:"details,omitempty"`
+	// RequestID is the ID of the request, it is also in the logs of the API
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// CodeFor is the code used for a status when a handler does not pick one
+func CodeFor(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusNotAcceptable:
+		return CodeNotAcceptable
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusGone:
+		return CodeGone
+	case http.StatusTooManyRequests:
+		return CodeOverloaded
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return CodeTimeout
+	}
+	return CodeInternal
+}
+
+// Write responds with the error, the request ID is taken from the response headers
+func Write(w http.ResponseWriter, status int, e Error) {
+	if e.Code == "" {
+		e.Code = CodeFor(status)
+	}
+	if e.RequestID == "" {
+		e.RequestID = w.Header().Get(RequestIDHeader)
+	}
+	data, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,56 @@
+// Package apitypes is the bodies of the requests of the tavern API, it is shared by the API and the tavernclient
+// The bodies are mapped to the types of the workflows, so the JSON of the API can change without changing what is in
+// the history of a workflow, and the other way around
+package apitypes
+
+import (
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/workflows/orders"
+	"programmingpercy/cadence-tavern/workflows/tabs"
+)
+
+// Order is the body of POST /order
+// The fields the API or the order workflow fill in, such as the location, the trace or the discount, are left out
+type Order struct {
+	// ID identifies the order, the API picks one when it is empty
+	ID   string `json:"id,omitempty"`
+	Item string `json:"item"`
+	// Price is {"currency": "USD", "minor": 450}, a bare number such as 4.5 is in the currency of the payments
+	Price models.Money `json:"price"`
+	By    string       `json:"by,omitempty"`
+	// LoyaltyID identifies the customer by their loyalty card instead of By
+	LoyaltyID string `json:"loyaltyId,omitempty"`
+	// CallbackURL is where the outcome of the order is POSTed once it is completed or dead lettered
+	CallbackURL string `json:"callbackUrl,omitempty"`
+}
+
+// WorkflowOrder is the order the order workflow is signalled with
+func (o Order) WorkflowOrder() orders.Order {
+	return orders.Order{
+		ID:          o.ID,
+		Item:        o.Item,
+		Price:       o.Price,
+		By:          o.By,
+		LoyaltyID:   o.LoyaltyID,
+		CallbackURL: o.CallbackURL,
+	}
+}
+
+// SettleTab is the body of POST /tab/settle
+// Bodies sent before tips and splits existed are customers, only their name is read
+type SettleTab struct {
+	// Name is the customer whose tab is settled
+	Name string `json:"name"`
+	// Tip is paid on top of the total, {"currency": "USD", "minor": 200} or a bare number in the currency of the payments
+	Tip models.Money `json:"tip,omitempty"`
+	// Split shares the total and the tip between payers, such as [{"payer": "anna"}, {"payer": "bo", "parts": 2}]
+	Split []models.Share `json:"split,omitempty"`
+}
+
+// Settlement is the settlement the tab workflow is signalled with
+func (st SettleTab) Settlement() tabs.Settlement {
+	return tabs.Settlement{
+		Tip:   st.Tip,
+		Split: st.Split,
+	}
+}
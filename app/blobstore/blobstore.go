@@ -0,0 +1,102 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"programmingpercy/cadence-tavern/config"
+	"strings"
+	"sync"
+)
+
+// Store is the needed methods to store generated files such as receipts
+// Put returns the URL where the stored blob can be fetched
+type Store interface {
+	Put(ctx context.Context, key string, contentType string, data []byte) (string, error)
+}
+
+// NewStore will create the blob store selected in the configuration
+func NewStore(cfg config.BlobStore) (Store, error) {
+	switch cfg.Type {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("blob store file needs a directory")
+		}
+		return &FileStore{
+			Dir:     cfg.Dir,
+			BaseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown blob store: %s", cfg.Type)
+	}
+}
+
+// Blob is a stored file in the MemoryStore
+type Blob struct {
+	ContentType string
+	Data        []byte
+}
+
+// MemoryStore keeps blobs in Memory
+type MemoryStore struct {
+	sync.RWMutex
+	Blobs map[string]Blob
+}
+
+// NewMemoryStore will init a new in memory blob store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		Blobs: make(map[string]Blob),
+	}
+}
+
+// Put stores the blob in memory and returns a mem:// URL
+func (ms *MemoryStore) Put(ctx context.Context, key string, contentType string, data []byte) (string, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.Blobs[key] = Blob{
+		ContentType: contentType,
+		Data:        data,
+	}
+	return "mem://" + key, nil
+}
+
+// Get returns a stored blob
+func (ms *MemoryStore) Get(key string) (Blob, bool) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	blob, ok := ms.Blobs[key]
+	return blob, ok
+}
+
+// FileStore writes blobs into a directory
+// BaseURL is the URL the directory is served on, if empty a file:// URL is returned
+type FileStore struct {
+	Dir     string
+	BaseURL string
+}
+
+// Put writes the blob to Dir/key
+func (fs *FileStore) Put(ctx context.Context, key string, contentType string, data []byte) (string, error) {
+	path := filepath.Join(fs.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write blob: %v", err)
+	}
+
+	if fs.BaseURL == "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		return "file://" + filepath.ToSlash(abs), nil
+	}
+	return fs.BaseURL + "/" + key, nil
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache holds encoded responses for a while, it is only a shortcut so failures are treated as misses
+type Cache interface {
+	// Get returns the value stored at key if it has not expired
+	Get(key string) ([]byte, bool)
+	// Set stores the value at key for ttl
+	Set(key string, value []byte, ttl time.Duration)
+	// Invalidate removes every value whose key starts with prefix
+	Invalidate(prefix string)
+}
+
+// New creates the cache selected by the configuration, none disables caching
+func New(cfg config.Cache) (Cache, error) {
+	switch cfg.Backend {
+	case "", "none":
+		return Nop{}, nil
+	case "memory":
+		return NewMemoryCache(cfg.Size), nil
+	case "redis":
+		return NewRedisCache(cfg.RedisAddress, cfg.RedisPassword), nil
+	}
+	return nil, fmt.Errorf("unknown cache backend: %s", cfg.Backend)
+}
+
+// Nop never holds anything, it is used when caching is disabled
+type Nop struct{}
+
+func (Nop) Get(key string) ([]byte, bool)                   { return nil, false }
+func (Nop) Set(key string, value []byte, ttl time.Duration) {}
+func (Nop) Invalidate(prefix string)                        {}
+
+// MemoryCache is a least recently used cache in this process, the oldest values are dropped once it holds size values
+type MemoryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// entry is a value in the MemoryCache
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache creates a MemoryCache holding at most size values
+func NewMemoryCache(size int) *MemoryCache {
+	if size <= 0 {
+		size = 1000
+	}
+	return &MemoryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value and marks it as recently used
+func (mc *MemoryCache) Get(key string) ([]byte, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	el, ok := mc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		mc.remove(el)
+		return nil, false
+	}
+	mc.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores the value, the least recently used values are dropped when the cache is full
+func (mc *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if el, ok := mc.entries[key]; ok {
+		mc.remove(el)
+	}
+	mc.entries[key] = mc.order.PushFront(&entry{key: key, value: value, expires: time.Now().Add(ttl)})
+	for mc.order.Len() > mc.size {
+		mc.remove(mc.order.Back())
+	}
+}
+
+// Invalidate removes the values with the prefix
+func (mc *MemoryCache) Invalidate(prefix string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for key, el := range mc.entries {
+		if strings.HasPrefix(key, prefix) {
+			mc.remove(el)
+		}
+	}
+}
+
+// remove drops the element, the caller holds mu
+func (mc *MemoryCache) remove(el *list.Element) {
+	mc.order.Remove(el)
+	delete(mc.entries, el.Value.(*entry).key)
+}
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisCache shares the cache between every API replica
+// It speaks the small part of the redis protocol it needs, errors are logged and treated as misses
+type RedisCache struct {
+	mu       sync.Mutex
+	address  string
+	password string
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// NewRedisCache creates a RedisCache of the server at address, HOST:PORT, it connects on first use
+func NewRedisCache(address string, password string) *RedisCache {
+	return &RedisCache{address: address, password: password}
+}
+
+// Get reads the value with GET
+func (rc *RedisCache) Get(key string) ([]byte, bool) {
+	reply, err := rc.do("GET", key)
+	if err != nil {
+		log.Println("cache: ", err)
+		return nil, false
+	}
+	value, ok := reply.([]byte)
+	return value, ok
+}
+
+// Set writes the value with SET, redis expires it
+func (rc *RedisCache) Set(key string, value []byte, ttl time.Duration) {
+	if _, err := rc.do("SET", key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		log.Println("cache: ", err)
+	}
+}
+
+// Invalidate scans for the keys with the prefix and deletes them
+func (rc *RedisCache) Invalidate(prefix string) {
+	cursor := "0"
+	for {
+		reply, err := rc.do("SCAN", cursor, "MATCH", escapePattern(prefix)+"*", "COUNT", "100")
+		if err != nil {
+			log.Println("cache: ", err)
+			return
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			log.Println("cache: unexpected SCAN reply")
+			return
+		}
+		next, _ := page[0].([]byte)
+		keys, _ := page[1].([]interface{})
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, key := range keys {
+				if b, ok := key.([]byte); ok {
+					args = append(args, string(b))
+				}
+			}
+			if _, err := rc.do(args...); err != nil {
+				log.Println("cache: ", err)
+				return
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+// do sends the command and reads the reply, the connection is dropped on failure and made again by the next command
+func (rc *RedisCache) do(args ...string) (interface{}, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.conn == nil {
+		if err := rc.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := rc.command(args...)
+	if err != nil {
+		var redisErr redisError
+		if !errors.As(err, &redisErr) {
+			rc.conn.Close()
+			rc.conn = nil
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+// connect dials the server and authenticates, the caller holds mu
+func (rc *RedisCache) connect() error {
+	conn, err := net.DialTimeout("tcp", rc.address, time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %v", err)
+	}
+	rc.conn = conn
+	rc.reader = bufio.NewReader(conn)
+	if rc.password == "" {
+		return nil
+	}
+	if _, err := rc.command("AUTH", rc.password); err != nil {
+		conn.Close()
+		rc.conn = nil
+		return fmt.Errorf("failed to authenticate with redis: %v", err)
+	}
+	return nil
+}
+
+// command writes the command as a array of bulk strings and reads one reply, the caller holds mu
+func (rc *RedisCache) command(args ...string) (interface{}, error) {
+	rc.conn.SetDeadline(time.Now().Add(time.Second))
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := rc.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(rc.reader)
+}
+
+// redisError is a error reply, the connection is still usable after it
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readReply reads a reply, bulk strings are []byte, arrays are []interface{} and a missing value is nil
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("malformed redis reply: %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply: %q", line)
+}
+
+// escapePattern escapes the glob characters of a SCAN pattern
+func escapePattern(s string) string {
+	var escaped []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, s[i])
+	}
+	return string(escaped)
+}
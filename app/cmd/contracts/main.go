@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"reflect"
+
+	// Every package registering activities is imported so its init runs
+	_ "programmingpercy/cadence-tavern/workflows/flags"
+	_ "programmingpercy/cadence-tavern/workflows/greetings"
+	_ "programmingpercy/cadence-tavern/workflows/notify"
+	_ "programmingpercy/cadence-tavern/workflows/orders"
+	_ "programmingpercy/cadence-tavern/workflows/payments"
+	_ "programmingpercy/cadence-tavern/workflows/receipts"
+	_ "programmingpercy/cadence-tavern/workflows/seating"
+	_ "programmingpercy/cadence-tavern/workflows/session"
+	_ "programmingpercy/cadence-tavern/workflows/slo"
+	_ "programmingpercy/cadence-tavern/workflows/tabs"
+)
+
+// contracts verifies that the activity names and payloads match the checked in contracts, run it from the module root
+//
+//	contracts         fails when an activity was added, removed or changed its payload
+//	contracts -write  updates the contracts after a deliberate change
+func main() {
+	file := flag.String("file", registry.ContractsFile, "the contracts file")
+	write := flag.Bool("write", false, "write the contracts of the code to the file instead of verifying")
+	flag.Parse()
+
+	current := registry.Contracts()
+	if *write {
+		data, err := json.MarshalIndent(current, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*file, append(data, '\n'), 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %d contracts to %s", len(current), *file)
+		return
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var checkedIn []registry.Contract
+	if err := json.Unmarshal(data, &checkedIn); err != nil {
+		log.Fatalf("failed to read %s: %v", *file, err)
+	}
+
+	problems := compare(checkedIn, current)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d contract problems, run with -write if the change is deliberate\n", len(problems))
+		os.Exit(1)
+	}
+	log.Printf("%d contracts match", len(current))
+}
+
+// compare lists the activities that differ between the checked in and current contracts
+func compare(checkedIn, current []registry.Contract) []string {
+	expected := make(map[string]registry.Contract, len(checkedIn))
+	for _, contract := range checkedIn {
+		expected[contract.Name] = contract
+	}
+
+	var problems []string
+	for _, contract := range current {
+		old, ok := expected[contract.Name]
+		delete(expected, contract.Name)
+		switch {
+		case !ok:
+			problems = append(problems, "new activity: "+contract.Name)
+		case !reflect.DeepEqual(old, contract):
+			problems = append(problems, "payload changed: "+contract.Name)
+		}
+	}
+	for _, contract := range checkedIn {
+		if _, ok := expected[contract.Name]; ok {
+			problems = append(problems, "activity removed: "+contract.Name)
+		}
+	}
+	return problems
+}
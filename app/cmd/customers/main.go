@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// customers is a small CLI used to export and import customers through the API
+//
+//	customers export -format csv -file customers.csv
+//	customers import -format csv -conflict merge -file customers.csv
+//	customers export -location north -file north.json
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	api := fs.String("api", "http://localhost:8080", "address of the tavern API")
+	format := fs.String("format", "json", "json or csv")
+	file := fs.String("file", "", "file to read or write, defaults to stdin/stdout")
+	conflict := fs.String("conflict", "skip", "import conflict resolution: skip, overwrite or merge")
+	loc := fs.String("location", "", "location whose customers are exported or imported, empty is the default location")
+	fs.Parse(os.Args[2:])
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = export(*api, *loc, *format, *file)
+	case "import":
+		err = importCustomers(*api, *loc, *format, *conflict, *file)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// export downloads all customers of the location and writes them to file
+func export(api, loc, format, file string) error {
+	resp, err := http.Get(api + "/customers?format=" + url.QueryEscape(format) + "&location=" + url.QueryEscape(loc))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("export failed with %d: %s", resp.StatusCode, body)
+	}
+
+	out := os.Stdout
+	if file != "" {
+		out, err = os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// importCustomers uploads the customers in file to the location
+func importCustomers(api, loc, format, conflict, file string) error {
+	in := os.Stdin
+	if file != "" {
+		var err error
+		in, err = os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	contentType := "application/json"
+	if format == "csv" {
+		contentType = "text/csv"
+	}
+	target := fmt.Sprintf("%s/customers?format=%s&conflict=%s&location=%s", api, url.QueryEscape(format), url.QueryEscape(conflict), url.QueryEscape(loc))
+	resp, err := http.Post(target, contentType, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("import failed with %d: %s", resp.StatusCode, body)
+	}
+	fmt.Println(string(body))
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: customers export|import [-api url] [-format json|csv] [-file path] [-conflict skip|overwrite|merge] [-location name]")
+	os.Exit(2)
+}
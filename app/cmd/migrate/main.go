@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/migrations"
+	"strings"
+)
+
+// migrate copies data from one repository backend to another
+//
+//	migrate -from sqlite -from-dsn tavern.db -to postgres -to-dsn postgres://tavern@localhost/tavern -batch 500
+//
+// The repositories are scoped by location, -locations lists the locations to copy besides the default location
+// The schema of a SQL backend is migrated with up, version reports how far it is migrated
+//
+//	migrate up -backend postgres -dsn postgres://tavern@localhost/tavern
+//	migrate version -backend sqlite -dsn tavern.db
+func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "up" || os.Args[1] == "version") {
+		schema(os.Args[1], os.Args[2:])
+		return
+	}
+
+	from := flag.String("from", "sqlite", "backend to copy from: memory, file, sqlite or postgres")
+	fromDSN := flag.String("from-dsn", "", "connection string of the source backend")
+	to := flag.String("to", "postgres", "backend to copy to: memory, file, sqlite or postgres")
+	toDSN := flag.String("to-dsn", "", "connection string of the target backend")
+	batchSize := flag.Int("batch", 100, "how many records to copy between progress reports")
+	dryRun := flag.Bool("dry-run", false, "read the source and report what would be copied without writing")
+	locationList := flag.String("locations", "", "comma separated locations to copy besides the default location")
+	flag.Parse()
+
+	if *batchSize <= 0 {
+		fmt.Fprintln(os.Stderr, "batch must be positive")
+		os.Exit(2)
+	}
+
+	source, err := customer.Open(*from, *fromDSN)
+	if err != nil {
+		log.Fatalf("failed to open source: %v", err)
+	}
+	target, err := customer.Open(*to, *toDSN)
+	if err != nil {
+		log.Fatalf("failed to open target: %v", err)
+	}
+
+	// Interrupting the migration cancels the repository call in flight, a batch is either stored or not
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	locations := []string{""}
+	for _, loc := range strings.Split(*locationList, ",") {
+		if loc = strings.TrimSpace(loc); loc != "" {
+			locations = append(locations, loc)
+		}
+	}
+
+	// Every kind of data that should be migrated gets a step here
+	steps := []struct {
+		name string
+		run  func() (int, error)
+	}{
+		{"customers", func() (int, error) { return migrateCustomers(ctx, source, target, locations, *batchSize, *dryRun) }},
+	}
+
+	for _, step := range steps {
+		n, err := step.run()
+		if err != nil {
+			log.Fatalf("failed to migrate %s after %d records: %v", step.name, n, err)
+		}
+		if *dryRun {
+			log.Printf("dry-run: would migrate %d %s", n, step.name)
+		} else {
+			log.Printf("migrated %d %s", n, step.name)
+		}
+	}
+}
+
+// migrateCustomers copies all customers of the locations in batches, reporting progress after each batch
+func migrateCustomers(ctx context.Context, source, target customer.Repository, locations []string, batchSize int, dryRun bool) (int, error) {
+	var customers []customer.Customer
+	for _, loc := range locations {
+		found, err := source.List(ctx, loc)
+		if err != nil {
+			return 0, err
+		}
+		customers = append(customers, found...)
+	}
+
+	copied := 0
+	for start := 0; start < len(customers); start += batchSize {
+		end := start + batchSize
+		if end > len(customers) {
+			end = len(customers)
+		}
+
+		// Each batch is stored in one call, a failing batch leaves none of its customers behind
+		if !dryRun {
+			if err := target.UpdateMany(ctx, customers[start:end]); err != nil {
+				return copied, err
+			}
+		}
+		copied += end - start
+		log.Printf("customers: %d/%d (%.0f%%)", copied, len(customers), float64(copied)/float64(len(customers))*100)
+	}
+	return copied, nil
+}
+
+// schema runs the up or version command against the database of a SQL backend
+func schema(command string, args []string) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	backend := flags.String("backend", "sqlite", "backend to migrate: sqlite or postgres")
+	dsn := flags.String("dsn", "", "connection string of the backend")
+	flags.Parse(args)
+
+	driver := *backend
+	if driver == "sqlite" {
+		driver = "sqlite3"
+	}
+	db, err := sql.Open(driver, *dsn)
+	if err != nil {
+		log.Fatalf("failed to open %s: %v", *backend, err)
+	}
+	defer db.Close()
+
+	if command == "up" {
+		if err := migrations.Up(command, db, driver); err != nil {
+			log.Fatalf("failed to migrate: %v", err)
+		}
+	}
+	status, err := migrations.Current(db, driver)
+	if err != nil {
+		log.Fatalf("failed to read the schema version: %v", err)
+	}
+	data, _ := json.MarshalIndent(status, "", "  ")
+	fmt.Println(string(data))
+}
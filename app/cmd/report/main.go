@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"time"
+
+	// The packages registering workflows are imported so -type can be a short name
+	_ "programmingpercy/cadence-tavern/workflows/greetings"
+	_ "programmingpercy/cadence-tavern/workflows/orders"
+	_ "programmingpercy/cadence-tavern/workflows/seating"
+	_ "programmingpercy/cadence-tavern/workflows/session"
+	_ "programmingpercy/cadence-tavern/workflows/tabs"
+
+	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/client"
+	"go.uber.org/thriftrw/protocol"
+	"go.uber.org/yarpc"
+	"go.uber.org/yarpc/transport/grpc"
+)
+
+const (
+	reportClientName = "cadence-report"
+	cadenceService   = "cadence-frontend"
+)
+
+// report scans the histories of closed workflows and prints statistics of each workflow type
+//
+//	report -since 24h
+//	report -from 2024-05-01T00:00:00Z -to 2024-05-02T00:00:00Z -type orders.WorkflowOrder -format csv -out orders.csv
+//
+// The domain and the Cadence server are read from the configuration of the API, the range is on the close time
+// The numbers are used to tune timeouts, retry policies and the thresholds that continue long workflows as new
+func main() {
+	since := flag.Duration("since", 24*time.Hour, "report workflows closed within this long, unused when -from is set")
+	from := flag.String("from", "", "RFC3339 start of the range workflows closed in")
+	to := flag.String("to", "", "RFC3339 end of the range workflows closed in, defaults to now")
+	workflowType := flag.String("type", "", "only report this workflow type, registered names such as orders.WorkflowOrder work")
+	limit := flag.Int("limit", 1000, "most workflows to scan, every one costs a history read")
+	format := flag.String("format", "json", "output format: json or csv")
+	out := flag.String("out", "", "file to write the report to, defaults to stdout")
+	flag.Parse()
+
+	if *format != "json" && *format != "csv" {
+		fmt.Fprintln(os.Stderr, "format must be json or csv")
+		os.Exit(2)
+	}
+	end := time.Now()
+	if *to != "" {
+		parsed, err := time.Parse(time.RFC3339, *to)
+		if err != nil {
+			log.Fatalf("invalid -to: %v", err)
+		}
+		end = parsed
+	}
+	start := end.Add(-*since)
+	if *from != "" {
+		parsed, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			log.Fatalf("invalid -from: %v", err)
+		}
+		start = parsed
+	}
+	name := *workflowType
+	if resolved, ok := registry.Resolve(name); ok {
+		name = resolved
+	}
+
+	cfg, err := config.Load(config.APIDefaults())
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+	service, err := newService(cfg.CadenceHost)
+	if err != nil {
+		log.Fatalf("failed to connect to cadence: %v", err)
+	}
+	cadenceClient := client.NewClient(service, cfg.Domain, &client.Options{Identity: cfg.Identity.String(reportClientName)})
+
+	ctx := context.Background()
+	executions, err := listClosed(ctx, service, cfg.Domain, name, start, end, *limit)
+	if err != nil {
+		log.Fatalf("failed to list closed workflows: %v", err)
+	}
+	log.Printf("scanning %d workflows closed between %s and %s", len(executions), start.Format(time.RFC3339), end.Format(time.RFC3339))
+
+	stats := make(map[string]*typeStats)
+	for i, execution := range executions {
+		run, err := scanHistory(ctx, cadenceClient, execution)
+		if err != nil {
+			// A history can be archived or deleted by retention while the report runs
+			log.Printf("skipped %s: %v", execution.Execution.GetWorkflowId(), err)
+			continue
+		}
+		typeName := execution.Type.GetName()
+		if stats[typeName] == nil {
+			stats[typeName] = &typeStats{}
+		}
+		stats[typeName].add(run)
+		if (i+1)%100 == 0 {
+			log.Printf("scanned %d/%d", i+1, len(executions))
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("failed to create %s: %v", *out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := writeReport(w, *format, summarize(stats)); err != nil {
+		log.Fatalf("failed to write the report: %v", err)
+	}
+}
+
+// newService connects to the Cadence frontend over gRPC, the same way as the API
+func newService(host string) (workflowserviceclient.Interface, error) {
+	dispatcher := yarpc.NewDispatcher(yarpc.Config{
+		Name: reportClientName,
+		Outbounds: yarpc.Outbounds{
+			cadenceService: {Unary: grpc.NewTransport().NewSingleOutbound(host)},
+		},
+	})
+	if err := dispatcher.Start(); err != nil {
+		return nil, err
+	}
+	return workflowserviceclient.New(dispatcher.ClientConfig(cadenceService)), nil
+}
+
+// listClosed pages through the workflows that closed in the range, it works without advanced visibility
+func listClosed(ctx context.Context, service workflowserviceclient.Interface, domain, workflowType string, start, end time.Time, limit int) ([]*shared.WorkflowExecutionInfo, error) {
+	request := &shared.ListClosedWorkflowExecutionsRequest{
+		Domain: &domain,
+		StartTimeFilter: &shared.StartTimeFilter{
+			EarliestTime: int64Ptr(start.UnixNano()),
+			LatestTime:   int64Ptr(end.UnixNano()),
+		},
+	}
+	if workflowType != "" {
+		request.TypeFilter = &shared.WorkflowTypeFilter{Name: &workflowType}
+	}
+
+	var executions []*shared.WorkflowExecutionInfo
+	for len(executions) < limit {
+		pageSize := int32(limit - len(executions))
+		if pageSize > 100 {
+			pageSize = 100
+		}
+		request.MaximumPageSize = &pageSize
+		response, err := service.ListClosedWorkflowExecutions(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, response.Executions...)
+		if len(response.NextPageToken) == 0 {
+			break
+		}
+		request.NextPageToken = response.NextPageToken
+	}
+	if len(executions) > limit {
+		executions = executions[:limit]
+	}
+	return executions, nil
+}
+
+// scanHistory reads the history of a closed run and counts what it cost
+func scanHistory(ctx context.Context, c client.Client, execution *shared.WorkflowExecutionInfo) (runStats, error) {
+	run := runStats{
+		Duration: time.Duration(execution.GetCloseTime() - execution.GetStartTime()),
+		Failed: execution.GetCloseStatus() != shared.WorkflowExecutionCloseStatusCompleted &&
+			execution.GetCloseStatus() != shared.WorkflowExecutionCloseStatusContinuedAsNew,
+	}
+
+	iter := c.GetWorkflowHistory(ctx, execution.Execution.GetWorkflowId(), execution.Execution.GetRunId(), false, shared.HistoryEventFilterTypeAllEvent)
+	counter := &countingWriter{}
+	for iter.HasNext() {
+		event, err := iter.Next()
+		if err != nil {
+			return runStats{}, err
+		}
+		run.Events++
+		// The server limits the size of the history as it is stored, which is thrift encoded
+		if value, err := event.ToWire(); err == nil {
+			protocol.Binary.Encode(value, counter)
+		}
+
+		switch event.GetEventType() {
+		case shared.EventTypeDecisionTaskCompleted:
+			run.Decisions++
+		case shared.EventTypeDecisionTaskFailed, shared.EventTypeDecisionTaskTimedOut:
+			run.DecisionFailures++
+		case shared.EventTypeActivityTaskScheduled:
+			run.Activities++
+		case shared.EventTypeActivityTaskStarted:
+			// An activity retried by its retry policy is only started once in the history, on its last attempt
+			run.ActivityRetries += int(event.ActivityTaskStartedEventAttributes.GetAttempt())
+		case shared.EventTypeActivityTaskTimedOut:
+			run.ActivityTimeouts++
+		}
+	}
+	run.Bytes = counter.n
+	return run, nil
+}
+
+// countingWriter counts the bytes written to it and drops them
+type countingWriter struct {
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += len(p)
+	return len(p), nil
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
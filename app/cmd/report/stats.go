@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// runStats is what a single closed run cost
+type runStats struct {
+	Duration         time.Duration
+	Failed           bool
+	Events           int
+	Bytes            int
+	Decisions        int
+	DecisionFailures int
+	Activities       int
+	ActivityRetries  int
+	ActivityTimeouts int
+}
+
+// typeStats collects the runs of a workflow type
+type typeStats struct {
+	runs []runStats
+}
+
+func (ts *typeStats) add(run runStats) {
+	ts.runs = append(ts.runs, run)
+}
+
+// Row is the report of a workflow type, durations are in seconds so they can be charted
+type Row struct {
+	WorkflowType     string  `json:"workflowType"`
+	Runs             int     `json:"runs"`
+	Failed           int     `json:"failed"`
+	DurationP50      float64 `json:"durationP50Seconds"`
+	DurationP95      float64 `json:"durationP95Seconds"`
+	DurationMax      float64 `json:"durationMaxSeconds"`
+	DecisionsMean    float64 `json:"decisionsMean"`
+	DecisionsMax     int     `json:"decisionsMax"`
+	DecisionFailures int     `json:"decisionFailures"`
+	Activities       int     `json:"activities"`
+	ActivityRetries  int     `json:"activityRetries"`
+	ActivityTimeouts int     `json:"activityTimeouts"`
+	EventsMean       float64 `json:"historyEventsMean"`
+	EventsP95        int     `json:"historyEventsP95"`
+	EventsMax        int     `json:"historyEventsMax"`
+	BytesMean        float64 `json:"historyBytesMean"`
+	BytesP95         int     `json:"historyBytesP95"`
+	BytesMax         int     `json:"historyBytesMax"`
+}
+
+// summarize turns the runs of every type into a row, sorted by workflow type
+func summarize(stats map[string]*typeStats) []Row {
+	rows := make([]Row, 0, len(stats))
+	for name, ts := range stats {
+		row := Row{WorkflowType: name, Runs: len(ts.runs)}
+		var durations []float64
+		var events, bytes []int
+		decisions := 0
+		for _, run := range ts.runs {
+			if run.Failed {
+				row.Failed++
+			}
+			durations = append(durations, run.Duration.Seconds())
+			events = append(events, run.Events)
+			bytes = append(bytes, run.Bytes)
+			decisions += run.Decisions
+			if run.Decisions > row.DecisionsMax {
+				row.DecisionsMax = run.Decisions
+			}
+			row.DecisionFailures += run.DecisionFailures
+			row.Activities += run.Activities
+			row.ActivityRetries += run.ActivityRetries
+			row.ActivityTimeouts += run.ActivityTimeouts
+		}
+		sort.Float64s(durations)
+		sort.Ints(events)
+		sort.Ints(bytes)
+
+		row.DurationP50 = durations[percentile(len(durations), 0.5)]
+		row.DurationP95 = durations[percentile(len(durations), 0.95)]
+		row.DurationMax = durations[len(durations)-1]
+		row.DecisionsMean = float64(decisions) / float64(row.Runs)
+		row.EventsMean = mean(events)
+		row.EventsP95 = events[percentile(len(events), 0.95)]
+		row.EventsMax = events[len(events)-1]
+		row.BytesMean = mean(bytes)
+		row.BytesP95 = bytes[percentile(len(bytes), 0.95)]
+		row.BytesMax = bytes[len(bytes)-1]
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].WorkflowType < rows[j].WorkflowType
+	})
+	return rows
+}
+
+// percentile is the index of the nearest rank percentile p of n sorted values
+func percentile(n int, p float64) int {
+	i := int(float64(n)*p+0.5) - 1
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+func mean(values []int) float64 {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return float64(total) / float64(len(values))
+}
+
+// writeReport writes the rows as JSON or CSV
+func writeReport(w io.Writer, format string, rows []Row) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"workflow_type", "runs", "failed", "duration_p50_seconds", "duration_p95_seconds", "duration_max_seconds",
+		"decisions_mean", "decisions_max", "decision_failures", "activities", "activity_retries", "activity_timeouts",
+		"history_events_mean", "history_events_p95", "history_events_max", "history_bytes_mean", "history_bytes_p95", "history_bytes_max",
+	})
+	for _, row := range rows {
+		cw.Write([]string{
+			row.WorkflowType, strconv.Itoa(row.Runs), strconv.Itoa(row.Failed),
+			formatFloat(row.DurationP50), formatFloat(row.DurationP95), formatFloat(row.DurationMax),
+			formatFloat(row.DecisionsMean), strconv.Itoa(row.DecisionsMax), strconv.Itoa(row.DecisionFailures),
+			strconv.Itoa(row.Activities), strconv.Itoa(row.ActivityRetries), strconv.Itoa(row.ActivityTimeouts),
+			formatFloat(row.EventsMean), strconv.Itoa(row.EventsP95), strconv.Itoa(row.EventsMax),
+			formatFloat(row.BytesMean), strconv.Itoa(row.BytesP95), strconv.Itoa(row.BytesMax),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
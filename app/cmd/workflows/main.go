@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// workflows is a small CLI used to debug and clean up workflows through the API
+//
+//	workflows stack -id orders
+//	workflows stack -id north/orders -run <run id>
+//	workflows reset -id orders -reason "bad deploy" [-event 42]
+//	workflows pause -id orders -reason "cellar maintenance"
+//	workflows resume -id orders
+//	workflows paused -id orders
+//	workflows batch -action terminate -reason stale -query "WorkflowType = '...' AND CloseTime = missing" [-dry-run=false]
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	api := fs.String("api", "http://localhost:8080", "address of the tavern API")
+	id := fs.String("id", "", "ID of the workflow")
+	run := fs.String("run", "", "run ID of the workflow, defaults to the current run")
+	event := fs.Int64("event", 0, "decision completed event to reset to, defaults to the last good decision")
+	reason := fs.String("reason", "", "why the workflow is reset, paused or terminated, it is kept in the history")
+	yes := fs.Bool("yes", false, "reset without asking for confirmation")
+	query := fs.String("query", "", "visibility query selecting the workflows of a batch")
+	action := fs.String("action", "cancel", "what a batch does to each workflow, cancel or terminate")
+	dryRun := fs.Bool("dry-run", true, "only list the workflows a batch would act on")
+	limit := fs.Int("limit", 0, "most workflows a batch acts on, defaults to the API maximum")
+	rate := fs.Float64("rate", 0, "workflows a batch acts on each second, defaults to the API rate")
+	fs.Parse(os.Args[2:])
+	if *id == "" && os.Args[1] != "batch" {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "stack":
+		err = stack(*api, *id, *run)
+	case "reset":
+		err = reset(*api, *id, *run, *event, *reason, *yes)
+	case "pause":
+		err = signal(*api, *id, "pause", map[string]string{"reason": *reason})
+	case "resume":
+		err = signal(*api, *id, "resume", nil)
+	case "paused":
+		err = get(*api + "/workflows/" + *id + "/paused?run_id=" + url.QueryEscape(*run))
+	case "batch":
+		err = batch(*api, map[string]interface{}{
+			"query": *query, "action": *action, "reason": *reason, "dry_run": *dryRun, "limit": *limit, "rate": *rate,
+		})
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// stack prints the goroutine dump of the workflow
+func stack(api, id, run string) error {
+	return get(api + "/workflows/" + id + "/stack?run_id=" + url.QueryEscape(run))
+}
+
+// signal sends the pause or resume action to the workflow
+func signal(api, id, action string, body interface{}) error {
+	if err := post(api+"/workflows/"+id+"/"+action, body, http.StatusOK, nil); err != nil {
+		return err
+	}
+	fmt.Println("Sent", action, "to", id)
+	return nil
+}
+
+// get prints the body of the response
+func get(target string) error {
+	resp, err := http.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with %d: %s", resp.StatusCode, body)
+	}
+	fmt.Println(string(body))
+	return nil
+}
+
+// reset asks the API for a reset plan, shows it and confirms it with the token of the plan
+func reset(api, id, run string, event int64, reason string, yes bool) error {
+	var plan struct {
+		RunID   string `json:"run_id"`
+		EventID int64  `json:"event_id"`
+		Token   string `json:"token"`
+	}
+	req := map[string]interface{}{"run_id": run, "event_id": event, "reason": reason}
+	if err := post(api+"/workflows/"+id+"/reset", req, http.StatusAccepted, &plan); err != nil {
+		return err
+	}
+
+	fmt.Printf("Reset %s run %s to event %d\n", id, plan.RunID, plan.EventID)
+	if !yes {
+		fmt.Print("Type yes to continue: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			return fmt.Errorf("reset aborted")
+		}
+	}
+
+	var result struct {
+		RunID string `json:"run_id"`
+	}
+	req = map[string]interface{}{"run_id": plan.RunID, "event_id": plan.EventID, "reason": reason, "token": plan.Token}
+	if err := post(api+"/workflows/"+id+"/reset", req, http.StatusOK, &result); err != nil {
+		return err
+	}
+	fmt.Println("Workflow continues as run", result.RunID)
+	return nil
+}
+
+// batch runs the batch and prints the result of the API
+func batch(api string, req map[string]interface{}) error {
+	var result json.RawMessage
+	if err := post(api+"/workflows/batch", req, http.StatusOK, &result); err != nil {
+		return err
+	}
+	fmt.Println(string(result))
+	return nil
+}
+
+// post sends body as JSON and decodes the response into out when the status is the expected one, out can be nil
+func post(target string, body interface{}, status int, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(target, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		return fmt.Errorf("request failed with %d: %s", resp.StatusCode, data)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: workflows stack|reset|pause|resume|paused -id workflow [-run id] [-event id] [-reason text] [-yes] [-api url]")
+	fmt.Fprintln(os.Stderr, "       workflows batch -query text [-action cancel|terminate] [-reason text] [-dry-run=false] [-limit n] [-rate n] [-api url]")
+	os.Exit(2)
+}
@@ -0,0 +1,945 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the resolved configuration used by the Worker and the API
+// Every field can be overridden by the environment variable in its env tag
+// Fields tagged with secret:"true" are redacted when the configuration is dumped, and can hold a reference, see Secrets
+type Config struct {
+	// Domain is the domain you have registered and want to operate in
+	Domain string `env:"TAVERN_DOMAIN" json:"domain"`
+	// TaskList is the identifier for tasks, activites and workflows
+	TaskList string `env:"TAVERN_TASKLIST" json:"taskList"`
+	// TaskListVersion is appended to every task list, such as greetings-v2, so workers of new workflow code poll
+	// task lists of their own. The Worker polls the version, the API starts new workflows on it and can switch at runtime
+	TaskListVersion string `env:"TAVERN_TASKLIST_VERSION" json:"taskListVersion"`
+	// Locations are the taverns served by this deployment besides the default one
+	// Each location gets its own task list, workflows and metric tags
+	Locations []string `env:"TAVERN_LOCATIONS" json:"locations"`
+	// CadenceHost is the Cadence server IP:Port
+	CadenceHost string `env:"TAVERN_CADENCE_HOST" json:"cadenceHost"`
+	// ListenAddress is the IP:PORT the API serves HTTP on, unused by the Worker
+	ListenAddress string `env:"TAVERN_LISTEN_ADDRESS" json:"listenAddress"`
+	// MetricsAddress is the IP:PORT that prometheus scrapes metrics from
+	MetricsAddress string `env:"TAVERN_METRICS_ADDRESS" json:"metricsAddress"`
+	// Metrics configures how timers and histograms are exported to prometheus
+	Metrics Metrics `json:"metrics"`
+	// OpsAddress is the IP:PORT of the operations listener (pprof, expvar, config), empty disables it
+	OpsAddress string `env:"TAVERN_OPS_ADDRESS" json:"opsAddress"`
+	// StrictRegistration stops the Worker at startup if it is missing a workflow the API starts
+	// When false the missing workflows are only logged and reported as a metric
+	StrictRegistration bool `env:"TAVERN_STRICT_REGISTRATION" json:"strictRegistration"`
+	// OrderSupervisorInterval is how often the API checks that the order workflow is open
+	OrderSupervisorInterval time.Duration `env:"TAVERN_ORDER_SUPERVISOR_INTERVAL" json:"orderSupervisorInterval"`
+	// OrderIndexInterval is how often the API catches the order index up with the order events, 0 disables it
+	OrderIndexInterval time.Duration `env:"TAVERN_ORDER_INDEX_INTERVAL" json:"orderIndexInterval"`
+	// LogLevel is the zap level the Worker logs at, such as debug, info or warn
+	LogLevel string `env:"TAVERN_LOG_LEVEL" json:"logLevel"`
+	// Worker tunes the concurrency and rate limits of the Worker
+	Worker Worker `json:"worker"`
+	// Startup is the backoff used while waiting for the Cadence server at startup
+	Startup Startup `json:"startup"`
+	// FaultInjection delays or fails activities on purpose, unused by the API
+	FaultInjection FaultInjection `json:"faultInjection"`
+	// SelfTest runs a ping workflow through the Worker right after it started, unused by the API
+	SelfTest SelfTest `json:"selfTest"`
+	// Canary periodically checks the dependencies of the Worker, unused by the API
+	Canary Canary `json:"canary"`
+	// StockMonitor periodically alerts about items running low, unused by the API
+	StockMonitor StockMonitor `json:"stockMonitor"`
+	// Payroll periodically pays the employees for their shifts, unused by the API
+	Payroll Payroll `json:"payroll"`
+	// Shadow replays recent workflow histories against the Worker before it starts polling, unused by the API
+	Shadow Shadow `json:"shadow"`
+	// Identity describes this replica, it is shown in the Cadence UI describe output
+	Identity Identity `json:"identity"`
+	// Flags are the feature flags read by workflows at start
+	Flags Flags `json:"flags"`
+	// Payments configures how customers are charged
+	Payments Payments `json:"payments"`
+	// Receipts configures how receipts are rendered and stored
+	Receipts Receipts `json:"receipts"`
+	// Notify configures email and sms notifications
+	Notify Notify `json:"notify"`
+	// Callbacks configures the results pushed to the callbackUrl of orders and greetings
+	Callbacks Callbacks `json:"callbacks"`
+	// Repository selects where customers are stored
+	Repository Repository `json:"repository"`
+	// Outbox configures the relay publishing customer changes downstream
+	Outbox Outbox `json:"outbox"`
+	// Tracing configures the opentracing tracer
+	Tracing Tracing `json:"tracing"`
+	// DiscountTiers are the returning customer discounts written as visits=percent, such as 10=5
+	DiscountTiers []string `env:"TAVERN_DISCOUNT_TIERS" json:"discountTiers"`
+	// PayloadEncoding is how workflow inputs and signals are serialized, json or proto
+	// Both are always decoded, so it can be changed one deployment at a time
+	PayloadEncoding string `env:"TAVERN_PAYLOAD_ENCODING" json:"payloadEncoding"`
+	// Tables is the layout of the tables in every location written as id=capacity, such as 1=4
+	Tables []string `env:"TAVERN_TABLES" json:"tables"`
+	// OrderRoundWindow is how long orders of the same customer are collected into one round, 0 processes every order by itself
+	OrderRoundWindow time.Duration `env:"TAVERN_ORDER_ROUND_WINDOW" json:"orderRoundWindow"`
+	// OrderAlertAfter is how long an order may be prepared before the staff is alerted, 0 disables the alert
+	OrderAlertAfter time.Duration `env:"TAVERN_ORDER_ALERT_AFTER" json:"orderAlertAfter"`
+	// RoundParentClosePolicy is what happens to a running round when the order workflow closes: terminate, cancel or abandon
+	RoundParentClosePolicy string `env:"TAVERN_ROUND_PARENT_CLOSE_POLICY" json:"roundParentClosePolicy"`
+	// CustomerOrderLimit is how many orders a customer may make within the window before they are cut off
+	CustomerOrderLimit CustomerOrderLimit `json:"customerOrderLimit"`
+	// OpeningHours are when the taverns take orders, orders outside of them are refused
+	OpeningHours OpeningHours `json:"openingHours"`
+	// IntakeLimit is the units of alcohol a customer is served before their tab is settled, 0 disables it
+	IntakeLimit float64 `env:"TAVERN_INTAKE_LIMIT" json:"intakeLimit"`
+	// ActivityProfiles override the timeouts and retries of a kind of activity, such as payment.startToClose=5m
+	// see profiles.Parse for the fields
+	ActivityProfiles []string `env:"TAVERN_ACTIVITY_PROFILES" json:"activityProfiles"`
+	// LeaderboardSize is how many customers the leaderboard of each location ranks by spend
+	LeaderboardSize int `env:"TAVERN_LEADERBOARD_SIZE" json:"leaderboardSize"`
+	// ActivityQuarantineAfter is how many times an activity may panic on the same payload before the payload is
+	// quarantined with the dead letters and fails right away, 0 keeps retrying it
+	ActivityQuarantineAfter int `env:"TAVERN_ACTIVITY_QUARANTINE_AFTER" json:"activityQuarantineAfter"`
+	// HistoryLimit is how many events the order and tab workflows record before they continue as new, 0 disables it
+	HistoryLimit int `env:"TAVERN_HISTORY_LIMIT" json:"historyLimit"`
+	// Equipment configures the bar equipment drinks are poured with
+	Equipment Equipment `json:"equipment"`
+	// Deliveries configures the webhook suppliers report the status of their deliveries to, unused by the Worker
+	Deliveries Deliveries `json:"deliveries"`
+	// Stock is what each location has of the items on the menu written as item=count, such as Mead=20
+	// Items that are not listed are never out of stock
+	Stock []string `env:"TAVERN_STOCK" json:"stock"`
+	// SLO are the objectives burn rates are reported against
+	SLO SLO `json:"slo"`
+	// Sessions makes the API greet customers in a session workflow that lasts the whole visit, unused by the Worker
+	// Turn it on once every worker runs the session workflow
+	Sessions bool `env:"TAVERN_SESSIONS" json:"sessions"`
+	// Standalone runs the workflows inside the API instead of on a Cadence server, for demos on a laptop without
+	// docker-compose. Nothing survives a restart of the API, unused by the Worker
+	Standalone bool `env:"TAVERN_STANDALONE" json:"standalone"`
+	// WorkflowAllowlist are the workflows the API may start on request, by their registered name or the end of it
+	// such as orders.WorkflowOrder, unused by the Worker
+	WorkflowAllowlist []string `env:"TAVERN_WORKFLOW_ALLOWLIST" json:"workflowAllowlist"`
+	// Cache configures the response cache of the read heavy routes of the API, unused by the Worker
+	Cache Cache `json:"cache"`
+	// CORS configures the browser origins allowed to call the API, unused by the Worker
+	CORS CORS `json:"cors"`
+	// Auth identifies the callers of the API by their API key, unused by the Worker
+	Auth Auth `json:"auth"`
+	// Admission turns orders away while the workers are behind, unused by the Worker
+	Admission Admission `json:"admission"`
+	// ResetSecret signs the confirmation tokens of workflow resets, every API replica needs the same secret
+	// When empty each replica generates its own and a reset has to be confirmed on the replica that asked for it
+	ResetSecret string `env:"TAVERN_RESET_SECRET" json:"resetSecret" secret:"true"`
+	// Secrets configures the providers the secret fields can be read from, see Secrets
+	Secrets Secrets `json:"secrets"`
+}
+
+// SLO are the service level objectives of greetings and orders
+// A request is good when it succeeds within the latency, the target is the share of good requests
+type SLO struct {
+	GreetingsTarget  float64       `env:"TAVERN_SLO_GREETINGS_TARGET" json:"greetingsTarget"`
+	GreetingsLatency time.Duration `env:"TAVERN_SLO_GREETINGS_LATENCY" json:"greetingsLatency"`
+	OrdersTarget     float64       `env:"TAVERN_SLO_ORDERS_TARGET" json:"ordersTarget"`
+	OrdersLatency    time.Duration `env:"TAVERN_SLO_ORDERS_LATENCY" json:"ordersLatency"`
+}
+
+// The modes of the Shadow
+const (
+	// ShadowOff starts polling right away
+	ShadowOff = "off"
+	// ShadowGate replays before polling and stops the Worker from starting when a replay is not deterministic
+	ShadowGate = "gate"
+	// ShadowOnly replays and exits without polling, used by canary jobs of a deployment
+	ShadowOnly = "only"
+)
+
+// Shadow configures the workflow shadower, it catches changes that break the replay of running workflows
+type Shadow struct {
+	// Mode is off, gate or only
+	Mode string `env:"TAVERN_SHADOW_MODE" json:"mode"`
+	// SamplingRate is the share of the matching workflows that are replayed, between 0 and 1
+	SamplingRate float64 `env:"TAVERN_SHADOW_SAMPLING_RATE" json:"samplingRate"`
+	// WorkflowTypes are the workflows replayed by their registered name or the end of it, empty replays every type
+	WorkflowTypes []string `env:"TAVERN_SHADOW_WORKFLOW_TYPES" json:"workflowTypes"`
+	// WorkflowStatus are the statuses replayed such as OPEN, CLOSED or ALL
+	WorkflowStatus []string `env:"TAVERN_SHADOW_WORKFLOW_STATUS" json:"workflowStatus"`
+	// StartedWithin only replays workflows started this recently, 0 replays all
+	StartedWithin time.Duration `env:"TAVERN_SHADOW_STARTED_WITHIN" json:"startedWithin"`
+	// Count is the most workflows replayed
+	Count int `env:"TAVERN_SHADOW_COUNT" json:"count"`
+	// Timeout is how long shadowing can take, the workflows replayed by then are enough
+	Timeout time.Duration `env:"TAVERN_SHADOW_TIMEOUT" json:"timeout"`
+}
+
+// Worker tunes the cadence worker, zero leaves the cadence default
+type Worker struct {
+	// MaxConcurrentActivities is how many activities the Worker runs at the same time
+	MaxConcurrentActivities int `env:"TAVERN_WORKER_MAX_ACTIVITIES" json:"maxConcurrentActivities"`
+	// MaxConcurrentDecisions is how many decision tasks the Worker runs at the same time
+	MaxConcurrentDecisions int `env:"TAVERN_WORKER_MAX_DECISIONS" json:"maxConcurrentDecisions"`
+	// ActivitiesPerSecond rate limits how many activities the Worker starts each second
+	ActivitiesPerSecond float64 `env:"TAVERN_WORKER_ACTIVITIES_PER_SECOND" json:"activitiesPerSecond"`
+	// StopTimeout is how long the Worker waits for running tasks to finish when it is stopped, the rest are abandoned
+	StopTimeout time.Duration `env:"TAVERN_WORKER_STOP_TIMEOUT" json:"stopTimeout"`
+}
+
+// Startup configures the exponential backoff used when connecting to Cadence
+type Startup struct {
+	InitialInterval time.Duration `env:"TAVERN_STARTUP_INITIAL_INTERVAL" json:"initialInterval"`
+	MaxInterval     time.Duration `env:"TAVERN_STARTUP_MAX_INTERVAL" json:"maxInterval"`
+	// MaxElapsedTime is how long to keep retrying before the binary gives up and exits
+	MaxElapsedTime time.Duration `env:"TAVERN_STARTUP_MAX_ELAPSED" json:"maxElapsedTime"`
+}
+
+// startupDefaults keeps retrying for two minutes, enough for a Cadence server to boot next to us
+func startupDefaults() Startup {
+	return Startup{
+		InitialInterval: 500 * time.Millisecond,
+		MaxInterval:     10 * time.Second,
+		MaxElapsedTime:  2 * time.Minute,
+	}
+}
+
+// defaultTables are two tables for two and two tables for four, the worker and API need the same layout
+func defaultTables() []string {
+	return []string{"1=2", "2=2", "3=4", "4=4"}
+}
+
+// Metrics configures the prometheus reporter
+type Metrics struct {
+	// Backend is where metrics go: prometheus scrapes MetricsAddress, statsd, m3 and otlp are sent to Endpoint
+	Backend string `env:"TAVERN_METRICS_BACKEND" json:"backend"`
+	// Endpoint is the HOST:PORT of the statsd or m3 agent, or the URL of the OTLP collector such as http://otel:4318/v1/metrics
+	Endpoint string `env:"TAVERN_METRICS_ENDPOINT" json:"endpoint"`
+	// Service and Env tell the senders apart on m3 and OTLP, prometheus gets this from the scrape target
+	Service string `env:"TAVERN_METRICS_SERVICE" json:"service"`
+	Env     string `env:"TAVERN_METRICS_ENV" json:"env"`
+	// PushInterval is how often metrics are sent to the OTLP collector
+	PushInterval time.Duration `env:"TAVERN_METRICS_PUSH_INTERVAL" json:"pushInterval"`
+	// TimerType is histogram or summary, histograms can be aggregated across replicas
+	TimerType string `env:"TAVERN_METRICS_TIMER_TYPE" json:"timerType"`
+	// TimerBuckets are the upper bounds in seconds of the buckets timers are reported in
+	TimerBuckets []float64 `env:"TAVERN_METRICS_TIMER_BUCKETS" json:"timerBuckets"`
+	// DefaultBuckets are the buckets of histograms created without their own buckets
+	DefaultBuckets []float64 `env:"TAVERN_METRICS_BUCKETS" json:"defaultBuckets"`
+	// MaxTagValues is how many values a tag may have on one metric, later values are reported as other
+	// 0 leaves the tags uncapped
+	MaxTagValues int `env:"TAVERN_METRICS_MAX_TAG_VALUES" json:"maxTagValues"`
+	// HashedTags are tags that identify people, such as customer, their values are hashed into HashBuckets buckets
+	HashedTags  []string `env:"TAVERN_METRICS_HASHED_TAGS" json:"hashedTags"`
+	HashBuckets int      `env:"TAVERN_METRICS_HASH_BUCKETS" json:"hashBuckets"`
+}
+
+// latencyBuckets covers everything from a fast activity to a slow workflow, in seconds
+func latencyBuckets() []float64 {
+	return []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+}
+
+// Tracing selects the tracer
+type Tracing struct {
+	// Type is jaeger or noop
+	Type string `env:"TAVERN_TRACER" json:"type"`
+	// AgentHost is the HOST:PORT of the jaeger agent
+	AgentHost string `env:"TAVERN_TRACER_AGENT" json:"agentHost"`
+	// SampleRate is the share of traces sampled when no rule matches, between 0 and 1
+	SampleRate float64 `env:"TAVERN_TRACER_SAMPLE_RATE" json:"sampleRate"`
+	// SamplingRules are pattern=rate pairs matched against the root operation, such as greetings=0.1
+	SamplingRules []string `env:"TAVERN_TRACER_SAMPLING" json:"samplingRules"`
+}
+
+// CORS configures cross origin requests, so dashboards hosted somewhere else can call the API from a browser
+type CORS struct {
+	// AllowedOrigins are the origins such as https://dashboard.example.com, * allows any, empty disables CORS
+	AllowedOrigins []string `env:"TAVERN_CORS_ORIGINS" json:"allowedOrigins"`
+	// AllowedMethods and AllowedHeaders are what preflight requests are allowed to ask for
+	AllowedMethods []string `env:"TAVERN_CORS_METHODS" json:"allowedMethods"`
+	AllowedHeaders []string `env:"TAVERN_CORS_HEADERS" json:"allowedHeaders"`
+	// ExposedHeaders are the response headers scripts can read
+	ExposedHeaders []string `env:"TAVERN_CORS_EXPOSED_HEADERS" json:"exposedHeaders"`
+	// AllowCredentials lets the browser send cookies and authorization, the origin is then echoed instead of *
+	AllowCredentials bool `env:"TAVERN_CORS_CREDENTIALS" json:"allowCredentials"`
+	// MaxAge is how long browsers cache a preflight
+	MaxAge time.Duration `env:"TAVERN_CORS_MAX_AGE" json:"maxAge"`
+}
+
+// Cache configures the response cache of the API
+type Cache struct {
+	// Backend is none, memory or redis, the memory cache is per replica
+	Backend string `env:"TAVERN_CACHE" json:"backend"`
+	// Size is how many responses the memory cache holds
+	Size int `env:"TAVERN_CACHE_SIZE" json:"size"`
+	// RedisAddress is the HOST:PORT of the redis server
+	RedisAddress  string `env:"TAVERN_CACHE_REDIS_ADDRESS" json:"redisAddress"`
+	RedisPassword string `env:"TAVERN_CACHE_REDIS_PASSWORD" json:"redisPassword" secret:"true"`
+	// TTL is how long the menu and customers are cached, changes through the API invalidate them right away
+	TTL time.Duration `env:"TAVERN_CACHE_TTL" json:"ttl"`
+	// StatusTTL is how long tabs and order statuses are cached, workflows change them without the API knowing so it is short
+	StatusTTL time.Duration `env:"TAVERN_CACHE_STATUS_TTL" json:"statusTtl"`
+}
+
+// Admission configures the backpressure on the order route of the API
+type Admission struct {
+	// MaxBacklog is how many tasks may wait on the task list of a location before orders are refused, 0 disables it
+	MaxBacklog int64 `env:"TAVERN_ADMISSION_MAX_BACKLOG" json:"maxBacklog"`
+	// CheckInterval is how long the backlog of a task list is cached before it is asked for again
+	CheckInterval time.Duration `env:"TAVERN_ADMISSION_CHECK_INTERVAL" json:"checkInterval"`
+	// RetryAfter is sent to refused callers as the Retry-After header
+	RetryAfter time.Duration `env:"TAVERN_ADMISSION_RETRY_AFTER" json:"retryAfter"`
+}
+
+// Outbox configures the outbox relay
+type Outbox struct {
+	// PublishURL receives every outbox entry as a POST, when empty entries are only logged
+	PublishURL string `env:"TAVERN_OUTBOX_URL" json:"publishUrl"`
+	// Interval is how often the outbox is polled
+	Interval time.Duration `env:"TAVERN_OUTBOX_INTERVAL" json:"interval"`
+}
+
+// Repository selects the storage backend
+type Repository struct {
+	// Backend is memory, file, sqlite or postgres
+	Backend string `env:"TAVERN_REPOSITORY" json:"backend"`
+	// DSN is the connection string of the backend, it can contain credentials. It is the path of the file backend
+	DSN string `env:"TAVERN_REPOSITORY_DSN" json:"dsn" secret:"true"`
+	// SlowQuery is how long a call of the customer repository may take before it is logged, 0 disables the logging
+	SlowQuery time.Duration `env:"TAVERN_REPOSITORY_SLOW_QUERY" json:"slowQuery"`
+	// CacheTTL is how long customers are cached in front of the backend, 0 disables the cache
+	// Changes made by this process are seen right away, changes made by other replicas once the ttl ran out
+	// The activities that check bans read past the cache, so a ban made through the API is seen at once
+	CacheTTL time.Duration `env:"TAVERN_REPOSITORY_CACHE_TTL" json:"cacheTtl"`
+	// CacheSize is how many customers the cache holds
+	CacheSize int `env:"TAVERN_REPOSITORY_CACHE_SIZE" json:"cacheSize"`
+}
+
+// Notify configures how notifications are delivered
+type Notify struct {
+	// DryRun keeps notifications in memory instead of sending them
+	DryRun bool `env:"TAVERN_NOTIFY_DRY_RUN" json:"dryRun"`
+	// From is the sender used for emails and text messages
+	From string `env:"TAVERN_NOTIFY_FROM" json:"from"`
+	// OpsEmail receives operational alerts
+	OpsEmail string `env:"TAVERN_NOTIFY_OPS_EMAIL" json:"opsEmail"`
+	// StaffEmail receives the alerts about orders that take too long, OpsEmail does when it is empty
+	StaffEmail string `env:"TAVERN_NOTIFY_STAFF_EMAIL" json:"staffEmail"`
+	// SMTPHost is the HOST:PORT of the SMTP server, empty disables email
+	SMTPHost     string `env:"TAVERN_SMTP_HOST" json:"smtpHost"`
+	SMTPUsername string `env:"TAVERN_SMTP_USERNAME" json:"smtpUsername"`
+	SMTPPassword string `env:"TAVERN_SMTP_PASSWORD" json:"smtpPassword" secret:"true"`
+	// SMSGatewayURL is the HTTP gateway text messages are POSTed to, empty disables sms
+	SMSGatewayURL string `env:"TAVERN_SMS_GATEWAY_URL" json:"smsGatewayUrl"`
+	SMSAPIKey     string `env:"TAVERN_SMS_API_KEY" json:"smsApiKey" secret:"true"`
+	// SlackWebhookURL receives the alerts about the Worker itself, such as failing decision tasks, empty disables them
+	// The URL is the credential of the webhook
+	SlackWebhookURL string `env:"TAVERN_NOTIFY_SLACK_WEBHOOK_URL" json:"slackWebhookUrl" secret:"true"`
+	// DecisionAlertCooldown is how long a workflow run with failing decision tasks is not alerted again
+	DecisionAlertCooldown time.Duration `env:"TAVERN_NOTIFY_DECISION_ALERT_COOLDOWN" json:"decisionAlertCooldown"`
+}
+
+// CustomerOrderLimit is the threshold orders of a customer are refused from
+type CustomerOrderLimit struct {
+	// Orders is the most orders within the window, 0 disables the limit
+	Orders int           `env:"TAVERN_CUSTOMER_ORDER_LIMIT" json:"orders"`
+	Window time.Duration `env:"TAVERN_CUSTOMER_ORDER_WINDOW" json:"window"`
+}
+
+// OpeningHours are the hours of the weekdays the taverns take orders
+type OpeningHours struct {
+	// Hours are written as days=open-close, such as mon-thu=16:00-23:00 or fri,sat=16:00-02:00, a close before the
+	// open is on the day after. Without any hours the taverns are always open
+	Hours []string `env:"TAVERN_OPENING_HOURS" json:"hours"`
+	// TimeZone is the IANA zone the hours are in, such as Europe/Stockholm, UTC when empty
+	TimeZone string `env:"TAVERN_OPENING_HOURS_TIMEZONE" json:"timeZone"`
+}
+
+// Auth configures the API keys of the API
+type Auth struct {
+	// Keys are name:role:key entries, such as ops:admin:s3cr3t, the name is recorded in the audit log
+	// The API refuses to start without keys unless Insecure is set
+	Keys []string `env:"TAVERN_AUTH_KEYS" json:"keys" secret:"true"`
+	// Insecure runs the API without keys, every caller is anonymous and allowed to do everything
+	// It is meant for local development and must never be set in production
+	Insecure bool `env:"TAVERN_AUTH_INSECURE" json:"insecure"`
+}
+
+// Callbacks configures the callback deliveries, the API checks the URLs and the Worker delivers them
+type Callbacks struct {
+	// Secret signs every delivery with HMAC-SHA256, empty sends them unsigned
+	Secret string `env:"TAVERN_CALLBACK_SECRET" json:"secret" secret:"true"`
+	// AllowedHosts are the hosts callbacks may be sent to, empty allows every host but loopback, link-local and
+	// private addresses
+	AllowedHosts []string `env:"TAVERN_CALLBACK_ALLOWED_HOSTS" json:"allowedHosts"`
+	// Timeout is how long a single delivery may take
+	Timeout time.Duration `env:"TAVERN_CALLBACK_TIMEOUT" json:"timeout"`
+}
+
+// Receipts configures receipt generation
+type Receipts struct {
+	// Format is either html or pdf
+	Format string `env:"TAVERN_RECEIPT_FORMAT" json:"format"`
+	// Store is where receipts are stored
+	Store BlobStore `json:"store"`
+}
+
+// BlobStore selects where generated files are stored
+type BlobStore struct {
+	// Type is either memory or file
+	Type string `env:"TAVERN_BLOB_STORE" json:"type"`
+	// Dir is the directory used by the file store
+	Dir string `env:"TAVERN_BLOB_DIR" json:"dir"`
+	// BaseURL is the URL that Dir is served on, used to build the returned URLs
+	BaseURL string `env:"TAVERN_BLOB_BASE_URL" json:"baseUrl"`
+}
+
+// Equipment configures the taps and espresso machines, they report back to the API when a pour is done
+type Equipment struct {
+	// Machine is simulator, which reports every pour as done after PourTime, or manual to wait for the real equipment
+	Machine string `env:"TAVERN_EQUIPMENT" json:"machine"`
+	// DoneURL is the done endpoint of the API the simulator reports to
+	DoneURL string `env:"TAVERN_EQUIPMENT_DONE_URL" json:"doneUrl"`
+	// PourTime is how long the simulator takes to pour
+	PourTime time.Duration `env:"TAVERN_EQUIPMENT_POUR_TIME" json:"pourTime"`
+	// Timeout is how long a pour may take before the order fails
+	Timeout time.Duration `env:"TAVERN_EQUIPMENT_TIMEOUT" json:"timeout"`
+	// ReconcileInterval is how often the API fails pours past their timeout, unused by the Worker
+	ReconcileInterval time.Duration `env:"TAVERN_EQUIPMENT_RECONCILE_INTERVAL" json:"reconcileInterval"`
+	// Secrets sign the done calls, the simulator signs with the first and the API accepts any of them so they can be
+	// rotated, the API refuses to start without secrets unless Insecure is set
+	Secrets []string `env:"TAVERN_EQUIPMENT_SECRETS" json:"secrets" secret:"true"`
+	// Insecure accepts unsigned done calls when there are no secrets, it is meant for local development
+	Insecure bool `env:"TAVERN_EQUIPMENT_INSECURE" json:"insecure"`
+	// SignatureTolerance is how far the timestamp of a signed done call may be from the clock of the API
+	SignatureTolerance time.Duration `env:"TAVERN_EQUIPMENT_SIGNATURE_TOLERANCE" json:"signatureTolerance"`
+}
+
+// Deliveries configures how the API accepts the delivery status calls of the suppliers
+type Deliveries struct {
+	// Secrets sign the status calls, the API accepts any of them so they can be rotated, the API refuses to start
+	// without secrets unless Insecure is set
+	Secrets []string `env:"TAVERN_DELIVERY_SECRETS" json:"secrets" secret:"true"`
+	// Insecure accepts unsigned status calls when there are no secrets, it is meant for local development
+	Insecure bool `env:"TAVERN_DELIVERY_INSECURE" json:"insecure"`
+	// SignatureTolerance is how far the timestamp of a signed status call may be from the clock of the API
+	SignatureTolerance time.Duration `env:"TAVERN_DELIVERY_SIGNATURE_TOLERANCE" json:"signatureTolerance"`
+}
+
+// Payments selects and configures the payment provider
+type Payments struct {
+	// Provider is either mock or http
+	Provider string `env:"TAVERN_PAYMENT_PROVIDER" json:"provider"`
+	// URL is the base URL of the http payment provider
+	URL string `env:"TAVERN_PAYMENT_URL" json:"url"`
+	// APIKey is used to authenticate against the http payment provider
+	APIKey string `env:"TAVERN_PAYMENT_API_KEY" json:"apiKey" secret:"true"`
+	// Currency is the currency customers are charged in, prices written without a currency are in it as well
+	// The API and the Worker must agree on it
+	Currency string `env:"TAVERN_PAYMENT_CURRENCY" json:"currency"`
+	// Rates are the other currencies prices are accepted in, written as currency=rate such as eur=1.08 for when one
+	// euro is 1.08 of Currency. Prices in them are converted before they are charged
+	Rates []string `env:"TAVERN_PAYMENT_RATES" json:"rates"`
+	// RatesURL is an exchange rate service used when a customer pays in another currency, when empty Rates are used
+	RatesURL string `env:"TAVERN_PAYMENT_RATES_URL" json:"ratesUrl"`
+	// RatesCacheTTL is how long a rate fetched from RatesURL is used before it is fetched again
+	RatesCacheTTL time.Duration `env:"TAVERN_PAYMENT_RATES_CACHE_TTL" json:"ratesCacheTtl"`
+}
+
+// Flags toggles workflow behavior without redeploying the workflows
+type Flags struct {
+	// ServiceURL is a flag service to fetch flags from, when empty the values below are used
+	ServiceURL string `env:"TAVERN_FLAGS_URL" json:"serviceUrl"`
+	// EnforceAgeCheck makes orders verify the age of the customer
+	EnforceAgeCheck bool `env:"TAVERN_FLAG_ENFORCE_AGE_CHECK" json:"enforceAgeCheck"`
+	// HappyHour halves the price of all orders
+	HappyHour bool `env:"TAVERN_FLAG_HAPPY_HOUR" json:"happyHour"`
+	// RequirePayment makes orders charge the customer before being served
+	RequirePayment bool `env:"TAVERN_FLAG_REQUIRE_PAYMENT" json:"requirePayment"`
+}
+
+// FaultInjection configures the faults injected into the activities of the Worker, see faultinject.Parse for the rules
+// Never enable it for a tavern serving real customers
+type FaultInjection struct {
+	// Enabled wires the faults into the activities, the rules are ignored without it
+	Enabled bool `env:"TAVERN_FAULT_INJECTION" json:"enabled"`
+	// Rules are the faults, such as tavern.payments.charge=fail:0.2 or tavern.orders.*=delay:0.5:3s
+	Rules []string `env:"TAVERN_FAULT_INJECTION_RULES" json:"rules"`
+}
+
+// SelfTest configures the smoke test the Worker runs on itself after starting
+// The result is reported as metrics and on the /readyz endpoint of the ops listener
+type SelfTest struct {
+	// Enabled runs the self test, /readyz only reports the workers as started without it
+	Enabled bool `env:"TAVERN_SELF_TEST" json:"enabled"`
+	// Timeout is how long the ping workflow may take before the self test fails
+	Timeout time.Duration `env:"TAVERN_SELF_TEST_TIMEOUT" json:"timeout"`
+}
+
+// Canary configures the canary workflow the Worker schedules for every location
+type Canary struct {
+	// Schedule is the cron schedule of the canary, such as */5 * * * * for every five minutes, empty disables it
+	Schedule string `env:"TAVERN_CANARY_SCHEDULE" json:"schedule"`
+}
+
+// StockMonitor configures the low stock monitor the Worker schedules for every location
+type StockMonitor struct {
+	// Schedule is the cron schedule of the monitor, such as 0 * * * * for every hour, empty disables it
+	Schedule string `env:"TAVERN_STOCK_MONITOR_SCHEDULE" json:"schedule"`
+	// Thresholds are the counts items alert below written as item=count, such as Mead=5
+	// Items without a threshold never alert
+	Thresholds []string `env:"TAVERN_STOCK_THRESHOLDS" json:"thresholds"`
+	// Restock are the items ordered from the supplier when they alert written as item=quantity, such as Mead=24
+	Restock []string `env:"TAVERN_STOCK_RESTOCK" json:"restock"`
+	// Suppliers are asked for quotes and the cheapest is ordered from, written as name=url such as
+	// brewery=https://brewery.example/api. When empty the orders are only logged
+	Suppliers []string `env:"TAVERN_SUPPLIERS" json:"suppliers"`
+}
+
+// Payroll configures the payroll the Worker schedules for every location and the overtime rules it pays by
+type Payroll struct {
+	// Schedule is the cron schedule the payroll runs on, such as 0 6 * * 1 for Monday mornings, empty disables it
+	// A run only pays once a whole pay period has ended, so the schedule can be more often than the period
+	Schedule string `env:"TAVERN_PAYROLL_SCHEDULE" json:"schedule"`
+	// Period is how long a pay period is, such as 336h for every other week
+	Period time.Duration `env:"TAVERN_PAYROLL_PERIOD" json:"period"`
+	// Anchor is the day one of the pay periods started written as 2006-01-02, the others start a whole number of
+	// periods away from it
+	Anchor string `env:"TAVERN_PAYROLL_ANCHOR" json:"anchor"`
+	// DailyHours are the hours of a day paid at the regular rate, 0 disables the daily overtime
+	DailyHours float64 `env:"TAVERN_PAYROLL_DAILY_HOURS" json:"dailyHours"`
+	// WeeklyHours are the regular hours of a week paid at the regular rate, 0 disables the weekly overtime
+	WeeklyHours float64 `env:"TAVERN_PAYROLL_WEEKLY_HOURS" json:"weeklyHours"`
+	// OvertimeMultiplier is how much more an hour of overtime pays than a regular one, such as 1.5
+	OvertimeMultiplier float64 `env:"TAVERN_PAYROLL_OVERTIME_MULTIPLIER" json:"overtimeMultiplier"`
+}
+
+// Identity is used to tell replicas apart when several workers or clients are deployed
+type Identity struct {
+	// PodName is the name of the replica, defaults to the hostname
+	PodName string `env:"TAVERN_POD_NAME" json:"podName"`
+	// Version is the version of the deployed binary
+	Version string `env:"TAVERN_VERSION" json:"version"`
+	// Region is where the replica runs
+	Region string `env:"TAVERN_REGION" json:"region"`
+}
+
+// String builds the identity for the given service name, empty parts are left out
+// The result looks like greetings-worker@tavern-7f9c@v1.2.0@eu-north-1
+func (i Identity) String(service string) string {
+	pod := i.PodName
+	if pod == "" {
+		pod, _ = os.Hostname()
+	}
+	parts := []string{service}
+	for _, part := range []string{pod, i.Version, i.Region} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "@")
+}
+
+// WorkerDefaults is the configuration used by the Worker when nothing is overridden
+func WorkerDefaults() Config {
+	return Config{
+		Secrets: Secrets{
+			VaultTimeout: 10 * time.Second,
+		},
+		Domain:             "tavern",
+		TaskList:           "greetings",
+		CadenceHost:        "127.0.0.1:7933",
+		MetricsAddress:     "127.0.0.1:9098",
+		OpsAddress:         "127.0.0.1:6060",
+		StrictRegistration: true,
+		LogLevel:           "info",
+		Startup:            startupDefaults(),
+		Worker: Worker{
+			StopTimeout: 30 * time.Second,
+		},
+		Shadow: Shadow{
+			Mode:           ShadowOff,
+			SamplingRate:   0.1,
+			WorkflowStatus: []string{"OPEN"},
+			StartedWithin:  24 * time.Hour,
+			Count:          100,
+			Timeout:        5 * time.Minute,
+		},
+		Metrics: Metrics{
+			Backend:        "prometheus",
+			Service:        "cadence-tavern",
+			Env:            "default",
+			PushInterval:   10 * time.Second,
+			TimerType:      "histogram",
+			TimerBuckets:   latencyBuckets(),
+			DefaultBuckets: latencyBuckets(),
+			MaxTagValues:   100,
+			HashedTags:     []string{"customer", "email", "phone", "loyaltyId"},
+			HashBuckets:    16,
+		},
+		Flags: Flags{
+			EnforceAgeCheck: true,
+		},
+		Payments: Payments{
+			Provider:      "mock",
+			Currency:      "usd",
+			RatesCacheTTL: 10 * time.Minute,
+		},
+		Receipts: Receipts{
+			Format: "html",
+			Store: BlobStore{
+				Type: "memory",
+			},
+		},
+		SelfTest: SelfTest{
+			Timeout: 30 * time.Second,
+		},
+		Canary: Canary{
+			Schedule: "*/5 * * * *",
+		},
+		StockMonitor: StockMonitor{
+			Schedule: "*/15 * * * *",
+		},
+		Payroll: Payroll{
+			Schedule:           "0 6 * * 1",
+			Period:             14 * 24 * time.Hour,
+			Anchor:             "2024-01-01",
+			DailyHours:         8,
+			WeeklyHours:        40,
+			OvertimeMultiplier: 1.5,
+		},
+		Notify: Notify{
+			DryRun:                true,
+			From:                  "tavern@example.com",
+			DecisionAlertCooldown: 15 * time.Minute,
+		},
+		Callbacks: Callbacks{
+			Timeout: 10 * time.Second,
+		},
+		Equipment: Equipment{
+			Machine:  "simulator",
+			DoneURL:  "http://localhost:8080/equipment/done",
+			PourTime: 2 * time.Second,
+			Timeout:  2 * time.Minute,
+		},
+		Repository: Repository{
+			Backend:   "memory",
+			SlowQuery: 250 * time.Millisecond,
+			CacheTTL:  10 * time.Second,
+			CacheSize: 1000,
+		},
+		Outbox: Outbox{
+			Interval: 5 * time.Second,
+		},
+		Tracing: Tracing{
+			Type:          "noop",
+			AgentHost:     "127.0.0.1:6831",
+			SampleRate:    1,
+			SamplingRules: []string{"greetings=0.1"},
+		},
+		DiscountTiers:           []string{"10=5", "25=10"},
+		OrderRoundWindow:        5 * time.Second,
+		OrderAlertAfter:         90 * time.Second,
+		RoundParentClosePolicy:  "terminate",
+		HistoryLimit:            10000,
+		LeaderboardSize:         10,
+		ActivityQuarantineAfter: 3,
+		IntakeLimit:             8,
+		CustomerOrderLimit: CustomerOrderLimit{
+			Orders: 10,
+			Window: time.Hour,
+		},
+		Tables:          defaultTables(),
+		PayloadEncoding: "json",
+		SLO: SLO{
+			GreetingsTarget:  0.99,
+			GreetingsLatency: 2 * time.Second,
+			OrdersTarget:     0.95,
+			OrdersLatency:    30 * time.Second,
+		},
+	}
+}
+
+// APIDefaults is the configuration used by the API when nothing is overridden
+func APIDefaults() Config {
+	return Config{
+		Secrets: Secrets{
+			VaultTimeout: 10 * time.Second,
+		},
+		Domain:                  "tavern",
+		TaskList:                "greetings",
+		CadenceHost:             "localhost:7833",
+		ListenAddress:           "localhost:8080",
+		MetricsAddress:          "127.0.0.1:9099",
+		OpsAddress:              "127.0.0.1:6061",
+		OrderSupervisorInterval: 30 * time.Second,
+		OrderIndexInterval:      30 * time.Second,
+		Tables:                  defaultTables(),
+		WorkflowAllowlist:       []string{"orders.WorkflowOrder", "tabs.WorkflowTab"},
+		PayloadEncoding:         "json",
+		Startup:                 startupDefaults(),
+		Metrics: Metrics{
+			Backend:        "prometheus",
+			Service:        "cadence-tavern",
+			Env:            "default",
+			PushInterval:   10 * time.Second,
+			TimerType:      "histogram",
+			TimerBuckets:   latencyBuckets(),
+			DefaultBuckets: latencyBuckets(),
+			MaxTagValues:   100,
+			HashedTags:     []string{"customer", "email", "phone", "loyaltyId"},
+			HashBuckets:    16,
+		},
+		Equipment: Equipment{
+			ReconcileInterval:  30 * time.Second,
+			SignatureTolerance: 5 * time.Minute,
+		},
+		Deliveries: Deliveries{
+			SignatureTolerance: 5 * time.Minute,
+		},
+		Admission: Admission{
+			MaxBacklog:    1000,
+			CheckInterval: 5 * time.Second,
+			RetryAfter:    10 * time.Second,
+		},
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+			AllowedHeaders: []string{"Content-Type", "Accept", "X-Tavern-Location", "X-Request-ID", "Uber-Trace-Id", "Authorization"},
+			ExposedHeaders: []string{"X-Request-ID", "X-Cache", "Retry-After", "X-Total-Count", "X-Next-Cursor"},
+			MaxAge:         10 * time.Minute,
+		},
+		Cache: Cache{
+			Backend:   "memory",
+			Size:      1000,
+			TTL:       time.Minute,
+			StatusTTL: 2 * time.Second,
+		},
+		Repository: Repository{
+			Backend:   "memory",
+			SlowQuery: 250 * time.Millisecond,
+		},
+		Tracing: Tracing{
+			Type:          "noop",
+			AgentHost:     "127.0.0.1:6831",
+			SampleRate:    1,
+			SamplingRules: []string{"greetings=0.1"},
+		},
+	}
+}
+
+// FileVariable is the environment variable pointing to a optional configuration file
+// The file holds KEY=VALUE lines using the same names as the environment, the environment wins over the file
+// Unlike the environment the file can be changed and reloaded while running
+const FileVariable = "TAVERN_CONFIG_FILE"
+
+// Load will apply the TAVERN_ENV profile, then any file and environment overrides on top of the defaults given
+// Secret fields holding a reference are then replaced with the secret they point to
+func Load(defaults Config) (Config, error) {
+	defaults, err := applyProfile(os.Getenv(EnvironmentVariable), defaults)
+	if err != nil {
+		return Config{}, err
+	}
+	file, err := readFile(os.Getenv(FileVariable))
+	if err != nil {
+		return Config{}, err
+	}
+	lookup := func(name string) (string, bool) {
+		if raw, ok := os.LookupEnv(name); ok {
+			return raw, true
+		}
+		raw, ok := file[name]
+		return raw, ok
+	}
+
+	cfg := defaults
+	if err := loadEnv(reflect.ValueOf(&cfg).Elem(), lookup); err != nil {
+		return Config{}, err
+	}
+	if err := resolveSecrets(&cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// readFile parses the KEY=VALUE lines of the configuration file, empty lines and # comments are skipped
+func readFile(path string) (map[string]string, error) {
+	values := make(map[string]string)
+	if path == "" {
+		return values, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %v", err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d should be KEY=VALUE", path, i+1)
+		}
+		values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return values, nil
+}
+
+// loadEnv walks the struct and sets each field that has its variable set
+func loadEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		def := t.Field(i)
+
+		if field.Kind() == reflect.Struct && def.Type != reflect.TypeOf(time.Time{}) {
+			if err := loadEnv(field, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := def.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// setField parses the raw environment value into the field
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var values []string
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		switch field.Type().Elem().Kind() {
+		case reflect.String:
+			field.Set(reflect.ValueOf(values))
+		case reflect.Float64:
+			floats := make([]float64, 0, len(values))
+			for _, v := range values {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return err
+				}
+				floats = append(floats, f)
+			}
+			field.Set(reflect.ValueOf(floats))
+		default:
+			return fmt.Errorf("unsupported slice type %s", field.Type())
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Changes returns the json paths of every setting that differs between old and new, such as flags.happyHour
+func Changes(old, new Config) []string {
+	return changes("", reflect.ValueOf(old), reflect.ValueOf(new))
+}
+
+func changes(prefix string, old, new reflect.Value) []string {
+	var paths []string
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		def := t.Field(i)
+		if def.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(def.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = def.Name
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if def.Type.Kind() == reflect.Struct && def.Type != reflect.TypeOf(time.Time{}) {
+			paths = append(paths, changes(name, old.Field(i), new.Field(i))...)
+			continue
+		}
+		if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+			paths = append(paths, name)
+		}
+	}
+	return paths
+}
+
+// Redacted returns the configuration as a map, where all secret fields are masked
+// This is what should be used when printing or exposing the configuration
+func (c Config) Redacted() map[string]interface{} {
+	return redact(reflect.ValueOf(c))
+}
+
+// redact turns a struct into a map keyed by the json names, masking secrets
+func redact(v reflect.Value) map[string]interface{} {
+	out := make(map[string]interface{})
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		def := t.Field(i)
+		if def.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(def.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = def.Name
+		}
+		field := v.Field(i)
+		switch {
+		case def.Tag.Get("secret") == "true":
+			if field.IsZero() {
+				out[name] = ""
+			} else {
+				out[name] = "******"
+			}
+		case field.Kind() == reflect.Struct && def.Type != reflect.TypeOf(time.Time{}):
+			out[name] = redact(field)
+		case field.Type() == reflect.TypeOf(time.Duration(0)):
+			out[name] = field.Interface().(time.Duration).String()
+		default:
+			out[name] = field.Interface()
+		}
+	}
+	return out
+}
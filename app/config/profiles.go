@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// EnvironmentVariable selects the profile applied on top of the defaults, local when empty
+const EnvironmentVariable = "TAVERN_ENV"
+
+// profile is where the other services are found, and what interfaces to listen on, in an environment
+// Only the hosts change, the ports of the defaults are kept
+type profile struct {
+	// CadenceHost is the host of the Cadence frontend
+	CadenceHost string
+	// BindHost is the interface the API and metrics listen on
+	BindHost string
+	// OpsHost is the interface of the ops listener, keep it private where possible
+	OpsHost string
+	// TracerHost is the host of the jaeger agent
+	TracerHost string
+}
+
+// profiles are the known environments
+// docker matches the service names of cadence/docker-compose-mysql.yml
+var profiles = map[string]profile{
+	"local": {
+		CadenceHost: "127.0.0.1",
+		BindHost:    "127.0.0.1",
+		OpsHost:     "127.0.0.1",
+		TracerHost:  "127.0.0.1",
+	},
+	"docker": {
+		CadenceHost: "cadence",
+		BindHost:    "0.0.0.0",
+		OpsHost:     "0.0.0.0",
+		TracerHost:  "jaeger",
+	},
+	"k8s": {
+		CadenceHost: "cadence-frontend.cadence.svc.cluster.local",
+		BindHost:    "0.0.0.0",
+		// Reach the ops listener with kubectl port-forward
+		OpsHost:    "127.0.0.1",
+		TracerHost: "jaeger-agent.observability.svc.cluster.local",
+	},
+}
+
+// applyProfile rewrites the hosts of the defaults for the named environment
+func applyProfile(name string, cfg Config) (Config, error) {
+	if name == "" {
+		return cfg, nil
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return Config{}, fmt.Errorf("unknown %s: %s", EnvironmentVariable, name)
+	}
+
+	cfg.CadenceHost = withHost(cfg.CadenceHost, p.CadenceHost)
+	cfg.ListenAddress = withHost(cfg.ListenAddress, p.BindHost)
+	cfg.MetricsAddress = withHost(cfg.MetricsAddress, p.BindHost)
+	cfg.OpsAddress = withHost(cfg.OpsAddress, p.OpsHost)
+	cfg.Tracing.AgentHost = withHost(cfg.Tracing.AgentHost, p.TracerHost)
+	return cfg, nil
+}
+
+// withHost replaces the host of a HOST:PORT address, empty addresses stay disabled
+func withHost(addr, host string) string {
+	if addr == "" {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Secrets configures where the secret fields are read from when they hold a reference instead of the secret itself
+// A reference is env://NAME, file:///run/secrets/name or vault://secret/data/tavern#key, any other value is used as is
+// References are resolved on every Load, so a reload picks up rotated files and Vault secrets
+type Secrets struct {
+	// VaultAddress is the address of HashiCorp Vault, such as https://vault:8200
+	VaultAddress string `env:"TAVERN_VAULT_ADDR" json:"vaultAddress"`
+	// VaultToken authenticates against Vault, it can itself be a env:// or file:// reference
+	VaultToken string `env:"TAVERN_VAULT_TOKEN" json:"vaultToken" secret:"true"`
+	// VaultNamespace is the Vault Enterprise namespace, empty uses the root namespace
+	VaultNamespace string `env:"TAVERN_VAULT_NAMESPACE" json:"vaultNamespace"`
+	// VaultTimeout is how long a read from Vault may take
+	VaultTimeout time.Duration `env:"TAVERN_VAULT_TIMEOUT" json:"vaultTimeout"`
+}
+
+// SecretProvider reads the secret a reference points to, the reference is given without its scheme
+type SecretProvider interface {
+	Secret(ref string) (string, error)
+}
+
+// EnvSecrets reads secrets from other environment variables, such as env://DB_PASSWORD
+type EnvSecrets struct{}
+
+// Secret returns the value of the variable
+func (EnvSecrets) Secret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// FileSecrets reads secrets from mounted files, such as docker or kubernetes secrets
+type FileSecrets struct{}
+
+// Secret returns the content of the file without the trailing newline
+func (FileSecrets) Secret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// VaultSecrets reads secrets from the key value engine of Vault, the reference is path#key
+// Both versions of the engine are read, a version 2 path includes data, such as secret/data/tavern
+type VaultSecrets struct {
+	address   string
+	token     string
+	namespace string
+	client    *http.Client
+	// read caches the secrets of each path for one Load
+	read map[string]map[string]interface{}
+}
+
+// NewVaultSecrets creates the Vault provider from configuration
+func NewVaultSecrets(cfg Secrets) *VaultSecrets {
+	return &VaultSecrets{
+		address:   strings.TrimRight(cfg.VaultAddress, "/"),
+		token:     cfg.VaultToken,
+		namespace: cfg.VaultNamespace,
+		client:    &http.Client{Timeout: cfg.VaultTimeout},
+		read:      make(map[string]map[string]interface{}),
+	}
+}
+
+// Secret reads the key of the path
+func (vs *VaultSecrets) Secret(ref string) (string, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", fmt.Errorf("vault reference %s should be path#key", ref)
+	}
+	path, key := strings.Trim(parts[0], "/"), parts[1]
+	if vs.address == "" {
+		return "", fmt.Errorf("TAVERN_VAULT_ADDR is needed to read %s", path)
+	}
+
+	values, ok := vs.read[path]
+	if !ok {
+		var err error
+		if values, err = vs.readPath(path); err != nil {
+			return "", err
+		}
+		vs.read[path] = values
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// readPath fetches the data of the secret at the path
+func (vs *VaultSecrets) readPath(path string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, vs.address+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", vs.token)
+	if vs.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vs.namespace)
+	}
+	res, err := vs.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: %v", path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read vault secret %s: vault responded with %d", path, res.StatusCode)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: %v", path, err)
+	}
+	// Version 2 of the engine nests the secret next to its metadata
+	if nested, ok := body.Data["data"].(map[string]interface{}); ok {
+		if _, versioned := body.Data["metadata"]; versioned {
+			return nested, nil
+		}
+	}
+	return body.Data, nil
+}
+
+// resolveSecrets replaces the references in the secret fields of cfg with the secrets they point to
+// The Vault token is resolved first, so it can be kept in a file next to the other secrets
+func resolveSecrets(cfg *Config) error {
+	local := map[string]SecretProvider{
+		"env://":  EnvSecrets{},
+		"file://": FileSecrets{},
+	}
+	token, err := resolveSecret(local, cfg.Secrets.VaultToken)
+	if err != nil {
+		return fmt.Errorf("failed to resolve TAVERN_VAULT_TOKEN: %v", err)
+	}
+	cfg.Secrets.VaultToken = token
+
+	providers := map[string]SecretProvider{
+		"env://":   EnvSecrets{},
+		"file://":  FileSecrets{},
+		"vault://": NewVaultSecrets(cfg.Secrets),
+	}
+	return resolveFields(reflect.ValueOf(cfg).Elem(), providers)
+}
+
+// resolveFields walks the struct and resolves every string, or list of strings, tagged as secret
+func resolveFields(v reflect.Value, providers map[string]SecretProvider) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		def := t.Field(i)
+
+		if field.Kind() == reflect.Struct && def.Type != reflect.TypeOf(time.Time{}) {
+			if err := resolveFields(field, providers); err != nil {
+				return err
+			}
+			continue
+		}
+		if def.Tag.Get("secret") != "true" {
+			continue
+		}
+
+		switch {
+		case field.Kind() == reflect.String:
+			value, err := resolveSecret(providers, field.String())
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %v", def.Tag.Get("env"), err)
+			}
+			field.SetString(value)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+			values := make([]string, field.Len())
+			for j := range values {
+				value, err := resolveSecret(providers, field.Index(j).String())
+				if err != nil {
+					return fmt.Errorf("failed to resolve %s: %v", def.Tag.Get("env"), err)
+				}
+				values[j] = value
+			}
+			if field.Len() > 0 {
+				field.Set(reflect.ValueOf(values))
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSecret returns the secret of a reference, and values that are not references as they are
+func resolveSecret(providers map[string]SecretProvider, value string) (string, error) {
+	for scheme, provider := range providers {
+		if strings.HasPrefix(value, scheme) {
+			return provider.Secret(strings.TrimPrefix(value, scheme))
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,236 @@
+package customer
+
+import (
+	"context"
+	"encoding/json"
+	"programmingpercy/cadence-tavern/cache"
+	"sync"
+	"time"
+)
+
+// The metrics of the cached repository, tagged with the op
+const (
+	MetricCacheHits   = "customer_cache_hits"
+	MetricCacheMisses = "customer_cache_misses"
+)
+
+// CachedRepository reads customers through a cache so the orders of a busy night don't all reach the backend
+// Changes made through it drop the cached customers right away, changes made by other processes are seen once the ttl ran out
+// Only found customers are cached, a customer that is missing is asked for again so new customers are seen at once
+// Reads that decide on a ban pass Uncached, since a ban made by the API is not seen by the cache of the Worker
+type CachedRepository struct {
+	next  Repository
+	cache cache.Cache
+	ttl   time.Duration
+
+	mu sync.Mutex
+	// generation counts the invalidations, a read that started before one does not store what it read
+	generation uint64
+}
+
+type uncachedKey struct{}
+
+// Uncached makes the cached repository read customers from the backend, what it reads is still cached for others
+func Uncached(ctx context.Context) context.Context {
+	return context.WithValue(ctx, uncachedKey{}, true)
+}
+
+// isUncached is true when the read should skip the cache
+func isUncached(ctx context.Context) bool {
+	uncached, _ := ctx.Value(uncachedKey{}).(bool)
+	return uncached
+}
+
+// Cached wraps the repository with a cache holding customers for ttl
+func Cached(next Repository, c cache.Cache, ttl time.Duration) *CachedRepository {
+	return &CachedRepository{next: next, cache: c, ttl: ttl}
+}
+
+// Unwrap returns the wrapped repository
+func (cr *CachedRepository) Unwrap() Repository {
+	return cr.next
+}
+
+// cacheKey is the key of a customer, it ends with a separator so invalidating bob leaves bobby cached
+func cacheKey(location, name string) string {
+	return "customer:" + location + ":" + name + "|"
+}
+
+// cached returns the customer if it is in the cache
+func (cr *CachedRepository) cached(location, name string) (Customer, bool) {
+	data, ok := cr.cache.Get(cacheKey(location, name))
+	if !ok {
+		return Customer{}, false
+	}
+	var cust Customer
+	if err := json.Unmarshal(data, &cust); err != nil {
+		return Customer{}, false
+	}
+	return cust, true
+}
+
+// currentGeneration is the generation a read of the backend starts in, see store
+func (cr *CachedRepository) currentGeneration() uint64 {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.generation
+}
+
+// store puts the customer read in the generation in the cache
+// A change invalidated since might have been applied after the read, so the customer is only stored when there was none
+func (cr *CachedRepository) store(generation uint64, cust Customer) {
+	data, err := json.Marshal(cust)
+	if err != nil {
+		return
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.generation != generation {
+		return
+	}
+	cr.cache.Set(cacheKey(cust.Location, cust.Name), data, cr.ttl)
+}
+
+// count reports hits and misses of op
+func (cr *CachedRepository) count(op string, hits, misses int) {
+	scope := Metrics.Tagged(map[string]string{"op": op})
+	if hits > 0 {
+		scope.Counter(MetricCacheHits).Inc(int64(hits))
+	}
+	if misses > 0 {
+		scope.Counter(MetricCacheMisses).Inc(int64(misses))
+	}
+}
+
+// Get is used to fetch a customer by Name in the location, from the cache when it is there and the read is not Uncached
+func (cr *CachedRepository) Get(ctx context.Context, location, name string) (Customer, error) {
+	if !isUncached(ctx) {
+		if cust, ok := cr.cached(location, name); ok {
+			cr.count("get", 1, 0)
+			return cust, nil
+		}
+	}
+	cr.count("get", 0, 1)
+	generation := cr.currentGeneration()
+	cust, err := cr.next.Get(ctx, location, name)
+	if err != nil {
+		return cust, err
+	}
+	cr.store(generation, cust)
+	return cust, nil
+}
+
+// GetByLoyaltyID fetches the customer holding the loyalty ID in the location, it is not cached
+func (cr *CachedRepository) GetByLoyaltyID(ctx context.Context, location, loyaltyID string) (Customer, error) {
+	return cr.next.GetByLoyaltyID(ctx, location, loyaltyID)
+}
+
+// GetMany fetches the customers of the location by name, only the ones missing from the cache reach the backend
+func (cr *CachedRepository) GetMany(ctx context.Context, location string, names []string) (map[string]Customer, error) {
+	found := make(map[string]Customer, len(names))
+	var missing []string
+	for _, name := range names {
+		if cust, ok := cr.cached(location, name); ok {
+			found[name] = cust
+			continue
+		}
+		missing = append(missing, name)
+	}
+	cr.count("getMany", len(found), len(missing))
+	if len(missing) == 0 {
+		return found, nil
+	}
+
+	generation := cr.currentGeneration()
+	fetched, err := cr.next.GetMany(ctx, location, missing)
+	if err != nil {
+		return nil, err
+	}
+	for name, cust := range fetched {
+		found[name] = cust
+		cr.store(generation, cust)
+	}
+	return found, nil
+}
+
+// Update stores the customer and drops it from the cache
+func (cr *CachedRepository) Update(ctx context.Context, customer Customer) error {
+	defer cr.invalidate(customer.Location, customer.Name)
+	return cr.next.Update(ctx, customer)
+}
+
+// UpdateMany stores the customers and drops them from the cache
+func (cr *CachedRepository) UpdateMany(ctx context.Context, customers []Customer) error {
+	defer func() {
+		for _, cust := range customers {
+			cr.invalidate(cust.Location, cust.Name)
+		}
+	}()
+	return cr.next.UpdateMany(ctx, customers)
+}
+
+// Delete soft deletes the customer and drops it from the cache
+func (cr *CachedRepository) Delete(ctx context.Context, location, name string) error {
+	defer cr.invalidate(location, name)
+	return cr.next.Delete(ctx, location, name)
+}
+
+// List returns all customers of the location, it is not cached
+func (cr *CachedRepository) List(ctx context.Context, location string) ([]Customer, error) {
+	return cr.next.List(ctx, location)
+}
+
+// invalidate drops the customer from the cache
+// It runs after the change as well when the change failed, the backend might have applied it anyway
+func (cr *CachedRepository) invalidate(location, name string) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.generation++
+	cr.cache.Invalidate(cacheKey(location, name))
+}
+
+// UpdateWithOutbox stores the customer and the entry together and drops the customer from the cache
+func (cr *CachedRepository) UpdateWithOutbox(ctx context.Context, customer Customer, entry OutboxEntry) error {
+	outbox, ok := cr.next.(Outbox)
+	if !ok {
+		return errUnsupported
+	}
+	defer cr.invalidate(customer.Location, customer.Name)
+	return outbox.UpdateWithOutbox(ctx, customer, entry)
+}
+
+// PendingOutbox returns unpublished entries, the wrapped repository has to be an Outbox
+func (cr *CachedRepository) PendingOutbox(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	outbox, ok := cr.next.(Outbox)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return outbox.PendingOutbox(ctx, limit)
+}
+
+// MarkPublished marks the entry as published, the wrapped repository has to be an Outbox
+func (cr *CachedRepository) MarkPublished(ctx context.Context, id int64) error {
+	outbox, ok := cr.next.(Outbox)
+	if !ok {
+		return errUnsupported
+	}
+	return outbox.MarkPublished(ctx, id)
+}
+
+// SaveRanking keeps the ranking, the wrapped repository has to be Rankings
+func (cr *CachedRepository) SaveRanking(ctx context.Context, ranking Ranking) error {
+	rankings, ok := cr.next.(Rankings)
+	if !ok {
+		return errUnsupported
+	}
+	return rankings.SaveRanking(ctx, ranking)
+}
+
+// LatestRanking returns the last ranking taken of the location, the wrapped repository has to be Rankings
+func (cr *CachedRepository) LatestRanking(ctx context.Context, location string) (Ranking, error) {
+	rankings, ok := cr.next.(Rankings)
+	if !ok {
+		return Ranking{}, errUnsupported
+	}
+	return rankings.LatestRanking(ctx, location)
+}
@@ -0,0 +1,83 @@
+package customer
+
+import (
+	"context"
+	"programmingpercy/cadence-tavern/cache"
+	"testing"
+	"time"
+)
+
+// pausedGet holds every read of the backend after it was made until it is released, like a slow backend
+type pausedGet struct {
+	Repository
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (pg *pausedGet) Get(ctx context.Context, location, name string) (Customer, error) {
+	cust, err := pg.Repository.Get(ctx, location, name)
+	pg.read <- struct{}{}
+	<-pg.release
+	return cust, err
+}
+
+func TestCachedReadBeforeAChangeIsNotStored(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryCustomers()
+	if err := backend.Update(ctx, Customer{Name: "Percy", Age: 30}); err != nil {
+		t.Fatal(err)
+	}
+	paused := &pausedGet{Repository: backend, read: make(chan struct{}), release: make(chan struct{})}
+	cr := Cached(paused, cache.NewMemoryCache(10), time.Minute)
+
+	// The customer is read before the ban and handed back after the ban dropped it from the cache
+	done := make(chan error)
+	go func() {
+		_, err := cr.Get(ctx, "", "Percy")
+		done <- err
+	}()
+	<-paused.read
+	if err := cr.Update(ctx, Customer{Name: "Percy", Age: 30, Banned: true}); err != nil {
+		t.Fatal(err)
+	}
+	close(paused.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { <-paused.read }()
+	cust, err := cr.Get(ctx, "", "Percy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cust.Banned {
+		t.Fatal("the customer read before the ban was cached over it")
+	}
+}
+
+func TestUncachedReadsSeeChangesOfOtherProcesses(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryCustomers()
+	if err := backend.Update(ctx, Customer{Name: "Percy", Age: 30}); err != nil {
+		t.Fatal(err)
+	}
+	cr := Cached(backend, cache.NewMemoryCache(10), time.Minute)
+	if _, err := cr.Get(ctx, "", "Percy"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process bans the customer, only the backend sees it
+	if err := backend.Update(ctx, Customer{Name: "Percy", Age: 30, Banned: true}); err != nil {
+		t.Fatal(err)
+	}
+	if cust, _ := cr.Get(ctx, "", "Percy"); cust.Banned {
+		t.Fatal("cached read saw the ban, want the cached customer until the ttl ran out")
+	}
+	cust, err := cr.Get(Uncached(ctx), "", "Percy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cust.Banned {
+		t.Fatal("uncached read served the cached customer, want the ban of the backend")
+	}
+}
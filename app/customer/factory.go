@@ -0,0 +1,38 @@
+package customer
+
+import (
+	"fmt"
+	"programmingpercy/cadence-tavern/cache"
+	"programmingpercy/cadence-tavern/config"
+)
+
+// NewRepository will create the repository backend selected in the configuration
+// The backend is instrumented, calls slower than cfg.SlowQuery are logged, and cached for cfg.CacheTTL
+func NewRepository(cfg config.Repository) (Repository, error) {
+	repo, err := Open(cfg.Backend, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	instrumented := Instrument(repo, cfg.Backend, cfg.SlowQuery)
+	if cfg.CacheTTL <= 0 {
+		return instrumented, nil
+	}
+	return Cached(instrumented, cache.NewMemoryCache(cfg.CacheSize), cfg.CacheTTL), nil
+}
+
+// Open will create a repository for the backend, backend is memory, file, sqlite or postgres
+// The dsn of the file backend is the path of the JSON file
+func Open(backend, dsn string) (Repository, error) {
+	switch backend {
+	case "", "memory":
+		return NewMemoryCustomers(), nil
+	case "file":
+		return NewJSONFileCustomers(dsn)
+	case "sqlite":
+		return NewSQLCustomers("sqlite3", dsn)
+	case "postgres":
+		return NewSQLCustomers("postgres", dsn)
+	default:
+		return nil, fmt.Errorf("unknown repository backend: %s", backend)
+	}
+}
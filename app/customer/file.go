@@ -0,0 +1,255 @@
+package customer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// JSONFileCustomers is used to store customers in a JSON file, it is the file persistence MemoryCustomers once had
+// Every call locks the file and reads it again, so several processes can share the file
+// Writes go to a temporary file that is renamed over the old one, a crash leaves either the old or the new file
+// The last good content is kept in a backup next to the file, it is used when the file can not be read
+type JSONFileCustomers struct {
+	path string
+	// mu serializes the calls of this process, the file lock is what keeps other processes out
+	mu sync.Mutex
+}
+
+// MetricFileBackupReads counts the reads of the file repository that used the backup, the file was missing or corrupt
+const MetricFileBackupReads = "customer_file_backup_reads"
+
+// customerFile is the content of the file
+type customerFile struct {
+	Customers []Customer `json:"customers"`
+}
+
+// NewJSONFileCustomers will use the file at path, it is created with the first customer stored
+func NewJSONFileCustomers(path string) (*JSONFileCustomers, error) {
+	if path == "" {
+		return nil, errors.New("file repository needs a path")
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	fc := &JSONFileCustomers{path: path}
+	// Fail on startup instead of on the first customer when neither the file nor the backup can be read
+	if err := fc.view(context.Background(), func(*MemoryCustomers) error { return nil }); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// Get is used to fetch a customer by Name in the location
+func (fc *JSONFileCustomers) Get(ctx context.Context, location, name string) (Customer, error) {
+	var cust Customer
+	err := fc.view(ctx, func(mc *MemoryCustomers) error {
+		var err error
+		cust, err = mc.Get(ctx, location, name)
+		return err
+	})
+	return cust, err
+}
+
+// GetByLoyaltyID is used to fetch a customer by the loyalty ID in the location
+func (fc *JSONFileCustomers) GetByLoyaltyID(ctx context.Context, location, loyaltyID string) (Customer, error) {
+	var cust Customer
+	err := fc.view(ctx, func(mc *MemoryCustomers) error {
+		var err error
+		cust, err = mc.GetByLoyaltyID(ctx, location, loyaltyID)
+		return err
+	})
+	return cust, err
+}
+
+// Update will override the information about a customer in the file
+func (fc *JSONFileCustomers) Update(ctx context.Context, customer Customer) error {
+	return fc.change(ctx, func(mc *MemoryCustomers) error {
+		return mc.Update(ctx, customer)
+	})
+}
+
+// GetMany is used to fetch the customers of the location by Name, the file is read once
+func (fc *JSONFileCustomers) GetMany(ctx context.Context, location string, names []string) (map[string]Customer, error) {
+	var found map[string]Customer
+	err := fc.view(ctx, func(mc *MemoryCustomers) error {
+		var err error
+		found, err = mc.GetMany(ctx, location, names)
+		return err
+	})
+	return found, err
+}
+
+// UpdateMany stores the customers with one write of the file, nothing is written when one of them fails
+func (fc *JSONFileCustomers) UpdateMany(ctx context.Context, customers []Customer) error {
+	return fc.change(ctx, func(mc *MemoryCustomers) error {
+		return mc.UpdateMany(ctx, customers)
+	})
+}
+
+// Delete will soft delete a customer, the record is kept in the file but no longer returned
+func (fc *JSONFileCustomers) Delete(ctx context.Context, location, name string) error {
+	return fc.change(ctx, func(mc *MemoryCustomers) error {
+		return mc.Delete(ctx, location, name)
+	})
+}
+
+// List returns all customers of the location sorted by name
+func (fc *JSONFileCustomers) List(ctx context.Context, location string) ([]Customer, error) {
+	var customers []Customer
+	err := fc.view(ctx, func(mc *MemoryCustomers) error {
+		var err error
+		customers, err = mc.List(ctx, location)
+		return err
+	})
+	return customers, err
+}
+
+// view runs fn on the customers of the file while holding a shared lock
+func (fc *JSONFileCustomers) view(ctx context.Context, fn func(*MemoryCustomers) error) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	// The caller might have given up while waiting for the other calls of this process
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(fc.path+".lock", false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	mc, _, err := fc.load()
+	if err != nil {
+		return err
+	}
+	return fn(mc)
+}
+
+// change runs fn on the customers of the file while holding an exclusive lock, the file is written when fn succeeds
+func (fc *JSONFileCustomers) change(ctx context.Context, fn func(*MemoryCustomers) error) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	// The caller might have given up while waiting for the other calls of this process
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(fc.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	mc, good, err := fc.load()
+	if err != nil {
+		return err
+	}
+	if err := fn(mc); err != nil {
+		return err
+	}
+
+	file := customerFile{Customers: make([]Customer, 0, len(mc.Customers))}
+	for _, cust := range mc.Customers {
+		file.Customers = append(file.Customers, cust)
+	}
+	// Sorted so the file only changes where a customer changed
+	sort.Slice(file.Customers, func(i, j int) bool {
+		return MemoryKey(file.Customers[i].Location, file.Customers[i].Name) < MemoryKey(file.Customers[j].Location, file.Customers[j].Name)
+	})
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The backup is the content that was last read without problems, so it is never a corrupt file
+	if good != nil {
+		if err := writeAtomic(fc.path+".bak", good); err != nil {
+			return err
+		}
+	}
+	return writeAtomic(fc.path, data)
+}
+
+// load reads the customers of the file, falling back to the backup when the file is missing or corrupt
+// good is the content the customers were read from, nil when there was none yet
+func (fc *JSONFileCustomers) load() (*MemoryCustomers, []byte, error) {
+	mc, good, err := readCustomerFile(fc.path)
+	if err == nil {
+		return mc, good, nil
+	}
+	backup, backupGood, backupErr := readCustomerFile(fc.path + ".bak")
+	if backupErr == nil {
+		// The next write replaces the broken file with the customers of the backup, the last write is lost
+		// It is reported on every read until then, the binaries set the Logger and Metrics after the first one
+		Metrics.Counter(MetricFileBackupReads).Inc(1)
+		Logger.Warn("Customer file can not be read, using its backup, the last write to it is lost",
+			zap.String("path", fc.path), zap.Error(err))
+		return backup, backupGood, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewMemoryCustomers(), nil, nil
+	}
+	return nil, nil, fmt.Errorf("failed to read %s and its backup: %v", fc.path, err)
+}
+
+// readCustomerFile reads the customers of the file at path into memory
+func readCustomerFile(path string) (*MemoryCustomers, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var file customerFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, nil, fmt.Errorf("corrupt customer file %s: %v", path, err)
+	}
+
+	mc := NewMemoryCustomers()
+	for _, cust := range file.Customers {
+		if err := mc.update(cust); err != nil {
+			return nil, nil, fmt.Errorf("corrupt customer file %s: %v", path, err)
+		}
+	}
+	return mc, data, nil
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it over path
+func writeAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", tmp.Name(), err)
+	}
+	// The content has to be on disk before the rename makes it the file
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+	// Sync the directory so the rename itself survives a crash, not every platform can
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package customer
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an flock on the file at path, exclusive for writers and shared for readers
+// The lock is released by the returned function, or by the kernel when the process dies
+func lockFile(path string, exclusive bool) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock %s: %v", path, err)
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
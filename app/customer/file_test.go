@@ -0,0 +1,50 @@
+package customer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCorruptFileReadFromBackupIsReported(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "customers.json")
+	fc, err := NewJSONFileCustomers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second write keeps the first in the backup
+	for _, age := range []int{30, 31} {
+		if err := fc.Update(ctx, Customer{Name: "Percy", Age: age}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, []byte(`{"customers": [`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	scope := tally.NewTestScope("", nil)
+	Logger, Metrics = zap.New(core), scope
+	defer func() { Logger, Metrics = zap.NewNop(), tally.NoopScope }()
+
+	cust, err := fc.Get(ctx, "", "Percy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cust.Age != 30 {
+		t.Fatalf("read %+v, want the customer of the backup", cust)
+	}
+	if logs.FilterMessageSnippet("backup").Len() != 1 {
+		t.Fatalf("logged %v, want a warning that the backup was used", logs.All())
+	}
+	if counter, ok := scope.Snapshot().Counters()[MetricFileBackupReads+"+"]; !ok || counter.Value() != 1 {
+		t.Fatalf("%s is %v, want 1", MetricFileBackupReads, scope.Snapshot().Counters())
+	}
+}
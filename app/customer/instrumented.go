@@ -0,0 +1,210 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// The metrics of the instrumented repository, tagged with the backend and the op
+const (
+	MetricRepositoryLatency = "customer_repository_latency"
+	MetricRepositoryErrors  = "customer_repository_errors"
+)
+
+var (
+	// Metrics is the scope the instrumented repository reports on, the binaries replace this during startup
+	Metrics = tally.NoopScope
+	// Logger logs the slow calls of the instrumented repository, the binaries replace this during startup
+	Logger = zap.NewNop()
+)
+
+// InstrumentedRepository wraps a backend with metrics, a span for every call and logging of slow calls
+// The spans are children of the span in the context, such as the span of the activity calling the repository
+// It forwards Outbox and Rankings as well, use AsOutbox and AsRankings to find out if the backend supports them
+type InstrumentedRepository struct {
+	next    Repository
+	backend string
+	// slow is how long a call may take before it is logged, 0 disables the logging
+	slow time.Duration
+}
+
+// Instrument wraps the repository of the backend, calls taking longer than slow are logged
+func Instrument(next Repository, backend string, slow time.Duration) *InstrumentedRepository {
+	if backend == "" {
+		backend = "memory"
+	}
+	return &InstrumentedRepository{next: next, backend: backend, slow: slow}
+}
+
+// Unwrap returns the wrapped backend
+func (ir *InstrumentedRepository) Unwrap() Repository {
+	return ir.next
+}
+
+// observe reports one call of op, it is deferred with the time the call started
+func (ir *InstrumentedRepository) observe(op string, started time.Time, span opentracing.Span, err error) {
+	took := time.Since(started)
+	scope := Metrics.Tagged(map[string]string{"backend": ir.backend, "op": op})
+	scope.Timer(MetricRepositoryLatency).Record(took)
+	// Missing customers are an answer, not a failure of the backend
+	if err != nil && !errors.Is(err, ErrNoSuchCustomer) && !errors.Is(err, ErrNoRanking) {
+		scope.Counter(MetricRepositoryErrors).Inc(1)
+		ext.Error.Set(span, true)
+		span.SetTag("error.message", err.Error())
+	}
+	span.Finish()
+
+	if ir.slow > 0 && took >= ir.slow {
+		Logger.Warn("Slow customer repository call", zap.String("backend", ir.backend), zap.String("op", op),
+			zap.Duration("took", took), zap.Error(err))
+	}
+}
+
+// start begins the span of op, the context returned carries it to the backend
+func (ir *InstrumentedRepository) start(ctx context.Context, op string, tags opentracing.Tags) (opentracing.Span, context.Context, time.Time) {
+	tags["backend"] = ir.backend
+	span, ctx := opentracing.StartSpanFromContext(ctx, "customerRepository."+op, tags)
+	return span, ctx, time.Now()
+}
+
+// Get is used to fetch a customer by Name in the location
+func (ir *InstrumentedRepository) Get(ctx context.Context, location, name string) (cust Customer, err error) {
+	span, ctx, started := ir.start(ctx, "get", opentracing.Tags{"location": location, "customer": name})
+	defer func() { ir.observe("get", started, span, err) }()
+	return ir.next.Get(ctx, location, name)
+}
+
+// GetByLoyaltyID fetches the customer holding the loyalty ID in the location
+func (ir *InstrumentedRepository) GetByLoyaltyID(ctx context.Context, location, loyaltyID string) (cust Customer, err error) {
+	span, ctx, started := ir.start(ctx, "getByLoyaltyID", opentracing.Tags{"location": location})
+	defer func() { ir.observe("getByLoyaltyID", started, span, err) }()
+	return ir.next.GetByLoyaltyID(ctx, location, loyaltyID)
+}
+
+// Update stores the customer
+func (ir *InstrumentedRepository) Update(ctx context.Context, customer Customer) (err error) {
+	span, ctx, started := ir.start(ctx, "update", opentracing.Tags{"location": customer.Location, "customer": customer.Name})
+	defer func() { ir.observe("update", started, span, err) }()
+	return ir.next.Update(ctx, customer)
+}
+
+// GetMany fetches the customers of the location by name
+func (ir *InstrumentedRepository) GetMany(ctx context.Context, location string, names []string) (found map[string]Customer, err error) {
+	span, ctx, started := ir.start(ctx, "getMany", opentracing.Tags{"location": location, "customers": len(names)})
+	defer func() { ir.observe("getMany", started, span, err) }()
+	return ir.next.GetMany(ctx, location, names)
+}
+
+// UpdateMany stores the customers
+func (ir *InstrumentedRepository) UpdateMany(ctx context.Context, customers []Customer) (err error) {
+	span, ctx, started := ir.start(ctx, "updateMany", opentracing.Tags{"customers": len(customers)})
+	defer func() { ir.observe("updateMany", started, span, err) }()
+	return ir.next.UpdateMany(ctx, customers)
+}
+
+// Delete soft deletes the customer
+func (ir *InstrumentedRepository) Delete(ctx context.Context, location, name string) (err error) {
+	span, ctx, started := ir.start(ctx, "delete", opentracing.Tags{"location": location, "customer": name})
+	defer func() { ir.observe("delete", started, span, err) }()
+	return ir.next.Delete(ctx, location, name)
+}
+
+// List returns all customers of the location
+func (ir *InstrumentedRepository) List(ctx context.Context, location string) (customers []Customer, err error) {
+	span, ctx, started := ir.start(ctx, "list", opentracing.Tags{"location": location})
+	defer func() { ir.observe("list", started, span, err) }()
+	return ir.next.List(ctx, location)
+}
+
+// UpdateWithOutbox stores the customer and the entry together, the backend has to be an Outbox
+func (ir *InstrumentedRepository) UpdateWithOutbox(ctx context.Context, customer Customer, entry OutboxEntry) (err error) {
+	span, ctx, started := ir.start(ctx, "updateWithOutbox", opentracing.Tags{"location": customer.Location, "customer": customer.Name})
+	defer func() { ir.observe("updateWithOutbox", started, span, err) }()
+	outbox, ok := ir.next.(Outbox)
+	if !ok {
+		return errUnsupported
+	}
+	return outbox.UpdateWithOutbox(ctx, customer, entry)
+}
+
+// PendingOutbox returns unpublished entries, the backend has to be an Outbox
+func (ir *InstrumentedRepository) PendingOutbox(ctx context.Context, limit int) (entries []OutboxEntry, err error) {
+	span, ctx, started := ir.start(ctx, "pendingOutbox", opentracing.Tags{})
+	defer func() { ir.observe("pendingOutbox", started, span, err) }()
+	outbox, ok := ir.next.(Outbox)
+	if !ok {
+		return nil, errUnsupported
+	}
+	return outbox.PendingOutbox(ctx, limit)
+}
+
+// MarkPublished marks the entry as published, the backend has to be an Outbox
+func (ir *InstrumentedRepository) MarkPublished(ctx context.Context, id int64) (err error) {
+	span, ctx, started := ir.start(ctx, "markPublished", opentracing.Tags{})
+	defer func() { ir.observe("markPublished", started, span, err) }()
+	outbox, ok := ir.next.(Outbox)
+	if !ok {
+		return errUnsupported
+	}
+	return outbox.MarkPublished(ctx, id)
+}
+
+// SaveRanking keeps the ranking, the backend has to be Rankings
+func (ir *InstrumentedRepository) SaveRanking(ctx context.Context, ranking Ranking) (err error) {
+	span, ctx, started := ir.start(ctx, "saveRanking", opentracing.Tags{"location": ranking.Location})
+	defer func() { ir.observe("saveRanking", started, span, err) }()
+	rankings, ok := ir.next.(Rankings)
+	if !ok {
+		return errUnsupported
+	}
+	return rankings.SaveRanking(ctx, ranking)
+}
+
+// LatestRanking returns the last ranking taken of the location, the backend has to be Rankings
+func (ir *InstrumentedRepository) LatestRanking(ctx context.Context, location string) (ranking Ranking, err error) {
+	span, ctx, started := ir.start(ctx, "latestRanking", opentracing.Tags{"location": location})
+	defer func() { ir.observe("latestRanking", started, span, err) }()
+	rankings, ok := ir.next.(Rankings)
+	if !ok {
+		return Ranking{}, errUnsupported
+	}
+	return rankings.LatestRanking(ctx, location)
+}
+
+// errUnsupported is returned when an optional interface is used on a backend that does not implement it
+var errUnsupported = errors.New("not supported by the customer repository backend")
+
+// AsOutbox returns the repository as an Outbox when its backend is one, the wrapping repositories are looked through
+func AsOutbox(repo Repository) (Outbox, bool) {
+	if _, ok := backendOf(repo).(Outbox); !ok {
+		return nil, false
+	}
+	outbox, ok := repo.(Outbox)
+	return outbox, ok
+}
+
+// AsRankings returns the repository as Rankings when its backend keeps them, the wrapping repositories are looked through
+func AsRankings(repo Repository) (Rankings, bool) {
+	if _, ok := backendOf(repo).(Rankings); !ok {
+		return nil, false
+	}
+	rankings, ok := repo.(Rankings)
+	return rankings, ok
+}
+
+// backendOf unwraps the repository until it reaches the backend
+func backendOf(repo Repository) Repository {
+	for {
+		wrapper, ok := repo.(interface{ Unwrap() Repository })
+		if !ok {
+			return repo
+		}
+		repo = wrapper.Unwrap()
+	}
+}
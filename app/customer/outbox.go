@@ -0,0 +1,137 @@
+package customer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// OutboxEntry is a message that should be published after a customer was stored
+type OutboxEntry struct {
+	ID int64 `json:"id"`
+	// Key makes the entry idempotent, storing the same key twice only keeps the first entry
+	Key         string     `json:"key"`
+	Topic       string     `json:"topic"`
+	Payload     []byte     `json:"payload"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+}
+
+// Outbox is implemented by repositories that can store a customer and a outbox entry atomically
+// The entries are published by a relay, so storage and notifications stay consistent under retries
+type Outbox interface {
+	UpdateWithOutbox(context.Context, Customer, OutboxEntry) error
+	PendingOutbox(ctx context.Context, limit int) ([]OutboxEntry, error)
+	MarkPublished(ctx context.Context, id int64) error
+}
+
+// UpdateWithOutbox stores the customer and the entry together
+func (mc *MemoryCustomers) UpdateWithOutbox(ctx context.Context, customer Customer, entry OutboxEntry) error {
+	mc.outboxMu.Lock()
+	defer mc.outboxMu.Unlock()
+
+	if err := mc.Update(ctx, customer); err != nil {
+		return err
+	}
+	if mc.outbox == nil {
+		mc.outbox = make(map[string]OutboxEntry)
+	}
+	if _, ok := mc.outbox[entry.Key]; ok {
+		return nil
+	}
+	mc.outboxSeq++
+	entry.ID = mc.outboxSeq
+	entry.CreatedAt = time.Now()
+	mc.outbox[entry.Key] = entry
+	return nil
+}
+
+// PendingOutbox returns unpublished entries, oldest first
+func (mc *MemoryCustomers) PendingOutbox(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	mc.outboxMu.Lock()
+	defer mc.outboxMu.Unlock()
+
+	var pending []OutboxEntry
+	for _, entry := range mc.outbox {
+		if entry.PublishedAt == nil {
+			pending = append(pending, entry)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].ID < pending[j].ID
+	})
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+	return pending, nil
+}
+
+// MarkPublished marks the entry as published
+func (mc *MemoryCustomers) MarkPublished(ctx context.Context, id int64) error {
+	mc.outboxMu.Lock()
+	defer mc.outboxMu.Unlock()
+
+	for key, entry := range mc.outbox {
+		if entry.ID == id {
+			now := time.Now()
+			entry.PublishedAt = &now
+			mc.outbox[key] = entry
+			return nil
+		}
+	}
+	return fmt.Errorf("no such outbox entry: %d", id)
+}
+
+// UpdateWithOutbox stores the customer and the entry in one transaction
+func (sc *SQLCustomers) UpdateWithOutbox(ctx context.Context, customer Customer, entry OutboxEntry) error {
+	tx, err := sc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := sc.upsert(ctx, tx, customer); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, sc.rebind(`INSERT INTO customer_outbox (key, topic, payload, created_at)
+		VALUES ($1, $2, $3, $4) ON CONFLICT (key) DO NOTHING`),
+		entry.Key, entry.Topic, string(entry.Payload), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store outbox entry: %v", err)
+	}
+	return tx.Commit()
+}
+
+// PendingOutbox returns unpublished entries, oldest first
+func (sc *SQLCustomers) PendingOutbox(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	rows, err := sc.db.QueryContext(ctx, sc.rebind(`SELECT id, key, topic, payload, created_at FROM customer_outbox
+		WHERE published_at IS NULL ORDER BY id LIMIT $1`), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %v", err)
+	}
+	defer rows.Close()
+
+	var pending []OutboxEntry
+	for rows.Next() {
+		var (
+			entry   OutboxEntry
+			payload string
+		)
+		if err := rows.Scan(&entry.ID, &entry.Key, &entry.Topic, &payload, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.Payload = []byte(payload)
+		pending = append(pending, entry)
+	}
+	return pending, rows.Err()
+}
+
+// MarkPublished marks the entry as published
+func (sc *SQLCustomers) MarkPublished(ctx context.Context, id int64) error {
+	_, err := sc.db.ExecContext(ctx, sc.rebind(`UPDATE customer_outbox SET published_at = $2 WHERE id = $1`), id, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox entry %d: %v", id, err)
+	}
+	return nil
+}
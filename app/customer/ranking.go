@@ -0,0 +1,92 @@
+package customer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoRanking is returned when no ranking was taken of a location yet
+var ErrNoRanking = errors.New("no ranking taken")
+
+// RankingEntry is the spend of one customer in a ranking
+type RankingEntry struct {
+	Customer string  `json:"customer"`
+	Spend    float32 `json:"spend"`
+	Orders   int     `json:"orders"`
+}
+
+// Ranking is a snapshot of the top customers of a location by spend, the largest spender first
+type Ranking struct {
+	Location string         `json:"location"`
+	TakenAt  time.Time      `json:"takenAt"`
+	Entries  []RankingEntry `json:"entries"`
+}
+
+// Rankings is implemented by repositories that can keep the snapshots of the leaderboard
+type Rankings interface {
+	SaveRanking(context.Context, Ranking) error
+	LatestRanking(ctx context.Context, location string) (Ranking, error)
+}
+
+// SaveRanking keeps the ranking next to the earlier ones of the location
+func (mc *MemoryCustomers) SaveRanking(ctx context.Context, ranking Ranking) error {
+	mc.rankingsMu.Lock()
+	defer mc.rankingsMu.Unlock()
+
+	if mc.rankings == nil {
+		mc.rankings = make(map[string][]Ranking)
+	}
+	mc.rankings[ranking.Location] = append(mc.rankings[ranking.Location], ranking)
+	return nil
+}
+
+// LatestRanking returns the last ranking taken of the location
+func (mc *MemoryCustomers) LatestRanking(ctx context.Context, location string) (Ranking, error) {
+	mc.rankingsMu.Lock()
+	defer mc.rankingsMu.Unlock()
+
+	rankings := mc.rankings[location]
+	if len(rankings) == 0 {
+		return Ranking{}, ErrNoRanking
+	}
+	return rankings[len(rankings)-1], nil
+}
+
+// SaveRanking stores the ranking, the entries are kept as JSON since they are always read together
+func (sc *SQLCustomers) SaveRanking(ctx context.Context, ranking Ranking) error {
+	entries, err := json.Marshal(ranking.Entries)
+	if err != nil {
+		return err
+	}
+	_, err = sc.db.ExecContext(ctx, sc.rebind(`INSERT INTO customer_rankings (location, taken_at, entries) VALUES ($1, $2, $3)`),
+		ranking.Location, ranking.TakenAt.UTC(), string(entries))
+	if err != nil {
+		return fmt.Errorf("failed to store ranking: %v", err)
+	}
+	return nil
+}
+
+// LatestRanking returns the last ranking taken of the location
+func (sc *SQLCustomers) LatestRanking(ctx context.Context, location string) (Ranking, error) {
+	row := sc.db.QueryRowContext(ctx, sc.rebind(`SELECT location, taken_at, entries FROM customer_rankings
+		WHERE location = $1 ORDER BY taken_at DESC, id DESC LIMIT 1`), location)
+
+	var (
+		ranking Ranking
+		entries string
+	)
+	if err := row.Scan(&ranking.Location, &ranking.TakenAt, &entries); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Ranking{}, ErrNoRanking
+		}
+		return Ranking{}, fmt.Errorf("failed to read ranking: %v", err)
+	}
+	if err := json.Unmarshal([]byte(entries), &ranking.Entries); err != nil {
+		return Ranking{}, fmt.Errorf("failed to decode ranking: %v", err)
+	}
+	return ranking, nil
+}
@@ -1,68 +1,225 @@
 package customer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"programmingpercy/cadence-tavern/models"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 var (
 	// Bad Solution for in mem during tutorial
-	Database = NewMemoryCustomers()
+	// The binaries replace this with the repository built from configuration
+	Database Repository = NewMemoryCustomers()
+
+	// ErrNoSuchCustomer is returned when a customer is not found in the repository
+	ErrNoSuchCustomer = errors.New("no such customer")
+	// ErrLoyaltyIDTaken is returned when a customer is stored with the loyalty ID of another customer
+	ErrLoyaltyIDTaken = errors.New("loyalty id belongs to another customer")
+)
+
+// Customer is representation of a client in the Tavern, see models.Customer
+type Customer = models.Customer
+
+// The loyalty statuses a customer has based on how often they visit
+const (
+	LoyaltyNew     = models.LoyaltyNew
+	LoyaltyRegular = models.LoyaltyRegular
+	LoyaltyGold    = models.LoyaltyGold
 )
 
-// Customer is representation of a client in the Tavern
-type Customer struct {
-	Name string `json:"name"`
-	// LastVisit is a timestamp of the last time this visitor came by the tavern
-	LastVisit time.Time `json:"lastVisit"`
-	// TimesVisited is how many times a user has visited
-	TimesVisited int `json:"timesVisited"`
-	// Age is the customer age
-	Age int `json:"age"`
+// NormalizeName trims the name and collapses the whitespace inside it, so "Percy " and "Percy" are the same name
+func NormalizeName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// SameName is true when the names only differ in case or whitespace, they are aliases of one customer
+func SameName(a, b string) bool {
+	return strings.EqualFold(NormalizeName(a), NormalizeName(b))
 }
 
 // Repository is the needed methods to be a customer repo
+// Every method is scoped to a location, a customer is never visible from another location
+// Update stores the customer in customer.Location
+// Every method takes the context of the caller, backends give up on calls when it is cancelled or past its deadline
 type Repository interface {
-	Get(string) (Customer, error)
-	Update(Customer) error
+	Get(ctx context.Context, location, name string) (Customer, error)
+	// GetByLoyaltyID fetches the customer holding the loyalty ID in the location
+	GetByLoyaltyID(ctx context.Context, location, loyaltyID string) (Customer, error)
+	Update(context.Context, Customer) error
+	// GetMany fetches the customers of the location by name in one call, names that are not found are left out
+	GetMany(ctx context.Context, location string, names []string) (map[string]Customer, error)
+	// UpdateMany stores the customers in one call, either all of them are stored or none
+	UpdateMany(context.Context, []Customer) error
+	Delete(ctx context.Context, location, name string) error
+	List(ctx context.Context, location string) ([]Customer, error)
 }
 
 // MemoryCustomers is used to store information in Memory
+// Customers is keyed by MemoryKey so the same name can exist in several locations
+// It is safe to use from concurrent activities, Customers should only be touched directly before it is shared
 type MemoryCustomers struct {
+	// mu guards Customers and loyalty
+	mu        sync.RWMutex
 	Customers map[string]Customer
+	// loyalty indexes the names by MemoryKey of the location and loyalty ID, it is kept by Update
+	loyalty map[string]string
+
+	// outbox is only touched through the outbox methods, outboxMu guards it since the relay reads it concurrently
+	outboxMu  sync.Mutex
+	outbox    map[string]OutboxEntry
+	outboxSeq int64
+
+	// rankings are the snapshots of the leaderboard by location, rankingsMu guards them
+	rankingsMu sync.Mutex
+	rankings   map[string][]Ranking
 }
 
 // NewMemoryCustomers will init a new in memory storage for customers
-func NewMemoryCustomers() MemoryCustomers {
-	customers := MemoryCustomers{
+func NewMemoryCustomers() *MemoryCustomers {
+	customers := &MemoryCustomers{
 		Customers: make(map[string]Customer),
+		loyalty:   make(map[string]string),
 	}
 
 	return customers
 }
 
-// Get is used to fetch a customer by Name
-func (mc *MemoryCustomers) Get(name string) (Customer, error) {
-	// if err := mc.LoadDataFile(); err != nil {
-	// 	return Customer{}, err
-	// }
-	if mc.Customers == nil {
-		mc.Customers = make(map[string]Customer)
-	}
-	if cust, ok := mc.Customers[name]; ok {
+// MemoryKey is the key of a customer in MemoryCustomers, names can not contain the NUL separator
+func MemoryKey(location, name string) string {
+	return location + "\x00" + name
+}
+
+// Get is used to fetch a customer by Name in the location
+func (mc *MemoryCustomers) Get(ctx context.Context, location, name string) (Customer, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return mc.get(location, name)
+}
+
+// get is Get for callers holding the lock
+func (mc *MemoryCustomers) get(location, name string) (Customer, error) {
+	if cust, ok := mc.Customers[MemoryKey(location, name)]; ok && cust.DeletedAt == nil {
 		return cust, nil
 	}
-	return Customer{}, fmt.Errorf("no such customer: %s", name)
+	return Customer{}, fmt.Errorf("%w: %s", ErrNoSuchCustomer, name)
+}
+
+// GetByLoyaltyID is used to fetch a customer by the loyalty ID in the location
+func (mc *MemoryCustomers) GetByLoyaltyID(ctx context.Context, location, loyaltyID string) (Customer, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	if name, ok := mc.loyalty[MemoryKey(location, loyaltyID)]; ok && loyaltyID != "" {
+		return mc.get(location, name)
+	}
+	return Customer{}, fmt.Errorf("%w: loyalty id %s", ErrNoSuchCustomer, loyaltyID)
 }
 
 // Update will override the information about a customer in storage
-func (mc *MemoryCustomers) Update(customer Customer) error {
+func (mc *MemoryCustomers) Update(ctx context.Context, customer Customer) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.update(customer)
+}
+
+// update is Update for callers holding the lock
+func (mc *MemoryCustomers) update(customer Customer) error {
 	if mc.Customers == nil {
 		mc.Customers = make(map[string]Customer)
 	}
+	if mc.loyalty == nil {
+		mc.loyalty = make(map[string]string)
+	}
 
-	mc.Customers[customer.Name] = customer
+	key := MemoryKey(customer.Location, customer.Name)
+	loyaltyKey := MemoryKey(customer.Location, customer.LoyaltyID)
+	if customer.LoyaltyID != "" {
+		if holder, ok := mc.loyalty[loyaltyKey]; ok && holder != customer.Name {
+			return fmt.Errorf("%w: %s", ErrLoyaltyIDTaken, customer.LoyaltyID)
+		}
+	}
+	if old, ok := mc.Customers[key]; ok && old.LoyaltyID != "" && old.LoyaltyID != customer.LoyaltyID {
+		delete(mc.loyalty, MemoryKey(old.Location, old.LoyaltyID))
+	}
+	if customer.LoyaltyID != "" {
+		mc.loyalty[loyaltyKey] = customer.Name
+	}
+
+	mc.Customers[key] = customer
 
 	return nil
 
 }
+
+// GetMany is used to fetch the customers of the location by Name at once, names that are not found are left out
+func (mc *MemoryCustomers) GetMany(ctx context.Context, location string, names []string) (map[string]Customer, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	found := make(map[string]Customer, len(names))
+	for _, name := range names {
+		if cust, err := mc.get(location, name); err == nil {
+			found[name] = cust
+		}
+	}
+	return found, nil
+}
+
+// UpdateMany stores all customers or none of them, the maps are copied so a failing customer leaves them untouched
+func (mc *MemoryCustomers) UpdateMany(ctx context.Context, customers []Customer) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	oldCustomers, oldLoyalty := mc.Customers, mc.loyalty
+	mc.Customers = make(map[string]Customer, len(oldCustomers)+len(customers))
+	for key, cust := range oldCustomers {
+		mc.Customers[key] = cust
+	}
+	mc.loyalty = make(map[string]string, len(oldLoyalty))
+	for key, name := range oldLoyalty {
+		mc.loyalty[key] = name
+	}
+
+	for _, cust := range customers {
+		if err := mc.update(cust); err != nil {
+			mc.Customers, mc.loyalty = oldCustomers, oldLoyalty
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete will soft delete a customer, the record is kept but no longer returned
+func (mc *MemoryCustomers) Delete(ctx context.Context, location, name string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	key := MemoryKey(location, name)
+	cust, ok := mc.Customers[key]
+	if !ok || cust.DeletedAt != nil {
+		return fmt.Errorf("%w: %s", ErrNoSuchCustomer, name)
+	}
+	now := time.Now()
+	cust.DeletedAt = &now
+	mc.Customers[key] = cust
+	return nil
+}
+
+// List returns all customers of the location sorted by name
+func (mc *MemoryCustomers) List(ctx context.Context, location string) ([]Customer, error) {
+	mc.mu.RLock()
+	customers := make([]Customer, 0, len(mc.Customers))
+	for _, cust := range mc.Customers {
+		if cust.DeletedAt != nil || cust.Location != location {
+			continue
+		}
+		customers = append(customers, cust)
+	}
+	mc.mu.RUnlock()
+	sort.Slice(customers, func(i, j int) bool {
+		return customers[i].Name < customers[j].Name
+	})
+	return customers, nil
+}
@@ -0,0 +1,148 @@
+package customer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"programmingpercy/cadence-tavern/cache"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBackends opens an empty repository of every backend, postgres only runs with TAVERN_TEST_POSTGRES_DSN set
+func testBackends(t *testing.T) map[string]Repository {
+	t.Helper()
+	dir := t.TempDir()
+	backends := map[string]Repository{
+		"memory": NewMemoryCustomers(),
+		"cached": Cached(NewMemoryCustomers(), cache.NewMemoryCache(100), time.Minute),
+	}
+	file, err := NewJSONFileCustomers(filepath.Join(dir, "customers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends["file"] = file
+	sqlite, err := NewSQLCustomers("sqlite3", "file:"+filepath.Join(dir, "customers.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	backends["sqlite"] = sqlite
+	if dsn := os.Getenv("TAVERN_TEST_POSTGRES_DSN"); dsn != "" {
+		postgres, err := NewSQLCustomers("postgres", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { postgres.Close() })
+		backends["postgres"] = postgres
+	}
+	return backends
+}
+
+func TestCrossLocationReads(t *testing.T) {
+	ctx := context.Background()
+	for name, repo := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			// The name is unique to the run, postgres is shared between runs
+			percy := Customer{Name: "Percy " + name + time.Now().Format("150405.000"), Age: 30, LoyaltyID: "L-" + name, Location: "a"}
+			if err := repo.Update(ctx, percy); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := repo.Get(ctx, "b", percy.Name); !errors.Is(err, ErrNoSuchCustomer) {
+				t.Errorf("Get from another location returned %v, want ErrNoSuchCustomer", err)
+			}
+			if _, err := repo.GetByLoyaltyID(ctx, "b", percy.LoyaltyID); !errors.Is(err, ErrNoSuchCustomer) {
+				t.Errorf("GetByLoyaltyID from another location returned %v, want ErrNoSuchCustomer", err)
+			}
+			many, err := repo.GetMany(ctx, "b", []string{percy.Name})
+			if err != nil || len(many) != 0 {
+				t.Errorf("GetMany from another location returned %v, %v, want nothing", many, err)
+			}
+			listed, err := repo.List(ctx, "b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range listed {
+				if c.Name == percy.Name {
+					t.Errorf("List of another location returned %v", c)
+				}
+			}
+
+			// Deleting in the other location leaves the customer alone
+			if err := repo.Delete(ctx, "b", percy.Name); err != nil && !errors.Is(err, ErrNoSuchCustomer) {
+				t.Fatal(err)
+			}
+			got, err := repo.Get(ctx, "a", percy.Name)
+			if err != nil || got.Age != 30 {
+				t.Errorf("Get from its own location returned %v, %v", got, err)
+			}
+
+			// The same name in both locations are two customers
+			other := percy
+			other.Location, other.Age = "b", 40
+			if err := repo.Update(ctx, other); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := repo.Get(ctx, "a", percy.Name); got.Age != 30 {
+				t.Errorf("storing the name in another location changed the customer to %v", got)
+			}
+		})
+	}
+}
+
+// TestConcurrentAccess reads and writes the repositories from many goroutines, run it with -race
+func TestConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	for name, repo := range testBackends(t) {
+		var wg sync.WaitGroup
+		errs := make(chan error, 64)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				visitor := Customer{Name: fmt.Sprintf("visitor-%d", i), Location: "a", Age: 30}
+				for n := 0; n < 10; n++ {
+					visitor.TimesVisited = n
+					if err := repo.Update(ctx, visitor); err != nil {
+						errs <- err
+						return
+					}
+					if _, err := repo.Get(ctx, "a", visitor.Name); err != nil {
+						errs <- err
+						return
+					}
+					batch := []Customer{{Name: "shared", Location: "a", Age: 40, TimesVisited: n}, visitor}
+					if err := repo.UpdateMany(ctx, batch); err != nil {
+						errs <- err
+						return
+					}
+					if _, err := repo.GetMany(ctx, "a", []string{"shared", visitor.Name}); err != nil {
+						errs <- err
+						return
+					}
+					if _, err := repo.List(ctx, "a"); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		listed, err := repo.List(ctx, "a")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(listed) != 9 {
+			t.Errorf("%s: listed %d customers, want the 8 visitors and the shared customer", name, len(listed))
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package customer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/migrations"
+	"strings"
+	"time"
+
+	// Register the drivers used by the SQL backends
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLCustomers is used to store customers in a SQL database
+// Both postgres and sqlite are supported, driver should be "postgres" or "sqlite3"
+type SQLCustomers struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewSQLCustomers will open the database and migrate its schema
+func NewSQLCustomers(driver, dsn string) (*SQLCustomers, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", driver, err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", driver, err)
+	}
+
+	sc := &SQLCustomers{
+		db:     db,
+		driver: driver,
+	}
+	if err := migrations.Up("customers", db, driver); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// rebind converts $1 style placeholders into the style of the driver
+func (sc *SQLCustomers) rebind(query string) string {
+	if sc.driver == "postgres" {
+		return query
+	}
+	return strings.ReplaceAll(query, "$", "?")
+}
+
+// Close will close the database connection
+func (sc *SQLCustomers) Close() error {
+	return sc.db.Close()
+}
+
+// Get is used to fetch a customer by Name in the location
+func (sc *SQLCustomers) Get(ctx context.Context, location, name string) (Customer, error) {
+	row := sc.db.QueryRowContext(ctx, sc.rebind(`SELECT name, age, times_visited, last_visit, email, phone, banned, location, loyalty_id, currency FROM customers WHERE location = $1 AND name = $2 AND deleted_at IS NULL`), location, name)
+
+	cust, err := scanCustomer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Customer{}, fmt.Errorf("%w: %s", ErrNoSuchCustomer, name)
+	}
+	return cust, err
+}
+
+// GetByLoyaltyID is used to fetch a customer by the loyalty ID in the location
+func (sc *SQLCustomers) GetByLoyaltyID(ctx context.Context, location, loyaltyID string) (Customer, error) {
+	if loyaltyID == "" {
+		return Customer{}, fmt.Errorf("%w: loyalty id %s", ErrNoSuchCustomer, loyaltyID)
+	}
+	row := sc.db.QueryRowContext(ctx, sc.rebind(`SELECT name, age, times_visited, last_visit, email, phone, banned, location, loyalty_id, currency FROM customers WHERE location = $1 AND loyalty_id = $2 AND deleted_at IS NULL`), location, loyaltyID)
+
+	cust, err := scanCustomer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Customer{}, fmt.Errorf("%w: loyalty id %s", ErrNoSuchCustomer, loyaltyID)
+	}
+	return cust, err
+}
+
+// Update will insert or override the information about a customer
+func (sc *SQLCustomers) Update(ctx context.Context, customer Customer) error {
+	return sc.upsert(ctx, sc.db, customer)
+}
+
+// sqlBatchSize keeps the placeholders of one query under the 999 older sqlite versions allow
+const sqlBatchSize = 500
+
+// GetMany is used to fetch the customers of the location by Name, one query for every sqlBatchSize names
+func (sc *SQLCustomers) GetMany(ctx context.Context, location string, names []string) (map[string]Customer, error) {
+	found := make(map[string]Customer, len(names))
+	for start := 0; start < len(names); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(names) {
+			end = len(names)
+		}
+		batch := names[start:end]
+
+		args := make([]interface{}, 0, len(batch)+1)
+		args = append(args, location)
+		placeholders := make([]string, len(batch))
+		for i, name := range batch {
+			args = append(args, name)
+			placeholders[i] = fmt.Sprintf("$%d", i+2)
+		}
+		rows, err := sc.db.QueryContext(ctx, sc.rebind(`SELECT name, age, times_visited, last_visit, email, phone, banned, location, loyalty_id, currency FROM customers
+			WHERE location = $1 AND deleted_at IS NULL AND name IN (`+strings.Join(placeholders, ", ")+`)`), args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get customers: %v", err)
+		}
+		for rows.Next() {
+			cust, err := scanCustomer(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			found[cust.Name] = cust
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// UpdateMany stores the customers in one transaction
+func (sc *SQLCustomers) UpdateMany(ctx context.Context, customers []Customer) error {
+	tx, err := sc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, customer := range customers {
+		if err := sc.upsert(ctx, tx, customer); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// execer is implemented by both sql.DB and sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// upsert writes the customer using db, which can be a transaction
+func (sc *SQLCustomers) upsert(ctx context.Context, db execer, customer Customer) error {
+	var deletedAt sql.NullTime
+	if customer.DeletedAt != nil {
+		deletedAt = sql.NullTime{Time: customer.DeletedAt.UTC(), Valid: true}
+	}
+	_, err := db.ExecContext(ctx, sc.rebind(`INSERT INTO customers (name, age, times_visited, last_visit, email, phone, banned, deleted_at, location, loyalty_id, currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (location, name) DO UPDATE SET
+			age = excluded.age,
+			times_visited = excluded.times_visited,
+			last_visit = excluded.last_visit,
+			email = excluded.email,
+			phone = excluded.phone,
+			banned = excluded.banned,
+			deleted_at = excluded.deleted_at,
+			loyalty_id = excluded.loyalty_id,
+			currency = excluded.currency`),
+		customer.Name, customer.Age, customer.TimesVisited, customer.LastVisit.UTC(), customer.Email, customer.Phone, customer.Banned, deletedAt, customer.Location, customer.LoyaltyID, customer.Currency)
+	if err != nil && isUniqueViolation(err) && customer.LoyaltyID != "" {
+		return fmt.Errorf("%w: %s", ErrLoyaltyIDTaken, customer.LoyaltyID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update customer: %v", err)
+	}
+	return nil
+}
+
+// Delete will soft delete a customer, the row is kept but no longer returned
+func (sc *SQLCustomers) Delete(ctx context.Context, location, name string) error {
+	result, err := sc.db.ExecContext(ctx, sc.rebind(`UPDATE customers SET deleted_at = $3 WHERE location = $1 AND name = $2 AND deleted_at IS NULL`), location, name, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to delete customer: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNoSuchCustomer, name)
+	}
+	return nil
+}
+
+// List returns all customers of the location sorted by name
+func (sc *SQLCustomers) List(ctx context.Context, location string) ([]Customer, error) {
+	rows, err := sc.db.QueryContext(ctx, sc.rebind(`SELECT name, age, times_visited, last_visit, email, phone, banned, location, loyalty_id, currency FROM customers WHERE location = $1 AND deleted_at IS NULL ORDER BY name`), location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list customers: %v", err)
+	}
+	defer rows.Close()
+
+	var customers []Customer
+	for rows.Next() {
+		cust, err := scanCustomer(rows)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, cust)
+	}
+	return customers, rows.Err()
+}
+
+// scanner is implemented by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCustomer reads a customer row
+func scanCustomer(row scanner) (Customer, error) {
+	var cust Customer
+	err := row.Scan(&cust.Name, &cust.Age, &cust.TimesVisited, &cust.LastVisit, &cust.Email, &cust.Phone, &cust.Banned, &cust.Location, &cust.LoyaltyID, &cust.Currency)
+	return cust, err
+}
+
+// isUniqueViolation is true when a write failed because of a unique index, the upsert only hits the loyalty index
+func isUniqueViolation(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unique constraint") || strings.Contains(msg, "duplicate key")
+}
@@ -0,0 +1,256 @@
+package customer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// FormatJSON exports customers as a JSON array
+	FormatJSON = "json"
+	// FormatCSV exports customers as CSV with a header row
+	FormatCSV = "csv"
+)
+
+// ConflictMode decides what happens when an imported customer already exists
+type ConflictMode string
+
+const (
+	// ConflictSkip keeps the stored customer
+	ConflictSkip ConflictMode = "skip"
+	// ConflictOverwrite replaces the stored customer
+	ConflictOverwrite ConflictMode = "overwrite"
+	// ConflictMerge adds the visit counts together and keeps the latest visit
+	ConflictMerge ConflictMode = "merge"
+)
+
+var csvHeader = []string{"name", "age", "timesVisited", "lastVisit", "email", "phone", "banned", "location", "loyaltyId", "currency"}
+
+// ImportResult reports what an import did
+type ImportResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// Export writes all customers of the location to w in the given format
+func Export(ctx context.Context, repo Repository, location string, w io.Writer, format string) error {
+	customers, err := repo.List(ctx, location)
+	if err != nil {
+		return err
+	}
+	return Encode(w, format, customers)
+}
+
+// Encode writes the customers to w in the given format
+func Encode(w io.Writer, format string, customers []Customer) error {
+	switch format {
+	case "", FormatJSON:
+		return json.NewEncoder(w).Encode(customers)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, cust := range customers {
+			lastVisit := ""
+			if !cust.LastVisit.IsZero() {
+				lastVisit = cust.LastVisit.Format(time.RFC3339)
+			}
+			record := []string{
+				cust.Name,
+				strconv.Itoa(cust.Age),
+				strconv.Itoa(cust.TimesVisited),
+				lastVisit,
+				cust.Email,
+				cust.Phone,
+				strconv.FormatBool(cust.Banned),
+				cust.Location,
+				cust.LoyaltyID,
+				cust.Currency,
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+// Decode reads customers in the given format
+func Decode(r io.Reader, format string) ([]Customer, error) {
+	switch format {
+	case "", FormatJSON:
+		var customers []Customer
+		if err := json.NewDecoder(r).Decode(&customers); err != nil {
+			return nil, fmt.Errorf("failed to decode customers: %v", err)
+		}
+		return customers, nil
+	case FormatCSV:
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv: %v", err)
+		}
+		if len(records) == 0 {
+			return nil, nil
+		}
+		customers := make([]Customer, 0, len(records)-1)
+		// First row is the header
+		for i, record := range records[1:] {
+			cust, err := parseRecord(record)
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %v", i+2, err)
+			}
+			customers = append(customers, cust)
+		}
+		return customers, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+// parseRecord turns a csv row into a Customer
+func parseRecord(record []string) (Customer, error) {
+	// Exports made before the banned, location, loyaltyId and currency columns were added have fewer columns
+	if len(record) < len(csvHeader)-4 || len(record) > len(csvHeader) {
+		return Customer{}, fmt.Errorf("expected %d columns, got %d", len(csvHeader), len(record))
+	}
+	var banned bool
+	if len(record) > 6 {
+		var err error
+		banned, err = strconv.ParseBool(record[6])
+		if err != nil {
+			return Customer{}, fmt.Errorf("invalid banned: %v", err)
+		}
+	}
+	var location string
+	if len(record) > 7 {
+		location = record[7]
+	}
+	var loyaltyID string
+	if len(record) > 8 {
+		loyaltyID = record[8]
+	}
+	var currency string
+	if len(record) > 9 {
+		currency = record[9]
+	}
+	age, err := strconv.Atoi(record[1])
+	if err != nil {
+		return Customer{}, fmt.Errorf("invalid age: %v", err)
+	}
+	visits, err := strconv.Atoi(record[2])
+	if err != nil {
+		return Customer{}, fmt.Errorf("invalid timesVisited: %v", err)
+	}
+	var lastVisit time.Time
+	if record[3] != "" {
+		lastVisit, err = time.Parse(time.RFC3339, record[3])
+		if err != nil {
+			return Customer{}, fmt.Errorf("invalid lastVisit: %v", err)
+		}
+	}
+	return Customer{
+		Name:         record[0],
+		Age:          age,
+		TimesVisited: visits,
+		LastVisit:    lastVisit,
+		Email:        record[4],
+		Phone:        record[5],
+		Banned:       banned,
+		Location:     location,
+		LoyaltyID:    loyaltyID,
+		Currency:     currency,
+	}, nil
+}
+
+// Import stores the customers in the location, resolving existing customers with mode
+// The location of the imported records is ignored so a file can never write into another location
+// The customers are stored in one call, when it fails none of them are imported
+func Import(ctx context.Context, repo Repository, location string, customers []Customer, mode ConflictMode) (ImportResult, error) {
+	var result ImportResult
+	switch mode {
+	case ConflictSkip, ConflictOverwrite, ConflictMerge:
+	default:
+		return result, fmt.Errorf("unknown conflict mode: %s", mode)
+	}
+
+	// The stored customers are fetched and the result stored in one call each instead of two calls per customer
+	names := make([]string, len(customers))
+	for i, incoming := range customers {
+		names[i] = incoming.Name
+	}
+	stored, err := repo.GetMany(ctx, location, names)
+	if err != nil {
+		return result, err
+	}
+
+	// pending are the customers to store by name, a name imported twice sees the first as existing
+	pending := make(map[string]int, len(customers))
+	var updates []Customer
+	for _, incoming := range customers {
+		incoming.Location = location
+		existing, ok := stored[incoming.Name]
+		if i, seen := pending[incoming.Name]; seen {
+			existing, ok = updates[i], true
+		}
+		if !ok {
+			pending[incoming.Name] = len(updates)
+			updates = append(updates, incoming)
+			result.Created++
+			continue
+		}
+
+		switch mode {
+		case ConflictSkip:
+			result.Skipped++
+			continue
+		case ConflictOverwrite:
+		case ConflictMerge:
+			incoming = merge(existing, incoming)
+		}
+
+		if i, seen := pending[incoming.Name]; seen {
+			updates[i] = incoming
+		} else {
+			pending[incoming.Name] = len(updates)
+			updates = append(updates, incoming)
+		}
+		result.Updated++
+	}
+	if err := repo.UpdateMany(ctx, updates); err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// merge combines an existing and incoming customer, visit counts are added together
+func merge(existing, incoming Customer) Customer {
+	merged := incoming
+	merged.TimesVisited = existing.TimesVisited + incoming.TimesVisited
+	if existing.LastVisit.After(incoming.LastVisit) {
+		merged.LastVisit = existing.LastVisit
+	}
+	if merged.Email == "" {
+		merged.Email = existing.Email
+	}
+	if merged.Phone == "" {
+		merged.Phone = existing.Phone
+	}
+	if merged.LoyaltyID == "" {
+		merged.LoyaltyID = existing.LoyaltyID
+	}
+	if merged.Currency == "" {
+		merged.Currency = existing.Currency
+	}
+	merged.Banned = existing.Banned || incoming.Banned
+	return merged
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/ops"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// MetricTasksAbandoned is counted for every activity still running once the workers stopped, tagged with the activity
+const MetricTasksAbandoned = "worker_tasks_abandoned"
+
+// abandonGrace is how long the abandoned activities get to return after their context is canceled
+// Cadence flushes the buffered heartbeat of an activity that fails, so they can resume from it on another worker
+const abandonGrace = 2 * time.Second
+
+// drain stops the workers so the process can exit without losing work
+// The workers stop polling right away and wait up to the stop timeout for the tasks they are running, cadence then
+// cancels the context of the activities that are left, they are counted as abandoned and retried elsewhere
+func drain(workers map[string]engine.Worker, readiness *ops.Readiness, scope tally.Scope, logger *zap.Logger) {
+	readiness.Set(false, "draining")
+	logger.Info("Draining workers", zap.Any("running", registry.Running()))
+
+	var wg sync.WaitGroup
+	for taskList, w := range workers {
+		wg.Add(1)
+		go func(taskList string, w engine.Worker) {
+			defer wg.Done()
+			w.Stop()
+			logger.Info("Stopped Worker.", zap.String("worker", taskList))
+		}(taskList, w)
+	}
+	wg.Wait()
+
+	abandoned := registry.Running()
+	if len(abandoned) == 0 {
+		logger.Info("Drained workers")
+		return
+	}
+	for name, count := range abandoned {
+		scope.Tagged(map[string]string{"activity": name}).Counter(MetricTasksAbandoned).Inc(int64(count))
+	}
+	logger.Warn("Abandoned running activities at the stop timeout", zap.Any("abandoned", abandoned))
+
+	deadline := time.Now().Add(abandonGrace)
+	for len(registry.Running()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+)
+
+// NewClient adapts the cadence client of the domain to a Client, service is the connection the client was created with
+func NewClient(c client.Client, service workflowserviceclient.Interface, domain string) Client {
+	return &cadenceClient{client: c, service: service, domain: domain}
+}
+
+type cadenceClient struct {
+	client client.Client
+	// service is used for the requests the client does not expose all options of
+	service workflowserviceclient.Interface
+	// domain is needed by the requests that are not wrapped by the client
+	domain string
+}
+
+func (cc *cadenceClient) options(opts StartOptions) client.StartWorkflowOptions {
+	options := client.StartWorkflowOptions{
+		ID:                           opts.ID,
+		TaskList:                     opts.TaskList,
+		ExecutionStartToCloseTimeout: opts.ExecutionTimeout,
+		CronSchedule:                 opts.CronSchedule,
+		DelayStart:                   opts.DelayStart,
+	}
+	if opts.AllowDuplicate {
+		options.WorkflowIDReusePolicy = client.WorkflowIDReusePolicyAllowDuplicate
+	}
+	return options
+}
+
+func (cc *cadenceClient) ExecuteWorkflow(ctx context.Context, opts StartOptions, workflow string, args ...interface{}) (Run, error) {
+	run, err := cc.client.ExecuteWorkflow(ctx, cc.options(opts), workflow, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &cadenceRun{id: run.GetID(), runID: run.GetRunID(), client: cc.client}, nil
+}
+
+func (cc *cadenceClient) StartWorkflow(ctx context.Context, opts StartOptions, workflow string, args ...interface{}) (Run, error) {
+	execution, err := cc.client.StartWorkflow(ctx, cc.options(opts), workflow, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &cadenceRun{id: execution.ID, runID: execution.RunID, client: cc.client}, nil
+}
+
+func (cc *cadenceClient) SignalWorkflow(ctx context.Context, id, runID, signal string, arg interface{}) error {
+	return cc.client.SignalWorkflow(ctx, id, runID, signal, arg)
+}
+
+func (cc *cadenceClient) SignalWithStartWorkflow(ctx context.Context, signal string, arg interface{}, opts StartOptions, workflow string, args ...interface{}) (Run, error) {
+	execution, err := cc.client.SignalWithStartWorkflow(ctx, opts.ID, signal, arg, cc.options(opts), workflow, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &cadenceRun{id: execution.ID, runID: execution.RunID, client: cc.client}, nil
+}
+
+func (cc *cadenceClient) QueryWorkflow(ctx context.Context, id, runID, query string, args ...interface{}) (Value, error) {
+	return cc.client.QueryWorkflow(ctx, id, runID, query, args...)
+}
+
+func (cc *cadenceClient) DescribeWorkflow(ctx context.Context, id, runID string) (Description, error) {
+	description, err := cc.client.DescribeWorkflowExecution(ctx, id, runID)
+	if err != nil {
+		return Description{}, err
+	}
+	info := description.WorkflowExecutionInfo
+	result := Description{RunID: info.Execution.GetRunId(), Open: info.CloseStatus == nil}
+	if info.CloseStatus != nil {
+		result.Status = info.CloseStatus.String()
+	}
+	return result, nil
+}
+
+func (cc *cadenceClient) LastGoodDecision(ctx context.Context, id, runID string) (int64, error) {
+	var last int64
+	iter := cc.client.GetWorkflowHistory(ctx, id, runID, false, shared.HistoryEventFilterTypeAllEvent)
+	for iter.HasNext() {
+		event, err := iter.Next()
+		if err != nil {
+			return 0, err
+		}
+		switch event.GetEventType() {
+		case shared.EventTypeDecisionTaskCompleted:
+			last = event.GetEventId()
+		case shared.EventTypeDecisionTaskFailed:
+			if last != 0 {
+				return last, nil
+			}
+		}
+	}
+	if last == 0 {
+		return 0, ErrNoDecision
+	}
+	return last, nil
+}
+
+func (cc *cadenceClient) ResetWorkflow(ctx context.Context, id, runID string, eventID int64, reason string) (string, error) {
+	response, err := cc.client.ResetWorkflow(ctx, &shared.ResetWorkflowExecutionRequest{
+		Domain:                &cc.domain,
+		WorkflowExecution:     &shared.WorkflowExecution{WorkflowId: &id, RunId: &runID},
+		Reason:                &reason,
+		DecisionFinishEventId: &eventID,
+		RequestId:             stringPtr(newRequestID()),
+	})
+	if err != nil {
+		return "", err
+	}
+	return response.GetRunId(), nil
+}
+
+func (cc *cadenceClient) ListWorkflows(ctx context.Context, query string, limit int) ([]Execution, error) {
+	var executions []Execution
+	var token []byte
+	for {
+		response, err := cc.client.ListWorkflow(ctx, &shared.ListWorkflowExecutionsRequest{
+			Domain:        &cc.domain,
+			PageSize:      int32Ptr(pageSize(limit - len(executions))),
+			NextPageToken: token,
+			Query:         &query,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range response.Executions {
+			executions = append(executions, Execution{
+				ID:      info.Execution.GetWorkflowId(),
+				RunID:   info.Execution.GetRunId(),
+				Type:    info.Type.GetName(),
+				Started: time.Unix(0, info.GetStartTime()),
+			})
+			if len(executions) >= limit {
+				return executions, nil
+			}
+		}
+		token = response.NextPageToken
+		if len(token) == 0 {
+			return executions, nil
+		}
+	}
+}
+
+func (cc *cadenceClient) CancelWorkflow(ctx context.Context, id, runID string) error {
+	return cc.client.CancelWorkflow(ctx, id, runID)
+}
+
+func (cc *cadenceClient) TerminateWorkflow(ctx context.Context, id, runID, reason string) error {
+	return cc.client.TerminateWorkflow(ctx, id, runID, reason, nil)
+}
+
+func (cc *cadenceClient) CompleteActivity(ctx context.Context, taskToken []byte, result interface{}, err error) error {
+	return cc.client.CompleteActivity(ctx, taskToken, result, err)
+}
+
+func (cc *cadenceClient) DescribeTaskList(ctx context.Context, taskList string) (TaskListStatus, error) {
+	var status TaskListStatus
+	for _, taskListType := range []shared.TaskListType{shared.TaskListTypeDecision, shared.TaskListTypeActivity} {
+		// The client does not ask for the backlog, so the service is called directly
+		response, err := cc.service.DescribeTaskList(ctx, &shared.DescribeTaskListRequest{
+			Domain:                &cc.domain,
+			TaskList:              &shared.TaskList{Name: &taskList},
+			TaskListType:          taskListType.Ptr(),
+			IncludeTaskListStatus: boolPtr(true),
+		})
+		if err != nil {
+			return TaskListStatus{}, err
+		}
+		if taskListType == shared.TaskListTypeDecision {
+			status.Pollers = len(response.GetPollers())
+		}
+		status.Backlog += response.GetTaskListStatus().GetBacklogCountHint()
+	}
+	return status, nil
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// cadenceRun is a started cadence workflow
+type cadenceRun struct {
+	id     string
+	runID  string
+	client client.Client
+}
+
+func (r *cadenceRun) ID() string    { return r.id }
+func (r *cadenceRun) RunID() string { return r.runID }
+
+func (r *cadenceRun) Get(ctx context.Context, valuePtr interface{}) error {
+	return r.client.GetWorkflow(ctx, r.id, r.runID).Get(ctx, valuePtr)
+}
+
+// IsAlreadyStarted is true when the workflow could not be started since its ID is already open
+func IsAlreadyStarted(err error) bool {
+	_, ok := err.(*shared.WorkflowExecutionAlreadyStartedError)
+	return ok
+}
+
+// IsNotFound is true when the workflow does not exist
+func IsNotFound(err error) bool {
+	_, ok := err.(*shared.EntityNotExistsError)
+	return ok
+}
+
+// The workflow helpers, workflows that use these instead of the cadence packages move to another engine unchanged
+type (
+	Context              = workflow.Context
+	Future               = workflow.Future
+	Settable             = workflow.Settable
+	Channel              = workflow.Channel
+	Selector             = workflow.Selector
+	WaitGroup            = workflow.WaitGroup
+	ActivityOptions      = workflow.ActivityOptions
+	ChildWorkflowOptions = workflow.ChildWorkflowOptions
+	RetryPolicy          = cadence.RetryPolicy
+)
+
+var (
+	ExecuteActivity        = workflow.ExecuteActivity
+	ExecuteChildWorkflow   = workflow.ExecuteChildWorkflow
+	WithActivityOptions    = workflow.WithActivityOptions
+	WithChildOptions       = workflow.WithChildOptions
+	WithRetryPolicy        = workflow.WithRetryPolicy
+	GetSignalChannel       = workflow.GetSignalChannel
+	SignalExternalWorkflow = workflow.SignalExternalWorkflow
+	SetQueryHandler        = workflow.SetQueryHandler
+	NewSelector            = workflow.NewSelector
+	NewChannel             = workflow.NewChannel
+	NewBufferedChannel     = workflow.NewBufferedChannel
+	NewWaitGroup           = workflow.NewWaitGroup
+	NewFuture              = workflow.NewFuture
+	NewTimer               = workflow.NewTimer
+	Sleep                  = workflow.Sleep
+	Now                    = workflow.Now
+	Go                     = workflow.Go
+	GetLogger              = workflow.GetLogger
+	NewContinueAsNewError  = workflow.NewContinueAsNewError
+)
+
+// NewApplicationError is an error crossing activity and workflow boundaries with a reason retry policies can match
+func NewApplicationError(reason string, details ...interface{}) error {
+	return cadence.NewCustomError(reason, details...)
+}
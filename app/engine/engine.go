@@ -0,0 +1,125 @@
+// Package engine is the seam between the tavern and the workflow engine it runs on
+// The API and worker talk to the engine through this package, and workflows can use its helpers instead of the
+// cadence workflow package. cadence.go adapts the cadence SDK, moving to Temporal is done by moving packages over to
+// engine one at a time and adding a temporal adapter of the same helpers once the SDK is in go.mod
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// QueryStackTrace is the built in query answered by every workflow with the stack of its goroutines
+const QueryStackTrace = "__stack_trace"
+
+// StartOptions are the options a workflow is started with
+type StartOptions struct {
+	ID       string
+	TaskList string
+	// ExecutionTimeout is how long the workflow may run
+	ExecutionTimeout time.Duration
+	// AllowDuplicate allows the ID to be reused once the previous run is closed, no matter how it closed
+	AllowDuplicate bool
+	// CronSchedule runs the workflow on the schedule, such as 0 3 * * * for every night at three
+	CronSchedule string
+	// DelayStart waits before the first run is started
+	DelayStart time.Duration
+}
+
+// Run is a started workflow
+type Run interface {
+	ID() string
+	RunID() string
+	// Get waits for the workflow to complete and reads the result into valuePtr
+	Get(ctx context.Context, valuePtr interface{}) error
+}
+
+// Value is the encoded result of a query
+type Value interface {
+	Get(valuePtr interface{}) error
+}
+
+// Description is the state of a workflow execution
+type Description struct {
+	// RunID is the run that was described, the current run when no run was asked for
+	RunID string
+	// Open is true while the workflow is running
+	Open bool
+	// Status is how the workflow closed, it is empty while the workflow is open
+	Status string
+}
+
+// TaskListStatus is the state of a task list as seen by the server
+type TaskListStatus struct {
+	// Pollers are the workers that recently polled the task list for decisions
+	Pollers int
+	// Backlog is an estimate of the decision and activity tasks waiting for a worker
+	Backlog int64
+}
+
+// Execution is a workflow run found by ListWorkflows
+type Execution struct {
+	ID      string    `json:"id"`
+	RunID   string    `json:"run_id"`
+	Type    string    `json:"type"`
+	Started time.Time `json:"started"`
+}
+
+// Client is what the API needs from a workflow engine, workflows are referred to by their registered name
+type Client interface {
+	ExecuteWorkflow(ctx context.Context, opts StartOptions, workflow string, args ...interface{}) (Run, error)
+	// StartWorkflow starts the workflow without waiting for it, IsAlreadyStarted is true for the error when the ID is open
+	StartWorkflow(ctx context.Context, opts StartOptions, workflow string, args ...interface{}) (Run, error)
+	SignalWorkflow(ctx context.Context, id, runID, signal string, arg interface{}) error
+	SignalWithStartWorkflow(ctx context.Context, signal string, arg interface{}, opts StartOptions, workflow string, args ...interface{}) (Run, error)
+	QueryWorkflow(ctx context.Context, id, runID, query string, args ...interface{}) (Value, error)
+	// DescribeWorkflow fails with an error IsNotFound is true for when the workflow has never run
+	DescribeWorkflow(ctx context.Context, id, runID string) (Description, error)
+	// LastGoodDecision is the ID of the last completed decision before the first failed decision, or the last
+	// completed decision when none failed. A non-deterministic deploy shows up as failing decisions, so this is
+	// where the workflow is reset to when the operator does not pick an event
+	LastGoodDecision(ctx context.Context, id, runID string) (int64, error)
+	// ResetWorkflow replays the run up to the decision completed in eventID and continues it as a new run
+	ResetWorkflow(ctx context.Context, id, runID string, eventID int64, reason string) (string, error)
+	// ListWorkflows returns at most limit runs matching the visibility query, it needs advanced visibility
+	ListWorkflows(ctx context.Context, query string, limit int) ([]Execution, error)
+	// CancelWorkflow asks the run to cancel, the workflow can clean up before it closes
+	CancelWorkflow(ctx context.Context, id, runID string) error
+	// TerminateWorkflow closes the run right away without running any more workflow code
+	TerminateWorkflow(ctx context.Context, id, runID, reason string) error
+	// CompleteActivity completes an activity that returned without a result, with the result or with err when it failed
+	CompleteActivity(ctx context.Context, taskToken []byte, result interface{}, err error) error
+	// DescribeTaskList returns the pollers and the backlog of the task list
+	DescribeTaskList(ctx context.Context, taskList string) (TaskListStatus, error)
+}
+
+// ErrNoDecision is returned by LastGoodDecision when the workflow has not completed any decision
+var ErrNoDecision = errors.New("workflow has no completed decision to reset to")
+
+// newRequestID generates a UUID so a request that is retried is only done once
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Worker polls a task list, cadence workers satisfy it
+type Worker interface {
+	Start() error
+	Stop()
+}
+
+// pageSize is the size of the next page of a list that should stop after remaining more results
+func pageSize(remaining int) int32 {
+	if remaining > 1000 {
+		return 1000
+	}
+	return int32(remaining)
+}
@@ -0,0 +1,460 @@
+//go:build standalone
+// +build standalone
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/.gen/go/shared"
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/encoded"
+	"go.uber.org/cadence/interceptors"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/cadence/worker"
+	"go.uber.org/cadence/workflow"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// standaloneIdle is how long a standalone run may wait for a signal, the test environment fails runs idle for longer
+const standaloneIdle = 365 * 24 * time.Hour
+
+// ErrStandalone is returned for the requests that need the history or visibility of a Cadence server
+var ErrStandalone = errors.New("not supported by the standalone engine, it needs a Cadence server")
+
+// NewStandaloneClient runs the workflows registered in this process in memory, there is no Cadence server to talk to
+// Every started workflow runs in its own test environment of the cadence SDK, so nothing survives a restart. Children
+// run in the environment of their parent, they can be signalled but not queried. Only the DataConverter,
+// ContextPropagators, Logger, MetricsScope and WorkflowInterceptorChainFactories of the options are used. Runs that
+// continue as new are closed, there is no next run, and the cron schedules and delays of the start options are ignored
+func NewStandaloneClient(options worker.Options) Client {
+	sc := &standaloneClient{
+		options:  options,
+		runs:     make(map[string]*standaloneRun),
+		children: make(map[string]*standaloneRun),
+	}
+	if options.Logger != nil {
+		sc.suite.SetLogger(options.Logger)
+	}
+	return sc
+}
+
+type standaloneClient struct {
+	options worker.Options
+	suite   testsuite.WorkflowTestSuite
+
+	mu sync.Mutex
+	// runs are the latest run of every workflow ID started
+	runs map[string]*standaloneRun
+	// children are the open children by workflow ID, with the run whose environment they run in
+	children map[string]*standaloneRun
+}
+
+// standaloneRun is a workflow running in its own test environment
+type standaloneRun struct {
+	id       string
+	runID    string
+	taskList string
+	env      *testsuite.TestWorkflowEnvironment
+	// done is closed once the run closed, status is set before
+	done chan struct{}
+	// err is why the test environment could not run the workflow, such as an unknown workflow type
+	err error
+
+	mu sync.Mutex
+	// status is how the run closed, it is empty while the run is open
+	status     string
+	terminated bool
+	// pending are the task tokens of the activities that are running
+	pending map[string]bool
+}
+
+func (sr *standaloneRun) ID() string    { return sr.id }
+func (sr *standaloneRun) RunID() string { return sr.runID }
+
+func (sr *standaloneRun) Get(ctx context.Context, valuePtr interface{}) error {
+	select {
+	case <-sr.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if sr.err != nil {
+		return sr.err
+	}
+	return sr.env.GetWorkflowResult(valuePtr)
+}
+
+// closeStatus is how the run closed, empty while it is open
+func (sr *standaloneRun) closeStatus() string {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.status
+}
+
+// inLoop runs fn in the main loop of the environment, so it does not race the workflow
+// False when the run closed before fn ran
+func (sr *standaloneRun) inLoop(ctx context.Context, fn func()) (bool, error) {
+	ran := make(chan struct{})
+	sr.env.RegisterDelayedCallback(func() {
+		fn()
+		close(ran)
+	}, 0)
+	select {
+	case <-ran:
+		return true, nil
+	case <-sr.done:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// find returns the run of the workflow, the latest run when runID is empty
+func (sc *standaloneClient) find(id, runID string) (*standaloneRun, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	run, ok := sc.runs[id]
+	if !ok || (runID != "" && run.runID != runID) {
+		return nil, &shared.EntityNotExistsError{Message: fmt.Sprintf("workflow %s does not exist", id)}
+	}
+	return run, nil
+}
+
+// start runs the workflow in a new test environment, signal is delivered before the first decision when it is not empty
+func (sc *standaloneClient) start(opts StartOptions, workflowType string, args []interface{}, signal string, signalArg interface{}) (*standaloneRun, error) {
+	if opts.ID == "" {
+		opts.ID = newRequestID()
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if previous, ok := sc.runs[opts.ID]; ok {
+		status := previous.closeStatus()
+		// Like the default reuse policy of Cadence, a completed workflow is only started again when duplicates are allowed
+		if status == "" || (status == shared.WorkflowExecutionCloseStatusCompleted.String() && !opts.AllowDuplicate) {
+			return nil, &shared.WorkflowExecutionAlreadyStartedError{Message: stringPtr(fmt.Sprintf("workflow %s is already started", opts.ID))}
+		}
+	}
+	if _, ok := sc.children[opts.ID]; ok {
+		return nil, &shared.WorkflowExecutionAlreadyStartedError{Message: stringPtr(fmt.Sprintf("workflow %s is already started", opts.ID))}
+	}
+
+	run := &standaloneRun{
+		id:       opts.ID,
+		runID:    newRequestID(),
+		taskList: opts.TaskList,
+		env:      sc.suite.NewTestWorkflowEnvironment(),
+		done:     make(chan struct{}),
+		pending:  make(map[string]bool),
+	}
+	options := sc.options
+	options.WorkflowInterceptorChainFactories = append(append([]interceptors.WorkflowInterceptorFactory{},
+		sc.options.WorkflowInterceptorChainFactories...), &standaloneFactory{run: run})
+	run.env.SetWorkerOptions(options)
+	run.env.SetTestTimeout(standaloneIdle)
+	run.env.SetWorkflowTimeout(opts.ExecutionTimeout)
+	run.env.RegisterActivityWithOptions(func(ctx context.Context) error {
+		// The activity is abandoned when the run closes, it only has to return then
+		<-run.done
+		return nil
+	}, activity.RegisterOptions{Name: standaloneKeepalive})
+	sc.listen(run)
+
+	if signal != "" {
+		// Callbacks run in order, so the signal is sent once the run is started
+		run.env.RegisterDelayedCallback(func() {
+			run.env.SignalWorkflow(signal, signalArg)
+		}, 0)
+	}
+	sc.runs[opts.ID] = run
+	go sc.execute(run, workflowType, args)
+	return run, nil
+}
+
+// listen routes the requests of the run to other workflows through the client and keeps track of its children
+func (sc *standaloneClient) listen(run *standaloneRun) {
+	env := run.env
+	env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		func(domain, id, runID, signal string, arg interface{}) error {
+			return sc.SignalWorkflow(context.Background(), id, runID, signal, arg)
+		})
+	env.OnRequestCancelExternalWorkflow(mock.Anything, mock.Anything, mock.Anything).Return(
+		func(domain, id, runID string) error {
+			return sc.CancelWorkflow(context.Background(), id, runID)
+		})
+
+	env.SetOnChildWorkflowStartedListener(func(info *workflow.Info, ctx workflow.Context, args encoded.Values) {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		sc.children[info.WorkflowExecution.ID] = run
+	})
+	env.SetOnChildWorkflowCompletedListener(func(info *workflow.Info, result encoded.Value, err error) {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		if sc.children[info.WorkflowExecution.ID] == run {
+			delete(sc.children, info.WorkflowExecution.ID)
+		}
+	})
+
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args encoded.Values) {
+		if info.ActivityType.Name == standaloneKeepalive {
+			return
+		}
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		run.pending[string(info.TaskToken)] = true
+	})
+	done := func(info *activity.Info) {
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		delete(run.pending, string(info.TaskToken))
+	}
+	env.SetOnActivityCompletedListener(func(info *activity.Info, result encoded.Value, err error) { done(info) })
+	env.SetOnActivityCanceledListener(done)
+}
+
+// execute runs the workflow until it closes and records how it closed
+func (sc *standaloneClient) execute(run *standaloneRun, workflowType string, args []interface{}) {
+	status := shared.WorkflowExecutionCloseStatusFailed
+	defer func() {
+		// The test environment panics when the workflow can not be run
+		if r := recover(); r != nil {
+			run.err = fmt.Errorf("workflow %s failed to run: %v", workflowType, r)
+		}
+
+		run.mu.Lock()
+		if run.terminated {
+			status = shared.WorkflowExecutionCloseStatusTerminated
+		}
+		run.status = status.String()
+		run.mu.Unlock()
+
+		sc.mu.Lock()
+		for id, owner := range sc.children {
+			if owner == run {
+				delete(sc.children, id)
+			}
+		}
+		sc.mu.Unlock()
+		close(run.done)
+	}()
+
+	run.env.ExecuteWorkflow(workflowType, args...)
+	err := run.env.GetWorkflowError()
+	var (
+		canceled    *cadence.CanceledError
+		timeout     *workflow.TimeoutError
+		continueNew *workflow.ContinueAsNewError
+	)
+	switch {
+	case err == nil:
+		status = shared.WorkflowExecutionCloseStatusCompleted
+	case errors.As(err, &canceled):
+		status = shared.WorkflowExecutionCloseStatusCanceled
+	case errors.As(err, &timeout):
+		status = shared.WorkflowExecutionCloseStatusTimedOut
+	case errors.As(err, &continueNew):
+		status = shared.WorkflowExecutionCloseStatusContinuedAsNew
+	}
+}
+
+func (sc *standaloneClient) ExecuteWorkflow(ctx context.Context, opts StartOptions, workflow string, args ...interface{}) (Run, error) {
+	return sc.StartWorkflow(ctx, opts, workflow, args...)
+}
+
+func (sc *standaloneClient) StartWorkflow(ctx context.Context, opts StartOptions, workflow string, args ...interface{}) (Run, error) {
+	return sc.start(opts, workflow, args, "", nil)
+}
+
+func (sc *standaloneClient) SignalWorkflow(ctx context.Context, id, runID, signal string, arg interface{}) error {
+	run, err := sc.find(id, runID)
+	if err == nil && run.closeStatus() == "" {
+		run.env.SignalWorkflow(signal, arg)
+		return nil
+	}
+
+	// Children are signalled through the environment of their parent
+	sc.mu.Lock()
+	owner, ok := sc.children[id]
+	sc.mu.Unlock()
+	if !ok {
+		return &shared.EntityNotExistsError{Message: fmt.Sprintf("workflow %s is not running", id)}
+	}
+	var signalErr error
+	ran, err := owner.inLoop(ctx, func() {
+		signalErr = owner.env.SignalWorkflowByID(id, signal, arg)
+	})
+	if err != nil {
+		return err
+	}
+	if !ran {
+		return &shared.EntityNotExistsError{Message: fmt.Sprintf("workflow %s is not running", id)}
+	}
+	return signalErr
+}
+
+func (sc *standaloneClient) SignalWithStartWorkflow(ctx context.Context, signal string, arg interface{}, opts StartOptions, workflow string, args ...interface{}) (Run, error) {
+	if run, err := sc.find(opts.ID, ""); err == nil && run.closeStatus() == "" {
+		run.env.SignalWorkflow(signal, arg)
+		return run, nil
+	}
+	return sc.start(opts, workflow, args, signal, arg)
+}
+
+func (sc *standaloneClient) QueryWorkflow(ctx context.Context, id, runID, query string, args ...interface{}) (Value, error) {
+	run, err := sc.find(id, runID)
+	if err != nil {
+		sc.mu.Lock()
+		_, child := sc.children[id]
+		sc.mu.Unlock()
+		if child {
+			return nil, fmt.Errorf("workflow %s is a child, %w", id, ErrStandalone)
+		}
+		return nil, err
+	}
+
+	var (
+		value    Value
+		queryErr error
+	)
+	ask := func() {
+		value, queryErr = run.env.QueryWorkflow(query, args...)
+	}
+	ran, err := run.inLoop(ctx, ask)
+	if err != nil {
+		return nil, err
+	}
+	if !ran {
+		// The run closed, nothing else touches the environment anymore
+		ask()
+	}
+	return value, queryErr
+}
+
+func (sc *standaloneClient) DescribeWorkflow(ctx context.Context, id, runID string) (Description, error) {
+	run, err := sc.find(id, runID)
+	if err != nil {
+		sc.mu.Lock()
+		_, child := sc.children[id]
+		sc.mu.Unlock()
+		if child {
+			return Description{Open: true}, nil
+		}
+		return Description{}, err
+	}
+	status := run.closeStatus()
+	return Description{RunID: run.runID, Open: status == "", Status: status}, nil
+}
+
+func (sc *standaloneClient) LastGoodDecision(ctx context.Context, id, runID string) (int64, error) {
+	return 0, ErrStandalone
+}
+
+func (sc *standaloneClient) ResetWorkflow(ctx context.Context, id, runID string, eventID int64, reason string) (string, error) {
+	return "", ErrStandalone
+}
+
+func (sc *standaloneClient) ListWorkflows(ctx context.Context, query string, limit int) ([]Execution, error) {
+	return nil, ErrStandalone
+}
+
+func (sc *standaloneClient) CancelWorkflow(ctx context.Context, id, runID string) error {
+	run, err := sc.find(id, runID)
+	if err != nil {
+		return err
+	}
+	if run.closeStatus() != "" {
+		return &shared.EntityNotExistsError{Message: fmt.Sprintf("workflow %s is not running", id)}
+	}
+	run.env.CancelWorkflow()
+	return nil
+}
+
+// TerminateWorkflow cancels the run, the test environment can not stop a workflow without running its code
+func (sc *standaloneClient) TerminateWorkflow(ctx context.Context, id, runID, reason string) error {
+	run, err := sc.find(id, runID)
+	if err != nil {
+		return err
+	}
+	run.mu.Lock()
+	run.terminated = true
+	run.mu.Unlock()
+	return sc.CancelWorkflow(ctx, id, runID)
+}
+
+// CompleteActivity completes the activity in the run that is waiting on the task token
+// The tokens of the test environment are only unique within a run, so a token more than one run waits on fails
+func (sc *standaloneClient) CompleteActivity(ctx context.Context, taskToken []byte, result interface{}, err error) error {
+	sc.mu.Lock()
+	var waiting []*standaloneRun
+	for _, run := range sc.runs {
+		run.mu.Lock()
+		if run.pending[string(taskToken)] {
+			waiting = append(waiting, run)
+		}
+		run.mu.Unlock()
+	}
+	sc.mu.Unlock()
+
+	switch len(waiting) {
+	case 0:
+		return &shared.EntityNotExistsError{Message: "no activity is waiting on the task token"}
+	case 1:
+		return waiting[0].env.CompleteActivity(taskToken, result, err)
+	default:
+		return fmt.Errorf("%d runs wait on the task token, %w", len(waiting), ErrStandalone)
+	}
+}
+
+// DescribeTaskList reports this process as the only poller, the activities run the moment they are scheduled
+func (sc *standaloneClient) DescribeTaskList(ctx context.Context, taskList string) (TaskListStatus, error) {
+	return TaskListStatus{Pollers: 1}, nil
+}
+
+// standaloneKeepalive is the activity every standalone run keeps running until it closes
+// The test environment skips ahead to the next timer whenever no activity is running, the keepalive makes timers and
+// timeouts fire on the wall clock instead, like they would with a Cadence server
+const standaloneKeepalive = "standalone.keepalive"
+
+// standaloneInterceptor gives the run its workflow ID, run ID and task list, the test environment uses the same for
+// every run, and runs the keepalive next to it
+type standaloneInterceptor struct {
+	interceptors.WorkflowInterceptorBase
+	run  *standaloneRun
+	root bool
+}
+
+// standaloneFactory creates the interceptor of the run, it is the last of the chain so the keepalive is not reported
+type standaloneFactory struct {
+	run *standaloneRun
+}
+
+func (sf *standaloneFactory) NewInterceptor(info *workflow.Info, next interceptors.WorkflowInterceptor) interceptors.WorkflowInterceptor {
+	// Children in the environment already have the ID they were started with
+	root := info.ParentWorkflowExecution == nil
+	if root {
+		info.WorkflowExecution.ID = sf.run.id
+		info.WorkflowExecution.RunID = sf.run.runID
+		if sf.run.taskList != "" {
+			info.TaskListName = sf.run.taskList
+		}
+	}
+	return &standaloneInterceptor{WorkflowInterceptorBase: interceptors.WorkflowInterceptorBase{Next: next}, run: sf.run, root: root}
+}
+
+func (si *standaloneInterceptor) ExecuteWorkflow(ctx workflow.Context, workflowType string, args ...interface{}) []interface{} {
+	if si.root {
+		keepaliveCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			ScheduleToStartTimeout: standaloneIdle,
+			StartToCloseTimeout:    standaloneIdle,
+		})
+		si.Next.ExecuteActivity(keepaliveCtx, standaloneKeepalive)
+	}
+	return si.Next.ExecuteWorkflow(ctx, workflowType, args...)
+}
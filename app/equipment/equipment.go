@@ -0,0 +1,136 @@
+package equipment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/menu"
+	"programmingpercy/cadence-tavern/workflows/callback"
+	"time"
+)
+
+// The machines drinks are poured with
+const (
+	MachineTap      = "tap"
+	MachineEspresso = "espresso"
+)
+
+var (
+	// Pours is the store of the pours waiting for a machine, the binaries replace this during startup
+	// The Worker registers pours and the API completes them, so both need to use the same backend
+	Pours Store = NewMemoryStore()
+
+	// ErrNoSuchPour is returned when the pour is not waiting, it was never started or is already completed
+	ErrNoSuchPour = errors.New("no such pour")
+)
+
+// Pour is a drink being poured by a machine, the activity it belongs to is completed with the task token once it is done
+type Pour struct {
+	ID       string    `json:"id"`
+	Token    []byte    `json:"-"`
+	Location string    `json:"location,omitempty"`
+	OrderID  string    `json:"orderId"`
+	Item     string    `json:"item"`
+	Machine  string    `json:"machine"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// Done is what a machine reports once a pour is done, an Error means the drink was not poured
+type Done struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// Result is the result of the pour activity
+type Result struct {
+	Machine  string    `json:"machine"`
+	PouredAt time.Time `json:"pouredAt"`
+}
+
+// MachineFor is the machine an item is poured with, drinks with alcohol come from the tap
+func MachineFor(item string) string {
+	if drink, ok := menu.Find(item); ok && !drink.Alcoholic {
+		return MachineEspresso
+	}
+	return MachineTap
+}
+
+// Machine starts pours, it reports back on its own once a pour is done
+type Machine interface {
+	Start(pour Pour) error
+}
+
+// NewMachine will create the machine of the configuration
+func NewMachine(cfg config.Equipment) (Machine, error) {
+	switch cfg.Machine {
+	case "", "simulator":
+		simulator := &Simulator{DoneURL: cfg.DoneURL, PourTime: cfg.PourTime, Client: &http.Client{Timeout: 10 * time.Second}}
+		if len(cfg.Secrets) > 0 {
+			simulator.Secret = []byte(cfg.Secrets[0])
+		}
+		return simulator, nil
+	case "manual":
+		return Manual{}, nil
+	default:
+		return nil, fmt.Errorf("unknown equipment machine: %s", cfg.Machine)
+	}
+}
+
+// Manual only logs the pour, the real equipment or an operator reports it as done to the API
+type Manual struct{}
+
+// Start logs the pour
+func (Manual) Start(pour Pour) error {
+	log.Printf("Waiting for the %s to pour %s, report it done with id %s", pour.Machine, pour.Item, pour.ID)
+	return nil
+}
+
+// Simulator is a pretend machine that reports every pour as done to the API after PourTime
+type Simulator struct {
+	DoneURL  string
+	PourTime time.Duration
+	Client   *http.Client
+	// Secret signs the done calls like the real equipment would, nil sends them unsigned
+	Secret []byte
+}
+
+// Start pours in the background
+func (s *Simulator) Start(pour Pour) error {
+	go func() {
+		time.Sleep(s.PourTime)
+		if err := s.done(Done{ID: pour.ID}); err != nil {
+			log.Printf("Simulated %s failed to report pour %s: %v", pour.Machine, pour.ID, err)
+		}
+	}()
+	return nil
+}
+
+// done posts the pour to the done endpoint
+func (s *Simulator) done(done Done) error {
+	data, err := json.Marshal(done)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.DoneURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.Secret) > 0 {
+		req.Header.Set(callback.SignatureHeader, callback.Sign(s.Secret, time.Now().Unix(), data))
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("done endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package equipment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/migrations"
+	"strings"
+	"sync"
+	"time"
+
+	// Register the drivers used by the SQL backend
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Store is the needed methods to keep the pours waiting for a machine
+type Store interface {
+	// Register keeps the pour until it is taken
+	Register(ctx context.Context, pour Pour) error
+	// Take removes the pour and returns it, so a pour is only ever completed once
+	Take(ctx context.Context, id string) (Pour, error)
+	// Expired returns the pours with a deadline before now
+	Expired(ctx context.Context, now time.Time) ([]Pour, error)
+}
+
+// NewStore will create the store for the configured repository backend
+// Pours are kept next to the customers
+func NewStore(cfg config.Repository) (Store, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return NewSQLStore("sqlite3", cfg.DSN)
+	case "postgres":
+		return NewSQLStore("postgres", cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unknown pour store backend: %s", cfg.Backend)
+	}
+}
+
+// MemoryStore keeps pours in Memory
+type MemoryStore struct {
+	sync.Mutex
+	pours map[string]Pour
+}
+
+// NewMemoryStore will init a new in memory pour store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pours: make(map[string]Pour),
+	}
+}
+
+// Register keeps the pour
+func (ms *MemoryStore) Register(ctx context.Context, pour Pour) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.pours[pour.ID] = pour
+	return nil
+}
+
+// Take removes the pour
+func (ms *MemoryStore) Take(ctx context.Context, id string) (Pour, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	pour, ok := ms.pours[id]
+	if !ok {
+		return Pour{}, ErrNoSuchPour
+	}
+	delete(ms.pours, id)
+	return pour, nil
+}
+
+// Expired returns the pours past their deadline
+func (ms *MemoryStore) Expired(ctx context.Context, now time.Time) ([]Pour, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	var expired []Pour
+	for _, pour := range ms.pours {
+		if pour.Deadline.Before(now) {
+			expired = append(expired, pour)
+		}
+	}
+	return expired, nil
+}
+
+// SQLStore keeps pours in a SQL table
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewSQLStore will open the database and migrate its schema
+func NewSQLStore(driver, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", driver, err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", driver, err)
+	}
+
+	if err := migrations.Up("pours", db, driver); err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db, driver: driver}, nil
+}
+
+// rebind converts $1 style placeholders into the style of the driver
+func (ss *SQLStore) rebind(query string) string {
+	if ss.driver == "postgres" {
+		return query
+	}
+	return strings.ReplaceAll(query, "$", "?")
+}
+
+// Register inserts the pour
+func (ss *SQLStore) Register(ctx context.Context, pour Pour) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`INSERT INTO pours (id, token, location, order_id, item, machine, deadline)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`),
+		pour.ID, pour.Token, pour.Location, pour.OrderID, pour.Item, pour.Machine, pour.Deadline.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to register pour: %v", err)
+	}
+	return nil
+}
+
+// Take deletes the pour, the delete decides which caller gets it when two take the same pour
+func (ss *SQLStore) Take(ctx context.Context, id string) (Pour, error) {
+	var pour Pour
+	err := ss.db.QueryRowContext(ctx, ss.rebind(`SELECT id, token, location, order_id, item, machine, deadline FROM pours WHERE id = $1`), id).
+		Scan(&pour.ID, &pour.Token, &pour.Location, &pour.OrderID, &pour.Item, &pour.Machine, &pour.Deadline)
+	if err == sql.ErrNoRows {
+		return Pour{}, ErrNoSuchPour
+	}
+	if err != nil {
+		return Pour{}, fmt.Errorf("failed to read pour: %v", err)
+	}
+
+	result, err := ss.db.ExecContext(ctx, ss.rebind(`DELETE FROM pours WHERE id = $1`), id)
+	if err != nil {
+		return Pour{}, fmt.Errorf("failed to take pour: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return Pour{}, ErrNoSuchPour
+	}
+	return pour, nil
+}
+
+// Expired returns the pours past their deadline
+func (ss *SQLStore) Expired(ctx context.Context, now time.Time) ([]Pour, error) {
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(`SELECT id, token, location, order_id, item, machine, deadline FROM pours WHERE deadline < $1`), now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read expired pours: %v", err)
+	}
+	defer rows.Close()
+
+	var pours []Pour
+	for rows.Next() {
+		var pour Pour
+		if err := rows.Scan(&pour.ID, &pour.Token, &pour.Location, &pour.OrderID, &pour.Item, &pour.Machine, &pour.Deadline); err != nil {
+			return nil, err
+		}
+		pours = append(pours, pour)
+	}
+	return pours, rows.Err()
+}
@@ -3,9 +3,16 @@ module programmingpercy/cadence-tavern
 go 1.17
 
 require (
+	github.com/golang/protobuf v1.3.3
+	github.com/lib/pq v1.10.9
 	github.com/m3db/prometheus_client_golang v0.8.1
+	github.com/mattn/go-sqlite3 v1.14.16
+	github.com/opentracing/opentracing-go v1.1.0
+	github.com/stretchr/testify v1.4.0
 	github.com/uber-go/tally v3.3.15+incompatible
+	github.com/uber/jaeger-client-go v2.22.1+incompatible
 	go.uber.org/cadence v0.19.0
+	go.uber.org/thriftrw v1.25.0
 	go.uber.org/yarpc v1.55.0
 	go.uber.org/zap v1.13.0
 )
@@ -24,14 +31,12 @@ require (
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/gogo/status v1.1.0 // indirect
 	github.com/golang/mock v1.4.4 // indirect
-	github.com/golang/protobuf v1.3.3 // indirect
 	github.com/jessevdk/go-flags v1.4.0 // indirect
 	github.com/kisielk/errcheck v1.5.0 // indirect
 	github.com/m3db/prometheus_client_model v0.1.0 // indirect
 	github.com/m3db/prometheus_common v0.1.0 // indirect
 	github.com/m3db/prometheus_procfs v0.8.1 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.2-0.20181231171920-c182affec369 // indirect
-	github.com/opentracing/opentracing-go v1.1.0 // indirect
 	github.com/pborman/uuid v0.0.0-20160209185913-a97ce2ca70fa // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
@@ -41,13 +46,12 @@ require (
 	github.com/prometheus/procfs v0.0.9 // indirect
 	github.com/robfig/cron v1.2.0 // indirect
 	github.com/stretchr/objx v0.2.0 // indirect
-	github.com/stretchr/testify v1.4.0 // indirect
 	github.com/uber-go/mapdecode v1.0.0 // indirect
+	github.com/uber/jaeger-lib v2.2.0+incompatible // indirect
 	github.com/uber/tchannel-go v1.16.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
 	go.uber.org/net/metrics v1.3.0 // indirect
-	go.uber.org/thriftrw v1.25.0 // indirect
 	golang.org/x/lint v0.0.0-20200130185559-910be7a94367 // indirect
 	golang.org/x/mod v0.3.0 // indirect
 	golang.org/x/net v0.0.0-20201021035429-f5854403a974 // indirect
@@ -0,0 +1,70 @@
+// Package heartbeat reports when a worker last talked to the Cadence server
+// A worker that is running but stuck, or polling the wrong domain, still reports up, these gauges show that it is not working
+package heartbeat
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/yarpc/api/transport"
+)
+
+// The gauges are unix timestamps, so alerts can compare them with time()
+const (
+	// GaugeStarted is when the process started
+	GaugeStarted = "worker_start_time_seconds"
+	// GaugeLastPoll is when a poll of the task list last returned, tagged with the task type decision or activity
+	// An idle worker still returns from its long polls about once a minute
+	GaugeLastPoll = "worker_last_poll_seconds"
+	// GaugeLastDecision is when a decision task was last completed
+	GaugeLastDecision = "worker_last_decision_seconds"
+	// GaugeLastActivity is when a activity task was last completed, failed or canceled
+	GaugeLastActivity = "worker_last_activity_seconds"
+)
+
+// Middleware is a yarpc outbound middleware on the connection of the worker, it updates the gauges from the calls
+// the worker makes to poll and respond to tasks
+type Middleware struct {
+	polls     map[string]tally.Gauge
+	decisions tally.Gauge
+	activity  tally.Gauge
+}
+
+// New creates the Middleware and reports the start of the process
+func New(scope tally.Scope) *Middleware {
+	scope.Gauge(GaugeStarted).Update(float64(time.Now().Unix()))
+	return &Middleware{
+		polls: map[string]tally.Gauge{
+			"PollForDecisionTask": scope.Tagged(map[string]string{"task": "decision"}).Gauge(GaugeLastPoll),
+			"PollForActivityTask": scope.Tagged(map[string]string{"task": "activity"}).Gauge(GaugeLastPoll),
+		},
+		decisions: scope.Gauge(GaugeLastDecision),
+		activity:  scope.Gauge(GaugeLastActivity),
+	}
+}
+
+// Call passes the call on and updates the gauge of the procedure when it succeeds
+func (m *Middleware) Call(ctx context.Context, req *transport.Request, out transport.UnaryOutbound) (*transport.Response, error) {
+	res, err := out.Call(ctx, req)
+	if err != nil || res == nil || res.ApplicationError {
+		return res, err
+	}
+
+	// Thrift procedures are named Service::Method
+	method := req.Procedure
+	if i := strings.LastIndex(method, "::"); i >= 0 {
+		method = method[i+2:]
+	}
+	now := float64(time.Now().Unix())
+	switch {
+	case m.polls[method] != nil:
+		m.polls[method].Update(now)
+	case method == "RespondDecisionTaskCompleted":
+		m.decisions.Update(now)
+	case strings.HasPrefix(method, "RespondActivityTask"):
+		m.activity.Update(now)
+	}
+	return res, err
+}
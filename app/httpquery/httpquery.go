@@ -0,0 +1,286 @@
+// Package httpquery is how the list routes of the API read pagination, sorting and filters from the query string
+// ?limit= and ?offset= select the page, ?cursor= continues from the page it was returned with,
+// ?sort=name,-age sorts by the fields with - for descending and ?field=a,b keeps the items where the field is a or b
+// The page is described in headers so the body of a route stays a plain list in every format
+package httpquery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TotalCountHeader is the number of items matching the filters, over all pages
+	TotalCountHeader = "X-Total-Count"
+	// NextCursorHeader is the cursor of the next page, it is missing on the last page
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// Options are the parameters a route takes
+type Options struct {
+	// DefaultLimit is the page size without ?limit=, 0 returns every item
+	DefaultLimit int
+	// MaxLimit is the largest ?limit= taken, 0 takes any
+	MaxLimit int
+	// DefaultSort is the sort without ?sort=, such as -placedAt
+	DefaultSort string
+	// Sortable and Filterable are the fields that can be used in ?sort= and as filters
+	Sortable   []string
+	Filterable []string
+}
+
+// SortField is one field of ?sort=
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Query is the pagination, sorting and filters of a request
+type Query struct {
+	Limit  int
+	Offset int
+	Sort   []SortField
+	// Filters are the values each filtered field may have
+	Filters map[string][]string
+}
+
+// Page is the part of the list a Query returned
+type Page struct {
+	Total  int
+	Offset int
+	Limit  int
+	// Next is the cursor of the page after this one, empty on the last page
+	Next string
+}
+
+// cursor is what a cursor holds, the sort is kept so a cursor is not used with another order
+type cursor struct {
+	Offset int    `json:"o"`
+	Sort   string `json:"s"`
+}
+
+// Parse reads the Query of a request, the error explains which parameter is wrong
+func Parse(values url.Values, opts Options) (Query, error) {
+	q := Query{Limit: opts.DefaultLimit, Filters: make(map[string][]string)}
+
+	sortParam := values.Get("sort")
+	if sortParam == "" {
+		sortParam = opts.DefaultSort
+	}
+	for _, field := range strings.Split(sortParam, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		sf := SortField{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if !contains(opts.Sortable, sf.Field) {
+			return Query{}, fmt.Errorf("sort can only use %s", strings.Join(opts.Sortable, ", "))
+		}
+		q.Sort = append(q.Sort, sf)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || (opts.MaxLimit > 0 && n > opts.MaxLimit) {
+			if opts.MaxLimit > 0 {
+				return Query{}, fmt.Errorf("limit must be between 1 and %d", opts.MaxLimit)
+			}
+			return Query{}, fmt.Errorf("limit must be a positive number")
+		}
+		q.Limit = n
+	}
+	if offset := values.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return Query{}, fmt.Errorf("offset must be zero or a positive number")
+		}
+		q.Offset = n
+	}
+	if encoded := values.Get("cursor"); encoded != "" {
+		if values.Get("offset") != "" {
+			return Query{}, fmt.Errorf("offset and cursor can not be used together")
+		}
+		c, err := decodeCursor(encoded)
+		if err != nil || c.Sort != formatSort(q.Sort) {
+			return Query{}, fmt.Errorf("cursor is not valid for this sort")
+		}
+		q.Offset = c.Offset
+	}
+
+	for _, field := range opts.Filterable {
+		if value := values.Get(field); value != "" {
+			q.Filters[field] = strings.Split(value, ",")
+		}
+	}
+	return q, nil
+}
+
+// Filter returns the first value of a filter, empty when the field is not filtered
+func (q Query) Filter(field string) string {
+	if values := q.Filters[field]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Apply filters, sorts and pages a list of n items, value returns the field of an item as a string, bool, int,
+// int64, float64 or time.Time. The indexes of the items on the page are returned in the order they go in
+func (q Query) Apply(n int, value func(i int, field string) interface{}) ([]int, Page) {
+	indexes := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if q.matches(i, value) {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		for _, sf := range q.Sort {
+			c := compare(value(indexes[a], sf.Field), value(indexes[b], sf.Field))
+			if c == 0 {
+				continue
+			}
+			if sf.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+
+	page := Page{Total: len(indexes), Offset: q.Offset, Limit: q.Limit}
+	start, end := q.Offset, len(indexes)
+	if start > end {
+		start = end
+	}
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		page.Next = encodeCursor(cursor{Offset: end, Sort: formatSort(q.Sort)})
+	}
+	return indexes[start:end], page
+}
+
+// WriteHeaders describes the page in the headers of the response, they need to be written before the body
+func WriteHeaders(w http.ResponseWriter, page Page) {
+	w.Header().Set(TotalCountHeader, strconv.Itoa(page.Total))
+	if page.Next != "" {
+		w.Header().Set(NextCursorHeader, page.Next)
+	}
+}
+
+// matches is true when the item has one of the values of every filter
+func (q Query) matches(i int, value func(i int, field string) interface{}) bool {
+	for field, allowed := range q.Filters {
+		actual := format(value(i, field))
+		found := false
+		for _, v := range allowed {
+			if strings.EqualFold(actual, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders two values of the same field, -1 when a goes first
+func compare(a, b interface{}) int {
+	switch av := a.(type) {
+	case time.Time:
+		bv, _ := b.(time.Time)
+		switch {
+		case av.Before(bv):
+			return -1
+		case av.After(bv):
+			return 1
+		}
+		return 0
+	case int:
+		bv, _ := b.(int)
+		return compareFloat(float64(av), float64(bv))
+	case int64:
+		bv, _ := b.(int64)
+		return compareFloat(float64(av), float64(bv))
+	case float64:
+		bv, _ := b.(float64)
+		return compareFloat(av, bv)
+	case bool:
+		bv, _ := b.(bool)
+		return compareFloat(boolFloat(av), boolFloat(bv))
+	}
+	return strings.Compare(strings.ToLower(format(a)), strings.ToLower(format(b)))
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// format is how a value is matched against a filter
+func format(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case time.Time:
+		return value.Format(time.RFC3339)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// formatSort is the sort as it is written in ?sort=
+func formatSort(fields []SortField) string {
+	parts := make([]string, len(fields))
+	for i, sf := range fields {
+		parts[i] = sf.Field
+		if sf.Desc {
+			parts[i] = "-" + sf.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
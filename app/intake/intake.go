@@ -0,0 +1,187 @@
+// Package intake keeps how much alcohol each customer has been served since their tab was opened
+package intake
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/migrations"
+	"strings"
+	"sync"
+
+	// Register the drivers used by the SQL backend
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ErrOverLimit is returned when a drink would take the customer over the limit
+var ErrOverLimit = errors.New("customer would go over the intake limit")
+
+// Intake is the store of the drinks served to each customer, the Worker replaces this during startup
+var Intake Store = NewMemoryStore()
+
+// Customer identifies a customer at a location
+type Customer struct {
+	Location string
+	Name     string
+}
+
+// Store is the needed methods to keep the intake of customers
+type Store interface {
+	// Add records the units of the order unless the total of the customer would go over the limit, then ErrOverLimit
+	// is returned. Adding the same order again replaces it, so retried activities are only counted once
+	Add(ctx context.Context, cust Customer, orderID string, units float32, limit float32) (float32, error)
+	// Remove forgets the units of an order that was not served
+	Remove(ctx context.Context, cust Customer, orderID string) error
+	// Reset forgets everything the customer was served
+	Reset(ctx context.Context, cust Customer) error
+}
+
+// NewStore will create the store for the configured repository backend
+// The intake is kept next to the customers
+func NewStore(cfg config.Repository) (Store, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return NewSQLStore("sqlite3", cfg.DSN)
+	case "postgres":
+		return NewSQLStore("postgres", cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unknown intake store backend: %s", cfg.Backend)
+	}
+}
+
+// MemoryStore keeps the intake in Memory
+type MemoryStore struct {
+	sync.Mutex
+	orders map[Customer]map[string]float32
+}
+
+// NewMemoryStore will init a new in memory intake store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		orders: make(map[Customer]map[string]float32),
+	}
+}
+
+// Add records the units of the order
+func (ms *MemoryStore) Add(ctx context.Context, cust Customer, orderID string, units float32, limit float32) (float32, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	orders, ok := ms.orders[cust]
+	if !ok {
+		orders = make(map[string]float32)
+		ms.orders[cust] = orders
+	}
+	var total float32
+	for id, u := range orders {
+		if id != orderID {
+			total += u
+		}
+	}
+	if total+units > limit {
+		return total, ErrOverLimit
+	}
+	orders[orderID] = units
+	return total + units, nil
+}
+
+// Remove forgets the order
+func (ms *MemoryStore) Remove(ctx context.Context, cust Customer, orderID string) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	delete(ms.orders[cust], orderID)
+	return nil
+}
+
+// Reset forgets the customer
+func (ms *MemoryStore) Reset(ctx context.Context, cust Customer) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	delete(ms.orders, cust)
+	return nil
+}
+
+// SQLStore keeps the intake in a SQL table
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewSQLStore will open the database and migrate its schema
+func NewSQLStore(driver, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", driver, err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", driver, err)
+	}
+
+	if err := migrations.Up("intake", db, driver); err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db, driver: driver}, nil
+}
+
+// rebind converts $1 style placeholders into the style of the driver
+func (ss *SQLStore) rebind(query string) string {
+	if ss.driver == "postgres" {
+		return query
+	}
+	return strings.ReplaceAll(query, "$", "?")
+}
+
+// Add sums the other orders of the customer and records the order in one transaction
+func (ss *SQLStore) Add(ctx context.Context, cust Customer, orderID string, units float32, limit float32) (float32, error) {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add intake: %v", err)
+	}
+	defer tx.Rollback()
+
+	var total float32
+	err = tx.QueryRowContext(ctx, ss.rebind(`SELECT COALESCE(SUM(units), 0) FROM intake WHERE location = $1 AND customer = $2 AND order_id <> $3`),
+		cust.Location, cust.Name, orderID).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read intake: %v", err)
+	}
+	if total+units > limit {
+		return total, ErrOverLimit
+	}
+	_, err = tx.ExecContext(ctx, ss.rebind(`INSERT INTO intake (location, customer, order_id, units) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (location, customer, order_id) DO UPDATE SET units = excluded.units`),
+		cust.Location, cust.Name, orderID, units)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add intake: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to add intake: %v", err)
+	}
+	return total + units, nil
+}
+
+// Remove deletes the order
+func (ss *SQLStore) Remove(ctx context.Context, cust Customer, orderID string) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`DELETE FROM intake WHERE location = $1 AND customer = $2 AND order_id = $3`),
+		cust.Location, cust.Name, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to remove intake: %v", err)
+	}
+	return nil
+}
+
+// Reset deletes every order of the customer
+func (ss *SQLStore) Reset(ctx context.Context, cust Customer) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`DELETE FROM intake WHERE location = $1 AND customer = $2`), cust.Location, cust.Name)
+	if err != nil {
+		return fmt.Errorf("failed to reset intake: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// Bad Solution for in mem during tutorial
+	// The Worker replaces this with the stock of the configuration
+	Database Repository = NewMemoryInventory(nil)
+
+	// ErrOutOfStock is returned when there is nothing left of the item at the location
+	ErrOutOfStock = errors.New("out of stock")
+)
+
+// Repository is the needed methods to be an inventory repo
+// Stock is reserved for an order while it is processed, and either taken when the order completes or put back
+// Every method is scoped to a location, each location has its own stock
+type Repository interface {
+	// Reserve sets one of the item aside for the order, reserving the same order again does nothing
+	Reserve(location, orderID, item string) error
+	// Commit takes the reserved item out of the stock for good
+	Commit(location, orderID string) error
+	// Release puts the reserved item back, orders without a reservation are ignored
+	Release(location, orderID string) error
+	// Available is how many of the item can be reserved, false when the item is not tracked
+	Available(location, item string) (int, bool)
+	// Levels is how many of every tracked item can be reserved at the location
+	Levels(location string) map[string]int
+	// StockIn adds the delivered count of the item to the stock, stocking in the same delivery again does nothing
+	StockIn(location, deliveryID, item string, count int) error
+}
+
+// ParseStock reads the stock written as item=count, such as Mead=20
+// Items that are not in the stock are never out of stock
+func ParseStock(raw []string) (map[string]int, error) {
+	stock := make(map[string]int, len(raw))
+	for _, r := range raw {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("stock %q should be item=count", r)
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("stock %q should have a count of zero or more", r)
+		}
+		stock[parts[0]] = count
+	}
+	return stock, nil
+}
+
+// reservation is an item set aside for an order
+type reservation struct {
+	location string
+	item     string
+}
+
+// MemoryInventory is used to store the stock in Memory
+// Every location starts with the same stock the first time it is used
+type MemoryInventory struct {
+	mu           sync.Mutex
+	initial      map[string]int
+	stock        map[string]map[string]int
+	reservations map[string]reservation
+	deliveries   map[string]bool
+}
+
+// NewMemoryInventory will init a in memory storage with the stock every location starts with
+func NewMemoryInventory(stock map[string]int) *MemoryInventory {
+	return &MemoryInventory{
+		initial:      stock,
+		stock:        make(map[string]map[string]int),
+		reservations: make(map[string]reservation),
+		deliveries:   make(map[string]bool),
+	}
+}
+
+// location returns the stock of the location, the caller holds mu
+func (mi *MemoryInventory) location(location string) map[string]int {
+	stock, ok := mi.stock[location]
+	if !ok {
+		stock = make(map[string]int, len(mi.initial))
+		for item, count := range mi.initial {
+			stock[item] = count
+		}
+		mi.stock[location] = stock
+	}
+	return stock
+}
+
+// key is the key of a reservation or delivery, their IDs are only unique within a location
+func key(location, orderID string) string {
+	return location + "\x00" + orderID
+}
+
+// Reserve takes one of the item from the stock of the location and holds it for the order
+func (mi *MemoryInventory) Reserve(location, orderID, item string) error {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+
+	if _, ok := mi.reservations[key(location, orderID)]; ok {
+		return nil
+	}
+	stock := mi.location(location)
+	count, tracked := stock[item]
+	if tracked {
+		if count <= 0 {
+			return fmt.Errorf("%s: %w", item, ErrOutOfStock)
+		}
+		stock[item] = count - 1
+	}
+	mi.reservations[key(location, orderID)] = reservation{location: location, item: item}
+	return nil
+}
+
+// Commit forgets the reservation, the item stays out of the stock
+func (mi *MemoryInventory) Commit(location, orderID string) error {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+
+	delete(mi.reservations, key(location, orderID))
+	return nil
+}
+
+// Release returns the reserved item to the stock of the location
+func (mi *MemoryInventory) Release(location, orderID string) error {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+
+	r, ok := mi.reservations[key(location, orderID)]
+	if !ok {
+		return nil
+	}
+	delete(mi.reservations, key(location, orderID))
+	stock := mi.location(r.location)
+	if _, tracked := stock[r.item]; tracked {
+		stock[r.item]++
+	}
+	return nil
+}
+
+// Available is the stock of the item at the location
+func (mi *MemoryInventory) Available(location, item string) (int, bool) {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+
+	count, ok := mi.location(location)[item]
+	return count, ok
+}
+
+// Levels is a copy of the stock of the location
+func (mi *MemoryInventory) Levels(location string) map[string]int {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+
+	stock := mi.location(location)
+	levels := make(map[string]int, len(stock))
+	for item, count := range stock {
+		levels[item] = count
+	}
+	return levels
+}
+
+// StockIn adds the count to the stock of the item at the location, the item is tracked from then on
+func (mi *MemoryInventory) StockIn(location, deliveryID, item string, count int) error {
+	if count <= 0 {
+		return fmt.Errorf("delivery %s of %s should have a count above zero", deliveryID, item)
+	}
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+
+	if mi.deliveries[key(location, deliveryID)] {
+		return nil
+	}
+	mi.location(location)[item] += count
+	mi.deliveries[key(location, deliveryID)] = true
+	return nil
+}
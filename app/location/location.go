@@ -0,0 +1,76 @@
+package location
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Header is the HTTP header the API reads the location of a request from, the location query parameter also works
+const Header = "X-Tavern-Location"
+
+var (
+	// version is the suffix of every task list, such as v2 for greetings-v2, empty keeps the base task list
+	version   string
+	versionMu sync.RWMutex
+)
+
+// SetVersion replaces the task list version, the API calls it when new workflows are switched to another task list
+// Workflows keep the task list they were started on, so the workers of the old version drain them
+func SetVersion(v string) {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+	version = v
+}
+
+// Version is the task list version that TaskList appends
+func Version() string {
+	versionMu.RLock()
+	defer versionMu.RUnlock()
+	return version
+}
+
+// TaskList is the task list the workflows of a location run on, at the current Version
+// The default location, which is empty, keeps the base task list so single tavern deployments are unchanged
+func TaskList(base, location string) string {
+	return VersionedTaskList(base, location, Version())
+}
+
+// VersionedTaskList is the task list of a location at a given version
+func VersionedTaskList(base, location, version string) string {
+	taskList := base
+	if location != "" {
+		taskList += "-" + location
+	}
+	if version != "" {
+		taskList += "-" + version
+	}
+	return taskList
+}
+
+// WorkflowID scopes a workflow ID to the location, such as north/orders
+// Workflows of different taverns never share an ID so they stay isolated
+func WorkflowID(location, id string) string {
+	if location == "" {
+		return id
+	}
+	return location + "/" + id
+}
+
+// FromRequest reads the location of a HTTP request, allowed are the locations served by this deployment
+// Requests without a location belong to the default location
+func FromRequest(r *http.Request, allowed []string) (string, error) {
+	location := r.Header.Get(Header)
+	if location == "" {
+		location = r.URL.Query().Get("location")
+	}
+	if location == "" {
+		return "", nil
+	}
+	for _, a := range allowed {
+		if a == location {
+			return location, nil
+		}
+	}
+	return "", fmt.Errorf("unknown location: %s", location)
+}
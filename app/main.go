@@ -1,16 +1,64 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"programmingpercy/cadence-tavern/blobstore"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/equipment"
+	"programmingpercy/cadence-tavern/heartbeat"
+	"programmingpercy/cadence-tavern/intake"
+	"programmingpercy/cadence-tavern/inventory"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/migrations"
+	"programmingpercy/cadence-tavern/ops"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/outbox"
+	"programmingpercy/cadence-tavern/payloads"
 	localprom "programmingpercy/cadence-tavern/prometheus"
+	"programmingpercy/cadence-tavern/reload"
+	"programmingpercy/cadence-tavern/retry"
+	"programmingpercy/cadence-tavern/staff"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/callback"
+	"programmingpercy/cadence-tavern/workflows/decisions"
+	"programmingpercy/cadence-tavern/workflows/faultinject"
+	"programmingpercy/cadence-tavern/workflows/flags"
 	_ "programmingpercy/cadence-tavern/workflows/greetings"
-	_ "programmingpercy/cadence-tavern/workflows/orders"
+	"programmingpercy/cadence-tavern/workflows/history"
+	"programmingpercy/cadence-tavern/workflows/leaderboard"
+	"programmingpercy/cadence-tavern/workflows/notify"
+	"programmingpercy/cadence-tavern/workflows/orders"
+	"programmingpercy/cadence-tavern/workflows/payments"
+	"programmingpercy/cadence-tavern/workflows/payroll"
+	"programmingpercy/cadence-tavern/workflows/pouring"
+	"programmingpercy/cadence-tavern/workflows/pricing"
+	"programmingpercy/cadence-tavern/workflows/profiles"
+	"programmingpercy/cadence-tavern/workflows/receipts"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/selftest"
+	_ "programmingpercy/cadence-tavern/workflows/session"
+	"programmingpercy/cadence-tavern/workflows/setup"
+	"programmingpercy/cadence-tavern/workflows/slo"
+	"programmingpercy/cadence-tavern/workflows/sobriety"
+	"programmingpercy/cadence-tavern/workflows/stock"
+	_ "programmingpercy/cadence-tavern/workflows/tabs"
+	"syscall"
+	"time"
 
 	_ "go.uber.org/cadence/.gen/go/cadence"
 	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
 	"go.uber.org/cadence/worker"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber-go/tally"
 	"go.uber.org/yarpc"
+	"go.uber.org/yarpc/api/middleware"
 	_ "go.uber.org/yarpc/api/transport"
 	"go.uber.org/yarpc/transport/tchannel"
 	"go.uber.org/zap"
@@ -22,69 +70,433 @@ const (
 	CadenceService = "cadence-frontend"
 	// ClientName is the identifier for the service
 	ClientName = "greetings-worker"
-	// Domain is the domain you have registered and want to operate in
-	Domain = "tavern"
-	// Host is the Cadence server IP:Port
-	Host = "127.0.0.1:7933"
-	// TaskList is the identifier for tasks, activites and workflows
-	TaskList = "greetings"
 )
 
+// logLevel is shared by all loggers so it can be changed when the configuration is reloaded
+var logLevel = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
 func main() {
+	// Load the configuration, defaults can be overridden by the environment
+	cfg, err := config.Load(config.WorkerDefaults())
+	if err != nil {
+		panic(err)
+	}
+	// Apply the customer repository used by the activities
+	customer.Database, err = customer.NewRepository(cfg.Repository)
+	if err != nil {
+		panic(err)
+	}
+	// Apply the settings the workflows run with, the standalone API applies the same
+	if err := setup.Apply(cfg); err != nil {
+		panic(err)
+	}
+	// Apply the store order lifecycle events are appended to
+	orderstore.Events, err = orderstore.NewStore(cfg.Repository)
+	if err != nil {
+		panic(err)
+	}
+	if letters, ok := orderstore.Events.(orderstore.DeadLetterStore); ok {
+		orderstore.DeadLetters = letters
+	}
+	if index, ok := orderstore.Events.(orderstore.IndexStore); ok {
+		orderstore.Index = index
+	}
+	// Apply the intake of customers
+	intake.Intake, err = intake.NewStore(cfg.Repository)
+	if err != nil {
+		panic(err)
+	}
+	// Apply the task list version polled, workers of new workflow code poll task lists of their own
+	location.SetVersion(cfg.TaskListVersion)
+	// Apply how often an activity may panic on a payload before it is quarantined with the dead letters
+	registry.QuarantineAfter = cfg.ActivityQuarantineAfter
+	registry.Quarantine = func(ctx context.Context, q registry.Quarantined) error {
+		return orderstore.DeadLetters.AddDeadLetter(ctx, orderstore.DeadLetter{
+			Reason:   "panic: " + q.Value,
+			Attempts: q.Panics,
+			FailedAt: time.Now(),
+			Activity: q.Activity,
+			Payload:  q.Payload,
+		})
+	}
+	// Apply the payment provider used to charge customers
+	payments.Provider, err = payments.NewProvider(cfg.Payments)
+	if err != nil {
+		panic(err)
+	}
+	// Apply the low stock thresholds and where supplies are ordered
+	if err := applyStockMonitor(cfg); err != nil {
+		panic(err)
+	}
+	suppliers, err := stock.NewSuppliers(cfg.StockMonitor.Suppliers)
+	if err != nil {
+		panic(err)
+	}
+	stock.Suppliers = suppliers
+	// Apply where the shifts of the employees are kept and how they are paid
+	staff.Database, err = staff.NewStore(cfg.Repository)
+	if err != nil {
+		panic(err)
+	}
+	if err := applyPayroll(cfg); err != nil {
+		panic(err)
+	}
+	// Apply where receipts are rendered to
+	receipts.Format = cfg.Receipts.Format
+	receipts.Store, err = blobstore.NewStore(cfg.Receipts.Store)
+	if err != nil {
+		panic(err)
+	}
+	// Apply the bar equipment drinks are poured with
+	equipment.Pours, err = equipment.NewStore(cfg.Repository)
+	if err != nil {
+		panic(err)
+	}
+	pouring.Machine, err = equipment.NewMachine(cfg.Equipment)
+	if err != nil {
+		panic(err)
+	}
+	pouring.Timeout = cfg.Equipment.Timeout
+	// Apply how notifications are delivered
+	notify.Email, notify.SMS = notify.NewNotifiers(cfg.Notify)
+	notify.OpsRecipient = cfg.Notify.OpsEmail
+	notify.StaffRecipient = cfg.Notify.StaffEmail
+	notify.Alerts = notify.NewAlerts(cfg.Notify)
+	// Apply how the results of orders and greetings are pushed to their callbacks
+	callback.Sender = callback.NewSender(cfg.Callbacks)
 	// Create the Worker service
-	worker, logger, err := newWorkerServiceClient()
+	workers, logger, metricsScope, err := newWorkerServiceClient(cfg)
 	if err != nil {
 		panic(err)
 	}
+	// Canary jobs only shadow, the workers of the deployment do the polling
+	if cfg.Shadow.Mode == config.ShadowOnly {
+		logger.Info("Shadowing done, exiting without polling")
+		return
+	}
+
+	// Reload the changeable settings on SIGHUP, everything else is reported as requiring a restart
+	watcher := newWatcher(cfg, logger)
+	go watcher.Watch(context.Background())
 
-	// Start worker
-	if err := worker.Start(); err != nil {
-		panic(fmt.Errorf("failed to start the worker: %v", err))
+	// Start the ops listener used for diagnosing the worker
+	// The worker is ready once its workers started, and the self test passed when it is enabled
+	readiness := ops.NewReadiness("starting workers")
+	if cfg.OpsAddress != "" {
+		opsServer := ops.NewServer(cfg.OpsAddress, cfg)
+		opsServer.Handle("/readyz", readiness)
+		opsServer.Handle("/debug/sampling", tracing.Sampling)
+		opsServer.Handle("/debug/reload", watcher)
+		opsServer.Handle("/debug/metrics", localprom.Cardinality)
+		opsServer.HandleFunc("/debug/migrations", migrations.ServeHTTP)
+		opsServer.HandleFunc("/debug/registration", func(w http.ResponseWriter, r *http.Request) {
+			ops.WriteJSON(w, map[string]interface{}{
+				"workflows":  registry.Workflows(),
+				"activities": registry.Activities(),
+				"missing":    registry.Missing(registry.Manifest),
+			})
+		})
+		go func() {
+			if err := opsServer.ListenAndServe(); err != nil {
+				logger.Error("ops listener stopped", zap.Error(err))
+			}
+		}()
 	}
 
-	logger.Info("Started Worker.", zap.String("worker", TaskList))
+	// Start workers, the Cadence server might still be booting so keep trying for a while
+	for taskList, worker := range workers {
+		err = retry.NewPolicy(cfg.Startup).Do(context.Background(), metricsScope, logger, "worker", worker.Start)
+		if err != nil {
+			panic(fmt.Errorf("failed to start the worker: %v", err))
+		}
+
+		logger.Info("Started Worker.", zap.String("worker", taskList))
+	}
+	if cfg.SelfTest.Enabled {
+		go selfTest(cfg, readiness, metricsScope, logger)
+	} else {
+		readiness.Set(true, "workers started")
+	}
+	if jobs := scheduledWorkflows(cfg); len(jobs) > 0 {
+		go scheduleWorkflows(cfg, metricsScope, logger, jobs...)
+	}
 
-	// Block Forever
-	select {}
+	// Relay outbox entries downstream if the repository supports it
+	if source, ok := customer.AsOutbox(customer.Database); ok {
+		relay := &outbox.Relay{
+			Source:    source,
+			Publisher: outbox.NewPublisher(cfg.Outbox, logger),
+			Logger:    logger,
+			Interval:  cfg.Outbox.Interval,
+			BatchSize: 100,
+		}
+		go relay.Run(context.Background())
+	}
 
+	// Run until asked to stop, then drain the workers before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	drain(workers, readiness, metricsScope, logger)
 }
 
 // newWorkerServiceClient is used to initialize a new Worker service
 // It will handle Connecting and configuration of the client
-// Returns a Worker, the logger applied or an error
-// TODO expand this function to allow more configurations, will be done later in the article.
-func newWorkerServiceClient() (worker.Worker, *zap.Logger, error) {
+// There is one Worker for the task list of every location, keyed by task list
+// Returns the Workers, the logger and metrics scope applied or an error
+func newWorkerServiceClient(cfg config.Config) (map[string]engine.Worker, *zap.Logger, tally.Scope, error) {
 
 	// Create a logger to use for the service
-	logger, err := newLogger()
+	logger, err := newLogger(cfg.LogLevel)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	reporter, err := localprom.NewPrometheusReporter("127.0.0.1:9098", logger)
+	reporter, err := localprom.NewReporter(cfg.MetricsAddress, cfg.Metrics, logger)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	metricsScope := localprom.NewServiceScope(reporter)
+	metricsScope := localprom.NewServiceScope(reporter, cfg.Metrics)
+	// Make sure the worker can run every workflow the API starts
+	if err := checkRegistrations(cfg, metricsScope, logger); err != nil {
+		return nil, nil, nil, err
+	}
 
-	// build the most basic Options for now
-	workerOptions := worker.Options{
-		Logger:       logger,
-		MetricsScope: metricsScope,
+	// Export the SLO burn rates next to the cadence metrics
+	slo.Trackers = slo.NewTrackers(cfg.SLO)
+	go slo.Report(context.Background(), metricsScope, 30*time.Second)
+	// Report the calls of the customer repository next to the cadence metrics
+	customer.Metrics = metricsScope
+	customer.Logger = logger
+
+	// The tracer is also set globally so activities can start their own spans
+	tracer, _, err := tracing.NewTracer(ClientName, cfg.Tracing)
+	if err != nil {
+		return nil, nil, nil, err
 	}
+	opentracing.SetGlobalTracer(tracer)
+
+	// Customers and orders are sent as protobuf when configured
+	dataConverter, err := payloads.NewDataConverter(cfg.PayloadEncoding)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// build the most basic Options for now
+	// Failing decision tasks are only logged by cadence, the watcher alerts them
+	decisionWatcher := decisions.NewWatcher(metricsScope, notify.Alerts, cfg.Notify.DecisionAlertCooldown)
+	workerOptions := setup.Options(dataConverter, metricsScope, decisionWatcher.Logger(logger))
+	workerOptions.Identity = cfg.Identity.String(ClientName)
+	workerOptions.Tracer = tracer
+	workerOptions.MaxConcurrentActivityExecutionSize = cfg.Worker.MaxConcurrentActivities
+	workerOptions.MaxConcurrentDecisionTaskExecutionSize = cfg.Worker.MaxConcurrentDecisions
+	workerOptions.WorkerActivitiesPerSecond = cfg.Worker.ActivitiesPerSecond
+	workerOptions.WorkerStopTimeout = cfg.Worker.StopTimeout
 	// Create the connection that the worker should use
-	connection, err := newCadenceConnection(ClientName)
+	// Every call of the workers to the server passes the heartbeat, so alerts can find a worker that stopped polling
+	beat := heartbeat.New(metricsScope)
+	var connection workflowserviceclient.Interface
+	err = retry.NewPolicy(cfg.Startup).Do(context.Background(), metricsScope, logger, "dispatcher", func() error {
+		connection, err = newCadenceConnection(ClientName, cfg.CadenceHost, beat)
+		return err
+	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	// Replay recent histories before polling, so a change that breaks running workflows never takes a decision task
+	switch cfg.Shadow.Mode {
+	case "", config.ShadowOff:
+	case config.ShadowGate, config.ShadowOnly:
+		replay := worker.ReplayOptions{
+			DataConverter:                     workerOptions.DataConverter,
+			Tracer:                            workerOptions.Tracer,
+			ContextPropagators:                workerOptions.ContextPropagators,
+			WorkflowInterceptorChainFactories: workerOptions.WorkflowInterceptorChainFactories,
+		}
+		if err := shadow(cfg, connection, replay, metricsScope, logger); err != nil {
+			return nil, nil, nil, err
+		}
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown shadow mode: %s", cfg.Shadow.Mode)
+	}
+	//  Create the workers and return, the metrics of each tavern are tagged with its location
+	workers := make(map[string]engine.Worker)
+	for _, loc := range append([]string{""}, cfg.Locations...) {
+		tag := loc
+		if tag == "" {
+			tag = "default"
+		}
+		opts := workerOptions
+		opts.MetricsScope = metricsScope.Tagged(map[string]string{"location": tag})
+
+		taskList := location.TaskList(cfg.TaskList, loc)
+		workers[taskList] = worker.New(connection, cfg.Domain, taskList, opts)
+	}
+	// The self test runs on a task list only this process polls, so it proves the registrations of this binary
+	if cfg.SelfTest.Enabled {
+		taskList := selftest.TaskList(workerOptions.Identity)
+		workers[taskList] = worker.New(connection, cfg.Domain, taskList, workerOptions)
 	}
-	//  Create the worker and return
-	return worker.New(connection, Domain, TaskList, workerOptions), logger, nil
+	return workers, logger, metricsScope, nil
+}
+
+// newWatcher creates the configuration watcher with the settings the Worker can change while running
+func newWatcher(cfg config.Config, logger *zap.Logger) *reload.Watcher {
+	watcher := reload.NewWatcher(config.WorkerDefaults(), cfg, logger)
+	watcher.Apply("logLevel", func(cfg config.Config) error {
+		return logLevel.UnmarshalText([]byte(cfg.LogLevel))
+	})
+	watcher.Apply("flags", func(cfg config.Config) error {
+		flags.SetSource(flags.NewProvider(cfg.Flags))
+		return nil
+	})
+	// The guard of a run reads the limit once when it starts, so changing it is safe for open workflows
+	watcher.Apply("historyLimit", func(cfg config.Config) error {
+		history.SetMaxEvents(cfg.HistoryLimit)
+		return nil
+	})
+	// Each run of a leaderboard reads the size once when it starts
+	watcher.Apply("leaderboardSize", func(cfg config.Config) error {
+		leaderboard.SetSize(cfg.LeaderboardSize)
+		return nil
+	})
+	// The order workflow records the window in its history, so changing it is safe for open workflows
+	watcher.Apply("orderRoundWindow", func(cfg config.Config) error {
+		orders.SetRoundWindow(cfg.OrderRoundWindow)
+		return nil
+	})
+	// Each order records the threshold in its history, so changing it is safe for open workflows
+	watcher.Apply("orderAlertAfter", func(cfg config.Config) error {
+		orders.SetAlertAfter(cfg.OrderAlertAfter)
+		return nil
+	})
+	// The limit is read by the activity of every order
+	watcher.Apply("intakeLimit", func(cfg config.Config) error {
+		sobriety.SetLimit(float32(cfg.IntakeLimit))
+		return nil
+	})
+	// Each run of the order workflow reads the limit once when it starts
+	watcher.Apply("customerOrderLimit", func(cfg config.Config) error {
+		orders.SetCustomerLimit(orders.Limit{Orders: cfg.CustomerOrderLimit.Orders, Window: cfg.CustomerOrderLimit.Window})
+		return nil
+	})
+	// Each run of the order workflow reads the opening hours once when it starts as well
+	watcher.Apply("openingHours", func(cfg config.Config) error {
+		openingHours, err := orders.ParseOpeningHours(cfg.OpeningHours)
+		if err != nil {
+			return err
+		}
+		orders.SetOpeningHours(openingHours)
+		return nil
+	})
+	// The profiles apply to activities scheduled after the change, Cadence does not replay the options
+	watcher.Apply("activityProfiles", func(cfg config.Config) error {
+		activityProfiles, err := profiles.Parse(cfg.ActivityProfiles)
+		if err != nil {
+			return err
+		}
+		profiles.Set(activityProfiles)
+		return nil
+	})
+	// Faults are rolled for every execution, turning injection on or off needs a restart
+	watcher.Apply("faultInjection.rules", func(cfg config.Config) error {
+		faults, err := faultinject.Parse(cfg.FaultInjection.Rules)
+		if err != nil {
+			return err
+		}
+		faultinject.Set(faults)
+		return nil
+	})
+	watcher.Apply("payments.rates", func(cfg config.Config) error {
+		rates, err := pricing.ParseRates(cfg.Payments.Rates)
+		if err != nil {
+			return err
+		}
+		pricing.SetRates(rates)
+		return nil
+	})
+	watcher.Apply("stockMonitor.thresholds", applyStockMonitor)
+	watcher.Apply("stockMonitor.restock", applyStockMonitor)
+	watcher.Apply("stockMonitor.suppliers", func(cfg config.Config) error {
+		suppliers, err := stock.NewSuppliers(cfg.StockMonitor.Suppliers)
+		if err != nil {
+			return err
+		}
+		stock.SetSuppliers(suppliers)
+		return nil
+	})
+	watcher.Apply("payroll.period", applyPayroll)
+	watcher.Apply("payroll.anchor", applyPayroll)
+	watcher.Apply("payroll.dailyHours", applyPayroll)
+	watcher.Apply("payroll.weeklyHours", applyPayroll)
+	watcher.Apply("payroll.overtimeMultiplier", applyPayroll)
+	watcher.Apply("payments.ratesUrl", applyExchanges)
+	watcher.Apply("payments.ratesCacheTtl", applyExchanges)
+	watcher.Apply("tracing.sampleRate", applySampling)
+	watcher.Apply("tracing.samplingRules", applySampling)
+	return watcher
+}
+
+// applyStockMonitor replaces the thresholds and restock quantities of the stock monitor
+func applyStockMonitor(cfg config.Config) error {
+	thresholds, err := inventory.ParseStock(cfg.StockMonitor.Thresholds)
+	if err != nil {
+		return err
+	}
+	restock, err := inventory.ParseStock(cfg.StockMonitor.Restock)
+	if err != nil {
+		return err
+	}
+	stock.SetThresholds(thresholds, restock)
+	return nil
+}
+
+// applyPayroll replaces the pay periods and overtime rules, periods already paid are not paid again
+func applyPayroll(cfg config.Config) error {
+	rules, err := payroll.NewRules(cfg.Payroll)
+	if err != nil {
+		return err
+	}
+	payroll.SetRules(rules)
+	return nil
+}
+
+// applyExchanges replaces the provider of the exchange rates, the new provider starts with an empty cache
+func applyExchanges(cfg config.Config) error {
+	pricing.SetExchanges(pricing.NewRateProvider(cfg.Payments))
+	return nil
+}
+
+// applySampling replaces the sampling rules of the tracer
+func applySampling(cfg config.Config) error {
+	rules, err := tracing.ParseRules(cfg.Tracing.SamplingRules)
+	if err != nil {
+		return err
+	}
+	return tracing.Sampling.Set(cfg.Tracing.SampleRate, rules)
+}
+
+// checkRegistrations compares the registered workflows against the manifest of the API
+// The number of missing workflows is reported as a gauge so it can be alerted on
+func checkRegistrations(cfg config.Config, scope tally.Scope, logger *zap.Logger) error {
+	missing := registry.Missing(registry.Manifest)
+	scope.Gauge("registration_missing").Update(float64(len(missing)))
+	if len(missing) == 0 {
+		return nil
+	}
+	if cfg.StrictRegistration {
+		return fmt.Errorf("worker is missing workflows the API starts: %v", missing)
+	}
+	logger.Warn("Worker is missing workflows the API starts", zap.Strings("missing", missing))
+	return nil
 }
 
 // newCadenceConnection is used to create a new YARPC connection to the Cadence server
 // @clientName - used to identify the connection on YARPC
-func newCadenceConnection(clientName string) (workflowserviceclient.Interface, error) {
+// @host - the Cadence server IP:Port
+// @outbound - middleware applied to every call, it can be nil
+func newCadenceConnection(clientName, host string, outbound middleware.UnaryOutbound) (workflowserviceclient.Interface, error) {
 	// Create a new Channel to communicate through
 	// Set the service name to our Client name so we can Identify the connection
 	ch, err := tchannel.NewChannelTransport(tchannel.ServiceName(ClientName))
@@ -95,7 +507,10 @@ func newCadenceConnection(clientName string) (workflowserviceclient.Interface, e
 	dispatcher := yarpc.NewDispatcher(yarpc.Config{
 		Name: ClientName,
 		Outbounds: yarpc.Outbounds{
-			CadenceService: {Unary: ch.NewSingleOutbound(Host)},
+			CadenceService: {Unary: ch.NewSingleOutbound(host)},
+		},
+		OutboundMiddleware: yarpc.OutboundMiddleware{
+			Unary: outbound,
 		},
 	})
 	// Start the dispatcher to allow incomming/outgoing messages
@@ -107,11 +522,14 @@ func newCadenceConnection(clientName string) (workflowserviceclient.Interface, e
 }
 
 // newLogger will create a new logger to be used by the Worker Services
-// For now use DevelopmentConfig and Info level
-func newLogger() (*zap.Logger, error) {
+// For now use DevelopmentConfig, the level is the configured log level
+func newLogger(level string) (*zap.Logger, error) {
 	config := zap.NewDevelopmentConfig()
 
-	config.Level.SetLevel(zapcore.InfoLevel)
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %v", err)
+	}
+	config.Level = logLevel
 
 	var err error
 	logger, err := config.Build()
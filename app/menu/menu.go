@@ -0,0 +1,54 @@
+package menu
+
+import (
+	"programmingpercy/cadence-tavern/models"
+)
+
+// Drink is something that can be ordered in the Tavern
+type Drink struct {
+	Name      string       `json:"name"`
+	Price     models.Money `json:"price"`
+	Alcoholic bool         `json:"alcoholic"`
+	// Units is the alcohol of the drink in units, counted towards the intake limit of the customer
+	Units float32 `json:"units,omitempty"`
+}
+
+// Drinks is the menu of the Tavern, the first drink is the house special
+var Drinks = []Drink{
+	{Name: "House Ale", Price: usd(450), Alcoholic: true, Units: 2.3},
+	{Name: "Dark Stout", Price: usd(500), Alcoholic: true, Units: 2.4},
+	{Name: "Mead", Price: usd(600), Alcoholic: true, Units: 3},
+	{Name: "Cider", Price: usd(400), Alcoholic: true, Units: 2},
+	{Name: "Elderflower Lemonade", Price: usd(300)},
+	{Name: "Hot Cocoa", Price: usd(350)},
+}
+
+// usd is the price in cents, the menu is priced in the default currency of the payments
+func usd(cents int64) models.Money {
+	return models.Money{Currency: "USD", Minor: cents}
+}
+
+// Find returns the drink with the given name
+func Find(name string) (Drink, bool) {
+	for _, drink := range Drinks {
+		if drink.Name == name {
+			return drink, true
+		}
+	}
+	return Drink{}, false
+}
+
+// Recommend picks a drink for a customer, minors are only recommended drinks without alcohol
+// First time visitors get the house special, returning visitors are walked through the menu one visit at a time
+func Recommend(age int, timesVisited int) Drink {
+	var choices []Drink
+	for _, drink := range Drinks {
+		if age >= 18 || !drink.Alcoholic {
+			choices = append(choices, drink)
+		}
+	}
+	if timesVisited <= 1 {
+		return choices[0]
+	}
+	return choices[(timesVisited-1)%len(choices)]
+}
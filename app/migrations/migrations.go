@@ -0,0 +1,301 @@
+// Package migrations keeps the schema of the SQL backends, the files are embedded in the binaries
+// Each file is one version written the golang-migrate way, NNNN_name.up.sql, in a directory per dialect
+// The stores run Up when they open their database, cmd/migrate up runs it by hand
+// Only up migrations exist, a version is applied in one transaction together with its row in schema_migrations
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"programmingpercy/cadence-tavern/ops"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var files embed.FS
+
+// advisoryLock is the key of the postgres advisory lock held while a version is applied
+const advisoryLock = 7264128
+
+// Migration is one version of the schema
+type Migration struct {
+	Version    int    `json:"version"`
+	Name       string `json:"name"`
+	statements []string
+}
+
+// Status is how far the schema of a database is migrated
+type Status struct {
+	// Name is the store that opened the database, such as customers
+	Name    string `json:"name"`
+	Driver  string `json:"driver"`
+	Version int    `json:"version"`
+	Latest  int    `json:"latest"`
+	// Pending are the versions not applied yet
+	Pending   []Migration `json:"pending,omitempty"`
+	AppliedAt *time.Time  `json:"appliedAt,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+var (
+	// databases are the databases migrated by this process by store, they are reported by ServeHTTP
+	databases   = map[string]database{}
+	databasesMu sync.Mutex
+)
+
+// database is a database migrated by Up
+type database struct {
+	db     *sql.DB
+	driver string
+}
+
+// Load returns the migrations of the driver, sorted by version
+// The driver is postgres or sqlite3, like sql.Open takes it
+func Load(driver string) ([]Migration, error) {
+	dir, err := dialect(driver)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	seen := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSuffix(name, ".up.sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || version <= 0 {
+			return nil, fmt.Errorf("migration %s should be named NNNN_name.up.sql", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, name)
+		}
+		seen[version] = name
+
+		content, err := fs.ReadFile(files, dir+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: parts[1], statements: split(string(content))})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// dialect is the directory of the migrations of the driver
+func dialect(driver string) (string, error) {
+	switch driver {
+	case "postgres":
+		return "postgres", nil
+	case "sqlite3":
+		return "sqlite", nil
+	default:
+		return "", fmt.Errorf("no migrations for driver %s", driver)
+	}
+}
+
+// split returns the statements of a file, they end with a semicolon at the end of a line
+func split(content string) []string {
+	var statements []string
+	for _, statement := range strings.Split(content, ";\n") {
+		if statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";")); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// Up applies the migrations the database is missing, name is the store opening it and is used by ServeHTTP
+// Several processes can run it at the same time, a version another process applied first is skipped
+func Up(name string, db *sql.DB, driver string) error {
+	migrations, err := Load(driver)
+	if err != nil {
+		return err
+	}
+	if err := createVersionTable(db, driver); err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if err := apply(db, driver, migration); err != nil {
+			return fmt.Errorf("failed to apply migration %04d_%s: %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	databasesMu.Lock()
+	defer databasesMu.Unlock()
+	databases[name] = database{db: db, driver: driver}
+	return nil
+}
+
+// createVersionTable creates the table recording the applied versions
+func createVersionTable(db *sql.DB, driver string) error {
+	timestamp := "TIMESTAMP"
+	if driver == "postgres" {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + timestamp + ` NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+	return nil
+}
+
+// apply runs the migration unless it is applied already
+func apply(db *sql.DB, driver string, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Postgres processes wait for each other, sqlite locks the whole database on the first write instead
+	if driver == "postgres" {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, advisoryLock); err != nil {
+			return err
+		}
+	}
+	if applied, err := isApplied(tx, driver, migration.Version); err != nil || applied {
+		return err
+	}
+
+	for _, statement := range migration.statements {
+		if _, err := tx.Exec(statement); err != nil {
+			// Databases created before migrations existed already have some of the columns, sqlite has no
+			// ADD COLUMN IF NOT EXISTS like postgres so the error is ignored
+			if driver == "sqlite3" && isDuplicateColumn(err) {
+				continue
+			}
+			return err
+		}
+	}
+	_, err = tx.Exec(rebind(driver, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`),
+		migration.Version, migration.Name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		// Another sqlite process might have applied the version in the meantime
+		if applied, checkErr := isApplied(db, driver, migration.Version); checkErr == nil && applied {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// querier is implemented by both sql.DB and sql.Tx
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// isApplied is true when the version is recorded in schema_migrations
+func isApplied(db querier, driver string, version int) (bool, error) {
+	var count int
+	err := db.QueryRow(rebind(driver, `SELECT COUNT(*) FROM schema_migrations WHERE version = $1`), version).Scan(&count)
+	return count > 0, err
+}
+
+// Current returns how far the schema of the database is migrated
+func Current(db *sql.DB, driver string) (Status, error) {
+	status := Status{Driver: driver}
+	migrations, err := Load(driver)
+	if err != nil {
+		return status, err
+	}
+	if len(migrations) > 0 {
+		status.Latest = migrations[len(migrations)-1].Version
+	}
+
+	applied := make(map[int]bool)
+	// A database that was never migrated has no schema_migrations table, every version is pending then
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations ORDER BY version`)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				version   int
+				appliedAt time.Time
+			)
+			if err := rows.Scan(&version, &appliedAt); err != nil {
+				return status, err
+			}
+			applied[version] = true
+			status.Version = version
+			status.AppliedAt = &appliedAt
+		}
+		if err := rows.Err(); err != nil {
+			return status, err
+		}
+	}
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+	return status, nil
+}
+
+// ServeHTTP shows the schema version of every database migrated by this process
+// It is meant to be registered on the ops listener
+func ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	databasesMu.Lock()
+	names := make([]string, 0, len(databases))
+	for name := range databases {
+		names = append(names, name)
+	}
+	migrated := make(map[string]database, len(databases))
+	for name, db := range databases {
+		migrated[name] = db
+	}
+	databasesMu.Unlock()
+	sort.Strings(names)
+
+	statuses := make([]Status, 0, len(names))
+	for _, name := range names {
+		status, err := Current(migrated[name].db, migrated[name].driver)
+		if err != nil {
+			status.Error = err.Error()
+		}
+		status.Name = name
+		statuses = append(statuses, status)
+	}
+	ops.WriteJSON(w, statuses)
+}
+
+// rebind converts $1 style placeholders into the style of the driver
+func rebind(driver, query string) string {
+	if driver == "postgres" {
+		return query
+	}
+	return strings.ReplaceAll(query, "$", "?")
+}
+
+// isDuplicateColumn is true when a ADD COLUMN failed because the column is already there
+func isDuplicateColumn(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "duplicate column") || strings.Contains(msg, "already exists")
+}
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS customers (
+	name TEXT NOT NULL,
+	age INTEGER NOT NULL,
+	times_visited INTEGER NOT NULL,
+	last_visit TIMESTAMPTZ NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	phone TEXT NOT NULL DEFAULT '',
+	banned BOOLEAN NOT NULL DEFAULT FALSE,
+	location TEXT NOT NULL DEFAULT '',
+	loyalty_id TEXT NOT NULL DEFAULT '',
+	deleted_at TIMESTAMPTZ,
+	PRIMARY KEY (location, name)
+);
+
+-- Tables created before locations and loyalty cards existed are missing the columns
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS location TEXT NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS loyalty_id TEXT NOT NULL DEFAULT '';
+
+-- Customers without a card share the empty loyalty ID, only the cards are unique
+CREATE UNIQUE INDEX IF NOT EXISTS customers_location_loyalty_id ON customers (location, loyalty_id) WHERE loyalty_id <> '';
+
+-- The upsert conflicts on the location and name, tables created before locations existed still keep names
+-- unique over all locations through their old primary key
+CREATE UNIQUE INDEX IF NOT EXISTS customers_location_name ON customers (location, name);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS customer_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	key TEXT NOT NULL UNIQUE,
+	topic TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	published_at TIMESTAMPTZ
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS intake (
+	location TEXT NOT NULL DEFAULT '',
+	customer TEXT NOT NULL,
+	order_id TEXT NOT NULL,
+	units REAL NOT NULL,
+	PRIMARY KEY (location, customer, order_id)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS customer_rankings (
+	id BIGSERIAL PRIMARY KEY,
+	location TEXT NOT NULL,
+	taken_at TIMESTAMPTZ NOT NULL,
+	entries TEXT NOT NULL
+);
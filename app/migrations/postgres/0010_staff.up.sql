@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS employees (
+	location TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	phone TEXT NOT NULL DEFAULT '',
+	currency TEXT NOT NULL,
+	hourly_rate BIGINT NOT NULL,
+	PRIMARY KEY (location, name)
+);
+
+CREATE TABLE IF NOT EXISTS shifts (
+	id TEXT PRIMARY KEY,
+	location TEXT NOT NULL DEFAULT '',
+	employee TEXT NOT NULL,
+	start_at TIMESTAMPTZ NOT NULL,
+	end_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS shifts_location_start ON shifts (location, start_at);
+
+CREATE TABLE IF NOT EXISTS payroll_records (
+	location TEXT NOT NULL DEFAULT '',
+	employee TEXT NOT NULL,
+	period_start TIMESTAMPTZ NOT NULL,
+	period_end TIMESTAMPTZ NOT NULL,
+	shifts INTEGER NOT NULL,
+	regular_hours DOUBLE PRECISION NOT NULL,
+	overtime_hours DOUBLE PRECISION NOT NULL,
+	currency TEXT NOT NULL,
+	hourly_rate BIGINT NOT NULL,
+	regular BIGINT NOT NULL,
+	overtime BIGINT NOT NULL,
+	gross BIGINT NOT NULL,
+	PRIMARY KEY (location, employee, period_start)
+);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS order_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	item TEXT NOT NULL DEFAULT '',
+	customer TEXT NOT NULL DEFAULT '',
+	price REAL NOT NULL DEFAULT 0,
+	discount REAL NOT NULL DEFAULT 0,
+	occurred_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_events_order_id ON order_events (order_id);
+
+-- Tables created before discounts existed are missing the column
+ALTER TABLE order_events ADD COLUMN discount REAL NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS order_dead_letters (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_id TEXT NOT NULL,
+	location TEXT NOT NULL DEFAULT '',
+	item TEXT NOT NULL DEFAULT '',
+	customer TEXT NOT NULL DEFAULT '',
+	price REAL NOT NULL DEFAULT 0,
+	reason TEXT NOT NULL DEFAULT '',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	failed_at TIMESTAMP NOT NULL
+);
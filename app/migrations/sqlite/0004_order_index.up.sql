@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS order_index (
+	order_id TEXT PRIMARY KEY,
+	location TEXT NOT NULL DEFAULT '',
+	workflow_id TEXT NOT NULL,
+	run_id TEXT NOT NULL DEFAULT '',
+	item TEXT NOT NULL DEFAULT '',
+	customer TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	placed_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_index_location_placed ON order_index (location, placed_at);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS pours (
+	id TEXT PRIMARY KEY,
+	token BLOB NOT NULL,
+	location TEXT NOT NULL DEFAULT '',
+	order_id TEXT NOT NULL DEFAULT '',
+	item TEXT NOT NULL DEFAULT '',
+	machine TEXT NOT NULL DEFAULT '',
+	deadline TIMESTAMP NOT NULL
+);
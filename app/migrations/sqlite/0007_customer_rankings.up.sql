@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS customer_rankings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	location TEXT NOT NULL,
+	taken_at TIMESTAMP NOT NULL,
+	entries TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+-- Payloads activities kept panicking on are kept with the dead letters
+ALTER TABLE order_dead_letters ADD COLUMN activity TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE order_dead_letters ADD COLUMN payload TEXT NOT NULL DEFAULT '';
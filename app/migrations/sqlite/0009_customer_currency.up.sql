@@ -0,0 +1,2 @@
+-- Customers pay in the currency of the menu unless they have one of their own
+ALTER TABLE customers ADD COLUMN currency TEXT NOT NULL DEFAULT '';
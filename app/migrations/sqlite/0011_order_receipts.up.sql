@@ -0,0 +1,2 @@
+-- Orders paid upfront link to their receipt
+ALTER TABLE order_events ADD COLUMN receipt_url TEXT NOT NULL DEFAULT '';
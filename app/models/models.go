@@ -0,0 +1,204 @@
+// Package models is the domain model of the tavern, the customers, their orders and their tabs
+// The repositories, the workflows and the API all use these types, the packages that used to define them keep an alias
+// They are written into the history of the workflows, so a change of a field must still decode the histories of
+// running workflows. The bodies of the API requests are in apitypes and mapped to these
+package models
+
+import (
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/tracing"
+	"strings"
+	"time"
+)
+
+// Customer is representation of a client in the Tavern
+type Customer struct {
+	Name string `json:"name"`
+	// LastVisit is a timestamp of the last time this visitor came by the tavern
+	LastVisit time.Time `json:"lastVisit"`
+	// TimesVisited is how many times a user has visited
+	TimesVisited int `json:"timesVisited"`
+	// Age is the customer age
+	Age int `json:"age"`
+	// Email is used to send notifications such as receipts
+	Email string `json:"email,omitempty"`
+	// Phone is used to send text messages
+	Phone string `json:"phone,omitempty"`
+	// Banned customers are not served
+	Banned bool `json:"banned"`
+	// LoyaltyID is the number on the loyalty card of the customer, it is unique within a location
+	// Customers can order with it instead of their name, empty when they have no card
+	LoyaltyID string `json:"loyaltyId,omitempty"`
+	// Location is the tavern the customer belongs to, it is the partition key of the repositories
+	// Empty is the default location
+	Location string `json:"location,omitempty"`
+	// Currency is the currency the customer pays in, such as EUR, empty pays in the currency of the menu
+	Currency string `json:"currency,omitempty"`
+	// DeletedAt is set when the customer has been removed, deleted customers are never returned
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// The loyalty statuses a customer has based on how often they visit
+const (
+	LoyaltyNew     = "new"
+	LoyaltyRegular = "regular"
+	LoyaltyGold    = "gold"
+)
+
+// Loyalty returns the loyalty status of the customer
+func (c Customer) Loyalty() string {
+	switch {
+	case c.TimesVisited >= 10:
+		return LoyaltyGold
+	case c.TimesVisited >= 3:
+		return LoyaltyRegular
+	default:
+		return LoyaltyNew
+	}
+}
+
+// Validate returns why the customer can not be greeted or stored, nil when it can
+func (c Customer) Validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return errors.New("name is required")
+	}
+	if c.Age < 0 {
+		return errors.New("age can not be negative")
+	}
+	if c.TimesVisited < 0 {
+		return errors.New("timesVisited can not be negative")
+	}
+	if c.Currency != "" && (len(c.Currency) != 3 || NormalizeCurrency(c.Currency) != c.Currency) {
+		return fmt.Errorf("currency %q should be an upper case ISO 4217 code such as EUR", c.Currency)
+	}
+	return nil
+}
+
+// Order is a simple type to represent orders made
+type Order struct {
+	// ID identifies the order in the order store, it is set by the API
+	ID    string `json:"id"`
+	Item  string `json:"item"`
+	Price Money  `json:"price"`
+	By    string `json:"by"`
+	// LoyaltyID identifies the customer by their loyalty card instead of By, By is filled in once they are found
+	LoyaltyID string `json:"loyaltyId,omitempty"`
+	// Trace continues the trace started by the API when the order was made
+	Trace tracing.Carrier `json:"trace,omitempty"`
+	// Location is the tavern the order was made in, it is set by the API
+	Location string `json:"location,omitempty"`
+	// Discount is the returning customer discount taken off the price, it is set while processing
+	Discount Money `json:"discount,omitempty"`
+	// Table is where the order is served, it is set while processing and empty for customers that are not seated
+	Table string `json:"table,omitempty"`
+	// CallbackURL is where the outcome of the order is POSTed once it is completed or dead lettered
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// ReceiptURL is where the receipt of an order paid upfront can be found, it is set while processing
+	ReceiptURL string `json:"receiptUrl,omitempty"`
+}
+
+// Validate returns why the order can never be served, nil when it can
+func (o Order) Validate() error {
+	if strings.TrimSpace(o.By) == "" && strings.TrimSpace(o.LoyaltyID) == "" {
+		return errors.New("by or loyaltyId is required")
+	}
+	if strings.TrimSpace(o.Item) == "" {
+		return errors.New("item is required")
+	}
+	if err := o.Price.Validate(); err != nil {
+		return fmt.Errorf("price: %v", err)
+	}
+	if err := o.Discount.Validate(); err != nil {
+		return fmt.Errorf("discount: %v", err)
+	}
+	return nil
+}
+
+// TabItem is something that has been put on a tab
+type TabItem struct {
+	Item  string `json:"item"`
+	Price Money  `json:"price"`
+}
+
+// Tab is the bill of a customer
+type Tab struct {
+	Customer string    `json:"customer"`
+	Items    []TabItem `json:"items"`
+	Total    Money     `json:"total"`
+	// Tip is paid on top of the total, it is in the currency of the total
+	Tip Money `json:"tip,omitempty"`
+	// Charge is the payment the tab was settled with, it is empty when the tab was split
+	Charge Charge `json:"charge"`
+	// Exchange is how the total was converted when the customer pays in another currency than the menu
+	Exchange *Exchange `json:"exchange,omitempty"`
+	// Payments are the shares the total and the tip are paid in, a tab that is not split has one for its customer
+	Payments []TabPayment `json:"payments,omitempty"`
+	// ReceiptURL is where the receipt of the settled tab can be found
+	ReceiptURL string `json:"receiptUrl"`
+	// Visit is the run the tab was opened in, it is carried when the tab continues as new
+	// The workflow ID of a tab is reused on every visit of the customer, the visit keeps their payments apart
+	Visit string `json:"visit,omitempty"`
+}
+
+// TabPayment is the share of a tab one payer is charged
+type TabPayment struct {
+	Payer string `json:"payer"`
+	// Amount is the share of the total and the tip, in the currency of the total
+	Amount Money `json:"amount"`
+	// Reference identifies the share at the payment provider, so charging it again never charges twice
+	Reference string `json:"reference"`
+	// Exchange is how the share was converted when the payer pays in another currency than the menu
+	Exchange *Exchange `json:"exchange,omitempty"`
+	// Charge is set once the share is paid
+	Charge Charge `json:"charge"`
+	// Error is why the share could not be charged the last time the tab was settled
+	Error string `json:"error,omitempty"`
+}
+
+// Paid is true once the share has been charged
+func (p TabPayment) Paid() bool {
+	return p.Charge.ID != ""
+}
+
+// Settlement is how a customer wants to pay their tab, the zero value pays the total without a tip
+type Settlement struct {
+	// Tip is paid on top of the total, in the currency of the total
+	Tip Money `json:"tip,omitempty"`
+	// Split shares the total and the tip between payers, the customer of the tab pays all of it when empty
+	Split []Share `json:"split,omitempty"`
+}
+
+// Share is the part of a split tab one payer pays
+type Share struct {
+	Payer string `json:"payer"`
+	// Parts is how many parts of the tab the payer pays, payers with 2 parts pay twice as much as payers with 1
+	// It is 1 when left out
+	Parts int `json:"parts,omitempty"`
+}
+
+// Validate returns why the tab can not be settled like this, nil when it can
+func (s Settlement) Validate() error {
+	if err := s.Tip.Validate(); err != nil {
+		return fmt.Errorf("tip: %v", err)
+	}
+	for i, share := range s.Split {
+		if strings.TrimSpace(share.Payer) == "" {
+			return fmt.Errorf("split %d: payer is required", i)
+		}
+		if share.Parts < 0 {
+			return fmt.Errorf("split %d: parts can not be negative", i)
+		}
+	}
+	return nil
+}
+
+// Charge is the representation of a payment made by a customer
+type Charge struct {
+	ID       string `json:"id"`
+	Customer string `json:"customer"`
+	Amount   Money  `json:"amount"`
+	// Reference is what the charge was made for, such as the workflow ID
+	Reference string `json:"reference"`
+	Refunded  bool   `json:"refunded"`
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Currency is the currency amounts without one are in, the binaries replace it with the currency of the payments
+// Prices were bare numbers before Money existed, the histories of running workflows still have them and they are read
+// as amounts in this currency
+var Currency = "USD"
+
+// zeroDecimals are the currencies without a minor unit, every other currency has two decimals
+var zeroDecimals = map[string]bool{"JPY": true, "KRW": true, "ISK": true}
+
+// Money is an amount in a currency, kept in minor units such as cents so adding up a tab never rounds
+type Money struct {
+	// Currency is the ISO 4217 code, such as USD
+	Currency string `json:"currency"`
+	// Minor is the amount in the minor unit of the currency, 450 is 4.50 USD
+	Minor int64 `json:"minor"`
+}
+
+// Exchange is an amount converted into another currency
+type Exchange struct {
+	From Money `json:"from"`
+	To   Money `json:"to"`
+	// Rate is how much one unit of the From currency is in the To currency
+	Rate float64 `json:"rate"`
+	// Source is the provider the rate came from
+	Source string `json:"source"`
+	// At is when the provider published the rate
+	At time.Time `json:"at"`
+}
+
+// NewMoney rounds the amount, such as 4.5, to the minor units of the currency
+func NewMoney(currency string, amount float64) Money {
+	currency = NormalizeCurrency(currency)
+	return Money{Currency: currency, Minor: int64(math.Round(amount * math.Pow10(decimals(currency))))}
+}
+
+// NormalizeCurrency upper cases the currency code, the payment providers are often configured with usd
+func NormalizeCurrency(currency string) string {
+	return strings.ToUpper(strings.TrimSpace(currency))
+}
+
+// decimals is how many digits the minor unit of the currency has
+func decimals(currency string) int {
+	if zeroDecimals[currency] {
+		return 0
+	}
+	return 2
+}
+
+// Float64 is the amount in the major unit, it is only meant for reports and metrics, never for adding up money
+func (m Money) Float64() float64 {
+	return float64(m.Minor) / math.Pow10(decimals(m.Currency))
+}
+
+// IsZero is true for no money in any currency
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// String formats the amount with its currency, such as 4.50 USD
+func (m Money) String() string {
+	return fmt.Sprintf("%.*f %s", decimals(m.Currency), m.Float64(), m.Currency)
+}
+
+// Validate returns why the money can not be charged, nil when it can
+// The zero value is valid, it is nothing in no currency
+func (m Money) Validate() error {
+	if m.Currency == "" && m.Minor == 0 {
+		return nil
+	}
+	if len(m.Currency) != 3 || NormalizeCurrency(m.Currency) != m.Currency {
+		return fmt.Errorf("currency %q should be an upper case ISO 4217 code such as USD", m.Currency)
+	}
+	if m.Minor < 0 {
+		return errors.New("amount can not be negative")
+	}
+	return nil
+}
+
+// Add sums the amounts, the zero value takes the currency of the other amount so a total can start from nothing
+func (m Money) Add(other Money) (Money, error) {
+	switch {
+	case m.Currency == "" && m.Minor == 0:
+		return other, nil
+	case other.Currency == "" && other.Minor == 0:
+		return m, nil
+	case m.Currency != other.Currency:
+		return m, fmt.Errorf("can not add %s to %s", other.Currency, m.Currency)
+	}
+	return Money{Currency: m.Currency, Minor: m.Minor + other.Minor}, nil
+}
+
+// Sub takes the other amount off, they must be in the same currency
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(Money{Currency: other.Currency, Minor: -other.Minor})
+}
+
+// Percent is the percent of the amount rounded to the minor unit, such as the discount of a price
+func (m Money) Percent(percent float64) Money {
+	return Money{Currency: m.Currency, Minor: int64(math.Round(float64(m.Minor) * percent / 100))}
+}
+
+// Allocate splits the amount by the parts, such as 1, 1, 2 for a quarter, a quarter and a half
+// The minor units that can not be split evenly go to the first parts, so the allocated amounts add up to the amount
+func (m Money) Allocate(parts []int) []Money {
+	var sum int64
+	for _, p := range parts {
+		sum += int64(p)
+	}
+	allocated := make([]Money, len(parts))
+	if sum == 0 {
+		return allocated
+	}
+	left := m.Minor
+	for i, p := range parts {
+		allocated[i] = Money{Currency: m.Currency, Minor: m.Minor * int64(p) / sum}
+		left -= allocated[i].Minor
+	}
+	for i := 0; left > 0; i = (i + 1) % len(parts) {
+		if parts[i] > 0 {
+			allocated[i].Minor++
+			left--
+		}
+	}
+	return allocated
+}
+
+// UnmarshalJSON reads the money as it is encoded, an object such as {"currency":"USD","minor":450}
+// A bare number is a legacy price in Currency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' && data[0] != 'n' {
+		var amount float64
+		if err := json.Unmarshal(data, &amount); err != nil {
+			return fmt.Errorf("money should be an object with currency and minor or a number: %v", err)
+		}
+		*m = NewMoney(Currency, amount)
+		return nil
+	}
+	// The alias has no methods, so decoding it does not come back here
+	type money Money
+	var decoded money
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*m = Money(decoded)
+	return nil
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"programmingpercy/cadence-tavern/workflows/registry"
+)
+
+// Schemas are the JSON schemas of the models by name, they describe the models as the API and the workflows encode them
+// The same schemas validate the payloads of the signals, see registry.Signal
+func Schemas() map[string]*registry.Schema {
+	return map[string]*registry.Schema{
+		"Customer":   registry.SchemaOf(Customer{}),
+		"Order":      registry.SchemaOf(Order{}),
+		"Tab":        registry.SchemaOf(Tab{}),
+		"TabItem":    registry.SchemaOf(TabItem{}),
+		"Settlement": registry.SchemaOf(Settlement{}),
+		"Charge":     registry.SchemaOf(Charge{}),
+	}
+}
@@ -0,0 +1,121 @@
+package ops
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"programmingpercy/cadence-tavern/config"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var (
+	// publishOnce makes sure the runtime vars are only published once, expvar panics on duplicates
+	publishOnce sync.Once
+	startTime   = time.Now()
+)
+
+// Server is the operations HTTP listener used to diagnose a running binary
+// It exposes pprof, expvar runtime metrics and the resolved configuration
+type Server struct {
+	addr string
+	mux  *http.ServeMux
+}
+
+// NewServer will create a operations server listening on addr
+// cfg is dumped with secrets redacted on /debug/config
+func NewServer(addr string, cfg config.Config) *Server {
+	publishOnce.Do(publishRuntime)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/config", func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, cfg.Redacted())
+	})
+
+	return &Server{
+		addr: addr,
+		mux:  mux,
+	}
+}
+
+// Handle is used to register additional diagnostic endpoints on the ops listener
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc is used to register additional diagnostic endpoints on the ops listener
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// ListenAndServe will block and serve the ops endpoints
+func (s *Server) ListenAndServe() error {
+	return http.ListenAndServe(s.addr, s.mux)
+}
+
+// WriteJSON is a small helper to print diagnostic output as indented JSON
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// publishRuntime adds runtime information next to the default memstats and cmdline vars
+func publishRuntime() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("uptime", expvar.Func(func() interface{} {
+		return time.Since(startTime).String()
+	}))
+	expvar.Publish("runtime", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"version":    runtime.Version(),
+			"numCPU":     runtime.NumCPU(),
+			"gomaxprocs": runtime.GOMAXPROCS(0),
+		}
+	}))
+}
+
+// Readiness is served on /readyz, the binary marks itself ready once it can do its work
+type Readiness struct {
+	mu     sync.RWMutex
+	ready  bool
+	reason string
+}
+
+// NewReadiness starts out not ready with the reason
+func NewReadiness(reason string) *Readiness {
+	return &Readiness{reason: reason}
+}
+
+// Set changes the readiness, reason explains it to whoever looks at /readyz
+func (rd *Readiness) Set(ready bool, reason string) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.ready, rd.reason = ready, reason
+}
+
+// ServeHTTP answers 200 when ready and 503 when not, with the reason either way
+func (rd *Readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rd.mu.RLock()
+	ready, reason := rd.ready, rd.reason
+	rd.mu.RUnlock()
+
+	if !ready {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	WriteJSON(w, map[string]interface{}{"ready": ready, "reason": reason})
+}
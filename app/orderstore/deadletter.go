@@ -0,0 +1,125 @@
+package orderstore
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// DeadLetters is where orders that could not be processed are kept for an operator, the binaries replace this during startup
+var DeadLetters DeadLetterStore = NewMemoryStore()
+
+// DeadLetter is an order that failed after all retries
+// Payloads that activities kept panicking on are kept as dead letters too, they have the Activity and Payload set
+type DeadLetter struct {
+	OrderID  string  `json:"orderId"`
+	Location string  `json:"location,omitempty"`
+	Item     string  `json:"item"`
+	By       string  `json:"by"`
+	Price    float32 `json:"price"`
+	// Reason is the last error of the order
+	Reason string `json:"reason"`
+	// Attempts is how many times the order was tried
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failedAt"`
+	// Activity is the activity that panicked on the Payload, empty for orders
+	Activity string `json:"activity,omitempty"`
+	// Payload is the JSON array of the inputs the activity panicked on
+	Payload string `json:"payload,omitempty"`
+}
+
+// DeadLetterStore is the needed methods to keep dead letters, both stores of this package implement it
+type DeadLetterStore interface {
+	AddDeadLetter(ctx context.Context, letter DeadLetter) error
+	DeadLetters(ctx context.Context) ([]DeadLetter, error)
+}
+
+// AddDeadLetter keeps the letter
+func (ms *MemoryStore) AddDeadLetter(ctx context.Context, letter DeadLetter) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.deadLetters = append(ms.deadLetters, letter)
+	return nil
+}
+
+// DeadLetters returns all letters, oldest first
+func (ms *MemoryStore) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	letters := make([]DeadLetter, len(ms.deadLetters))
+	copy(letters, ms.deadLetters)
+	return letters, nil
+}
+
+// AddDeadLetter inserts the letter
+func (ss *SQLStore) AddDeadLetter(ctx context.Context, letter DeadLetter) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`INSERT INTO order_dead_letters (order_id, location, item, customer, price, reason, attempts, failed_at, activity, payload)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`),
+		letter.OrderID, letter.Location, letter.Item, letter.By, letter.Price, letter.Reason, letter.Attempts, letter.FailedAt.UTC(), letter.Activity, letter.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to add dead letter: %v", err)
+	}
+	return nil
+}
+
+// DeadLetters returns all letters, oldest first
+func (ss *SQLStore) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	rows, err := ss.db.QueryContext(ctx, `SELECT order_id, location, item, customer, price, reason, attempts, failed_at, activity, payload
+		FROM order_dead_letters ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %v", err)
+	}
+	defer rows.Close()
+
+	var letters []DeadLetter
+	for rows.Next() {
+		var letter DeadLetter
+		if err := rows.Scan(&letter.OrderID, &letter.Location, &letter.Item, &letter.By, &letter.Price, &letter.Reason, &letter.Attempts, &letter.FailedAt, &letter.Activity, &letter.Payload); err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+var deadLetterHeader = []string{"orderId", "location", "item", "by", "price", "reason", "attempts", "failedAt", "activity", "payload"}
+
+// EncodeDeadLetters writes the dead letters to w as json or csv with a header row
+func EncodeDeadLetters(w io.Writer, format string, letters []DeadLetter) error {
+	switch format {
+	case "", "json":
+		return json.NewEncoder(w).Encode(letters)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(deadLetterHeader); err != nil {
+			return err
+		}
+		for _, letter := range letters {
+			record := []string{
+				letter.OrderID,
+				letter.Location,
+				letter.Item,
+				letter.By,
+				strconv.FormatFloat(float64(letter.Price), 'f', -1, 32),
+				letter.Reason,
+				strconv.Itoa(letter.Attempts),
+				letter.FailedAt.Format(time.RFC3339),
+				letter.Activity,
+				letter.Payload,
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+}
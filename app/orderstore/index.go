@@ -0,0 +1,273 @@
+package orderstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Index is the record of the orders the API sent to an order workflow, the binaries replace this during startup
+// It is keyed by order ID so orders can be listed and looked up without Cadence visibility
+var Index IndexStore = NewMemoryStore()
+
+// IndexEntry is an order the API sent, with the workflow run that received it and its latest status
+type IndexEntry struct {
+	OrderID    string `json:"orderId"`
+	Location   string `json:"location,omitempty"`
+	WorkflowID string `json:"workflowId"`
+	RunID      string `json:"runId"`
+	Item       string `json:"item"`
+	By         string `json:"by"`
+	// Status is the type of the latest event of the order, received until the workflow records more
+	Status    string    `json:"status"`
+	PlacedAt  time.Time `json:"placedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Done is true for orders that will not change anymore
+func (ie IndexEntry) Done() bool {
+	return ie.Status == EventCompleted || ie.Status == EventFailed
+}
+
+// IndexFilter selects the entries returned by ListOrders, the empty Location is the default location and the other
+// empty fields match everything
+type IndexFilter struct {
+	Location string
+	Status   string
+	By       string
+	// Pending only matches orders that are not done
+	Pending bool
+	// Limit is the most entries returned, 0 returns all
+	Limit int
+}
+
+func (f IndexFilter) matches(entry IndexEntry) bool {
+	return entry.Location == f.Location &&
+		(f.Status == "" || entry.Status == f.Status) &&
+		(f.By == "" || entry.By == f.By) &&
+		(!f.Pending || !entry.Done())
+}
+
+// IndexStore is the needed methods to keep the order index, both stores of this package implement it
+type IndexStore interface {
+	// PutOrder adds the entry or replaces the entry with the same order ID
+	PutOrder(ctx context.Context, entry IndexEntry) error
+	// UpdateOrderStatus sets the status of an order, ErrNoSuchOrder is returned when it is not indexed
+	UpdateOrderStatus(ctx context.Context, orderID string, status string, at time.Time) error
+	// UpdateOrderRun sets the run the order was signalled to, ErrNoSuchOrder is returned when it is not indexed
+	UpdateOrderRun(ctx context.Context, orderID string, runID string) error
+	// LookupOrder returns the entry of an order, ErrNoSuchOrder is returned when it is not indexed
+	LookupOrder(ctx context.Context, orderID string) (IndexEntry, error)
+	// ListOrders returns the entries matching the filter, newest first
+	ListOrders(ctx context.Context, filter IndexFilter) ([]IndexEntry, error)
+}
+
+// PutOrder adds the entry or replaces the entry with the same order ID
+func (ms *MemoryStore) PutOrder(ctx context.Context, entry IndexEntry) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.index[entry.OrderID] = entry
+	return nil
+}
+
+// UpdateOrderStatus sets the status of an order
+func (ms *MemoryStore) UpdateOrderStatus(ctx context.Context, orderID string, status string, at time.Time) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	entry, ok := ms.index[orderID]
+	if !ok {
+		return ErrNoSuchOrder
+	}
+	entry.Status = status
+	entry.UpdatedAt = at
+	ms.index[orderID] = entry
+	return nil
+}
+
+// UpdateOrderRun sets the run the order was signalled to
+func (ms *MemoryStore) UpdateOrderRun(ctx context.Context, orderID string, runID string) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	entry, ok := ms.index[orderID]
+	if !ok {
+		return ErrNoSuchOrder
+	}
+	entry.RunID = runID
+	ms.index[orderID] = entry
+	return nil
+}
+
+// LookupOrder returns the entry of an order
+func (ms *MemoryStore) LookupOrder(ctx context.Context, orderID string) (IndexEntry, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	entry, ok := ms.index[orderID]
+	if !ok {
+		return IndexEntry{}, ErrNoSuchOrder
+	}
+	return entry, nil
+}
+
+// ListOrders returns the entries matching the filter, newest first
+func (ms *MemoryStore) ListOrders(ctx context.Context, filter IndexFilter) ([]IndexEntry, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	var entries []IndexEntry
+	for _, entry := range ms.index {
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].PlacedAt.After(entries[j].PlacedAt)
+	})
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// PutOrder inserts the entry or replaces the entry with the same order ID
+func (ss *SQLStore) PutOrder(ctx context.Context, entry IndexEntry) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`INSERT INTO order_index (order_id, location, workflow_id, run_id, item, customer, status, placed_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (order_id) DO UPDATE SET location = excluded.location, workflow_id = excluded.workflow_id, run_id = excluded.run_id,
+			item = excluded.item, customer = excluded.customer, status = excluded.status, placed_at = excluded.placed_at, updated_at = excluded.updated_at`),
+		entry.OrderID, entry.Location, entry.WorkflowID, entry.RunID, entry.Item, entry.By, entry.Status, entry.PlacedAt.UTC(), entry.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to index order: %v", err)
+	}
+	return nil
+}
+
+// UpdateOrderStatus sets the status of an order
+func (ss *SQLStore) UpdateOrderStatus(ctx context.Context, orderID string, status string, at time.Time) error {
+	result, err := ss.db.ExecContext(ctx, ss.rebind(`UPDATE order_index SET status = $1, updated_at = $2 WHERE order_id = $3`),
+		status, at.UTC(), orderID)
+	if err != nil {
+		return fmt.Errorf("failed to update indexed order: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNoSuchOrder
+	}
+	return nil
+}
+
+// UpdateOrderRun sets the run the order was signalled to
+func (ss *SQLStore) UpdateOrderRun(ctx context.Context, orderID string, runID string) error {
+	result, err := ss.db.ExecContext(ctx, ss.rebind(`UPDATE order_index SET run_id = $1 WHERE order_id = $2`), runID, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to update indexed order: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNoSuchOrder
+	}
+	return nil
+}
+
+// LookupOrder returns the entry of an order
+func (ss *SQLStore) LookupOrder(ctx context.Context, orderID string) (IndexEntry, error) {
+	row := ss.db.QueryRowContext(ctx, ss.rebind(`SELECT order_id, location, workflow_id, run_id, item, customer, status, placed_at, updated_at
+		FROM order_index WHERE order_id = $1`), orderID)
+	entry, err := scanIndexEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return IndexEntry{}, ErrNoSuchOrder
+	}
+	return entry, err
+}
+
+// ListOrders returns the entries matching the filter, newest first
+func (ss *SQLStore) ListOrders(ctx context.Context, filter IndexFilter) ([]IndexEntry, error) {
+	query := `SELECT order_id, location, workflow_id, run_id, item, customer, status, placed_at, updated_at
+		FROM order_index WHERE location = $1`
+	args := []interface{}{filter.Location}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if filter.By != "" {
+		args = append(args, filter.By)
+		query += fmt.Sprintf(" AND customer = $%d", len(args))
+	}
+	if filter.Pending {
+		args = append(args, EventCompleted, EventFailed)
+		query += fmt.Sprintf(" AND status NOT IN ($%d, $%d)", len(args)-1, len(args))
+	}
+	query += " ORDER BY placed_at DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexed orders: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []IndexEntry
+	for rows.Next() {
+		entry, err := scanIndexEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// scanIndexEntry reads a row selected with the columns of order_index in table order
+func scanIndexEntry(row interface {
+	Scan(dest ...interface{}) error
+}) (IndexEntry, error) {
+	var entry IndexEntry
+	err := row.Scan(&entry.OrderID, &entry.Location, &entry.WorkflowID, &entry.RunID, &entry.Item, &entry.By,
+		&entry.Status, &entry.PlacedAt, &entry.UpdatedAt)
+	return entry, err
+}
+
+var indexHeader = []string{"orderId", "location", "workflowId", "runId", "item", "by", "status", "placedAt", "updatedAt"}
+
+// EncodeIndex writes the entries to w as json or csv with a header row
+func EncodeIndex(w io.Writer, format string, entries []IndexEntry) error {
+	switch format {
+	case "", "json":
+		return json.NewEncoder(w).Encode(entries)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(indexHeader); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			record := []string{
+				entry.OrderID,
+				entry.Location,
+				entry.WorkflowID,
+				entry.RunID,
+				entry.Item,
+				entry.By,
+				entry.Status,
+				entry.PlacedAt.Format(time.RFC3339),
+				entry.UpdatedAt.Format(time.RFC3339),
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+}
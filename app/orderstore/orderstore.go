@@ -0,0 +1,222 @@
+package orderstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/migrations"
+	"strings"
+	"sync"
+	"time"
+
+	// Register the drivers used by the SQL backend
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The lifecycle events of an order
+const (
+	EventReceived  = "received"
+	EventVerified  = "verified"
+	EventPrepared  = "prepared"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+)
+
+var (
+	// Events is the store order events are appended to, the binaries replace this during startup
+	Events Store = NewMemoryStore()
+
+	// ErrNoSuchOrder is returned when there are no events for an order
+	ErrNoSuchOrder = errors.New("no such order")
+)
+
+// Event is something that happened to an order
+type Event struct {
+	OrderID string `json:"orderId"`
+	Type    string `json:"type"`
+	// Detail describes the event, such as the reason an order failed
+	Detail     string    `json:"detail,omitempty"`
+	Item       string    `json:"item,omitempty"`
+	By         string    `json:"by,omitempty"`
+	Price      float32   `json:"price,omitempty"`
+	Discount   float32   `json:"discount,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+	// ReceiptURL is set on the events after the receipt of the order was generated
+	ReceiptURL string `json:"receiptUrl,omitempty"`
+}
+
+// Status is the current state of an order projected from its events
+type Status struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Item      string    `json:"item"`
+	By        string    `json:"by"`
+	Price     float32   `json:"price"`
+	Discount  float32   `json:"discount,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	History   []Event   `json:"history"`
+	// ReceiptURL is where the receipt of an order paid upfront can be found, orders put on a tab have none
+	ReceiptURL string `json:"receiptUrl,omitempty"`
+	// WorkflowID and RunID are the order workflow run the order was sent to, they are set from the Index
+	WorkflowID string `json:"workflowId,omitempty"`
+	RunID      string `json:"runId,omitempty"`
+}
+
+// Store is the needed methods to be an append only order event store
+type Store interface {
+	Append(ctx context.Context, event Event) error
+	Events(ctx context.Context, orderID string) ([]Event, error)
+}
+
+// NewStore will create the store for the configured repository backend
+// Orders are kept next to the customers
+func NewStore(cfg config.Repository) (Store, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return NewSQLStore("sqlite3", cfg.DSN)
+	case "postgres":
+		return NewSQLStore("postgres", cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unknown order store backend: %s", cfg.Backend)
+	}
+}
+
+// Project folds the events of an order into its current status
+func Project(events []Event) (Status, error) {
+	if len(events) == 0 {
+		return Status{}, ErrNoSuchOrder
+	}
+
+	status := Status{
+		ID:      events[0].OrderID,
+		History: events,
+	}
+	for _, event := range events {
+		status.Status = event.Type
+		status.UpdatedAt = event.OccurredAt
+		status.Detail = event.Detail
+		if event.Item != "" {
+			status.Item = event.Item
+		}
+		if event.By != "" {
+			status.By = event.By
+		}
+		if event.Price != 0 {
+			status.Price = event.Price
+		}
+		if event.Discount != 0 {
+			status.Discount = event.Discount
+		}
+		if event.ReceiptURL != "" {
+			status.ReceiptURL = event.ReceiptURL
+		}
+	}
+	return status, nil
+}
+
+// MemoryStore keeps events in Memory
+type MemoryStore struct {
+	sync.RWMutex
+	events      map[string][]Event
+	deadLetters []DeadLetter
+	index       map[string]IndexEntry
+}
+
+// NewMemoryStore will init a new in memory event store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		events: make(map[string][]Event),
+		index:  make(map[string]IndexEntry),
+	}
+}
+
+// Append adds the event to the end of the order history
+func (ms *MemoryStore) Append(ctx context.Context, event Event) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.events[event.OrderID] = append(ms.events[event.OrderID], event)
+	return nil
+}
+
+// Events returns the history of an order, oldest first
+func (ms *MemoryStore) Events(ctx context.Context, orderID string) ([]Event, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+
+	events := make([]Event, len(ms.events[orderID]))
+	copy(events, ms.events[orderID])
+	return events, nil
+}
+
+// SQLStore keeps events in a append only SQL table
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewSQLStore will open the database and migrate its schema
+func NewSQLStore(driver, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", driver, err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", driver, err)
+	}
+
+	ss := &SQLStore{
+		db:     db,
+		driver: driver,
+	}
+
+	if err := migrations.Up("orders", db, driver); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// rebind converts $1 style placeholders into the style of the driver
+func (ss *SQLStore) rebind(query string) string {
+	if ss.driver == "postgres" {
+		return query
+	}
+	return strings.ReplaceAll(query, "$", "?")
+}
+
+// Append inserts the event
+func (ss *SQLStore) Append(ctx context.Context, event Event) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`INSERT INTO order_events (order_id, type, detail, item, customer, price, discount, occurred_at, receipt_url)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`),
+		event.OrderID, event.Type, event.Detail, event.Item, event.By, event.Price, event.Discount, event.OccurredAt.UTC(), event.ReceiptURL)
+	if err != nil {
+		return fmt.Errorf("failed to append order event: %v", err)
+	}
+	return nil
+}
+
+// Events returns the history of an order, oldest first
+func (ss *SQLStore) Events(ctx context.Context, orderID string) ([]Event, error) {
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(`SELECT order_id, type, detail, item, customer, price, discount, occurred_at, receipt_url
+		FROM order_events WHERE order_id = $1 ORDER BY id`), orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read order events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(&event.OrderID, &event.Type, &event.Detail, &event.Item, &event.By, &event.Price, &event.Discount, &event.OccurredAt, &event.ReceiptURL); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
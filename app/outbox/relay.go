@@ -0,0 +1,116 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/customer"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Publisher is the needed methods to deliver outbox entries downstream
+type Publisher interface {
+	Publish(ctx context.Context, entry customer.OutboxEntry) error
+}
+
+// NewPublisher creates the publisher from configuration
+// If no URL is configured the entries are only logged
+func NewPublisher(cfg config.Outbox, logger *zap.Logger) Publisher {
+	if cfg.PublishURL == "" {
+		return &LogPublisher{Logger: logger}
+	}
+	return &HTTPPublisher{
+		URL:    cfg.PublishURL,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// LogPublisher writes the entries to the log
+type LogPublisher struct {
+	Logger *zap.Logger
+}
+
+// Publish logs the entry
+func (lp *LogPublisher) Publish(ctx context.Context, entry customer.OutboxEntry) error {
+	lp.Logger.Info("Outbox entry published", zap.String("topic", entry.Topic), zap.ByteString("payload", entry.Payload))
+	return nil
+}
+
+// HTTPPublisher POSTs each entry payload to a URL
+// The outbox key is sent as Idempotency-Key since delivery is at least once
+type HTTPPublisher struct {
+	URL    string
+	Client *http.Client
+}
+
+// Publish sends the entry
+func (hp *HTTPPublisher) Publish(ctx context.Context, entry customer.OutboxEntry) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hp.URL, bytes.NewReader(entry.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", entry.Key)
+	req.Header.Set("X-Tavern-Topic", entry.Topic)
+
+	resp, err := hp.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish outbox entry: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("outbox publish responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Relay moves pending outbox entries to the Publisher
+type Relay struct {
+	Source    customer.Outbox
+	Publisher Publisher
+	Logger    *zap.Logger
+	// Interval is how long to wait between polls of the outbox
+	Interval time.Duration
+	// BatchSize is how many entries are read per poll
+	BatchSize int
+}
+
+// Run will poll the outbox until the ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.publishPending(ctx); err != nil {
+			r.Logger.Warn("Outbox relay failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishPending publishes the pending entries in order, it stops at the first failure to keep ordering
+func (r *Relay) publishPending(ctx context.Context) error {
+	pending, err := r.Source.PendingOutbox(ctx, r.BatchSize)
+	if err != nil {
+		return err
+	}
+	for _, entry := range pending {
+		if err := r.Publisher.Publish(ctx, entry); err != nil {
+			return err
+		}
+		if err := r.Source.MarkPublished(ctx, entry.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package payloads
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/workflows/orders"
+
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/cadence/encoded"
+)
+
+const (
+	// EncodingJSON is the default encoding of cadence
+	EncodingJSON = "json"
+	// EncodingProto encodes customers and orders as protobuf, every other value as JSON
+	EncodingProto = "proto"
+)
+
+// header starts every payload written with EncodingProto, payloads without it are decoded as JSON
+var header = []byte("TVP1")
+
+// The kinds of the values in a proto payload
+const (
+	kindProto byte = 'p'
+	kindJSON  byte = 'j'
+)
+
+// DataConverter serializes workflow and activity inputs, results and signals
+// Payloads of both encodings are always decoded, so workers and APIs can switch encoding one at a time
+type DataConverter struct {
+	proto    bool
+	fallback encoded.DataConverter
+}
+
+// NewDataConverter will create a DataConverter writing the encoding, json or proto
+func NewDataConverter(encoding string) (*DataConverter, error) {
+	switch encoding {
+	case "", EncodingJSON, EncodingProto:
+	default:
+		return nil, fmt.Errorf("unknown payload encoding: %s", encoding)
+	}
+	return &DataConverter{
+		proto:    encoding == EncodingProto,
+		fallback: encoded.GetDefaultDataConverter(),
+	}, nil
+}
+
+// ToData encodes the values, with EncodingProto each value is written as a kind, a length and the bytes
+func (dc *DataConverter) ToData(values ...interface{}) ([]byte, error) {
+	if !dc.proto {
+		return dc.fallback.ToData(values...)
+	}
+
+	buf := bytes.NewBuffer(append([]byte(nil), header...))
+	length := make([]byte, binary.MaxVarintLen64)
+	for i, value := range values {
+		kind := kindJSON
+		var data []byte
+		var err error
+		if msg, ok := toMessage(value); ok {
+			kind = kindProto
+			data, err = proto.Marshal(msg)
+		} else {
+			data, err = json.Marshal(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode value %d: %v", i, err)
+		}
+
+		buf.WriteByte(kind)
+		buf.Write(length[:binary.PutUvarint(length, uint64(len(data)))])
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// FromData decodes the input into the value pointers
+func (dc *DataConverter) FromData(input []byte, valuePtrs ...interface{}) error {
+	if !bytes.HasPrefix(input, header) {
+		return dc.fallback.FromData(input, valuePtrs...)
+	}
+
+	r := bytes.NewReader(input[len(header):])
+	for i, ptr := range valuePtrs {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("payload is missing value %d", i)
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return fmt.Errorf("payload has a bad length for value %d", i)
+		}
+		data := make([]byte, n)
+		r.Read(data)
+		if ptr == nil {
+			continue
+		}
+
+		switch kind {
+		case kindProto:
+			err = fromMessage(data, ptr)
+		case kindJSON:
+			err = json.Unmarshal(data, ptr)
+		default:
+			err = fmt.Errorf("unknown kind %q", kind)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode value %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// toMessage returns the protobuf message of the value, if it has one
+func toMessage(value interface{}) (proto.Message, bool) {
+	switch v := value.(type) {
+	case customer.Customer:
+		return FromCustomer(v), true
+	case *customer.Customer:
+		return FromCustomer(*v), true
+	case orders.Order:
+		return FromOrder(v), true
+	case *orders.Order:
+		return FromOrder(*v), true
+	}
+	return nil, false
+}
+
+// fromMessage decodes the protobuf message into ptr
+func fromMessage(data []byte, ptr interface{}) error {
+	switch p := ptr.(type) {
+	case *customer.Customer:
+		var m Customer
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*p = m.Customer()
+	case *orders.Order:
+		var m Order
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*p = m.Order()
+	default:
+		return fmt.Errorf("value is a protobuf message but is read into a %T", ptr)
+	}
+	return nil
+}
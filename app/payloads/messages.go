@@ -0,0 +1,140 @@
+package payloads
+
+import (
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/orders"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The messages of tavern.proto, the struct tags are what the proto package marshals
+// Keep the field numbers in sync with tavern.proto, other languages generate their types from it
+
+// Customer is the protobuf message of customer.Customer
+type Customer struct {
+	Name         string `protobuf:"bytes,1,opt,name=name,proto3"`
+	LastVisit    int64  `protobuf:"varint,2,opt,name=last_visit,json=lastVisit,proto3"`
+	TimesVisited int64  `protobuf:"varint,3,opt,name=times_visited,json=timesVisited,proto3"`
+	Age          int64  `protobuf:"varint,4,opt,name=age,proto3"`
+	Email        string `protobuf:"bytes,5,opt,name=email,proto3"`
+	Phone        string `protobuf:"bytes,6,opt,name=phone,proto3"`
+	Banned       bool   `protobuf:"varint,7,opt,name=banned,proto3"`
+	Location     string `protobuf:"bytes,8,opt,name=location,proto3"`
+	DeletedAt    int64  `protobuf:"varint,9,opt,name=deleted_at,json=deletedAt,proto3"`
+}
+
+func (m *Customer) Reset()         { *m = Customer{} }
+func (m *Customer) String() string { return proto.CompactTextString(m) }
+func (*Customer) ProtoMessage()    {}
+
+// Order is the protobuf message of orders.Order
+type Order struct {
+	Id       string            `protobuf:"bytes,1,opt,name=id,proto3"`
+	Item     string            `protobuf:"bytes,2,opt,name=item,proto3"`
+	Price    float32           `protobuf:"fixed32,3,opt,name=price,proto3"`
+	By       string            `protobuf:"bytes,4,opt,name=by,proto3"`
+	Trace    map[string]string `protobuf:"bytes,5,rep,name=trace,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Location string            `protobuf:"bytes,6,opt,name=location,proto3"`
+	Discount float32           `protobuf:"fixed32,7,opt,name=discount,proto3"`
+	Table    string            `protobuf:"bytes,8,opt,name=table,proto3"`
+	// Currency is the currency of PriceMinor and DiscountMinor, Price and Discount are kept for older readers
+	Currency      string `protobuf:"bytes,9,opt,name=currency,proto3"`
+	PriceMinor    int64  `protobuf:"varint,10,opt,name=price_minor,json=priceMinor,proto3"`
+	DiscountMinor int64  `protobuf:"varint,11,opt,name=discount_minor,json=discountMinor,proto3"`
+}
+
+func (m *Order) Reset()         { *m = Order{} }
+func (m *Order) String() string { return proto.CompactTextString(m) }
+func (*Order) ProtoMessage()    {}
+
+// FromCustomer converts the customer into its message
+func FromCustomer(c customer.Customer) *Customer {
+	m := &Customer{
+		Name:         c.Name,
+		LastVisit:    unixNano(c.LastVisit),
+		TimesVisited: int64(c.TimesVisited),
+		Age:          int64(c.Age),
+		Email:        c.Email,
+		Phone:        c.Phone,
+		Banned:       c.Banned,
+		Location:     c.Location,
+	}
+	if c.DeletedAt != nil {
+		m.DeletedAt = unixNano(*c.DeletedAt)
+	}
+	return m
+}
+
+// Customer converts the message back into a customer
+func (m *Customer) Customer() customer.Customer {
+	c := customer.Customer{
+		Name:         m.Name,
+		LastVisit:    fromUnixNano(m.LastVisit),
+		TimesVisited: int(m.TimesVisited),
+		Age:          int(m.Age),
+		Email:        m.Email,
+		Phone:        m.Phone,
+		Banned:       m.Banned,
+		Location:     m.Location,
+	}
+	if m.DeletedAt != 0 {
+		deletedAt := fromUnixNano(m.DeletedAt)
+		c.DeletedAt = &deletedAt
+	}
+	return c
+}
+
+// FromOrder converts the order into its message
+func FromOrder(o orders.Order) *Order {
+	return &Order{
+		Id:            o.ID,
+		Item:          o.Item,
+		Price:         float32(o.Price.Float64()),
+		By:            o.By,
+		Trace:         o.Trace,
+		Location:      o.Location,
+		Discount:      float32(o.Discount.Float64()),
+		Table:         o.Table,
+		Currency:      o.Price.Currency,
+		PriceMinor:    o.Price.Minor,
+		DiscountMinor: o.Discount.Minor,
+	}
+}
+
+// Order converts the message back into an order, messages without a currency are in models.Currency
+func (m *Order) Order() orders.Order {
+	o := orders.Order{
+		ID:       m.Id,
+		Item:     m.Item,
+		Price:    models.NewMoney(models.Currency, float64(m.Price)),
+		By:       m.By,
+		Trace:    tracing.Carrier(m.Trace),
+		Location: m.Location,
+		Discount: models.NewMoney(models.Currency, float64(m.Discount)),
+		Table:    m.Table,
+	}
+	if m.Currency != "" {
+		o.Price = models.Money{Currency: m.Currency, Minor: m.PriceMinor}
+		o.Discount = models.Money{Currency: m.Currency, Minor: m.DiscountMinor}
+	}
+	return o
+}
+
+// unixNano keeps the zero time as 0 so it survives the round trip
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano is the reverse of unixNano
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,250 @@
+package prometheus
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/ops"
+	"sort"
+	"sync"
+	"unicode"
+
+	"github.com/uber-go/tally"
+)
+
+// foldedValue is reported instead of the values of a tag past MaxTagValues
+const foldedValue = "other"
+
+// Cardinality guards the scope created by NewServiceScope or NewWorkerScope, it is meant to be registered
+// on the ops listener to see how many series each metric has
+var Cardinality = NewGuard(config.Metrics{})
+
+// Guard keeps the metrics of the scopes it wraps in shape for prometheus and grafana
+// Names are snake_case, tags identifying people are hashed into a few buckets and every other tag
+// is capped at MaxTagValues values per metric, so a new business metric can not blow up the series count
+type Guard struct {
+	sync.Mutex
+	maxTagValues int
+	hashBuckets  int
+	hashed       map[string]bool
+
+	// values are the values seen of each tag of each metric
+	values map[string]map[string]map[string]bool
+	// series are the tag combinations seen of each metric
+	series  map[string]map[string]bool
+	folded  map[string]int64
+	renamed map[string]string
+}
+
+// NewGuard creates a guard with the limits of cfg
+func NewGuard(cfg config.Metrics) *Guard {
+	gu := &Guard{
+		values:  make(map[string]map[string]map[string]bool),
+		series:  make(map[string]map[string]bool),
+		folded:  make(map[string]int64),
+		renamed: make(map[string]string),
+	}
+	gu.Configure(cfg)
+	return gu
+}
+
+// Configure replaces the limits, the series already seen are kept
+func (gu *Guard) Configure(cfg config.Metrics) {
+	gu.Lock()
+	defer gu.Unlock()
+	gu.maxTagValues = cfg.MaxTagValues
+	gu.hashBuckets = cfg.HashBuckets
+	gu.hashed = make(map[string]bool, len(cfg.HashedTags))
+	for _, tag := range cfg.HashedTags {
+		gu.hashed[tag] = true
+	}
+}
+
+// Wrap returns the scope with every metric created through it guarded
+func (gu *Guard) Wrap(scope tally.Scope) tally.Scope {
+	return &guardScope{guard: gu, scope: scope}
+}
+
+// clean returns the name and tags a metric is reported with and records the series
+// prefix is the sub scopes the metric was created in, it keeps metrics of the same name apart
+func (gu *Guard) clean(prefix, name string, tags map[string]string) (string, map[string]string) {
+	clean := snakeCase(name)
+
+	gu.Lock()
+	defer gu.Unlock()
+	if clean != name {
+		gu.renamed[name] = clean
+	}
+	full := prefix + clean
+
+	var cleaned map[string]string
+	if len(tags) > 0 {
+		cleaned = make(map[string]string, len(tags))
+	}
+	for key, value := range tags {
+		if gu.hashed[key] {
+			value = gu.hash(value)
+		}
+		cleaned[key] = gu.capValue(full, key, value)
+	}
+
+	if gu.series[full] == nil {
+		gu.series[full] = make(map[string]bool)
+	}
+	gu.series[full][metricKey(full, cleaned)] = true
+	return clean, cleaned
+}
+
+// hash puts the value in one of the HashBuckets, the same customer always lands in the same bucket
+func (gu *Guard) hash(value string) string {
+	buckets := gu.hashBuckets
+	if buckets <= 0 {
+		buckets = 1
+	}
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return fmt.Sprintf("bucket_%02d", h.Sum32()%uint32(buckets))
+}
+
+// capValue returns the value, or foldedValue once the tag of the metric has MaxTagValues other values
+func (gu *Guard) capValue(metric, key, value string) string {
+	if gu.maxTagValues <= 0 {
+		return value
+	}
+	tags := gu.values[metric]
+	if tags == nil {
+		tags = make(map[string]map[string]bool)
+		gu.values[metric] = tags
+	}
+	seen := tags[key]
+	if seen == nil {
+		seen = make(map[string]bool)
+		tags[key] = seen
+	}
+	if seen[value] {
+		return value
+	}
+	if len(seen) >= gu.maxTagValues {
+		gu.folded[metric]++
+		return foldedValue
+	}
+	seen[value] = true
+	return value
+}
+
+// seriesReport is what ServeHTTP shows
+type seriesReport struct {
+	Total   int               `json:"total"`
+	Series  []metricSeries    `json:"series"`
+	Renamed map[string]string `json:"renamed"`
+}
+
+// metricSeries is how many series a metric has and how often its tag values were folded into other
+type metricSeries struct {
+	Metric string `json:"metric"`
+	Series int    `json:"series"`
+	Folded int64  `json:"folded,omitempty"`
+}
+
+// ServeHTTP lists the series count of every metric, the largest first
+// It is meant to be registered on the ops listener
+func (gu *Guard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gu.Lock()
+	report := seriesReport{Renamed: make(map[string]string, len(gu.renamed))}
+	for metric, series := range gu.series {
+		report.Series = append(report.Series, metricSeries{Metric: metric, Series: len(series), Folded: gu.folded[metric]})
+		report.Total += len(series)
+	}
+	for from, to := range gu.renamed {
+		report.Renamed[from] = to
+	}
+	gu.Unlock()
+
+	sort.Slice(report.Series, func(i, j int) bool {
+		if report.Series[i].Series != report.Series[j].Series {
+			return report.Series[i].Series > report.Series[j].Series
+		}
+		return report.Series[i].Metric < report.Series[j].Metric
+	})
+	ops.WriteJSON(w, report)
+}
+
+// guardScope passes every metric through the guard, the tags are held until a metric is created
+// since the cap of a tag is per metric
+type guardScope struct {
+	guard  *Guard
+	scope  tally.Scope
+	prefix string
+	tags   map[string]string
+}
+
+func (gs *guardScope) Counter(name string) tally.Counter {
+	name, scope := gs.resolve(name)
+	return scope.Counter(name)
+}
+
+func (gs *guardScope) Gauge(name string) tally.Gauge {
+	name, scope := gs.resolve(name)
+	return scope.Gauge(name)
+}
+
+func (gs *guardScope) Timer(name string) tally.Timer {
+	name, scope := gs.resolve(name)
+	return scope.Timer(name)
+}
+
+func (gs *guardScope) Histogram(name string, buckets tally.Buckets) tally.Histogram {
+	name, scope := gs.resolve(name)
+	return scope.Histogram(name, buckets)
+}
+
+func (gs *guardScope) Tagged(tags map[string]string) tally.Scope {
+	merged := make(map[string]string, len(gs.tags)+len(tags))
+	for k, v := range gs.tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return &guardScope{guard: gs.guard, scope: gs.scope, prefix: gs.prefix, tags: merged}
+}
+
+func (gs *guardScope) SubScope(name string) tally.Scope {
+	return &guardScope{guard: gs.guard, scope: gs.scope.SubScope(name), prefix: gs.prefix + name + "_", tags: gs.tags}
+}
+
+func (gs *guardScope) Capabilities() tally.Capabilities {
+	return gs.scope.Capabilities()
+}
+
+// resolve cleans the metric and returns the scope it is created on
+func (gs *guardScope) resolve(name string) (string, tally.Scope) {
+	name, tags := gs.guard.clean(gs.prefix, name, gs.tags)
+	if len(tags) == 0 {
+		return name, gs.scope
+	}
+	return name, gs.scope.Tagged(tags)
+}
+
+// snakeCase turns orderPlaced into order_placed, names that already are snake or kebab case are kept
+// since the sanitizer replaces the dashes
+func snakeCase(name string) string {
+	runes := []rune(name)
+	out := make([]rune, 0, len(runes)+4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+				out = append(out, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
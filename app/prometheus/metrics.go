@@ -1,10 +1,15 @@
+// Package prometheus creates the tally scopes of the Worker and the API, the reporter they use is selected by
+// config.Metrics.Backend. Prometheus is scraped and the default, statsd, m3 and otlp push to an agent or collector
 package prometheus
 
 import (
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
 	"time"
 
 	prom "github.com/m3db/prometheus_client_golang/prometheus"
 	"github.com/uber-go/tally"
+	"github.com/uber-go/tally/m3"
 	"github.com/uber-go/tally/prometheus"
 	"go.uber.org/zap"
 )
@@ -29,12 +34,51 @@ var (
 	}
 )
 
+// NewReporter creates the reporter of the configured backend, it is handed to NewServiceScope or NewWorkerScope
+// addr is only used by prometheus, the other backends send to cfg.Endpoint
+func NewReporter(addr string, cfg config.Metrics, logger *zap.Logger) (tally.BaseStatsReporter, error) {
+	switch cfg.Backend {
+	case "", "prometheus":
+		return NewPrometheusReporter(addr, cfg, logger)
+	case "statsd":
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("the statsd backend needs a metrics endpoint")
+		}
+		return NewStatsdReporter(cfg.Endpoint, logger)
+	case "m3":
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("the m3 backend needs a metrics endpoint")
+		}
+		reporter, err := m3.NewReporter(m3.Options{
+			HostPorts: []string{cfg.Endpoint},
+			Service:   cfg.Service,
+			Env:       cfg.Env,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create m3 reporter: %v", err)
+		}
+		return reporter, nil
+	case "otlp":
+		if cfg.Endpoint == "" {
+			return nil, fmt.Errorf("the otlp backend needs a metrics endpoint")
+		}
+		return NewOTLPReporter(cfg, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown metrics backend: %s", cfg.Backend)
+	}
+}
+
 // NewPrometheusReporter is used to create a new reporter that can send info to prom
 // we need a zap logger inputted to make sure we get logs on error
 // addr should be the IP:PORT to send metrics
-func NewPrometheusReporter(addr string, logger *zap.Logger) (prometheus.Reporter, error) {
+// cfg selects if timers are reported as histograms and in what buckets
+func NewPrometheusReporter(addr string, cfg config.Metrics, logger *zap.Logger) (prometheus.Reporter, error) {
 	promCfg := prometheus.Configuration{
 		ListenAddress: addr,
+		TimerType:     cfg.TimerType,
+	}
+	for _, upper := range cfg.TimerBuckets {
+		promCfg.DefaultHistogramBuckets = append(promCfg.DefaultHistogramBuckets, prometheus.HistogramObjective{Upper: upper})
 	}
 
 	reporter, err := promCfg.NewReporter(
@@ -53,27 +97,43 @@ func NewPrometheusReporter(addr string, logger *zap.Logger) (prometheus.Reporter
 }
 
 // NewServiceScope is used by services and prefixed Service_
-func NewServiceScope(reporter prometheus.Reporter) tally.Scope {
-	serviceScope, _ := tally.NewRootScope(tally.ScopeOptions{
-		Prefix:          "Service_",
-		Tags:            map[string]string{},
-		CachedReporter:  reporter,
-		Separator:       prometheus.DefaultSeparator,
-		SanitizeOptions: &sanitizeOptions,
-	}, 1*time.Second)
-
-	return serviceScope
+// reporter is any reporter created by NewReporter, a prometheus.Reporter still works as before
+func NewServiceScope(reporter tally.BaseStatsReporter, cfg config.Metrics) tally.Scope {
+	return newScope("Service_", reporter, cfg)
 }
 
 // NewWorkerScope is used by Workers and prefixed Worker_
-func NewWorkerScope(reporter prometheus.Reporter) tally.Scope {
-	serviceScope, _ := tally.NewRootScope(tally.ScopeOptions{
-		Prefix:          "Worker_",
+func NewWorkerScope(reporter tally.BaseStatsReporter, cfg config.Metrics) tally.Scope {
+	return newScope("Worker_", reporter, cfg)
+}
+
+// newScope reports to the reporter the way it wants, prometheus and m3 keep their own metrics while statsd
+// and otlp are handed every value
+func newScope(prefix string, reporter tally.BaseStatsReporter, cfg config.Metrics) tally.Scope {
+	opts := tally.ScopeOptions{
+		Prefix:          prefix,
 		Tags:            map[string]string{},
-		CachedReporter:  reporter,
 		Separator:       prometheus.DefaultSeparator,
 		SanitizeOptions: &sanitizeOptions,
-	}, 1*time.Second)
+		DefaultBuckets:  defaultBuckets(cfg),
+	}
+	switch r := reporter.(type) {
+	case tally.CachedStatsReporter:
+		opts.CachedReporter = r
+	case tally.StatsReporter:
+		opts.Reporter = r
+	}
+	scope, _ := tally.NewRootScope(opts, 1*time.Second)
 
-	return serviceScope
+	// Every metric goes through the guard so the series stay countable, see Cardinality
+	Cardinality.Configure(cfg)
+	return Cardinality.Wrap(scope)
+}
+
+// defaultBuckets are used by histograms created without buckets, nil leaves the tally defaults
+func defaultBuckets(cfg config.Metrics) tally.Buckets {
+	if len(cfg.DefaultBuckets) == 0 {
+		return nil
+	}
+	return tally.ValueBuckets(cfg.DefaultBuckets)
 }
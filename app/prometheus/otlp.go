@@ -0,0 +1,335 @@
+package prometheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"programmingpercy/cadence-tavern/config"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// otlpDelta is the aggregation temporality of sums and histograms that only hold what happened since the last push
+const otlpDelta = 1
+
+// OTLPReporter pushes metrics to an OpenTelemetry collector using OTLP over HTTP with JSON
+// Counters become delta sums, gauges stay gauges and timers become histograms in the TimerBuckets
+type OTLPReporter struct {
+	endpoint string
+	interval time.Duration
+	resource []otlpAttribute
+	bounds   []float64
+	client   *http.Client
+	logger   *zap.Logger
+
+	mu         sync.Mutex
+	lastPush   time.Time
+	counters   map[string]*otlpValue
+	gauges     map[string]*otlpValue
+	histograms map[string]*otlpHistogram
+}
+
+// otlpValue is a counter or gauge with its tags
+type otlpValue struct {
+	name  string
+	tags  map[string]string
+	value float64
+}
+
+// otlpHistogram is a timer or histogram with its tags, counts has one more bucket than bounds for the overflow
+type otlpHistogram struct {
+	name   string
+	tags   map[string]string
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+	hasSum bool
+}
+
+// NewOTLPReporter creates a reporter pushing to cfg.Endpoint every cfg.PushInterval
+func NewOTLPReporter(cfg config.Metrics, logger *zap.Logger) *OTLPReporter {
+	interval := cfg.PushInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &OTLPReporter{
+		endpoint: cfg.Endpoint,
+		interval: interval,
+		resource: []otlpAttribute{
+			stringAttribute("service.name", cfg.Service),
+			stringAttribute("deployment.environment", cfg.Env),
+		},
+		bounds:     cfg.TimerBuckets,
+		client:     &http.Client{Timeout: 5 * time.Second},
+		logger:     logger,
+		lastPush:   time.Now(),
+		counters:   make(map[string]*otlpValue),
+		gauges:     make(map[string]*otlpValue),
+		histograms: make(map[string]*otlpHistogram),
+	}
+}
+
+// Capabilities are reporting and tagging
+func (or *OTLPReporter) Capabilities() tally.Capabilities {
+	return capabilities{}
+}
+
+// ReportCounter adds to the sum of the counter
+func (or *OTLPReporter) ReportCounter(name string, tags map[string]string, value int64) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+	key := metricKey(name, tags)
+	counter, ok := or.counters[key]
+	if !ok {
+		counter = &otlpValue{name: name, tags: tags}
+		or.counters[key] = counter
+	}
+	counter.value += float64(value)
+}
+
+// ReportGauge keeps the latest value of the gauge
+func (or *OTLPReporter) ReportGauge(name string, tags map[string]string, value float64) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+	or.gauges[metricKey(name, tags)] = &otlpValue{name: name, tags: tags, value: value}
+}
+
+// ReportTimer records one duration in seconds in the TimerBuckets
+func (or *OTLPReporter) ReportTimer(name string, tags map[string]string, interval time.Duration) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+	histogram := or.histogram(name, tags, or.bounds)
+	histogram.counts[sort.SearchFloat64s(histogram.bounds, interval.Seconds())]++
+	histogram.count++
+	histogram.sum += interval.Seconds()
+	histogram.hasSum = true
+}
+
+// ReportHistogramValueSamples adds the samples of one bucket, the sum of a tally histogram is not known
+func (or *OTLPReporter) ReportHistogramValueSamples(name string, tags map[string]string, buckets tally.Buckets, bucketLowerBound, bucketUpperBound float64, samples int64) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+	or.addSamples(or.histogram(name, tags, buckets.AsValues()), bucketUpperBound, samples)
+}
+
+// ReportHistogramDurationSamples adds the samples of one bucket, the bounds are in seconds
+func (or *OTLPReporter) ReportHistogramDurationSamples(name string, tags map[string]string, buckets tally.Buckets, bucketLowerBound, bucketUpperBound time.Duration, samples int64) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+	durations := buckets.AsDurations()
+	bounds := make([]float64, len(durations))
+	for i, d := range durations {
+		bounds[i] = d.Seconds()
+	}
+	or.addSamples(or.histogram(name, tags, bounds), bucketUpperBound.Seconds(), samples)
+}
+
+func (or *OTLPReporter) addSamples(histogram *otlpHistogram, upper float64, samples int64) {
+	histogram.counts[sort.SearchFloat64s(histogram.bounds, upper)] += samples
+	histogram.count += samples
+}
+
+// histogram returns the histogram of the metric, the lock needs to be held
+func (or *OTLPReporter) histogram(name string, tags map[string]string, bounds []float64) *otlpHistogram {
+	key := metricKey(name, tags)
+	histogram, ok := or.histograms[key]
+	if !ok {
+		histogram = &otlpHistogram{name: name, tags: tags, bounds: bounds, counts: make([]int64, len(bounds)+1)}
+		or.histograms[key] = histogram
+	}
+	return histogram
+}
+
+// Flush is called by tally every second, the metrics are only pushed once the PushInterval passed
+func (or *OTLPReporter) Flush() {
+	or.mu.Lock()
+	now := time.Now()
+	if now.Sub(or.lastPush) < or.interval {
+		or.mu.Unlock()
+		return
+	}
+	request := or.request(or.lastPush, now)
+	or.lastPush = now
+	or.counters = make(map[string]*otlpValue)
+	or.histograms = make(map[string]*otlpHistogram)
+	or.mu.Unlock()
+
+	if len(request.ResourceMetrics[0].ScopeMetrics[0].Metrics) == 0 {
+		return
+	}
+	if err := or.push(request); err != nil {
+		or.logger.Warn("error in otlp reporter", zap.Error(err))
+	}
+}
+
+func (or *OTLPReporter) push(request otlpRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	res, err := or.client.Post(or.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("otlp collector answered %s", res.Status)
+	}
+	return nil
+}
+
+// request is the export request of everything reported between start and now, the lock needs to be held
+func (or *OTLPReporter) request(start, now time.Time) otlpRequest {
+	startNano := strconv.FormatInt(start.UnixNano(), 10)
+	nowNano := strconv.FormatInt(now.UnixNano(), 10)
+
+	var metrics []otlpMetric
+	for _, counter := range or.counters {
+		metrics = append(metrics, otlpMetric{Name: counter.name, Sum: &otlpSum{
+			AggregationTemporality: otlpDelta,
+			IsMonotonic:            true,
+			DataPoints: []otlpPoint{{
+				Attributes:        attributes(counter.tags),
+				StartTimeUnixNano: startNano,
+				TimeUnixNano:      nowNano,
+				AsInt:             strconv.FormatInt(int64(counter.value), 10),
+			}},
+		}})
+	}
+	for _, gauge := range or.gauges {
+		value := gauge.value
+		metrics = append(metrics, otlpMetric{Name: gauge.name, Gauge: &otlpGauge{
+			DataPoints: []otlpPoint{{
+				Attributes:   attributes(gauge.tags),
+				TimeUnixNano: nowNano,
+				AsDouble:     &value,
+			}},
+		}})
+	}
+	for _, histogram := range or.histograms {
+		point := otlpPoint{
+			Attributes:        attributes(histogram.tags),
+			StartTimeUnixNano: startNano,
+			TimeUnixNano:      nowNano,
+			Count:             strconv.FormatInt(histogram.count, 10),
+			ExplicitBounds:    histogram.bounds,
+		}
+		for _, count := range histogram.counts {
+			point.BucketCounts = append(point.BucketCounts, strconv.FormatInt(count, 10))
+		}
+		if histogram.hasSum {
+			sum := histogram.sum
+			point.Sum = &sum
+		}
+		metrics = append(metrics, otlpMetric{Name: histogram.name, Histogram: &otlpHistogramData{
+			AggregationTemporality: otlpDelta,
+			DataPoints:             []otlpPoint{point},
+		}})
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: or.resource},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "programmingpercy/cadence-tavern"},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+// metricKey tells the metrics with the same name apart by their tags
+func metricKey(name string, tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return name + "+" + strings.Join(pairs, ",")
+}
+
+func attributes(tags map[string]string) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(tags))
+	for k, v := range tags {
+		attrs = append(attrs, stringAttribute(k, v))
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpAnyValue{StringValue: value}}
+}
+
+// The OTLP JSON encoding of an export request, 64 bit integers are strings as the protobuf JSON mapping wants
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name      string             `json:"name"`
+	Sum       *otlpSum           `json:"sum,omitempty"`
+	Gauge     *otlpGauge         `json:"gauge,omitempty"`
+	Histogram *otlpHistogramData `json:"histogram,omitempty"`
+}
+
+type otlpSum struct {
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	IsMonotonic            bool        `json:"isMonotonic"`
+	DataPoints             []otlpPoint `json:"dataPoints"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpPoint `json:"dataPoints"`
+}
+
+type otlpHistogramData struct {
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	DataPoints             []otlpPoint `json:"dataPoints"`
+}
+
+type otlpPoint struct {
+	Attributes        []otlpAttribute `json:"attributes"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsInt             string          `json:"asInt,omitempty"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+	Count             string          `json:"count,omitempty"`
+	Sum               *float64        `json:"sum,omitempty"`
+	BucketCounts      []string        `json:"bucketCounts,omitempty"`
+	ExplicitBounds    []float64       `json:"explicitBounds,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
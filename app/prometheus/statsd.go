@@ -0,0 +1,129 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// statsdPacketSize keeps each packet inside one UDP datagram on a regular network
+const statsdPacketSize = 1432
+
+// StatsdReporter sends metrics to a statsd agent over UDP
+// Tags are written the DogStatsD way, |#key:value, which Datadog, Telegraf and the statsd exporter all read
+type StatsdReporter struct {
+	conn   net.Conn
+	logger *zap.Logger
+
+	mu    sync.Mutex
+	lines []string
+}
+
+// NewStatsdReporter creates a reporter sending to the HOST:PORT of the agent
+func NewStatsdReporter(addr string, logger *zap.Logger) (*StatsdReporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to statsd: %v", err)
+	}
+	return &StatsdReporter{conn: conn, logger: logger}, nil
+}
+
+// Capabilities are reporting and tagging
+func (sr *StatsdReporter) Capabilities() tally.Capabilities {
+	return capabilities{}
+}
+
+// Flush sends what was reported since the last flush, as few packets as possible
+func (sr *StatsdReporter) Flush() {
+	sr.mu.Lock()
+	lines := sr.lines
+	sr.lines = nil
+	sr.mu.Unlock()
+
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > statsdPacketSize {
+			sr.send(&packet)
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	sr.send(&packet)
+}
+
+func (sr *StatsdReporter) send(packet *bytes.Buffer) {
+	if packet.Len() == 0 {
+		return
+	}
+	if _, err := sr.conn.Write(packet.Bytes()); err != nil {
+		sr.logger.Warn("error in statsd reporter", zap.Error(err))
+	}
+	packet.Reset()
+}
+
+// ReportCounter reports how much the counter grew since the last report
+func (sr *StatsdReporter) ReportCounter(name string, tags map[string]string, value int64) {
+	sr.add(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+// ReportGauge reports the current value of the gauge
+func (sr *StatsdReporter) ReportGauge(name string, tags map[string]string, value float64) {
+	sr.add(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// ReportTimer reports one recorded duration in milliseconds
+func (sr *StatsdReporter) ReportTimer(name string, tags map[string]string, interval time.Duration) {
+	sr.add(name, strconv.FormatFloat(float64(interval)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// ReportHistogramValueSamples counts the samples of a bucket, statsd has no buckets so the bucket is a tag
+func (sr *StatsdReporter) ReportHistogramValueSamples(name string, tags map[string]string, buckets tally.Buckets, bucketLowerBound, bucketUpperBound float64, samples int64) {
+	sr.add(name, strconv.FormatInt(samples, 10), "c", withTag(tags, "le", strconv.FormatFloat(bucketUpperBound, 'f', -1, 64)))
+}
+
+// ReportHistogramDurationSamples counts the samples of a bucket, the bound is in seconds like prometheus
+func (sr *StatsdReporter) ReportHistogramDurationSamples(name string, tags map[string]string, buckets tally.Buckets, bucketLowerBound, bucketUpperBound time.Duration, samples int64) {
+	sr.add(name, strconv.FormatInt(samples, 10), "c", withTag(tags, "le", strconv.FormatFloat(bucketUpperBound.Seconds(), 'f', -1, 64)))
+}
+
+func (sr *StatsdReporter) add(name, value, kind string, tags map[string]string) {
+	line := name + ":" + value + "|" + kind
+	if len(tags) > 0 {
+		pairs := make([]string, 0, len(tags))
+		for k, v := range tags {
+			pairs = append(pairs, k+":"+v)
+		}
+		sort.Strings(pairs)
+		line += "|#" + strings.Join(pairs, ",")
+	}
+
+	sr.mu.Lock()
+	sr.lines = append(sr.lines, line)
+	sr.mu.Unlock()
+}
+
+// withTag copies the tags with one more, the tags of tally are shared between reports
+func withTag(tags map[string]string, key, value string) map[string]string {
+	copied := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
+
+// capabilities of the reporters that push, they report and keep tags
+type capabilities struct{}
+
+func (capabilities) Reporting() bool { return true }
+func (capabilities) Tagging() bool   { return true }
@@ -0,0 +1,150 @@
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"programmingpercy/cadence-tavern/config"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Result describes the last reload
+type Result struct {
+	ReloadedAt time.Time `json:"reloadedAt"`
+	// Applied are the settings that changed and are now in use
+	Applied []string `json:"applied"`
+	// RequiresRestart are the settings that differ from what the binary started with and need a restart
+	RequiresRestart []string `json:"requiresRestart"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// Watcher reloads the configuration on SIGHUP and hands the changed settings to the registered appliers
+type Watcher struct {
+	sync.Mutex
+	defaults config.Config
+	started  config.Config
+	current  config.Config
+	appliers map[string]func(config.Config) error
+	logger   *zap.Logger
+	last     Result
+}
+
+// NewWatcher creates a watcher for a binary that started with cfg, defaults are the ones cfg was loaded from
+func NewWatcher(defaults, cfg config.Config, logger *zap.Logger) *Watcher {
+	return &Watcher{
+		defaults: defaults,
+		started:  cfg,
+		current:  cfg,
+		appliers: make(map[string]func(config.Config) error),
+		logger:   logger,
+	}
+}
+
+// Apply registers a setting that can change at runtime
+// path is a json path such as logLevel, or a prefix such as flags, fn is called with the new configuration
+func (w *Watcher) Apply(path string, fn func(config.Config) error) {
+	w.Lock()
+	defer w.Unlock()
+	w.appliers[path] = fn
+}
+
+// Reload loads the configuration again and applies what can be applied
+func (w *Watcher) Reload() Result {
+	w.Lock()
+	defer w.Unlock()
+
+	result := Result{ReloadedAt: time.Now()}
+	cfg, err := config.Load(w.defaults)
+	if err != nil {
+		result.Error = err.Error()
+		w.last = result
+		return result
+	}
+
+	applied := make(map[string]bool)
+	for _, path := range config.Changes(w.current, cfg) {
+		prefix, fn := w.applier(path)
+		if fn == nil || applied[prefix] {
+			continue
+		}
+		if err := fn(cfg); err != nil {
+			w.logger.Error("Failed to apply setting", zap.String("setting", path), zap.Error(err))
+			result.Error = err.Error()
+			continue
+		}
+		applied[prefix] = true
+	}
+	for _, path := range config.Changes(w.current, cfg) {
+		if prefix, fn := w.applier(path); fn != nil && applied[prefix] {
+			result.Applied = append(result.Applied, path)
+		}
+	}
+	// Compared to the startup configuration, so pending restarts are reported until they happen
+	for _, path := range config.Changes(w.started, cfg) {
+		if _, fn := w.applier(path); fn == nil {
+			result.RequiresRestart = append(result.RequiresRestart, path)
+		}
+	}
+
+	if result.Error == "" {
+		w.current = cfg
+	}
+	w.last = result
+	w.logger.Info("Reloaded configuration",
+		zap.Strings("applied", result.Applied),
+		zap.Strings("requiresRestart", result.RequiresRestart))
+	return result
+}
+
+// applier finds the applier registered for the path or one of its parents
+func (w *Watcher) applier(path string) (string, func(config.Config) error) {
+	for prefix, fn := range w.appliers {
+		if path == prefix || strings.HasPrefix(path, prefix+".") {
+			return prefix, fn
+		}
+	}
+	return "", nil
+}
+
+// Watch reloads on every SIGHUP until ctx is done
+func (w *Watcher) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			w.Reload()
+		}
+	}
+}
+
+// ServeHTTP shows the last reload on GET and reloads on POST
+// It is meant to be registered on the ops listener
+func (w *Watcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	var result Result
+	switch r.Method {
+	case http.MethodGet:
+		w.Lock()
+		result = w.last
+		w.Unlock()
+	case http.MethodPost:
+		result = w.Reload()
+	default:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(result)
+}
@@ -0,0 +1,79 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"programmingpercy/cadence-tavern/config"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// Policy is a exponential backoff with jitter
+type Policy struct {
+	// InitialInterval is the wait after the first failure, it doubles on every failure
+	InitialInterval time.Duration
+	// MaxInterval caps the wait between attempts
+	MaxInterval time.Duration
+	// MaxElapsedTime is how long to keep trying before giving up
+	MaxElapsedTime time.Duration
+}
+
+// NewPolicy creates the policy from configuration
+func NewPolicy(cfg config.Startup) Policy {
+	return Policy{
+		InitialInterval: cfg.InitialInterval,
+		MaxInterval:     cfg.MaxInterval,
+		MaxElapsedTime:  cfg.MaxElapsedTime,
+	}
+}
+
+// Do runs fn until it succeeds, the policy gives up or ctx is done
+// Attempts, failures and the time until success are reported on scope tagged with the component name
+func (p Policy) Do(ctx context.Context, scope tally.Scope, logger *zap.Logger, component string, fn func() error) error {
+	scope = scope.Tagged(map[string]string{"component": component})
+	started := time.Now()
+	interval := p.InitialInterval
+
+	for attempt := 1; ; attempt++ {
+		scope.Counter("startup_attempts").Inc(1)
+		err := fn()
+		if err == nil {
+			scope.Timer("startup_latency").Record(time.Since(started))
+			return nil
+		}
+		scope.Counter("startup_failures").Inc(1)
+
+		wait := jitter(interval)
+		if time.Since(started)+wait > p.MaxElapsedTime {
+			return fmt.Errorf("%s did not start after %d attempts: %v", component, attempt, err)
+		}
+		logger.Warn("Startup failed, retrying",
+			zap.String("component", component),
+			zap.Int("attempt", attempt),
+			zap.Duration("wait", wait),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		interval *= 2
+		if interval > p.MaxInterval {
+			interval = p.MaxInterval
+		}
+	}
+}
+
+// jitter picks a wait between half and the full interval so replicas do not retry in lockstep
+func jitter(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	half := interval / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/workflows/canary"
+	"programmingpercy/cadence-tavern/workflows/payroll"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/stock"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// scheduled is a workflow the Worker starts on a cron schedule for every location
+type scheduled struct {
+	// name is used in the workflow ID and the logs, such as canary
+	name     string
+	workflow string
+	schedule string
+	// timeout is how long each run may take, every run must finish well before the next one is due
+	timeout time.Duration
+	input   func(loc string) interface{}
+}
+
+// scheduleWorkflows starts the cron schedules on the task list of every location
+// The workflow ID is the same for every replica, so a schedule is only started once no matter how many workers run
+// A changed schedule only applies once the running workflows are terminated
+func scheduleWorkflows(cfg config.Config, scope tally.Scope, logger *zap.Logger, jobs ...scheduled) {
+	c, err := newClient(cfg, scope)
+	if err != nil {
+		logger.Error("Failed to create the client for the schedules", zap.Error(err))
+		return
+	}
+	for _, job := range jobs {
+		for _, loc := range append([]string{""}, cfg.Locations...) {
+			_, err := c.StartWorkflow(context.Background(), engine.StartOptions{
+				ID:               location.WorkflowID(loc, job.name),
+				TaskList:         location.TaskList(cfg.TaskList, loc),
+				CronSchedule:     job.schedule,
+				ExecutionTimeout: job.timeout,
+			}, job.workflow, job.input(loc))
+			switch {
+			case engine.IsAlreadyStarted(err):
+				logger.Debug("Workflow is already scheduled", zap.String("workflow", job.name), zap.String("location", loc))
+			case err != nil:
+				logger.Error("Failed to schedule workflow", zap.String("workflow", job.name), zap.String("location", loc), zap.Error(err))
+			default:
+				logger.Info("Scheduled workflow", zap.String("workflow", job.name), zap.String("location", loc), zap.String("schedule", job.schedule))
+			}
+		}
+	}
+}
+
+// scheduledWorkflows are the workflows with a schedule in the configuration
+func scheduledWorkflows(cfg config.Config) []scheduled {
+	var jobs []scheduled
+	if cfg.Canary.Schedule != "" {
+		jobs = append(jobs, scheduled{
+			name:     "canary",
+			workflow: registry.CanaryWorkflow,
+			schedule: cfg.Canary.Schedule,
+			timeout:  2 * time.Minute,
+			input:    func(loc string) interface{} { return canary.Canary{Location: loc} },
+		})
+	}
+	if cfg.StockMonitor.Schedule != "" {
+		jobs = append(jobs, scheduled{
+			name:     "stock-monitor",
+			workflow: registry.StockMonitorWorkflow,
+			schedule: cfg.StockMonitor.Schedule,
+			timeout:  5 * time.Minute,
+			input:    func(loc string) interface{} { return stock.Monitor{Location: loc} },
+		})
+	}
+	if cfg.Payroll.Schedule != "" {
+		jobs = append(jobs, scheduled{
+			name:     "payroll",
+			workflow: registry.PayrollWorkflow,
+			schedule: cfg.Payroll.Schedule,
+			timeout:  time.Hour,
+			input:    func(loc string) interface{} { return payroll.Run{Location: loc} },
+		})
+	}
+	return jobs
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/ops"
+	"programmingpercy/cadence-tavern/payloads"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/selftest"
+	"time"
+
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// selfTest runs the ping workflow on the self test task list of this worker and marks the readiness with the outcome
+// A failing self test leaves the worker running, it only stays not ready so whoever looks at /readyz can tell
+func selfTest(cfg config.Config, readiness *ops.Readiness, scope tally.Scope, logger *zap.Logger) {
+	taskList := selftest.TaskList(cfg.Identity.String(ClientName))
+	readiness.Set(false, "running self test on "+taskList)
+
+	c, err := newClient(cfg, scope)
+	if err == nil {
+		started := time.Now()
+		err = selftest.Run(context.Background(), c, taskList, cfg.SelfTest.Timeout, scope)
+		if err == nil {
+			logger.Info("Self test passed", zap.String("taskList", taskList), zap.Duration("took", time.Since(started)))
+			readiness.Set(true, "self test passed")
+			return
+		}
+	}
+	logger.Error("Self test failed", zap.String("taskList", taskList), zap.Error(err))
+	readiness.Set(false, "self test failed: "+err.Error())
+}
+
+// newClient creates the client the Worker starts its own workflows with, the self test and the canary
+// It encodes like the API does, so the ping also proves the worker decodes what it is sent
+func newClient(cfg config.Config, scope tally.Scope) (engine.Client, error) {
+	connection, err := newCadenceConnection(ClientName, cfg.CadenceHost, nil)
+	if err != nil {
+		return nil, err
+	}
+	dataConverter, err := payloads.NewDataConverter(cfg.PayloadEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the data converter: %v", err)
+	}
+	cadenceClient := client.NewClient(connection, cfg.Domain, &client.Options{
+		MetricsScope:  scope,
+		Identity:      cfg.Identity.String(ClientName),
+		DataConverter: dataConverter,
+		ContextPropagators: []workflow.ContextPropagator{
+			tracing.NewPropagator(),
+		},
+	})
+	return engine.NewClient(cadenceClient, connection, cfg.Domain), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"time"
+
+	"go.uber.org/cadence/.gen/go/cadence/workflowserviceclient"
+	"go.uber.org/cadence/worker"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+// shadow replays a sample of the recent histories of the domain against the workflows of this binary
+// It fails when a replay is not deterministic or a workflow type is no longer registered, histories that can not be
+// read are skipped
+func shadow(cfg config.Config, connection workflowserviceclient.Interface, replay worker.ReplayOptions, scope tally.Scope, logger *zap.Logger) error {
+	types := make([]string, 0, len(cfg.Shadow.WorkflowTypes))
+	for _, name := range cfg.Shadow.WorkflowTypes {
+		resolved, ok := registry.Resolve(name)
+		if !ok {
+			return fmt.Errorf("shadow workflow type %q is not registered", name)
+		}
+		types = append(types, resolved)
+	}
+
+	opts := worker.ShadowOptions{
+		WorkflowTypes:  types,
+		WorkflowStatus: cfg.Shadow.WorkflowStatus,
+		SamplingRate:   cfg.Shadow.SamplingRate,
+		ExitCondition: worker.ShadowExitCondition{
+			ExpirationInterval: cfg.Shadow.Timeout,
+			ShadowCount:        cfg.Shadow.Count,
+		},
+	}
+	if cfg.Shadow.StartedWithin > 0 {
+		now := time.Now()
+		opts.WorkflowStartTimeFilter = worker.TimeFilter{MinTimestamp: now.Add(-cfg.Shadow.StartedWithin), MaxTimestamp: now}
+	}
+
+	shadower, err := worker.NewWorkflowShadower(connection, cfg.Domain, opts, replay, logger)
+	if err != nil {
+		return fmt.Errorf("failed to create the workflow shadower: %v", err)
+	}
+
+	logger.Info("Shadowing workflows before polling",
+		zap.Strings("types", types),
+		zap.Float64("samplingRate", cfg.Shadow.SamplingRate),
+		zap.Int("count", cfg.Shadow.Count))
+	started := time.Now()
+	err = shadower.Run()
+	scope.Timer("shadow_latency").Record(time.Since(started))
+	if err != nil {
+		scope.Counter("shadow_failures").Inc(1)
+		return fmt.Errorf("shadowing found a change that breaks running workflows: %v", err)
+	}
+	logger.Info("Shadowing passed", zap.Duration("took", time.Since(started)))
+	return nil
+}
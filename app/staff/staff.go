@@ -0,0 +1,70 @@
+// Package staff keeps the employees of the taverns, the shifts they worked and what they were paid for them
+package staff
+
+import (
+	"context"
+	"programmingpercy/cadence-tavern/models"
+	"time"
+)
+
+// Database is the store of the employees and their shifts, the Worker replaces this during startup
+var Database Store = NewMemoryStore()
+
+// Employee is someone working at a location, shifts and payroll records refer to them by name
+type Employee struct {
+	Location string `json:"location,omitempty"`
+	Name     string `json:"name"`
+	// Email and Phone are where the payslips are sent, without either the employee is not notified
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+	// HourlyRate is what the employee is paid for a regular hour
+	HourlyRate models.Money `json:"hourlyRate"`
+}
+
+// Shift is a stretch of time an employee worked
+type Shift struct {
+	ID       string    `json:"id"`
+	Location string    `json:"location,omitempty"`
+	Employee string    `json:"employee"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
+
+// Hours is how long the shift was
+func (s Shift) Hours() float64 {
+	return s.End.Sub(s.Start).Hours()
+}
+
+// PayrollRecord is what an employee is paid for the shifts of a pay period
+type PayrollRecord struct {
+	Location string `json:"location,omitempty"`
+	Employee string `json:"employee"`
+	// PeriodStart and PeriodEnd are the pay period, shifts that started within it are paid
+	PeriodStart   time.Time `json:"periodStart"`
+	PeriodEnd     time.Time `json:"periodEnd"`
+	Shifts        int       `json:"shifts"`
+	RegularHours  float64   `json:"regularHours"`
+	OvertimeHours float64   `json:"overtimeHours"`
+	// HourlyRate is the rate of a regular hour the record was calculated with
+	HourlyRate models.Money `json:"hourlyRate"`
+	Regular    models.Money `json:"regular"`
+	Overtime   models.Money `json:"overtime"`
+	Gross      models.Money `json:"gross"`
+}
+
+// Store is the needed methods to keep the employees, shifts and payroll records
+// Every method is scoped to a location, each location has its own staff
+type Store interface {
+	// Employees returns the employees of the location sorted by name
+	Employees(ctx context.Context, location string) ([]Employee, error)
+	// SaveEmployee adds the employee or replaces the one with the same name
+	SaveEmployee(ctx context.Context, employee Employee) error
+	// AddShift records the shift, adding a shift with the same ID again replaces it
+	AddShift(ctx context.Context, shift Shift) error
+	// Shifts returns the shifts of the location that started from from until to, sorted by start
+	Shifts(ctx context.Context, location string, from, to time.Time) ([]Shift, error)
+	// SavePayroll keeps the record, saving the record of the same employee and period again replaces it
+	SavePayroll(ctx context.Context, record PayrollRecord) error
+	// Payroll returns the records of the location for the period that starts at start, sorted by employee
+	Payroll(ctx context.Context, location string, start time.Time) ([]PayrollRecord, error)
+}
@@ -0,0 +1,272 @@
+package staff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/migrations"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// Register the drivers used by the SQL backend
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// NewStore will create the store for the configured repository backend
+// The staff is kept next to the customers
+func NewStore(cfg config.Repository) (Store, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return NewSQLStore("sqlite3", cfg.DSN)
+	case "postgres":
+		return NewSQLStore("postgres", cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unknown staff store backend: %s", cfg.Backend)
+	}
+}
+
+// payrollKey identifies the record of an employee for a period
+type payrollKey struct {
+	location string
+	employee string
+	start    time.Time
+}
+
+// MemoryStore keeps the staff in Memory
+type MemoryStore struct {
+	sync.Mutex
+	employees map[string]map[string]Employee
+	shifts    map[string]Shift
+	payroll   map[payrollKey]PayrollRecord
+}
+
+// NewMemoryStore will init a new in memory staff store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		employees: make(map[string]map[string]Employee),
+		shifts:    make(map[string]Shift),
+		payroll:   make(map[payrollKey]PayrollRecord),
+	}
+}
+
+// Employees returns the employees of the location
+func (ms *MemoryStore) Employees(ctx context.Context, location string) ([]Employee, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	employees := make([]Employee, 0, len(ms.employees[location]))
+	for _, employee := range ms.employees[location] {
+		employees = append(employees, employee)
+	}
+	sort.Slice(employees, func(i, j int) bool { return employees[i].Name < employees[j].Name })
+	return employees, nil
+}
+
+// SaveEmployee keeps the employee
+func (ms *MemoryStore) SaveEmployee(ctx context.Context, employee Employee) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	employees, ok := ms.employees[employee.Location]
+	if !ok {
+		employees = make(map[string]Employee)
+		ms.employees[employee.Location] = employees
+	}
+	employees[employee.Name] = employee
+	return nil
+}
+
+// AddShift keeps the shift
+func (ms *MemoryStore) AddShift(ctx context.Context, shift Shift) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.shifts[shift.ID] = shift
+	return nil
+}
+
+// Shifts returns the shifts of the location that started within the period
+func (ms *MemoryStore) Shifts(ctx context.Context, location string, from, to time.Time) ([]Shift, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	var shifts []Shift
+	for _, shift := range ms.shifts {
+		if shift.Location == location && !shift.Start.Before(from) && shift.Start.Before(to) {
+			shifts = append(shifts, shift)
+		}
+	}
+	sort.Slice(shifts, func(i, j int) bool { return shifts[i].Start.Before(shifts[j].Start) })
+	return shifts, nil
+}
+
+// SavePayroll keeps the record
+func (ms *MemoryStore) SavePayroll(ctx context.Context, record PayrollRecord) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.payroll[payrollKey{location: record.Location, employee: record.Employee, start: record.PeriodStart.UTC()}] = record
+	return nil
+}
+
+// Payroll returns the records of the period
+func (ms *MemoryStore) Payroll(ctx context.Context, location string, start time.Time) ([]PayrollRecord, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	var records []PayrollRecord
+	for key, record := range ms.payroll {
+		if key.location == location && key.start.Equal(start) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Employee < records[j].Employee })
+	return records, nil
+}
+
+// SQLStore keeps the staff in SQL tables
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// NewSQLStore will open the database and migrate its schema
+func NewSQLStore(driver, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", driver, err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", driver, err)
+	}
+
+	if err := migrations.Up("staff", db, driver); err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db, driver: driver}, nil
+}
+
+// rebind converts $1 style placeholders into the style of the driver
+func (ss *SQLStore) rebind(query string) string {
+	if ss.driver == "postgres" {
+		return query
+	}
+	return strings.ReplaceAll(query, "$", "?")
+}
+
+// Employees selects the employees of the location
+func (ss *SQLStore) Employees(ctx context.Context, location string) ([]Employee, error) {
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(`SELECT location, name, email, phone, currency, hourly_rate FROM employees
+		WHERE location = $1 ORDER BY name`), location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read employees: %v", err)
+	}
+	defer rows.Close()
+
+	var employees []Employee
+	for rows.Next() {
+		var employee Employee
+		err := rows.Scan(&employee.Location, &employee.Name, &employee.Email, &employee.Phone, &employee.HourlyRate.Currency, &employee.HourlyRate.Minor)
+		if err != nil {
+			return nil, err
+		}
+		employees = append(employees, employee)
+	}
+	return employees, rows.Err()
+}
+
+// SaveEmployee upserts the employee
+func (ss *SQLStore) SaveEmployee(ctx context.Context, employee Employee) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`INSERT INTO employees (location, name, email, phone, currency, hourly_rate)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (location, name) DO UPDATE SET email = excluded.email, phone = excluded.phone,
+		currency = excluded.currency, hourly_rate = excluded.hourly_rate`),
+		employee.Location, employee.Name, employee.Email, employee.Phone, employee.HourlyRate.Currency, employee.HourlyRate.Minor)
+	if err != nil {
+		return fmt.Errorf("failed to save employee: %v", err)
+	}
+	return nil
+}
+
+// AddShift upserts the shift
+func (ss *SQLStore) AddShift(ctx context.Context, shift Shift) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`INSERT INTO shifts (id, location, employee, start_at, end_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET location = excluded.location, employee = excluded.employee,
+		start_at = excluded.start_at, end_at = excluded.end_at`),
+		shift.ID, shift.Location, shift.Employee, shift.Start.UTC(), shift.End.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to add shift: %v", err)
+	}
+	return nil
+}
+
+// Shifts selects the shifts of the location that started within the period
+func (ss *SQLStore) Shifts(ctx context.Context, location string, from, to time.Time) ([]Shift, error) {
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(`SELECT id, location, employee, start_at, end_at FROM shifts
+		WHERE location = $1 AND start_at >= $2 AND start_at < $3 ORDER BY start_at`), location, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shifts: %v", err)
+	}
+	defer rows.Close()
+
+	var shifts []Shift
+	for rows.Next() {
+		var shift Shift
+		if err := rows.Scan(&shift.ID, &shift.Location, &shift.Employee, &shift.Start, &shift.End); err != nil {
+			return nil, err
+		}
+		shifts = append(shifts, shift)
+	}
+	return shifts, rows.Err()
+}
+
+// SavePayroll upserts the record
+func (ss *SQLStore) SavePayroll(ctx context.Context, record PayrollRecord) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`INSERT INTO payroll_records (location, employee, period_start, period_end, shifts,
+		regular_hours, overtime_hours, currency, hourly_rate, regular, overtime, gross)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (location, employee, period_start) DO UPDATE SET period_end = excluded.period_end, shifts = excluded.shifts,
+		regular_hours = excluded.regular_hours, overtime_hours = excluded.overtime_hours, currency = excluded.currency,
+		hourly_rate = excluded.hourly_rate, regular = excluded.regular, overtime = excluded.overtime, gross = excluded.gross`),
+		record.Location, record.Employee, record.PeriodStart.UTC(), record.PeriodEnd.UTC(), record.Shifts,
+		record.RegularHours, record.OvertimeHours, record.Gross.Currency, record.HourlyRate.Minor,
+		record.Regular.Minor, record.Overtime.Minor, record.Gross.Minor)
+	if err != nil {
+		return fmt.Errorf("failed to save payroll record: %v", err)
+	}
+	return nil
+}
+
+// Payroll selects the records of the period
+func (ss *SQLStore) Payroll(ctx context.Context, location string, start time.Time) ([]PayrollRecord, error) {
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(`SELECT location, employee, period_start, period_end, shifts, regular_hours,
+		overtime_hours, currency, hourly_rate, regular, overtime, gross FROM payroll_records
+		WHERE location = $1 AND period_start = $2 ORDER BY employee`), location, start.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payroll records: %v", err)
+	}
+	defer rows.Close()
+
+	var records []PayrollRecord
+	for rows.Next() {
+		var record PayrollRecord
+		var currency string
+		err := rows.Scan(&record.Location, &record.Employee, &record.PeriodStart, &record.PeriodEnd, &record.Shifts, &record.RegularHours,
+			&record.OvertimeHours, &currency, &record.HourlyRate.Minor, &record.Regular.Minor, &record.Overtime.Minor, &record.Gross.Minor)
+		if err != nil {
+			return nil, err
+		}
+		record.HourlyRate.Currency = currency
+		record.Regular.Currency = currency
+		record.Overtime.Currency = currency
+		record.Gross.Currency = currency
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
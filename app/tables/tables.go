@@ -0,0 +1,178 @@
+package tables
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// Bad Solution for in mem during tutorial
+	// The Worker replaces this with the tables of the configuration
+	Database Repository = NewMemoryTables(nil)
+
+	// ErrNoFreeTable is returned when every table of the location is full
+	ErrNoFreeTable = errors.New("no free table")
+	// ErrNotSeated is returned when the customer is not seated at any table
+	ErrNotSeated = errors.New("customer is not seated")
+)
+
+// Table is a table in the Tavern that seats Capacity customers
+type Table struct {
+	ID       string `json:"id"`
+	Capacity int    `json:"capacity"`
+	// Location is the tavern the table stands in
+	Location string `json:"location,omitempty"`
+	// Seated are the names of the customers at the table
+	Seated []string `json:"seated"`
+}
+
+// Free is how many seats are left at the table
+func (t Table) Free() int {
+	return t.Capacity - len(t.Seated)
+}
+
+// Repository is the needed methods to be a table repo
+// Every method is scoped to a location, each location has its own tables
+type Repository interface {
+	// Assign seats the customer at a table with a free seat, customers that are already seated keep their table
+	Assign(location, customer string) (Table, error)
+	// Find returns the table the customer is seated at
+	Find(location, customer string) (Table, error)
+	// Release frees the seat of the customer and returns the table they left
+	Release(location, customer string) (Table, error)
+	// List returns all tables of the location
+	List(location string) ([]Table, error)
+}
+
+// ParseLayout reads tables written as id=capacity, such as 1=4
+func ParseLayout(raw []string) ([]Table, error) {
+	layout := make([]Table, 0, len(raw))
+	for _, r := range raw {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("table %q should be id=capacity", r)
+		}
+		capacity, err := strconv.Atoi(parts[1])
+		if err != nil || capacity <= 0 {
+			return nil, fmt.Errorf("table %q should have a positive capacity", r)
+		}
+		layout = append(layout, Table{ID: parts[0], Capacity: capacity})
+	}
+	return layout, nil
+}
+
+// MemoryTables is used to store the seating in Memory
+// Every location gets a copy of the same layout the first time it is used
+type MemoryTables struct {
+	mu     sync.Mutex
+	layout []Table
+	tables map[string][]Table
+}
+
+// NewMemoryTables will init a in memory storage with the layout used by every location
+func NewMemoryTables(layout []Table) *MemoryTables {
+	return &MemoryTables{
+		layout: layout,
+		tables: make(map[string][]Table),
+	}
+}
+
+// location returns the tables of the location, the caller holds mu
+func (mt *MemoryTables) location(location string) []Table {
+	tables, ok := mt.tables[location]
+	if !ok {
+		tables = make([]Table, len(mt.layout))
+		for i, table := range mt.layout {
+			table.Location = location
+			table.Seated = nil
+			tables[i] = table
+		}
+		mt.tables[location] = tables
+	}
+	return tables
+}
+
+// seatOf returns the index of the table the customer is seated at, or -1
+func seatOf(tables []Table, customer string) int {
+	for i, table := range tables {
+		for _, seated := range table.Seated {
+			if seated == customer {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Assign seats the customer at the first table in the layout with a free seat
+func (mt *MemoryTables) Assign(location, customer string) (Table, error) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	tables := mt.location(location)
+	if i := seatOf(tables, customer); i >= 0 {
+		return copyTable(tables[i]), nil
+	}
+	for i := range tables {
+		if tables[i].Free() > 0 {
+			tables[i].Seated = append(tables[i].Seated, customer)
+			return copyTable(tables[i]), nil
+		}
+	}
+	return Table{}, ErrNoFreeTable
+}
+
+// Find returns the table the customer is seated at
+func (mt *MemoryTables) Find(location, customer string) (Table, error) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	tables := mt.location(location)
+	i := seatOf(tables, customer)
+	if i < 0 {
+		return Table{}, fmt.Errorf("%w: %s", ErrNotSeated, customer)
+	}
+	return copyTable(tables[i]), nil
+}
+
+// Release frees the seat of the customer
+func (mt *MemoryTables) Release(location, customer string) (Table, error) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	tables := mt.location(location)
+	i := seatOf(tables, customer)
+	if i < 0 {
+		return Table{}, fmt.Errorf("%w: %s", ErrNotSeated, customer)
+	}
+	seated := tables[i].Seated[:0]
+	for _, name := range tables[i].Seated {
+		if name != customer {
+			seated = append(seated, name)
+		}
+	}
+	tables[i].Seated = seated
+	return copyTable(tables[i]), nil
+}
+
+// List returns all tables of the location in the order of the layout
+func (mt *MemoryTables) List(location string) ([]Table, error) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	tables := mt.location(location)
+	list := make([]Table, len(tables))
+	for i, table := range tables {
+		list[i] = copyTable(table)
+	}
+	return list, nil
+}
+
+// copyTable returns a table that does not share the seated slice with the repository
+func copyTable(table Table) Table {
+	table.Seated = append([]string(nil), table.Seated...)
+	return table
+}
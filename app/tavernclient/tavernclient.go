@@ -0,0 +1,217 @@
+// Package tavernclient is a typed client of the tavern HTTP API for other Go services
+package tavernclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"programmingpercy/cadence-tavern/apierror"
+	"programmingpercy/cadence-tavern/apitypes"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/greetings"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// Options configures a Client, zero values are replaced by the defaults
+type Options struct {
+	// Location is the tavern the requests are sent to, empty uses the default location of the API
+	Location string
+	// Timeout is how long a single attempt can take, a greeting waits for its workflow so it is generous
+	Timeout time.Duration
+	// Attempts is how many times a request is sent at most, including the first
+	Attempts int
+	// Backoff is the wait after the first failed attempt, it doubles on every failure
+	Backoff time.Duration
+	// HTTPClient is used to send the requests, http.DefaultClient is used when it is nil
+	HTTPClient *http.Client
+}
+
+// Client calls the tavern API
+// Network errors, 429 and 5xx responses are retried, other errors are returned right away
+type Client struct {
+	baseURL string
+	opts    Options
+}
+
+// Error is a response of the API that was not successful
+// Code is one of the codes of apierror, such as apierror.CodeNotFound
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    interface{}
+	// RequestID is the ID of the request in the logs of the API
+	RequestID string
+	// RetryAfter is how long the API asked to wait before trying again, it is set when orders are turned away
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tavern api responded %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// New creates a Client of the API at baseURL, such as http://localhost:8080
+func New(baseURL string, opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 15 * time.Second
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 3
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 200 * time.Millisecond
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), opts: opts}
+}
+
+// GreetCustomer welcomes the customer into the tavern, it opens their tab and seats them
+func (c *Client) GreetCustomer(ctx context.Context, visitor customer.Customer) (greetings.GreetingResult, error) {
+	var result greetings.GreetingResult
+	err := c.do(ctx, "GreetCustomer", http.MethodPost, "/greetings", visitor, &result)
+	return result, err
+}
+
+// PlaceOrder sends the order and returns its ID, the order is processed in the next round
+// The ID is picked before sending when it is empty, so a retried request can not place the order twice
+func (c *Client) PlaceOrder(ctx context.Context, order apitypes.Order) (string, error) {
+	if order.ID == "" {
+		order.ID = newOrderID()
+	}
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, "PlaceOrder", http.MethodPost, "/order", order, &result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// GetOrderStatus looks up the status and history of an order
+func (c *Client) GetOrderStatus(ctx context.Context, orderID string) (orderstore.Status, error) {
+	var status orderstore.Status
+	err := c.do(ctx, "GetOrderStatus", http.MethodGet, "/orders/"+url.PathEscape(orderID), nil, &status)
+	return status, err
+}
+
+// SettleTab asks the tab of the customer to be paid and closed, with the tip and split of the body
+func (c *Client) SettleTab(ctx context.Context, settle apitypes.SettleTab) error {
+	return c.do(ctx, "SettleTab", http.MethodPost, "/tab/settle", settle, nil)
+}
+
+// do sends the request with retries and decodes the response into out, out can be nil
+// Each call is a span, the trace is sent along in the headers so the API continues it
+func (c *Client) do(ctx context.Context, operation string, method string, path string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "tavernclient."+operation)
+	defer span.Finish()
+
+	wait := c.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := c.send(ctx, method, path, body, out)
+		if err == nil || !retry || attempt >= c.opts.Attempts {
+			if err != nil {
+				span.SetTag("error", true)
+				span.LogKV("error", err.Error())
+			}
+			return err
+		}
+
+		// The API knows better than the backoff how long it is going to be busy
+		if apiErr, ok := err.(*Error); ok && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// send sends the request once, retry is true when the failure is worth another attempt
+func (c *Client) send(ctx context.Context, method string, path string, body []byte, out interface{}) (retry bool, err error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(attemptCtx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.Location != "" {
+		req.Header.Set(location.Header, c.opts.Location)
+	}
+	tracing.InjectHTTP(ctx, req.Header)
+
+	res, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		// The attempt timing out is retried, the caller giving up is not
+		return ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return true, err
+	}
+	if res.StatusCode >= 300 {
+		var envelope apierror.Error
+		if err := json.Unmarshal(data, &envelope); err != nil || envelope.Code == "" {
+			// Proxies in front of the API answer without the envelope
+			envelope = apierror.Error{Code: apierror.CodeFor(res.StatusCode), Message: strings.TrimSpace(string(data))}
+		}
+		apiErr := &Error{
+			StatusCode: res.StatusCode,
+			Code:       envelope.Code,
+			Message:    envelope.Message,
+			Details:    envelope.Details,
+			RequestID:  envelope.RequestID,
+		}
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500, apiErr
+	}
+	if out == nil || len(data) == 0 {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return false, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return false, nil
+}
+
+// newOrderID creates a random order ID the same way the API does
+func newOrderID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+// Sampling is the sampler used by the jaeger tracer, it can be adjusted at runtime through ServeHTTP
+// The tracer replaces this during startup
+var Sampling = NewRuleSampler(1, nil)
+
+// Rule samples every operation containing Pattern at Rate, where 1 samples everything and 0 nothing
+type Rule struct {
+	Pattern string  `json:"pattern"`
+	Rate    float64 `json:"rate"`
+}
+
+// ParseRules reads rules written as pattern=rate, such as greetings=0.1
+func ParseRules(raw []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(raw))
+	for _, r := range raw {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("sampling rule %q should be pattern=rate", r)
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("sampling rule %q has a bad rate: %v", r, err)
+		}
+		rules = append(rules, Rule{Pattern: parts[0], Rate: rate})
+	}
+	return rules, validateRules(rules)
+}
+
+func validateRules(rules []Rule) error {
+	for _, rule := range rules {
+		if rule.Pattern == "" {
+			return errors.New("sampling rule is missing a pattern")
+		}
+		if rule.Rate < 0 || rule.Rate > 1 {
+			return fmt.Errorf("sampling rate of %s should be between 0 and 1", rule.Pattern)
+		}
+	}
+	return nil
+}
+
+// RuleSampler decides if a trace is sampled based on the operation of the root span
+// The first rule matching the operation is used, operations without a rule use the default rate
+type RuleSampler struct {
+	sync.RWMutex
+	defaultRate float64
+	rules       []Rule
+}
+
+// NewRuleSampler will create a sampler using rules in order
+func NewRuleSampler(defaultRate float64, rules []Rule) *RuleSampler {
+	return &RuleSampler{
+		defaultRate: defaultRate,
+		rules:       rules,
+	}
+}
+
+// Set replaces the rules of the sampler
+func (rs *RuleSampler) Set(defaultRate float64, rules []Rule) error {
+	if defaultRate < 0 || defaultRate > 1 {
+		return errors.New("default sampling rate should be between 0 and 1")
+	}
+	if err := validateRules(rules); err != nil {
+		return err
+	}
+	rs.Lock()
+	defer rs.Unlock()
+
+	rs.defaultRate = defaultRate
+	rs.rules = rules
+	return nil
+}
+
+// rate returns the sampling rate of the operation
+func (rs *RuleSampler) rate(operation string) float64 {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	for _, rule := range rs.rules {
+		if strings.Contains(operation, rule.Pattern) {
+			return rule.Rate
+		}
+	}
+	return rs.defaultRate
+}
+
+// IsSampled uses the random trace id to sample the operation at its rate
+func (rs *RuleSampler) IsSampled(id jaeger.TraceID, operation string) (bool, []jaeger.Tag) {
+	rate := rs.rate(operation)
+	// The top 53 bits of the id are a uniform number in [0, 1)
+	sampled := float64(id.Low>>11)/(1<<53) < rate
+	return sampled, []jaeger.Tag{
+		jaeger.NewTag("sampler.type", "rules"),
+		jaeger.NewTag("sampler.param", rate),
+	}
+}
+
+// Close has nothing to clean up
+func (rs *RuleSampler) Close() {}
+
+// Equal is only true for the same sampler since the rules can change at any time
+func (rs *RuleSampler) Equal(other jaeger.Sampler) bool {
+	return rs == other
+}
+
+// samplingConfig is the body used to read and change the sampler over HTTP
+type samplingConfig struct {
+	DefaultRate float64 `json:"defaultRate"`
+	Rules       []Rule  `json:"rules"`
+}
+
+// ServeHTTP shows the rules on GET and replaces them on PUT
+// It is meant to be registered on the ops listener
+func (rs *RuleSampler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body samplingConfig
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := rs.Set(body.DefaultRate, body.Rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rs.RLock()
+	current := samplingConfig{
+		DefaultRate: rs.defaultRate,
+		Rules:       rs.rules,
+	}
+	rs.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(current)
+}
+
+// ForceSample makes sure the span is reported no matter what the sampler decided
+// It is used for spans that are always interesting, such as failed orders
+func ForceSample(span opentracing.Span) {
+	ext.SamplingPriority.Set(span, 1)
+}
@@ -0,0 +1,210 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"programmingpercy/cadence-tavern/config"
+
+	"github.com/opentracing/opentracing-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+	"go.uber.org/cadence/workflow"
+)
+
+// traceHeader is the cadence header used to carry the trace between workflows and activities
+const traceHeader = "tavern-trace"
+
+// traceKey is the key the trace carrier is stored on in both context types
+type traceKey struct{}
+
+// Carrier is a serialized span context, it is a opentracing TextMap
+// It is carried inside signal payloads such as orders since signals have no headers
+type Carrier map[string]string
+
+// NewTracer will create the tracer from configuration
+// Type is jaeger or noop, the returned closer flushes the tracer on shutdown
+// The jaeger tracer samples using the configured rules, see Sampling
+func NewTracer(service string, cfg config.Tracing) (opentracing.Tracer, io.Closer, error) {
+	switch cfg.Type {
+	case "", "noop":
+		return opentracing.NoopTracer{}, nopCloser{}, nil
+	case "jaeger":
+		rules, err := ParseRules(cfg.SamplingRules)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := Sampling.Set(cfg.SampleRate, rules); err != nil {
+			return nil, nil, err
+		}
+		jcfg := jaegercfg.Configuration{
+			ServiceName: service,
+			Reporter: &jaegercfg.ReporterConfig{
+				LocalAgentHostPort: cfg.AgentHost,
+			},
+		}
+		tracer, closer, err := jcfg.NewTracer(jaegercfg.Sampler(Sampling))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create jaeger tracer: %v", err)
+		}
+		return tracer, closer, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown tracer: %s", cfg.Type)
+	}
+}
+
+// nopCloser is returned as closer for tracers that have nothing to flush
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// Inject serializes the span so it can be continued somewhere else
+func Inject(tracer opentracing.Tracer, span opentracing.Span) Carrier {
+	carrier := Carrier{}
+	if err := tracer.Inject(span.Context(), opentracing.TextMap, opentracing.TextMapCarrier(carrier)); err != nil {
+		return nil
+	}
+	return carrier
+}
+
+// WithTrace puts the carrier on the workflow context
+// All activities and child workflows scheduled with the returned context continue the trace
+func WithTrace(ctx workflow.Context, carrier Carrier) workflow.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return workflow.WithValue(ctx, traceKey{}, carrier)
+}
+
+// StartWorkflowSpan starts a span of the workflow as child of the carrier, activities and children scheduled with the
+// returned context become children of it. The span is started in a side effect, so a replay continues the same span
+// instead of starting another one. finish ends it, only the worker that started the span can, a span whose workflow
+// was replayed by another worker is never reported
+func StartWorkflowSpan(ctx workflow.Context, operation string, carrier Carrier, tags opentracing.Tags) (workflow.Context, func()) {
+	var span opentracing.Span
+	encoded := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		tracer := opentracing.GlobalTracer()
+		opts := []opentracing.StartSpanOption{tags}
+		if parent, err := tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier(carrier)); err == nil {
+			opts = append(opts, opentracing.ChildOf(parent))
+		}
+		span = tracer.StartSpan(operation, opts...)
+		return Inject(tracer, span)
+	})
+	var started Carrier
+	if err := encoded.Get(&started); err != nil || len(started) == 0 {
+		started = carrier
+	}
+	return WithTrace(ctx, started), func() {
+		if span != nil {
+			span.Finish()
+		}
+	}
+}
+
+// StartActivitySpan starts a span as child of the trace carried in the activity context
+// If there is no trace a new root span is started
+func StartActivitySpan(ctx context.Context, operation string, tags opentracing.Tags) opentracing.Span {
+	tracer := opentracing.GlobalTracer()
+	opts := []opentracing.StartSpanOption{tags}
+	if carrier, ok := ctx.Value(traceKey{}).(Carrier); ok {
+		if parent, err := tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier(carrier)); err == nil {
+			opts = append(opts, opentracing.ChildOf(parent))
+		}
+	}
+	return tracer.StartSpan(operation, opts...)
+}
+
+// InjectHTTP writes the span of ctx into the headers of a HTTP request, nothing is written when ctx has no span
+func InjectHTTP(ctx context.Context, header http.Header) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+}
+
+// StartHTTPSpan starts a span as child of the trace in the headers of the request
+// If there is no trace a new root span is started
+func StartHTTPSpan(r *http.Request, operation string, tags opentracing.Tags) opentracing.Span {
+	tracer := opentracing.GlobalTracer()
+	opts := []opentracing.StartSpanOption{tags}
+	if parent, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header)); err == nil {
+		opts = append(opts, opentracing.ChildOf(parent))
+	}
+	return tracer.StartSpan(operation, opts...)
+}
+
+// Propagator moves the trace carrier through cadence headers
+// It should be set in ContextPropagators of both worker.Options and client.Options
+type Propagator struct{}
+
+// NewPropagator creates the trace propagator
+func NewPropagator() workflow.ContextPropagator {
+	return &Propagator{}
+}
+
+// Inject writes the carrier of a go context into the headers
+func (p *Propagator) Inject(ctx context.Context, writer workflow.HeaderWriter) error {
+	carrier, ok := ctx.Value(traceKey{}).(Carrier)
+	if !ok {
+		return nil
+	}
+	return writeCarrier(carrier, writer)
+}
+
+// Extract reads the carrier from the headers into the go context used by activities
+func (p *Propagator) Extract(ctx context.Context, reader workflow.HeaderReader) (context.Context, error) {
+	carrier, err := readCarrier(reader)
+	if err != nil || carrier == nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, traceKey{}, carrier), nil
+}
+
+// InjectFromWorkflow writes the carrier of a workflow context into the headers
+func (p *Propagator) InjectFromWorkflow(ctx workflow.Context, writer workflow.HeaderWriter) error {
+	carrier, ok := ctx.Value(traceKey{}).(Carrier)
+	if !ok {
+		return nil
+	}
+	return writeCarrier(carrier, writer)
+}
+
+// ExtractToWorkflow reads the carrier from the headers into the workflow context
+func (p *Propagator) ExtractToWorkflow(ctx workflow.Context, reader workflow.HeaderReader) (workflow.Context, error) {
+	carrier, err := readCarrier(reader)
+	if err != nil || carrier == nil {
+		return ctx, err
+	}
+	return workflow.WithValue(ctx, traceKey{}, carrier), nil
+}
+
+// WithContext puts the carrier on a go context, used by clients starting workflows
+func WithContext(ctx context.Context, carrier Carrier) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey{}, carrier)
+}
+
+func writeCarrier(carrier Carrier, writer workflow.HeaderWriter) error {
+	data, err := json.Marshal(carrier)
+	if err != nil {
+		return err
+	}
+	writer.Set(traceHeader, data)
+	return nil
+}
+
+func readCarrier(reader workflow.HeaderReader) (Carrier, error) {
+	var carrier Carrier
+	err := reader.ForEachKey(func(key string, value []byte) error {
+		if key != traceHeader {
+			return nil
+		}
+		return json.Unmarshal(value, &carrier)
+	})
+	return carrier, err
+}
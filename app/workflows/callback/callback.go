@@ -0,0 +1,266 @@
+// Package callback pushes the results of orders and greetings to the callbackUrl their caller gave
+// Every delivery is a workflow of its own, so it is retried without holding up the workflow that produced the result
+package callback
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignatureHeader carries the signature of a delivery, t=<unix seconds>,v1=<hex hmac-sha256 of t.body>
+	SignatureHeader = "X-Tavern-Signature"
+	// DeliveryHeader is the ID of the delivery, it is the same on every retry so receivers can drop duplicates
+	DeliveryHeader = "X-Tavern-Delivery"
+
+	// ErrReasonRejected is a callback the receiver refused with a 4xx, it is not retried
+	ErrReasonRejected = "callback-rejected"
+)
+
+// The types of the results that are delivered
+const (
+	TypeOrder    = "order"
+	TypeGreeting = "greeting"
+)
+
+// Sender delivers the callbacks of the Worker, the Worker replaces this during startup
+var Sender = NewSender(config.Callbacks{Timeout: 10 * time.Second})
+
+// Payload is the body POSTed to the callback URL
+type Payload struct {
+	Type string `json:"type"`
+	// ID is the order ID or the name of the greeted customer
+	ID string `json:"id"`
+	// Status is completed or failed
+	Status string `json:"status"`
+	// Result is what the workflow returned, such as the greeting
+	Result interface{} `json:"result,omitempty"`
+	// Error is why the workflow failed
+	Error      string    `json:"error,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func init() {
+	registry.Workflow(workflowDeliver)
+	registry.Activity("tavern.callback.deliver", activityDeliver)
+}
+
+// Validate checks that the callback URL is absolute http or https, and on a allowed host when there are any
+// Without allowed hosts every host is allowed but the ones of the tavern itself, loopback, link-local and private
+// addresses could otherwise be reached through the Worker
+func Validate(rawURL string, allowedHosts []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("callbackUrl is not a URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("callbackUrl must be an absolute http or https URL")
+	}
+	if len(allowedHosts) == 0 {
+		host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return fmt.Errorf("callbackUrl host %s is not allowed", u.Hostname())
+		}
+		if ip := net.ParseIP(host); ip != nil && internalIP(ip) {
+			return fmt.Errorf("callbackUrl address %s is not allowed", ip)
+		}
+		return nil
+	}
+	for _, host := range allowedHosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("callbackUrl host %s is not allowed", u.Hostname())
+}
+
+// internalIP is true for the addresses callbacks are never sent to without allowed hosts
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+// refuseInternal is the dialer control of the deliveries without allowed hosts
+// Validate only sees the host of the URL, a name can still resolve to an internal address when it is delivered
+func refuseInternal(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+		return fmt.Errorf("callback address %s is not allowed", host)
+	}
+	return nil
+}
+
+// Sign is the value of the SignatureHeader for the body sent at timestamp
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	ts := strconv.FormatInt(timestamp, 10)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac(secret, ts, body))
+}
+
+// Verify checks the SignatureHeader of a delivery, it is used by receivers written in Go
+// Deliveries signed longer than tolerance ago are refused so a captured request can not be replayed later
+func Verify(secret []byte, header string, body []byte, tolerance time.Duration) error {
+	var ts, signature string
+	for _, part := range strings.Split(header, ",") {
+		if strings.HasPrefix(part, "t=") {
+			ts = strings.TrimPrefix(part, "t=")
+		}
+		if strings.HasPrefix(part, "v1=") {
+			signature = strings.TrimPrefix(part, "v1=")
+		}
+	}
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("signature has no timestamp")
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return errors.New("signature is too old")
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, mac(secret, ts, body)) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+func mac(secret []byte, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts + "."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// HTTPSender POSTs signed payloads
+type HTTPSender struct {
+	secret       []byte
+	allowedHosts []string
+	client       *http.Client
+}
+
+// NewSender creates the sender from configuration, without a secret the deliveries are not signed
+func NewSender(cfg config.Callbacks) *HTTPSender {
+	return &HTTPSender{
+		secret:       []byte(cfg.Secret),
+		allowedHosts: cfg.AllowedHosts,
+		client:       newClient(cfg),
+	}
+}
+
+// newClient is the client the deliveries are sent with
+// Without allowed hosts it refuses to connect to internal addresses, the redirects of a receiver included. It does
+// not use a proxy then, the address the proxy connects to could not be checked
+func newClient(cfg config.Callbacks) *http.Client {
+	if len(cfg.AllowedHosts) > 0 {
+		return &http.Client{Timeout: cfg.Timeout}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   refuseInternal,
+	}).DialContext
+	return &http.Client{Timeout: cfg.Timeout, Transport: transport}
+}
+
+// Send POSTs the payload once, 4xx responses other than 408 and 429 are returned as ErrReasonRejected
+func (hs *HTTPSender) Send(ctx context.Context, deliveryID string, callbackURL string, payload Payload) error {
+	if err := Validate(callbackURL, hs.allowedHosts); err != nil {
+		return cadence.NewCustomError(ErrReasonRejected, err.Error())
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, deliveryID)
+	if len(hs.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(hs.secret, time.Now().Unix(), body))
+	}
+
+	res, err := hs.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver callback: %v", err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode < 300:
+		return nil
+	case res.StatusCode < 500 && res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests:
+		return cadence.NewCustomError(ErrReasonRejected, fmt.Sprintf("callback responded with %d", res.StatusCode))
+	default:
+		return fmt.Errorf("callback responded with %d", res.StatusCode)
+	}
+}
+
+// deliveryRetryPolicy keeps trying a receiver that is down for an hour
+var deliveryRetryPolicy = cadence.RetryPolicy{
+	InitialInterval:          time.Second,
+	BackoffCoefficient:       2,
+	MaximumInterval:          5 * time.Minute,
+	ExpirationInterval:       time.Hour,
+	NonRetriableErrorReasons: []string{ErrReasonRejected},
+}
+
+// Deliver starts the delivery of the payload as a child workflow with the ID id, it returns once the child is started
+// The child is abandoned, so the delivery carries on after the calling workflow closes
+func Deliver(ctx workflow.Context, id string, callbackURL string, payload Payload) error {
+	ctx = workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:                   id,
+		ExecutionStartToCloseTimeout: 2 * deliveryRetryPolicy.ExpirationInterval,
+		ParentClosePolicy:            client.ParentClosePolicyAbandon,
+	})
+	return workflow.ExecuteChildWorkflow(ctx, workflowDeliver, callbackURL, payload).GetChildWorkflowExecution().Get(ctx, nil)
+}
+
+// workflowDeliver delivers the payload to the callback URL, retrying until it is accepted or the retries run out
+// The API starts it by itself for greetings, since their result is only known to the API
+func workflowDeliver(ctx workflow.Context, callbackURL string, payload Payload) error {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    time.Minute,
+		RetryPolicy:            &deliveryRetryPolicy,
+	})
+	err := workflow.ExecuteActivity(ctx, activityDeliver, callbackURL, payload).Get(ctx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Callback was not delivered", zap.String("type", payload.Type), zap.String("id", payload.ID), zap.Error(err))
+	}
+	return err
+}
+
+// activityDeliver POSTs the payload once, the workflow ID is the delivery ID
+func activityDeliver(ctx context.Context, callbackURL string, payload Payload) error {
+	info := activity.GetInfo(ctx)
+	activity.GetLogger(ctx).Info("Delivering callback", zap.String("type", payload.Type), zap.String("id", payload.ID),
+		zap.Int32("attempt", info.Attempt))
+	return Sender.Send(ctx, info.WorkflowExecution.ID, callbackURL, payload)
+}
@@ -0,0 +1,83 @@
+package callback
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"programmingpercy/cadence-tavern/config"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidateWithoutAllowedHosts(t *testing.T) {
+	for _, rawURL := range []string{
+		"http://localhost:8080/done",
+		"http://api.localhost/done",
+		"http://127.0.0.1/done",
+		"http://[::1]/done",
+		"http://10.0.0.5/done",
+		"http://172.16.3.4/done",
+		"http://192.168.1.1/done",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]/done",
+		"http://0.0.0.0/done",
+	} {
+		if err := Validate(rawURL, nil); err == nil {
+			t.Errorf("%s was allowed without allowed hosts, the Worker would call itself", rawURL)
+		}
+	}
+	for _, rawURL := range []string{"https://example.com/orders", "http://93.184.216.34/orders"} {
+		if err := Validate(rawURL, nil); err != nil {
+			t.Errorf("%s was refused: %v", rawURL, err)
+		}
+	}
+}
+
+func TestValidateWithAllowedHosts(t *testing.T) {
+	allowed := []string{"hooks.example.com", "10.0.0.5"}
+	for _, rawURL := range []string{"https://hooks.example.com/orders", "https://HOOKS.example.com/orders", "http://10.0.0.5/done"} {
+		if err := Validate(rawURL, allowed); err != nil {
+			t.Errorf("%s was refused: %v", rawURL, err)
+		}
+	}
+	for _, rawURL := range []string{"https://example.com/orders", "http://127.0.0.1/done", "ftp://hooks.example.com/orders"} {
+		if err := Validate(rawURL, allowed); err == nil {
+			t.Errorf("%s was allowed, only %v are", rawURL, allowed)
+		}
+	}
+}
+
+// TestDeliveryRefusesInternalAddresses checks the addresses the names of callback URLs resolve to
+// Validate only sees the host of the URL, a public name can still resolve to an internal address
+func TestDeliveryRefusesInternalAddresses(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "10.1.2.3:80", "169.254.169.254:80"} {
+		if err := refuseInternal("tcp", address, nil); err == nil {
+			t.Errorf("dialing %s was allowed", address)
+		}
+	}
+	if err := refuseInternal("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("dialing a public address was refused: %v", err)
+	}
+
+	var reached int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reached, 1)
+	}))
+	defer server.Close()
+
+	// Without allowed hosts the client does not connect to the loopback receiver
+	res, err := NewSender(config.Callbacks{Timeout: time.Second}).client.Get(server.URL)
+	if err == nil {
+		res.Body.Close()
+		t.Fatal("connected to the loopback receiver without allowed hosts")
+	}
+	// An allowed host is trusted, the deliveries to it are sent
+	sender := NewSender(config.Callbacks{Timeout: time.Second, AllowedHosts: []string{"127.0.0.1"}})
+	if err := sender.Send(context.Background(), "delivery-1", server.URL, Payload{Type: TypeOrder}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&reached); n != 1 {
+		t.Fatalf("receiver was reached %d times, want only the delivery to the allowed host", n)
+	}
+}
@@ -0,0 +1,149 @@
+// Package canary is the periodic check that the dependencies of a Worker are healthy
+// The Worker starts the canary workflow on a cron schedule for every location, each run exercises the kinds of
+// activities the tavern has, reading and writing the customer repository and rendering a notification into a dry run
+// sink, and reports the outcome of every check as metrics so an alert can fire before a customer notices
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/workflows/notify"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"strings"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// The metrics of the canary, tagged with the check and its outcome
+const (
+	MetricChecks  = "canary_checks"
+	MetricLatency = "canary_check_latency"
+)
+
+// The checks of a canary run
+const (
+	CheckReadRepository  = "readRepository"
+	CheckWriteRepository = "writeRepository"
+	CheckNotify          = "notify"
+)
+
+// CustomerName is the customer the canary reads and writes, it is deleted again at the end of every write check
+const CustomerName = "tavern-canary"
+
+func init() {
+	registry.Workflow(WorkflowCanary)
+
+	registry.Activity("tavern.canary.readRepository", activityReadRepository)
+	registry.Activity("tavern.canary.writeRepository", activityWriteRepository)
+	registry.Activity("tavern.canary.notify", activityNotify)
+}
+
+// Canary is the input of the canary workflow
+type Canary struct {
+	// Location is the tavern whose partition of the repository is checked
+	Location string `json:"location"`
+}
+
+// Check is the outcome of one check
+type Check struct {
+	Name    string        `json:"name"`
+	OK      bool          `json:"ok"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+// Report is the result of the canary workflow
+type Report struct {
+	Checks []Check `json:"checks"`
+}
+
+// WorkflowCanary runs every check, one failing does not skip the others
+// It fails when any of the checks failed, so the failed runs of the cron schedule stand out in the history as well
+func WorkflowCanary(ctx workflow.Context, canary Canary) (Report, error) {
+	// A canary is only useful when it finishes quickly, so there are no retries hiding a slow or failing dependency
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: 30 * time.Second,
+		StartToCloseTimeout:    10 * time.Second,
+		RetryPolicy: &cadence.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 1,
+			MaximumAttempts:    1,
+		},
+	})
+
+	checks := []struct {
+		name     string
+		activity interface{}
+	}{
+		{CheckReadRepository, activityReadRepository},
+		{CheckWriteRepository, activityWriteRepository},
+		{CheckNotify, activityNotify},
+	}
+	var report Report
+	var failed []string
+	for _, c := range checks {
+		started := workflow.Now(ctx)
+		err := workflow.ExecuteActivity(ctx, c.activity, canary).Get(ctx, nil)
+		check := Check{Name: c.name, OK: err == nil, Latency: workflow.Now(ctx).Sub(started)}
+
+		outcome := "ok"
+		if err != nil {
+			outcome = "failed"
+			check.Error = err.Error()
+			failed = append(failed, c.name)
+			workflow.GetLogger(ctx).Error("Canary check failed", zap.String("check", c.name), zap.Error(err))
+		}
+		scope := workflow.GetMetricsScope(ctx).Tagged(map[string]string{"check": c.name, "outcome": outcome})
+		scope.Counter(MetricChecks).Inc(1)
+		scope.Timer(MetricLatency).Record(check.Latency)
+		report.Checks = append(report.Checks, check)
+	}
+	if len(failed) > 0 {
+		return report, fmt.Errorf("canary checks failed: %s", strings.Join(failed, ", "))
+	}
+	return report, nil
+}
+
+// activityReadRepository reads the canary customer, not finding it is fine since it only exists during a write check
+func activityReadRepository(ctx context.Context, canary Canary) error {
+	_, err := customer.Database.Get(ctx, canary.Location, CustomerName)
+	if err != nil && !errors.Is(err, customer.ErrNoSuchCustomer) {
+		return err
+	}
+	return nil
+}
+
+// activityWriteRepository stores the canary customer, reads it back and deletes it
+func activityWriteRepository(ctx context.Context, canary Canary) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := customer.Database.Update(ctx, customer.Customer{Name: CustomerName, Location: canary.Location, LastVisit: now}); err != nil {
+		return fmt.Errorf("failed to store the canary: %v", err)
+	}
+	stored, err := customer.Database.Get(ctx, canary.Location, CustomerName)
+	if err != nil {
+		return fmt.Errorf("failed to read the canary back: %v", err)
+	}
+	if !stored.LastVisit.Equal(now) {
+		return errors.New("the canary read back is not the one stored")
+	}
+	if err := customer.Database.Delete(ctx, canary.Location, CustomerName); err != nil {
+		return fmt.Errorf("failed to delete the canary: %v", err)
+	}
+	return nil
+}
+
+// activityNotify renders an ops alert and sends it to a dry run sink, nothing is delivered to anyone
+func activityNotify(ctx context.Context, canary Canary) error {
+	msg, err := notify.Render(notify.TemplateOpsAlert, notify.OpsRecipient, map[string]interface{}{
+		"Reason": "canary check for location " + canary.Location,
+	})
+	if err != nil {
+		return err
+	}
+	return notify.NewDryRunSink().Send(ctx, msg)
+}
@@ -0,0 +1,162 @@
+// Package decisions alerts on decision tasks the Worker fails, such as a workflow that panics or no longer replays
+// Cadence retries those tasks quietly, they only show up in the server and worker logs, so the logger of the Worker
+// is watched for them. Each one is counted and sent to the alert notifier, a workflow run is alerted once per cooldown
+package decisions
+
+import (
+	"context"
+	"programmingpercy/cadence-tavern/workflows/notify"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The kinds of failures, they are the kind tag of the metric
+const (
+	KindFailed           = "failed"
+	KindNonDeterministic = "non-deterministic"
+)
+
+// MetricDecisionFailures is the critical metric counted for every failed decision task, tagged with the kind
+// and the workflow type
+const MetricDecisionFailures = "decision_task_failures"
+
+// The messages Cadence logs the failures with
+const (
+	messageFailed           = "Failed to process decision task."
+	messageNonDeterministic = "non-deterministic-error"
+)
+
+// Failure is a decision task the Worker failed
+type Failure struct {
+	Kind         string
+	WorkflowType string
+	WorkflowID   string
+	RunID        string
+	Error        string
+}
+
+// Watcher counts and alerts the failures it is shown
+type Watcher struct {
+	scope    tally.Scope
+	alerts   notify.Notifier
+	cooldown time.Duration
+	// logger is the logger the watcher was attached to, failing alerts are logged on it so they are not watched
+	logger *zap.Logger
+
+	mu sync.Mutex
+	// alerted is when each workflow run was last alerted
+	alerted map[string]time.Time
+}
+
+// NewWatcher reports failures on the scope and sends them to alerts, every run at most once per cooldown
+func NewWatcher(scope tally.Scope, alerts notify.Notifier, cooldown time.Duration) *Watcher {
+	return &Watcher{
+		scope:    scope,
+		alerts:   alerts,
+		cooldown: cooldown,
+		alerted:  make(map[string]time.Time),
+		logger:   zap.NewNop(),
+	}
+}
+
+// Logger returns logger with the watcher attached, it is used as the Logger of the worker options
+func (wa *Watcher) Logger(logger *zap.Logger) *zap.Logger {
+	wa.logger = logger
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, &watchCore{watcher: wa})
+	}))
+}
+
+// Report counts the failure and alerts it unless the run was alerted within the cooldown
+func (wa *Watcher) Report(failure Failure) {
+	wa.scope.Tagged(map[string]string{"kind": failure.Kind, "WorkflowType": failure.WorkflowType}).
+		Counter(MetricDecisionFailures).Inc(1)
+
+	now := time.Now()
+	key := failure.WorkflowID + "/" + failure.RunID
+	wa.mu.Lock()
+	if last, ok := wa.alerted[key]; ok && now.Sub(last) < wa.cooldown {
+		wa.mu.Unlock()
+		return
+	}
+	wa.alerted[key] = now
+	for other, last := range wa.alerted {
+		if now.Sub(last) >= wa.cooldown {
+			delete(wa.alerted, other)
+		}
+	}
+	wa.mu.Unlock()
+
+	// The failure is found while cadence logs it, the alert is sent without holding up the decision task
+	go wa.send(failure)
+}
+
+// send delivers the alert of the failure
+func (wa *Watcher) send(failure Failure) {
+	msg, err := notify.Render(notify.TemplateOpsAlert, notify.OpsRecipient, map[string]interface{}{
+		"Reason":     "decision task " + failure.Kind + " for " + failure.WorkflowType + ": " + failure.Error,
+		"WorkflowID": failure.WorkflowID,
+	})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := wa.alerts.Send(ctx, msg); err != nil {
+		wa.logger.Warn("Failed to send decision task alert", zap.String("workflow", failure.WorkflowID), zap.Error(err))
+	}
+}
+
+// watchCore is a zap core that only looks at the entries for decision task failures, it writes nothing
+type watchCore struct {
+	watcher *Watcher
+	fields  []zapcore.Field
+}
+
+// Enabled is true for warnings and errors, cadence logs the failures at those levels
+func (wc *watchCore) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.WarnLevel
+}
+
+func (wc *watchCore) With(fields []zapcore.Field) zapcore.Core {
+	return &watchCore{watcher: wc.watcher, fields: append(append([]zapcore.Field{}, wc.fields...), fields...)}
+}
+
+func (wc *watchCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if wc.Enabled(entry.Level) && (entry.Message == messageFailed || entry.Message == messageNonDeterministic) {
+		return checked.AddCore(entry, wc)
+	}
+	return checked
+}
+
+func (wc *watchCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range append(append([]zapcore.Field{}, wc.fields...), fields...) {
+		field.AddTo(enc)
+	}
+	value := func(key string) string {
+		s, _ := enc.Fields[key].(string)
+		return s
+	}
+
+	kind := KindFailed
+	if entry.Message == messageNonDeterministic {
+		kind = KindNonDeterministic
+	}
+	wc.watcher.Report(Failure{
+		Kind:         kind,
+		WorkflowType: value("WorkflowType"),
+		WorkflowID:   value("WorkflowID"),
+		RunID:        value("RunID"),
+		Error:        value("error"),
+	})
+	return nil
+}
+
+func (wc *watchCore) Sync() error {
+	return nil
+}
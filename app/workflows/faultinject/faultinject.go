@@ -0,0 +1,145 @@
+// Package faultinject delays or fails activities on purpose, at the rates configured for them
+// It is meant for showing retries, timeouts and the compensation of the order saga in the tutorial and for resilience
+// testing, the Worker only wires it into the activities when fault injection is enabled in its config
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence/activity"
+	"go.uber.org/zap"
+)
+
+// The kinds of faults
+const (
+	// Delay holds the activity for the delay of the rule before it runs, or until its context is done
+	Delay = "delay"
+	// Fail returns ErrInjected instead of running the activity, it is retried like any other failure
+	Fail = "fail"
+)
+
+// MetricFaultsInjected is counted for every injected fault, tagged with the activity and the kind
+const MetricFaultsInjected = "faults_injected"
+
+// ErrInjected is the error of the failures that are injected
+var ErrInjected = errors.New("fault injected")
+
+// Rule is a fault injected into the activities matching Activity
+type Rule struct {
+	// Activity is the registered name of an activity, a trailing * matches every name with the prefix
+	Activity string
+	Kind     string
+	// Rate is the chance of injecting the fault into one execution, between 0 and 1
+	Rate float64
+	// Delay is how long a delay fault holds the activity
+	Delay time.Duration
+}
+
+var (
+	rules   []Rule
+	rulesMu sync.RWMutex
+
+	// random decides which executions get a fault
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomMu sync.Mutex
+)
+
+// Set replaces the rules while activities might be reading them, used when the configuration is reloaded
+func Set(r []Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules = r
+}
+
+// Parse reads activity=kind:rate settings, delays also take the duration such as tavern.payments.charge=delay:0.5:3s
+// or tavern.orders.*=fail:0.1
+func Parse(settings []string) ([]Rule, error) {
+	parsed := make([]Rule, 0, len(settings))
+	for _, setting := range settings {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("fault %q should be activity=kind:rate", setting)
+		}
+		rule := Rule{Activity: strings.TrimSpace(parts[0])}
+
+		fields := strings.Split(strings.TrimSpace(parts[1]), ":")
+		rule.Kind = fields[0]
+		switch {
+		case rule.Kind == Fail && len(fields) == 2:
+		case rule.Kind == Delay && len(fields) == 3:
+			d, err := time.ParseDuration(fields[2])
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("fault %s: delay must be a positive duration", setting)
+			}
+			rule.Delay = d
+		case rule.Kind == Fail || rule.Kind == Delay:
+			return nil, fmt.Errorf("fault %q should be activity=fail:rate or activity=delay:rate:duration", setting)
+		default:
+			return nil, fmt.Errorf("fault %s: unknown kind %s", setting, rule.Kind)
+		}
+
+		rate, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("fault %s: rate must be a number between 0 and 1", setting)
+		}
+		rule.Rate = rate
+		parsed = append(parsed, rule)
+	}
+	return parsed, nil
+}
+
+// matches is true when the rule applies to the activity
+func (ru Rule) matches(name string) bool {
+	if strings.HasSuffix(ru.Activity, "*") {
+		return strings.HasPrefix(name, strings.TrimSuffix(ru.Activity, "*"))
+	}
+	return ru.Activity == name
+}
+
+// Inject runs the faults of the rules matching the activity, it is called before the activity runs
+// Delays are applied first, an error means the activity must fail with it instead of running
+func Inject(ctx context.Context, name string) error {
+	rulesMu.RLock()
+	current := rules
+	rulesMu.RUnlock()
+
+	for _, rule := range current {
+		if !rule.matches(name) || !roll(rule.Rate) {
+			continue
+		}
+		activity.GetMetricsScope(ctx).Tagged(map[string]string{"activity": name, "kind": rule.Kind}).
+			Counter(MetricFaultsInjected).Inc(1)
+		activity.GetLogger(ctx).Warn("Injecting fault", zap.String("activity", name), zap.String("kind", rule.Kind))
+
+		switch rule.Kind {
+		case Delay:
+			timer := time.NewTimer(rule.Delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		case Fail:
+			return ErrInjected
+		}
+	}
+	return nil
+}
+
+// roll is true with the chance of rate
+func roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	return random.Float64() < rate
+}
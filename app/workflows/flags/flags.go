@@ -0,0 +1,123 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence/workflow"
+)
+
+var (
+	// Source is where the flags are read from, the Worker replaces this during startup
+	// Use SetSource to replace it once the Worker is running
+	Source Provider = StaticProvider{Flags: Flags{EnforceAgeCheck: true}}
+
+	sourceMu sync.RWMutex
+)
+
+// SetSource replaces the Source while activities might be reading it, used when the configuration is reloaded
+func SetSource(p Provider) {
+	sourceMu.Lock()
+	defer sourceMu.Unlock()
+	Source = p
+}
+
+// Flags are toggles that change workflow behavior without redeploying workflows
+type Flags struct {
+	// EnforceAgeCheck makes orders verify the age of the customer
+	EnforceAgeCheck bool `json:"enforceAgeCheck"`
+	// HappyHour halves the price of all orders
+	HappyHour bool `json:"happyHour"`
+	// RequirePayment makes orders charge the customer before being served
+	RequirePayment bool `json:"requirePayment"`
+}
+
+// Provider is the needed methods to be a source of feature flags
+type Provider interface {
+	Fetch(ctx context.Context) (Flags, error)
+}
+
+// NewProvider will pick the provider based on configuration
+// If a flag service URL is configured the flags are fetched from it, otherwise the configured values are used
+func NewProvider(cfg config.Flags) Provider {
+	static := StaticProvider{
+		Flags: Flags{
+			EnforceAgeCheck: cfg.EnforceAgeCheck,
+			HappyHour:       cfg.HappyHour,
+			RequirePayment:  cfg.RequirePayment,
+		},
+	}
+	if cfg.ServiceURL == "" {
+		return static
+	}
+	return &HTTPProvider{
+		URL:    cfg.ServiceURL,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// StaticProvider always returns the same flags
+type StaticProvider struct {
+	Flags Flags
+}
+
+// Fetch returns the static flags
+func (sp StaticProvider) Fetch(ctx context.Context) (Flags, error) {
+	return sp.Flags, nil
+}
+
+// HTTPProvider fetches the flags as JSON from a flag service
+type HTTPProvider struct {
+	URL    string
+	Client *http.Client
+}
+
+// Fetch will GET the flags from the flag service
+func (hp *HTTPProvider) Fetch(ctx context.Context) (Flags, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hp.URL, nil)
+	if err != nil {
+		return Flags{}, err
+	}
+	resp, err := hp.Client.Do(req)
+	if err != nil {
+		return Flags{}, fmt.Errorf("failed to fetch flags: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Flags{}, fmt.Errorf("flag service responded with %d", resp.StatusCode)
+	}
+
+	var flags Flags
+	if err := json.NewDecoder(resp.Body).Decode(&flags); err != nil {
+		return Flags{}, fmt.Errorf("failed to decode flags: %v", err)
+	}
+	return flags, nil
+}
+
+func init() {
+	registry.Activity("tavern.flags.load", activityLoadFlags)
+}
+
+// Load is used by workflows at start to read the flags
+// Since they are read through an activity the result is recorded in history, so replays stay deterministic
+// ctx needs to have ActivityOptions applied
+func Load(ctx workflow.Context) (Flags, error) {
+	var flags Flags
+	err := workflow.ExecuteActivity(ctx, activityLoadFlags).Get(ctx, &flags)
+	return flags, err
+}
+
+// activityLoadFlags reads the current flags from the configured Source
+func activityLoadFlags(ctx context.Context) (Flags, error) {
+	sourceMu.RLock()
+	source := Source
+	sourceMu.RUnlock()
+	return source.Fetch(ctx)
+}
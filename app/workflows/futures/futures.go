@@ -0,0 +1,105 @@
+package futures
+
+import (
+	"errors"
+	"programmingpercy/cadence-tavern/engine"
+	"strings"
+)
+
+// Errors are the errors of several futures, in the order the futures were given
+type Errors []error
+
+// Error lists every error separated by ;
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Combine returns the errors that are not nil as Errors, or nil if there are none
+func Combine(errs ...error) error {
+	var combined Errors
+	for _, err := range errs {
+		if err != nil {
+			combined = append(combined, err)
+		}
+	}
+	if len(combined) == 0 {
+		return nil
+	}
+	return combined
+}
+
+// WaitAll waits for every future, even when some of them fail
+// results are the values the futures are read into in the same order, nil or missing results are ignored
+func WaitAll(ctx engine.Context, futures []engine.Future, results ...interface{}) error {
+	errs := make([]error, len(futures))
+	for i, future := range futures {
+		var result interface{}
+		if i < len(results) {
+			result = results[i]
+		}
+		errs[i] = future.Get(ctx, result)
+	}
+	return Combine(errs...)
+}
+
+// WaitAny waits for the first future that succeeds, reads it into result and returns its index
+// The errors of all futures are returned when every one of them fails
+func WaitAny(ctx engine.Context, futures []engine.Future, result interface{}) (int, error) {
+	if len(futures) == 0 {
+		return -1, errors.New("no futures to wait for")
+	}
+
+	winner := -1
+	errs := make([]error, len(futures))
+	selector := engine.NewSelector(ctx)
+	for i, future := range futures {
+		i := i
+		selector.AddFuture(future, func(f engine.Future) {
+			if err := f.Get(ctx, result); err != nil {
+				errs[i] = err
+				return
+			}
+			winner = i
+		})
+	}
+
+	for done := 0; done < len(futures) && winner < 0; done++ {
+		selector.Select(ctx)
+	}
+	if winner >= 0 {
+		return winner, nil
+	}
+	return -1, Combine(errs...)
+}
+
+// Map runs fn for 0 to n-1 with at most limit running at the same time, 0 runs all at once
+// It returns once all have finished, with the errors in the order of i
+func Map(ctx engine.Context, n int, limit int, fn func(ctx engine.Context, i int) error) error {
+	if n == 0 {
+		return nil
+	}
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	errs := make([]error, n)
+	// Every running fn holds a slot in the channel, sending blocks while all slots are taken
+	slots := engine.NewBufferedChannel(ctx, limit)
+	wg := engine.NewWaitGroup(ctx)
+	for i := 0; i < n; i++ {
+		i := i
+		slots.Send(ctx, true)
+		wg.Add(1)
+		engine.Go(ctx, func(ctx engine.Context) {
+			defer wg.Done()
+			defer slots.Receive(ctx, nil)
+			errs[i] = fn(ctx, i)
+		})
+	}
+	wg.Wait(ctx)
+	return Combine(errs...)
+}
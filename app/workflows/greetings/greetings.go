@@ -2,30 +2,81 @@ package greetings
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/menu"
+	"programmingpercy/cadence-tavern/tables"
+	"programmingpercy/cadence-tavern/workflows/profiles"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/seating"
+	"programmingpercy/cadence-tavern/workflows/slo"
+	"strings"
 	"time"
 
+	"go.uber.org/cadence"
 	"go.uber.org/cadence/activity"
 	"go.uber.org/cadence/workflow"
 	"go.uber.org/zap"
 )
 
-var (
-	visitorCount = 0
-)
+// MetricVisitorsGreeted is the counter increased by every greeting, it replaces counting the visitors in memory
+// which was lost on restart and raced between concurrent activities
+const MetricVisitorsGreeted = "visitors_greeted"
+
+// ErrReasonInvalidCustomer is returned before anything is done for a visitor that could never be greeted
+// It is a CustomError so it is never retried
+const ErrReasonInvalidCustomer = "invalid-customer"
+
+// validateChangeID marks the runs that validate the visitor before greeting them
+const validateChangeID = "validate-customer"
+
+// normalizeChangeID marks the runs that normalize the visitor before greeting them
+const normalizeChangeID = "normalize-customer"
+
+// GreetingResult is what the greetings workflow returns to the API
+type GreetingResult struct {
+	// Customer is the visitor with the visit recorded
+	Customer customer.Customer `json:"customer"`
+	// Welcome is the message the visitor is greeted with
+	Welcome string `json:"welcome"`
+	// RecommendedDrink is a drink from the menu suggested to the visitor
+	RecommendedDrink menu.Drink `json:"recommendedDrink"`
+	// Loyalty is the loyalty status of the visitor
+	Loyalty string `json:"loyalty"`
+	// Table is where the visitor is seated, it is empty when every table is full
+	Table *tables.Table `json:"table,omitempty"`
+}
+
+// newGreetingResult builds the greeting for the visitor
+// It only depends on the visitor so it is safe to call from the workflow
+func newGreetingResult(visitor customer.Customer) GreetingResult {
+	welcome := fmt.Sprintf("Welcome to the Tavern %s, first time here?", visitor.Name)
+	if visitor.TimesVisited > 1 {
+		welcome = fmt.Sprintf("Welcome back %s, this is visit number %d!", visitor.Name, visitor.TimesVisited)
+	}
+	return GreetingResult{
+		Customer:         visitor,
+		Welcome:          welcome,
+		RecommendedDrink: menu.Recommend(visitor.Age, visitor.TimesVisited),
+		Loyalty:          visitor.Loyalty(),
+	}
+}
 
 func init() {
 	// init will be called once the workflow file is imported
 	// this will Register the workflow to the Worker service
-	workflow.Register(workflowGreetings)
+	registry.Workflow(workflowGreetings)
 	// Register the activities also
-	activity.Register(activityGreetings)
-	activity.Register(activityStoreCustomer)
+	registry.Activity("tavern.greetings.greet", activityGreetings)
+	registry.Activity("tavern.greetings.storeCustomer", activityStoreCustomer)
+	registry.Activity("tavern.greetings.normalize", activityNormalizeCustomer)
 }
 
 // workflowGreetings is the Workflow that is used to handle new Customers in the Tavern.
-// our Workflow accepts a customer as Input, and Outputs a Customer, and an Error
-func workflowGreetings(ctx workflow.Context, visitor customer.Customer) (customer.Customer, error) {
+// our Workflow accepts a customer as Input, and Outputs a GreetingResult, and an Error
+func workflowGreetings(ctx workflow.Context, visitor customer.Customer) (GreetingResult, error) {
 	// workflow Options for HeartBeat Timeout and other Timeouts.
 	ao := workflow.ActivityOptions{
 		ScheduleToStartTimeout: time.Minute,
@@ -39,6 +90,26 @@ func workflowGreetings(ctx workflow.Context, visitor customer.Customer) (custome
 	logger := workflow.GetLogger(ctx)
 	logger.Info("greetings workflow started")
 
+	// Garbage input is rejected before any activity runs, runs from before the check replay without it
+	if workflow.GetVersion(ctx, validateChangeID, workflow.DefaultVersion, 1) != workflow.DefaultVersion {
+		if err := visitor.Validate(); err != nil {
+			logger.Warn("Rejected invalid visitor", zap.Error(err))
+			return GreetingResult{}, cadence.NewCustomError(ErrReasonInvalidCustomer, err.Error())
+		}
+	}
+	// started is used to measure the greeting against its SLO
+	started := workflow.Now(ctx)
+
+	// The visitor is matched to the stored customer first, so aliases of a name are greeted as the same customer
+	if workflow.GetVersion(ctx, normalizeChangeID, workflow.DefaultVersion, 1) != workflow.DefaultVersion {
+		err := workflow.ExecuteActivity(ctx, activityNormalizeCustomer, visitor).Get(ctx, &visitor)
+		if err != nil {
+			logger.Error("Failed to normalize customer", zap.Error(err))
+			slo.Record(ctx, slo.Greetings, started, err)
+			return GreetingResult{}, err
+		}
+	}
+
 	// Execute the activityGreetings and Wait for the Response with GET
 	// GET() will Block until the activitiy is Completed.
 	// Get accepts input to marshal result to,
@@ -47,19 +118,33 @@ func workflowGreetings(ctx workflow.Context, visitor customer.Customer) (custome
 	err := workflow.ExecuteActivity(ctx, activityGreetings, visitor).Get(ctx, &visitor)
 	if err != nil {
 		logger.Error("Greetings Activity failed", zap.Error(err))
-		return customer.Customer{}, err
+		slo.Record(ctx, slo.Greetings, started, err)
+		return GreetingResult{}, err
 	}
 
-	err = workflow.ExecuteActivity(ctx, activityStoreCustomer, visitor).Get(ctx, nil)
+	err = workflow.ExecuteActivity(profiles.WithActivityProfile(ctx, profiles.StoreCustomer), activityStoreCustomer, visitor).Get(ctx, nil)
 	if err != nil {
 		logger.Error("Failed to update customer", zap.Error(err))
-		return customer.Customer{}, err
+		slo.Record(ctx, slo.Greetings, started, err)
+		return GreetingResult{}, err
 	}
 
+	result := newGreetingResult(visitor)
+
+	// A full tavern does not stop the greeting, the visitor can still order at the bar
+	table, err := seating.Assign(ctx, visitor.Location, visitor.Name)
+	if err != nil {
+		logger.Warn("Failed to assign a table", zap.Error(err))
+	} else {
+		result.Table = &table
+	}
+
+	slo.Record(ctx, slo.Greetings, started, nil)
+
 	// Let us wait for orders
 
-	// The output of the Workflow is a Visitor with filled information
-	return visitor, nil
+	// The output of the Workflow is a Visitor with filled information and the greeting
+	return result, nil
 }
 
 // activityGreetings is used to say Hello to a Customer and change their LastVisit and TimesVisisted
@@ -67,16 +152,74 @@ func workflowGreetings(ctx workflow.Context, visitor customer.Customer) (custome
 func activityGreetings(ctx context.Context, visitor customer.Customer) (customer.Customer, error) {
 	logger := activity.GetLogger(ctx)
 	logger.Info("Greetings activity started")
-	logger.Info("New Visitor", zap.String("customer", visitor.Name), zap.Int("visitorCount", visitorCount))
-	visitorCount++
-
-	oldCustomerInfo, _ := customer.Database.Get(visitor.Name)
+	// Without the stored customer the visit count and the ban would be written over, so only a new visitor goes on
+	// The ban is carried over from what is read, so it is read past the cache
+	oldCustomerInfo, err := customer.Database.Get(customer.Uncached(ctx), visitor.Location, visitor.Name)
+	if err != nil && !errors.Is(err, customer.ErrNoSuchCustomer) {
+		return visitor, err
+	}
+	logger.Info("New Visitor", zap.String("customer", visitor.Name), zap.Int("timesVisited", oldCustomerInfo.TimesVisited))
+	activity.GetMetricsScope(ctx).Counter(MetricVisitorsGreeted).Inc(1)
 
 	visitor.LastVisit = time.Now()
 	visitor.TimesVisited = oldCustomerInfo.TimesVisited + 1
+	// A ban is only lifted by staff, never by the visitor
+	visitor.Banned = oldCustomerInfo.Banned
 	return visitor, nil
 }
 
+// activityNormalizeCustomer cleans up the visitor before it is stored
+// The name is trimmed and, when a stored customer has the same name in another case, replaced by the stored name
+// Fields the visitor left empty are filled from the stored customer, so greeting by name does not forget them
+func activityNormalizeCustomer(ctx context.Context, visitor customer.Customer) (customer.Customer, error) {
+	visitor.Name = customer.NormalizeName(visitor.Name)
+	visitor.Email = strings.ToLower(strings.TrimSpace(visitor.Email))
+	visitor.Phone = strings.TrimSpace(visitor.Phone)
+
+	stored, err := customer.Database.Get(ctx, visitor.Location, visitor.Name)
+	if errors.Is(err, customer.ErrNoSuchCustomer) {
+		stored, err = findAlias(ctx, visitor)
+	}
+	if errors.Is(err, customer.ErrNoSuchCustomer) {
+		return visitor, nil
+	}
+	if err != nil {
+		return visitor, err
+	}
+
+	if stored.Name != visitor.Name {
+		activity.GetLogger(ctx).Info("Greeting alias as stored customer", zap.String("alias", visitor.Name), zap.String("customer", stored.Name))
+	}
+	visitor.Name = stored.Name
+	if visitor.Age == 0 {
+		visitor.Age = stored.Age
+	}
+	if visitor.Email == "" {
+		visitor.Email = stored.Email
+	}
+	if visitor.Phone == "" {
+		visitor.Phone = stored.Phone
+	}
+	if visitor.LoyaltyID == "" {
+		visitor.LoyaltyID = stored.LoyaltyID
+	}
+	return visitor, nil
+}
+
+// findAlias returns the stored customer whose name only differs from the visitor in case or whitespace
+func findAlias(ctx context.Context, visitor customer.Customer) (customer.Customer, error) {
+	customers, err := customer.Database.List(ctx, visitor.Location)
+	if err != nil {
+		return customer.Customer{}, err
+	}
+	for _, cust := range customers {
+		if customer.SameName(cust.Name, visitor.Name) {
+			return cust, nil
+		}
+	}
+	return customer.Customer{}, fmt.Errorf("%w: %s", customer.ErrNoSuchCustomer, visitor.Name)
+}
+
 // activityStoreCustomer is used to store the Customer in the configured Customer Storage.
 func activityStoreCustomer(ctx context.Context, visitor customer.Customer) error {
 	logger := activity.GetLogger(ctx)
@@ -84,8 +227,23 @@ func activityStoreCustomer(ctx context.Context, visitor customer.Customer) error
 	logger.Info("Updating Customer", zap.String("customer", visitor.Name), zap.Time("lastVisit", visitor.LastVisit),
 		zap.Int("timesVisited", visitor.TimesVisited))
 
+	// When the repository has an outbox, the change is published downstream by the relay
+	// The key is the activity so a retried activity does not publish twice
+	if outbox, ok := customer.AsOutbox(customer.Database); ok {
+		payload, err := json.Marshal(visitor)
+		if err != nil {
+			return err
+		}
+		info := activity.GetInfo(ctx)
+		return outbox.UpdateWithOutbox(ctx, visitor, customer.OutboxEntry{
+			Key:     info.WorkflowExecution.ID + "/" + info.ActivityID,
+			Topic:   "customer.updated",
+			Payload: payload,
+		})
+	}
+
 	// Store Customer in Database (Memory Cache during this Example)
-	err := customer.Database.Update(visitor)
+	err := customer.Database.Update(ctx, visitor)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,127 @@
+package greetings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/tables"
+	"programmingpercy/cadence-tavern/workflows/retries"
+	"sync"
+	"testing"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/encoded"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/zap"
+)
+
+func TestInvalidVisitorsAreRejected(t *testing.T) {
+	for name, visitor := range map[string]customer.Customer{
+		"empty name":   {Name: " ", Age: 30},
+		"negative age": {Name: "Percy", Age: -1},
+	} {
+		var suite testsuite.WorkflowTestSuite
+		suite.SetLogger(zap.NewNop())
+		env := suite.NewTestWorkflowEnvironment()
+		var activities []string
+		env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args encoded.Values) {
+			activities = append(activities, info.ActivityType.Name)
+		})
+
+		env.ExecuteWorkflow(workflowGreetings, visitor)
+		err := env.GetWorkflowError()
+		var custom *cadence.CustomError
+		if !errors.As(err, &custom) || custom.Reason() != ErrReasonInvalidCustomer {
+			t.Errorf("%s: greeting returned %v, want %s", name, err, ErrReasonInvalidCustomer)
+			continue
+		}
+		if retries.Classify(err) != retries.Permanent {
+			t.Errorf("%s: the rejection would be retried", name)
+		}
+		if len(activities) != 0 {
+			t.Errorf("%s: ran activities %v before rejecting the visitor", name, activities)
+		}
+	}
+}
+
+// TestConcurrentGreetings greets visitors at the same time against one repository, run it with -race
+func TestConcurrentGreetings(t *testing.T) {
+	customer.Database = customer.NewMemoryCustomers()
+	tables.Database = tables.NewMemoryTables(nil)
+	scope := tally.NewTestScope("", nil)
+	var suite testsuite.WorkflowTestSuite
+	suite.SetLogger(zap.NewNop())
+	suite.SetMetricsScope(scope)
+
+	const visitors = 8
+	envs := make([]*testsuite.TestWorkflowEnvironment, visitors)
+	for i := range envs {
+		envs[i] = suite.NewTestWorkflowEnvironment()
+	}
+	var wg sync.WaitGroup
+	for i, env := range envs {
+		wg.Add(1)
+		go func(i int, env *testsuite.TestWorkflowEnvironment) {
+			defer wg.Done()
+			env.ExecuteWorkflow(workflowGreetings, customer.Customer{Name: fmt.Sprintf("visitor-%d", i), Age: 30})
+		}(i, env)
+	}
+	wg.Wait()
+
+	for i, env := range envs {
+		if err := env.GetWorkflowError(); err != nil {
+			t.Fatalf("greeting visitor-%d failed: %v", i, err)
+		}
+		stored, err := customer.Database.Get(context.Background(), "", fmt.Sprintf("visitor-%d", i))
+		if err != nil || stored.TimesVisited != 1 {
+			t.Errorf("visitor-%d is stored as %+v, %v, want one visit", i, stored, err)
+		}
+	}
+	var greeted int64
+	for _, c := range scope.Snapshot().Counters() {
+		if c.Name() == MetricVisitorsGreeted {
+			greeted += c.Value()
+		}
+	}
+	if greeted != visitors {
+		t.Errorf("%s is %d, want %d", MetricVisitorsGreeted, greeted, visitors)
+	}
+}
+
+// unavailableGet fails every lookup of a customer, like a repository that timed out
+type unavailableGet struct {
+	customer.Repository
+}
+
+func (unavailableGet) Get(ctx context.Context, location, name string) (customer.Customer, error) {
+	return customer.Customer{}, context.DeadlineExceeded
+}
+
+func TestGreetingKeepsTheBanWhenTheLookupFails(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	suite.SetLogger(zap.NewNop())
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activityGreetings)
+
+	// Only a visitor that is not stored yet is greeted without the stored customer
+	customer.Database = customer.NewMemoryCustomers()
+	value, err := env.ExecuteActivity(activityGreetings, customer.Customer{Name: "Percy", Age: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var greeted customer.Customer
+	if err := value.Get(&greeted); err != nil {
+		t.Fatal(err)
+	}
+	if greeted.TimesVisited != 1 || greeted.Banned {
+		t.Fatalf("greeted new visitor as %+v, want a first visit without a ban", greeted)
+	}
+
+	customer.Database = unavailableGet{customer.NewMemoryCustomers()}
+	if _, err := env.ExecuteActivity(activityGreetings, customer.Customer{Name: "Percy", Age: 30}); err == nil {
+		t.Fatal("greeted the visitor without the stored customer, the ban and visits would be written over")
+	}
+}
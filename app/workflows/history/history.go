@@ -0,0 +1,76 @@
+// Package history keeps long running workflows from growing past the history limits of the Cadence server
+// By default the server warns from 50k events and fails the workflow at 200k
+package history
+
+import (
+	"sync"
+
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// MetricLimitReached is the counter increased when a workflow continues as new because of its history, tagged with
+// the workflow type
+const MetricLimitReached = "workflow_history_limit_reached"
+
+// changeID marks the runs that read the limit, see NewGuard
+const changeID = "history-guard"
+
+var (
+	// MaxEvents is how long the history of a run may get before the Guard asks it to continue as new, 0 disables it
+	// The client of this Cadence version only exposes the length of the history, not its size in bytes
+	MaxEvents   = 10000
+	maxEventsMu sync.RWMutex
+)
+
+// SetMaxEvents replaces MaxEvents while workflows might be reading it, used when the configuration is reloaded
+func SetMaxEvents(n int) {
+	maxEventsMu.Lock()
+	defer maxEventsMu.Unlock()
+	MaxEvents = n
+}
+
+// Guard is checked by the loop of a long running workflow, once every iteration
+type Guard struct {
+	maxEvents int64
+	reached   bool
+}
+
+// NewGuard reads MaxEvents as a side effect, so replays use the limit the run started with no matter the configuration
+// Runs started before the guard existed have no side effect in their history to replay, they are not guarded
+func NewGuard(ctx workflow.Context) *Guard {
+	if workflow.GetVersion(ctx, changeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		return &Guard{}
+	}
+	encoded := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		maxEventsMu.RLock()
+		defer maxEventsMu.RUnlock()
+		return MaxEvents
+	})
+	var maxEvents int
+	if err := encoded.Get(&maxEvents); err != nil {
+		maxEvents = 0
+	}
+	return &Guard{maxEvents: int64(maxEvents)}
+}
+
+// Reached is true once the history of the run is longer than the limit, the workflow should then continue as new
+// The length is the ID of the event that started the current decision, it is the same when the decision is replayed
+func (g *Guard) Reached(ctx workflow.Context) bool {
+	if g.reached {
+		return true
+	}
+	if g.maxEvents <= 0 {
+		return false
+	}
+	info := workflow.GetInfo(ctx)
+	if info.GetDecisionStartedEventID() < g.maxEvents {
+		return false
+	}
+
+	g.reached = true
+	workflow.GetLogger(ctx).Info("History limit reached, continuing as new",
+		zap.Int64("events", info.GetDecisionStartedEventID()), zap.Int64("limit", g.maxEvents))
+	workflow.GetMetricsScope(ctx).Tagged(map[string]string{"workflow": info.WorkflowType.Name}).Counter(MetricLimitReached).Inc(1)
+	return true
+}
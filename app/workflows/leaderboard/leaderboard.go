@@ -0,0 +1,212 @@
+// Package leaderboard keeps the top customers of each location by how much they spent
+// Completed orders signal their spend to the leaderboard workflow of the location, which answers the top query
+// and stores a snapshot of the ranking in the customer repository every night
+package leaderboard
+
+import (
+	"context"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/history"
+	"programmingpercy/cadence-tavern/workflows/profiles"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignalSpend is the signal used when a customer completed an order, the payload is a Spend
+	SignalSpend = "spend"
+	// QueryTop is the query used to look at the top customers, the largest spender first
+	QueryTop = "top"
+
+	// SignalVersion is the version of the payload of the spend signal
+	SignalVersion = 1
+)
+
+var (
+	// Size is how many customers the top query and the snapshots hold, the Worker replaces this during startup
+	Size = 10
+
+	sizeMu sync.RWMutex
+)
+
+// SetSize replaces the Size while workflows might be reading it, used when the configuration is reloaded
+func SetSize(n int) {
+	sizeMu.Lock()
+	defer sizeMu.Unlock()
+	Size = n
+}
+
+func init() {
+	registry.Workflow(WorkflowLeaderboard)
+
+	registry.Signal(SignalSpend, SignalVersion, Spend{})
+
+	registry.Activity("tavern.leaderboard.snapshot", activitySnapshot)
+}
+
+// Spend is what a customer paid for a completed order
+type Spend struct {
+	Customer string  `json:"customer"`
+	Amount   float32 `json:"amount"`
+	OrderID  string  `json:"orderId,omitempty"`
+}
+
+// Board is the spend of every customer of a location, it is the input of each run so it carries over
+type Board struct {
+	Location string                           `json:"location"`
+	Totals   map[string]customer.RankingEntry `json:"totals,omitempty"`
+}
+
+// WorkflowID is the workflow ID of the leaderboard of a location, there is only one open at a time in each tavern
+func WorkflowID(loc string) string {
+	return location.WorkflowID(loc, "leaderboard")
+}
+
+// WorkflowLeaderboard adds up the spend signals of a location, at midnight it stores a snapshot of the top customers
+// and continues as new with the totals so the history stays short
+func WorkflowLeaderboard(ctx workflow.Context, board Board) error {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    time.Minute,
+	})
+	logger := workflow.GetLogger(ctx)
+	if board.Totals == nil {
+		board.Totals = make(map[string]customer.RankingEntry)
+	}
+
+	size := boardSize(ctx)
+	err := workflow.SetQueryHandler(ctx, QueryTop, func() ([]customer.RankingEntry, error) {
+		return top(board.Totals, size), nil
+	})
+	if err != nil {
+		return err
+	}
+	// guard continues the run early on a busy day, before the nightly snapshot
+	guard := history.NewGuard(ctx)
+
+	// snapshotTaken is set at midnight, drained once the signals received until then are added
+	var snapshotTaken, draining, drained bool
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalSpend), func(c workflow.Channel, more bool) {
+		var spend Spend
+		if err := signals.Receive(ctx, c, nil, &spend); err != nil {
+			logger.Error("Dropped spend signal that could not be decoded", zap.Error(err))
+			return
+		}
+		entry := board.Totals[spend.Customer]
+		entry.Customer = spend.Customer
+		entry.Spend += spend.Amount
+		entry.Orders++
+		board.Totals[spend.Customer] = entry
+	})
+
+	now := workflow.Now(ctx)
+	midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+	selector.AddFuture(workflow.NewTimer(ctx, midnight.Sub(now)), func(f workflow.Future) {
+		ranking := customer.Ranking{Location: board.Location, TakenAt: workflow.Now(ctx), Entries: top(board.Totals, size)}
+		ctx := profiles.WithActivityProfile(ctx, profiles.StoreCustomer)
+		if err := workflow.ExecuteActivity(ctx, activitySnapshot, ranking).Get(ctx, nil); err != nil {
+			logger.Error("Failed to store the leaderboard snapshot", zap.Error(err))
+		}
+		snapshotTaken = true
+	})
+
+	for {
+		if (snapshotTaken || guard.Reached(ctx)) && !draining {
+			// The default is only selected once no spend signal is waiting, so none are lost by the new run
+			draining = true
+			selector.AddDefault(func() {
+				drained = true
+			})
+		}
+
+		selector.Select(ctx)
+
+		if drained {
+			return workflow.NewContinueAsNewError(ctx, WorkflowLeaderboard, board)
+		}
+	}
+}
+
+// boardSize reads the Size as a side effect, so replays use the same size no matter the configuration
+func boardSize(ctx workflow.Context) int {
+	encoded := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		sizeMu.RLock()
+		defer sizeMu.RUnlock()
+		return Size
+	})
+	var size int
+	if err := encoded.Get(&size); err != nil {
+		return 0
+	}
+	return size
+}
+
+// top returns the size customers that spent the most, ties are ordered by name so every replay answers the same
+func top(totals map[string]customer.RankingEntry, size int) []customer.RankingEntry {
+	entries := make([]customer.RankingEntry, 0, len(totals))
+	for _, entry := range totals {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Spend != entries[j].Spend {
+			return entries[i].Spend > entries[j].Spend
+		}
+		return entries[i].Customer < entries[j].Customer
+	})
+	if size > 0 && len(entries) > size {
+		entries = entries[:size]
+	}
+	return entries
+}
+
+// Record is used by workflows to add the spend of a completed order to the leaderboard of the location
+// Workflows can not signal with start, so a location without an open leaderboard gets one as an abandoned child
+func Record(ctx workflow.Context, loc string, spend Spend) error {
+	payload, err := signals.Wrap(SignalVersion, spend)
+	if err != nil {
+		return err
+	}
+	id := WorkflowID(loc)
+	if err := workflow.SignalExternalWorkflow(ctx, id, "", SignalSpend, payload).Get(ctx, nil); err == nil {
+		return nil
+	}
+
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: id,
+		// Each run continues as new at midnight, the timeout only needs to cover one day
+		ExecutionStartToCloseTimeout: 48 * time.Hour,
+		ParentClosePolicy:            client.ParentClosePolicyAbandon,
+		WorkflowIDReusePolicy:        client.WorkflowIDReusePolicyAllowDuplicate,
+	})
+	// Another order might have started the leaderboard in the meantime, it is signalled again either way
+	if err := workflow.ExecuteChildWorkflow(childCtx, WorkflowLeaderboard, Board{Location: loc}).GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+		workflow.GetLogger(ctx).Info("Leaderboard was started by someone else", zap.String("location", loc), zap.Error(err))
+	}
+	return workflow.SignalExternalWorkflow(ctx, id, "", SignalSpend, payload).Get(ctx, nil)
+}
+
+// activitySnapshot stores the ranking in the customer repository
+// Repositories that can not keep rankings skip the snapshot, the leaderboard keeps working from the workflow
+func activitySnapshot(ctx context.Context, ranking customer.Ranking) error {
+	span := tracing.StartActivitySpan(ctx, "snapshotLeaderboard", opentracing.Tags{"location": ranking.Location})
+	defer span.Finish()
+
+	rankings, ok := customer.AsRankings(customer.Database)
+	if !ok {
+		activity.GetLogger(ctx).Warn("Customer repository can not store rankings, snapshot skipped")
+		return nil
+	}
+	return rankings.SaveRanking(opentracing.ContextWithSpan(ctx, span), ranking)
+}
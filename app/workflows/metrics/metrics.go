@@ -0,0 +1,123 @@
+// Package metrics is the workflow interceptor that reports every workflow, activity and child workflow by its type
+// Each workflow gets a sub scope tagged with its WorkflowType, the calls it makes are reported on a sub scope of that
+// tagged with the ActivityType or ChildWorkflowType, so dashboards can be split per workflow instead of one total
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/interceptors"
+	"go.uber.org/cadence/workflow"
+)
+
+// The tags of the sub scopes, WorkflowType and ActivityType match the tags Cadence puts on its own metrics
+const (
+	TagWorkflowType      = "WorkflowType"
+	TagActivityType      = "ActivityType"
+	TagChildWorkflowType = "ChildWorkflowType"
+	// TagOutcome is completed, failed, canceled or continued
+	TagOutcome = "outcome"
+)
+
+// The outcomes of a workflow or a call
+const (
+	OutcomeCompleted = "completed"
+	OutcomeFailed    = "failed"
+	OutcomeCanceled  = "canceled"
+	OutcomeContinued = "continued"
+)
+
+// Factory creates the interceptor of each workflow, it is added to the WorkflowInterceptorChainFactories of the Worker
+type Factory struct{}
+
+// NewInterceptor wraps the next interceptor of the workflow
+func (Factory) NewInterceptor(info *workflow.Info, next interceptors.WorkflowInterceptor) interceptors.WorkflowInterceptor {
+	return &interceptor{
+		WorkflowInterceptorBase: interceptors.WorkflowInterceptorBase{Next: next},
+		workflowType:            info.WorkflowType.Name,
+	}
+}
+
+// interceptor reports the workflow and its calls, the scopes Cadence hands out are silent during replay
+// so nothing is counted twice
+type interceptor struct {
+	interceptors.WorkflowInterceptorBase
+	workflowType string
+}
+
+// GetMetricsScope is the scope of the workflow, the metrics the workflow reports are tagged with its type
+func (wi *interceptor) GetMetricsScope(ctx workflow.Context) tally.Scope {
+	return wi.Next.GetMetricsScope(ctx).Tagged(map[string]string{TagWorkflowType: wi.workflowType})
+}
+
+// ExecuteWorkflow reports the outcome and duration of the run
+func (wi *interceptor) ExecuteWorkflow(ctx workflow.Context, workflowType string, args ...interface{}) []interface{} {
+	started := wi.Now(ctx)
+	results := wi.Next.ExecuteWorkflow(ctx, workflowType, args...)
+
+	var err error
+	if len(results) > 0 {
+		err, _ = results[len(results)-1].(error)
+	}
+	report(wi.GetMetricsScope(ctx), "workflow", outcome(err), wi.Now(ctx).Sub(started))
+	return results
+}
+
+// ExecuteActivity reports the activity once it is done
+func (wi *interceptor) ExecuteActivity(ctx workflow.Context, activityType string, args ...interface{}) workflow.Future {
+	future := wi.Next.ExecuteActivity(ctx, activityType, args...)
+	wi.watch(ctx, map[string]string{TagActivityType: activityType}, "activity", future)
+	return future
+}
+
+// ExecuteLocalActivity reports the local activity once it is done
+func (wi *interceptor) ExecuteLocalActivity(ctx workflow.Context, activityType string, args ...interface{}) workflow.Future {
+	future := wi.Next.ExecuteLocalActivity(ctx, activityType, args...)
+	wi.watch(ctx, map[string]string{TagActivityType: activityType}, "local_activity", future)
+	return future
+}
+
+// ExecuteChildWorkflow reports the child once it is done
+func (wi *interceptor) ExecuteChildWorkflow(ctx workflow.Context, childWorkflowType string, args ...interface{}) workflow.ChildWorkflowFuture {
+	future := wi.Next.ExecuteChildWorkflow(ctx, childWorkflowType, args...)
+	wi.watch(ctx, map[string]string{TagChildWorkflowType: childWorkflowType}, "child_workflow", future)
+	return future
+}
+
+// watch waits for the future in a goroutine of its own, so the workflow is not held up by the reporting
+// A workflow that closes before the call is done does not report it
+func (wi *interceptor) watch(ctx workflow.Context, tags map[string]string, name string, future workflow.Future) {
+	scope := wi.GetMetricsScope(ctx).Tagged(tags)
+	scope.Counter(name + "_calls").Inc(1)
+
+	started := wi.Now(ctx)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		err := future.Get(ctx, nil)
+		report(scope, name, outcome(err), wi.Now(ctx).Sub(started))
+	})
+}
+
+// report counts the outcome and records the duration of a workflow or call
+func report(scope tally.Scope, name string, result string, duration time.Duration) {
+	scope.Tagged(map[string]string{TagOutcome: result}).Counter(name + "_outcomes").Inc(1)
+	scope.Timer(name + "_duration").Record(duration)
+}
+
+// outcome sorts the error of a workflow or call
+func outcome(err error) string {
+	if err == nil {
+		return OutcomeCompleted
+	}
+	var continued *workflow.ContinueAsNewError
+	if errors.As(err, &continued) {
+		return OutcomeContinued
+	}
+	var canceled *cadence.CanceledError
+	if errors.As(err, &canceled) {
+		return OutcomeCanceled
+	}
+	return OutcomeFailed
+}
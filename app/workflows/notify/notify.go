@@ -0,0 +1,366 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// TemplateTabReceipt is sent to the customer when a tab is settled
+	TemplateTabReceipt = "tab-receipt"
+	// TemplateOpsAlert is sent to the ops recipient when something needs attention
+	TemplateOpsAlert = "ops-alert"
+	// TemplateStaffAlert is sent to the staff recipient when an order takes too long
+	TemplateStaffAlert = "staff-alert"
+	// TemplatePayslip is sent to an employee once they are paid for a pay period
+	TemplatePayslip = "payslip"
+)
+
+var (
+	// Email is used to send emails, the Worker replaces this during startup
+	Email Notifier = NewDryRunSink()
+	// SMS is used to send text messages, the Worker replaces this during startup
+	SMS Notifier = NewDryRunSink()
+	// Alerts is used to send the alerts about the Worker itself, the Worker replaces this during startup
+	Alerts Notifier = NewDryRunSink()
+	// OpsRecipient is the email address ops alerts are sent to
+	OpsRecipient = ""
+	// StaffRecipient is the email address of the bar staff, staff alerts go to the OpsRecipient when it is empty
+	StaffRecipient = ""
+
+	templates = map[string]*template.Template{
+		TemplateTabReceipt: template.Must(template.New(TemplateTabReceipt).Parse(
+			`Thanks for visiting the Tavern {{.Customer}}! Your tab of {{.Total}}{{with .Paid}} paid as {{.}}{{end}} is settled.{{if .ReceiptURL}} Receipt: {{.ReceiptURL}}{{end}}`)),
+		TemplateOpsAlert: template.Must(template.New(TemplateOpsAlert).Parse(
+			`Tavern alert: {{.Reason}}{{if .WorkflowID}} (workflow {{.WorkflowID}}){{end}}`)),
+		TemplateStaffAlert: template.Must(template.New(TemplateStaffAlert).Parse(
+			`{{.Item}} for {{.Customer}}{{if .Table}} at table {{.Table}}{{end}} has been waiting for {{.Waiting}}, please check on order {{.OrderID}}`)),
+		TemplatePayslip: template.Must(template.New(TemplatePayslip).Parse(
+			`Hi {{.Employee}}, you are paid {{.Gross}} for {{.Hours}} hours worked from {{.From}} until {{.To}}{{if .Overtime}}, {{.Overtime}} of them overtime{{end}}.`)),
+	}
+	subjects = map[string]string{
+		TemplateTabReceipt: "Your Tavern receipt",
+		TemplateOpsAlert:   "Tavern ops alert",
+		TemplateStaffAlert: "Order is taking too long",
+		TemplatePayslip:    "Your Tavern payslip",
+	}
+)
+
+// Message is a rendered notification
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier is the needed methods to deliver a message
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewNotifiers creates the email and sms notifiers from configuration
+// When DryRun is set both are replaced by a DryRunSink
+func NewNotifiers(cfg config.Notify) (Notifier, Notifier) {
+	if cfg.DryRun {
+		return NewDryRunSink(), NewDryRunSink()
+	}
+
+	var email, sms Notifier = NewDryRunSink(), NewDryRunSink()
+	if cfg.SMTPHost != "" {
+		email = &SMTPNotifier{
+			Addr:     cfg.SMTPHost,
+			From:     cfg.From,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+		}
+	}
+	if cfg.SMSGatewayURL != "" {
+		sms = &HTTPSMSNotifier{
+			URL:    cfg.SMSGatewayURL,
+			APIKey: cfg.SMSAPIKey,
+			From:   cfg.From,
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return email, sms
+}
+
+// NewAlerts creates the notifier of the alerts about the Worker from configuration
+// The alerts are posted to the Slack webhook, without one they are kept by a DryRunSink
+func NewAlerts(cfg config.Notify) Notifier {
+	if cfg.DryRun || cfg.SlackWebhookURL == "" {
+		return NewDryRunSink()
+	}
+	return &SlackNotifier{
+		URL:    cfg.SlackWebhookURL,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Render builds the message from the named template
+func Render(name string, to string, data interface{}) (Message, error) {
+	tmpl, ok := templates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("no such template: %s", name)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s: %v", name, err)
+	}
+	return Message{
+		To:      to,
+		Subject: subjects[name],
+		Body:    body.String(),
+	}, nil
+}
+
+// SMTPNotifier sends emails through a SMTP server
+type SMTPNotifier struct {
+	// Addr is the HOST:PORT of the SMTP server
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// Send will deliver the message as a plain text email
+func (sn *SMTPNotifier) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if sn.Username != "" {
+		host := strings.Split(sn.Addr, ":")[0]
+		auth = smtp.PlainAuth("", sn.Username, sn.Password, host)
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", sn.From, msg.To, msg.Subject, msg.Body)
+	if err := smtp.SendMail(sn.Addr, auth, sn.From, []string{msg.To}, []byte(body)); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
+
+// HTTPSMSNotifier sends text messages through a HTTP gateway
+// The message is POSTed as JSON {from, to, body}
+type HTTPSMSNotifier struct {
+	URL    string
+	APIKey string
+	From   string
+	Client *http.Client
+}
+
+// Send will POST the message to the gateway
+func (hn *HTTPSMSNotifier) Send(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(map[string]string{
+		"from": hn.From,
+		"to":   msg.To,
+		"body": msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hn.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+hn.APIKey)
+
+	resp, err := hn.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send sms: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SlackNotifier posts messages to a Slack incoming webhook, the recipient is the channel of the webhook
+type SlackNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Send will POST the message to the webhook as {text}
+func (sn *SlackNotifier) Send(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(map[string]string{
+		"text": "*" + msg.Subject + "*\n" + msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sn.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := sn.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to slack: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack webhook responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// DryRunSink keeps messages in memory instead of delivering them
+// Used for local development and tests
+type DryRunSink struct {
+	sync.Mutex
+	Messages []Message
+}
+
+// NewDryRunSink will init a new empty sink
+func NewDryRunSink() *DryRunSink {
+	return &DryRunSink{}
+}
+
+// Send stores the message
+func (ds *DryRunSink) Send(ctx context.Context, msg Message) error {
+	ds.Lock()
+	defer ds.Unlock()
+
+	ds.Messages = append(ds.Messages, msg)
+	return nil
+}
+
+func init() {
+	registry.Activity("tavern.notify.customer", activityNotifyCustomer)
+	registry.Activity("tavern.notify.ops", activityNotifyOps)
+	registry.Activity("tavern.notify.staff", activityNotifyStaff)
+	registry.Activity("tavern.notify.employee", activityNotifyEmployee)
+}
+
+// Contact is where the notifications of someone that is not a customer are sent, such as an employee
+type Contact struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+}
+
+// Customer is used by workflows to notify a customer using the named template
+// The customer gets an email and or a sms depending on what contact details are stored
+// ctx needs to have ActivityOptions applied
+func Customer(ctx workflow.Context, location string, name string, templateName string, data map[string]interface{}) error {
+	return workflow.ExecuteActivity(ctx, activityNotifyCustomer, location, name, templateName, data).Get(ctx, nil)
+}
+
+// Ops is used by workflows to send an alert to the ops recipient
+// ctx needs to have ActivityOptions applied
+func Ops(ctx workflow.Context, data map[string]interface{}) error {
+	return workflow.ExecuteActivity(ctx, activityNotifyOps, data).Get(ctx, nil)
+}
+
+// Staff is used by workflows to alert the bar staff about an order
+// ctx needs to have ActivityOptions applied
+func Staff(ctx workflow.Context, data map[string]interface{}) error {
+	return workflow.ExecuteActivity(ctx, activityNotifyStaff, data).Get(ctx, nil)
+}
+
+// Employee is used by workflows to notify an employee using the named template
+// The employee gets an email and or a sms depending on the contact details
+// ctx needs to have ActivityOptions applied
+func Employee(ctx workflow.Context, contact Contact, templateName string, data map[string]interface{}) error {
+	return workflow.ExecuteActivity(ctx, activityNotifyEmployee, contact, templateName, data).Get(ctx, nil)
+}
+
+// activityNotifyCustomer looks up the contact details of the customer and sends the notification
+func activityNotifyCustomer(ctx context.Context, location string, name string, templateName string, data map[string]interface{}) error {
+	cust, err := customer.Database.Get(ctx, location, name)
+	if err != nil {
+		return err
+	}
+	return send(ctx, zap.String("customer", name), cust.Email, cust.Phone, templateName, data)
+}
+
+// activityNotifyEmployee sends the notification to the contact details of the employee
+func activityNotifyEmployee(ctx context.Context, contact Contact, templateName string, data map[string]interface{}) error {
+	if contact.Email == "" && contact.Phone == "" {
+		activity.GetLogger(ctx).Warn("Employee has no contact details, dropping notification", zap.String("employee", contact.Name), zap.String("template", templateName))
+		return nil
+	}
+	return send(ctx, zap.String("employee", contact.Name), contact.Email, contact.Phone, templateName, data)
+}
+
+// send renders the template to the email and the phone that are set, recipient is logged with every message sent
+func send(ctx context.Context, recipient zap.Field, email, phone string, templateName string, data map[string]interface{}) error {
+	logger := activity.GetLogger(ctx)
+
+	if email != "" {
+		msg, err := Render(templateName, email, data)
+		if err != nil {
+			return err
+		}
+		if err := Email.Send(ctx, msg); err != nil {
+			return err
+		}
+		logger.Info("Sent email", recipient, zap.String("template", templateName))
+	}
+
+	if phone != "" {
+		msg, err := Render(templateName, phone, data)
+		if err != nil {
+			return err
+		}
+		if err := SMS.Send(ctx, msg); err != nil {
+			return err
+		}
+		logger.Info("Sent sms", recipient, zap.String("template", templateName))
+	}
+	return nil
+}
+
+// activityNotifyOps sends the ops alert email
+func activityNotifyOps(ctx context.Context, data map[string]interface{}) error {
+	logger := activity.GetLogger(ctx)
+	if OpsRecipient == "" {
+		logger.Warn("No ops recipient configured, dropping alert", zap.Any("alert", data))
+		return nil
+	}
+
+	msg, err := Render(TemplateOpsAlert, OpsRecipient, data)
+	if err != nil {
+		return err
+	}
+	return Email.Send(ctx, msg)
+}
+
+// activityNotifyStaff sends the staff alert email
+func activityNotifyStaff(ctx context.Context, data map[string]interface{}) error {
+	recipient := StaffRecipient
+	if recipient == "" {
+		recipient = OpsRecipient
+	}
+	if recipient == "" {
+		activity.GetLogger(ctx).Warn("No staff recipient configured, dropping alert", zap.Any("alert", data))
+		return nil
+	}
+
+	msg, err := Render(TemplateStaffAlert, recipient, data)
+	if err != nil {
+		return err
+	}
+	return Email.Send(ctx, msg)
+}
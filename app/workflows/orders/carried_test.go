@@ -0,0 +1,85 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/cadence/workflow"
+)
+
+// continuedWith is the input the order workflow continued as new with
+func continuedWith(t *testing.T, err error) Carried {
+	t.Helper()
+	var continued *workflow.ContinueAsNewError
+	if !errors.As(err, &continued) {
+		t.Fatalf("order workflow returned %v, want it to continue as new", err)
+	}
+	args := continued.Args()
+	if len(args) != 1 {
+		t.Fatalf("order workflow continued with %v, want the carried state", args)
+	}
+	carried, ok := args[0].(Carried)
+	if !ok {
+		t.Fatalf("order workflow continued with a %T, want Carried", args[0])
+	}
+	return carried
+}
+
+func TestQueriesAnswerTheCarriedState(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite)
+	env.OnWorkflow(workflowProcessRound, mock.Anything, mock.Anything).Return(nil)
+	for i, by := range []string{"Percy", "Percy", "Ada"} {
+		order := testOrder(fmt.Sprintf("order-%d", i), by)
+		env.RegisterDelayedCallback(func() { signalOrder(t, env, order) }, time.Second)
+	}
+	env.ExecuteWorkflow(WorkflowOrder)
+	carried := continuedWith(t, env.GetWorkflowError())
+	if carried.Stats.Orders != 3 || len(carried.Recent["Percy"]) != 2 {
+		t.Fatalf("order workflow carried %+v, want the stats and recent orders of the run", carried)
+	}
+
+	// The next run starts from the carried input, as the server starts it after ContinueAsNew
+	env = newTestEnv(t, &suite)
+	env.OnWorkflow(workflowProcessRound, mock.Anything, mock.Anything).Return(nil)
+	env.RegisterDelayedCallback(func() { signalOrder(t, env, testOrder("order-3", "Percy")) }, time.Second)
+	env.RegisterDelayedCallback(func() {
+		value, err := env.QueryWorkflow(QueryStats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stats Stats
+		if err := value.Get(&stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.Orders != carried.Stats.Orders || !stats.Since.Equal(carried.Stats.Since) {
+			t.Errorf("stats query answered %+v, want the carried %+v", stats, carried.Stats)
+		}
+
+		value, err = env.QueryWorkflow(QueryPending)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pending []Round
+		if err := value.Get(&pending); err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 1 || pending[0].Orders[0].ID != "order-3" {
+			t.Errorf("pending query answered %v, want the round of order-3", pending)
+		}
+	}, 2*time.Second)
+	for i := 4; i < 3+MaxSignalsAmount; i++ {
+		order := testOrder(fmt.Sprintf("order-%d", i), "Ada")
+		env.RegisterDelayedCallback(func() { signalOrder(t, env, order) }, time.Minute)
+	}
+	env.ExecuteWorkflow(workflowOrderCarried, carried)
+
+	next := continuedWith(t, env.GetWorkflowError())
+	if next.Stats.Orders != carried.Stats.Orders+MaxSignalsAmount {
+		t.Errorf("second run carried %d orders, want %d", next.Stats.Orders, carried.Stats.Orders+MaxSignalsAmount)
+	}
+}
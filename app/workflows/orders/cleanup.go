@@ -0,0 +1,168 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/inventory"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// ErrReasonOutOfStock is returned when the item of an order is out of stock, it is never retried
+	ErrReasonOutOfStock = "out-of-stock"
+
+	// signalRoundDone tells the cleanup of a round that the round closed by itself
+	signalRoundDone = "round-done"
+	// cleanupGrace is how long after the round should have timed out the cleanup releases the stock
+	cleanupGrace = time.Minute
+)
+
+// ParseParentClosePolicy reads a parent close policy written as terminate, cancel or abandon
+func ParseParentClosePolicy(policy string) (client.ParentClosePolicy, error) {
+	switch policy {
+	case "", "terminate":
+		return client.ParentClosePolicyTerminate, nil
+	case "cancel":
+		return client.ParentClosePolicyRequestCancel, nil
+	case "abandon":
+		return client.ParentClosePolicyAbandon, nil
+	}
+	return 0, fmt.Errorf("unknown parent close policy: %s", policy)
+}
+
+// Cleanup is the stock a round reserved, it is put back if the round is gone before it released it
+type Cleanup struct {
+	Location string   `json:"location,omitempty"`
+	OrderIDs []string `json:"orderIds"`
+	// Timeout is how long the round may run
+	Timeout time.Duration `json:"timeout"`
+}
+
+// startCleanup starts the cleanup of the round as a detached child
+// The child is abandoned when the round closes, so it still runs when the round is terminated halfway
+func startCleanup(ctx workflow.Context, round Round) (workflow.ChildWorkflowFuture, error) {
+	info := workflow.GetInfo(ctx)
+	cleanup := Cleanup{
+		Location: round.Location,
+		Timeout:  time.Duration(info.ExecutionStartToCloseTimeoutSeconds) * time.Second,
+	}
+	for _, order := range round.Orders {
+		if order.ID != "" {
+			cleanup.OrderIDs = append(cleanup.OrderIDs, order.ID)
+		}
+	}
+
+	cleanupCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		// Every attempt of the round gets a cleanup of its own, the attempt is kept in case a retry reuses the run ID
+		WorkflowID:                   fmt.Sprintf("%s-cleanup-%s-%d", info.WorkflowExecution.ID, info.WorkflowExecution.RunID, info.Attempt),
+		ExecutionStartToCloseTimeout: cleanup.Timeout + 2*cleanupGrace,
+		ParentClosePolicy:            client.ParentClosePolicyAbandon,
+	})
+	future := workflow.ExecuteChildWorkflow(cleanupCtx, workflowCleanupRound, cleanup)
+	// Only once the child has started will it outlive the round
+	if err := future.GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+		return nil, err
+	}
+	return future, nil
+}
+
+// finishCleanup tells the cleanup that the round released its stock by itself
+func finishCleanup(ctx workflow.Context, future workflow.ChildWorkflowFuture) {
+	done, err := signals.Wrap(signals.LegacyVersion, nil)
+	if err == nil {
+		err = future.SignalChildWorkflow(ctx, signalRoundDone, done).Get(ctx, nil)
+	}
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Failed to finish the cleanup of the round", zap.Error(err))
+	}
+}
+
+// workflowCleanupRound waits for the round to finish, if it has not after its timeout the round was terminated, timed
+// out or lost and the stock of its orders is put back. Completed orders have no reservation left so they are skipped
+func workflowCleanupRound(ctx workflow.Context, cleanup Cleanup) error {
+	logger := workflow.GetLogger(ctx)
+	ctx = workflow.WithActivityOptions(ctx, orderActivityOptions)
+
+	done := false
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, signalRoundDone), func(c workflow.Channel, more bool) {
+		if err := signals.Receive(ctx, c, nil, nil); err != nil {
+			logger.Error("Dropped done signal that could not be decoded", zap.Error(err))
+		}
+		done = true
+	})
+	selector.AddFuture(workflow.NewTimer(ctx, cleanup.Timeout+cleanupGrace), func(f workflow.Future) {})
+	selector.Select(ctx)
+	if done {
+		return nil
+	}
+
+	logger.Warn("Round never finished, releasing its stock", zap.Strings("orders", cleanup.OrderIDs))
+	workflow.GetMetricsScope(ctx).Counter("order_round_cleanups").Inc(1)
+	for _, id := range cleanup.OrderIDs {
+		if err := workflow.ExecuteActivity(ctx, activityReleaseStock, cleanup.Location, id).Get(ctx, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reserveStock sets the item of the order aside, orders without an ID are not tracked
+func reserveStock(ctx workflow.Context, order Order) error {
+	if order.ID == "" {
+		return nil
+	}
+	return workflow.ExecuteActivity(ctx, activityReserveStock, order.Location, order.ID, order.Item).Get(ctx, nil)
+}
+
+// settleStock takes the reserved stock when the order completed and puts it back when it failed
+func settleStock(ctx workflow.Context, order Order, completed bool) {
+	if order.ID == "" {
+		return
+	}
+	activity := activityReleaseStock
+	if completed {
+		activity = activityCommitStock
+	}
+	if err := workflow.ExecuteActivity(ctx, activity, order.Location, order.ID).Get(ctx, nil); err != nil {
+		// The cleanup of the round releases it when the round is gone
+		workflow.GetLogger(ctx).Error("Failed to settle stock", zap.String("order", order.ID), zap.Error(err))
+	}
+}
+
+// activityReserveStock reserves the item in the inventory
+func activityReserveStock(ctx context.Context, location, orderID, item string) error {
+	span := tracing.StartActivitySpan(ctx, "reserveStock", opentracing.Tags{"order": orderID, "item": item, "location": location})
+	defer span.Finish()
+
+	err := inventory.Database.Reserve(location, orderID, item)
+	if errors.Is(err, inventory.ErrOutOfStock) {
+		return cadence.NewCustomError(ErrReasonOutOfStock, item)
+	}
+	return err
+}
+
+// activityCommitStock takes the reserved item out of the inventory
+func activityCommitStock(ctx context.Context, location, orderID string) error {
+	span := tracing.StartActivitySpan(ctx, "commitStock", opentracing.Tags{"order": orderID, "location": location})
+	defer span.Finish()
+
+	return inventory.Database.Commit(location, orderID)
+}
+
+// activityReleaseStock puts the reserved item back in the inventory
+func activityReleaseStock(ctx context.Context, location, orderID string) error {
+	span := tracing.StartActivitySpan(ctx, "releaseStock", opentracing.Tags{"order": orderID, "location": location})
+	defer span.Finish()
+
+	return inventory.Database.Release(location, orderID)
+}
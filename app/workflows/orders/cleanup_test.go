@@ -0,0 +1,63 @@
+package orders
+
+import (
+	"programmingpercy/cadence-tavern/inventory"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"testing"
+	"time"
+
+	"go.uber.org/cadence/testsuite"
+)
+
+// reserveRound reserves a Beer for each order of the round at the tavern, the inventory starts with two
+func reserveRound(t *testing.T, orderIDs ...string) *inventory.MemoryInventory {
+	t.Helper()
+	stock := inventory.NewMemoryInventory(map[string]int{"Beer": 2})
+	for _, id := range orderIDs {
+		if err := stock.Reserve("tavern-1", id, "Beer"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inventory.Database = stock
+	return stock
+}
+
+func TestCleanupReleasesStockOfTerminatedRound(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite)
+	stock := reserveRound(t, "order-1", "order-2")
+	// order-2 completed before the round was terminated, so its Beer was served
+	if err := stock.Commit("tavern-1", "order-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The round never signals that it is done, like when it is terminated with the order workflow
+	env.ExecuteWorkflow(workflowCleanupRound, Cleanup{Location: "tavern-1", OrderIDs: []string{"order-1", "order-2"}, Timeout: 2 * time.Minute})
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := stock.Available("tavern-1", "Beer"); count != 1 {
+		t.Fatalf("%d Beer left, want the Beer of order-1 back", count)
+	}
+}
+
+func TestCleanupKeepsStockOfFinishedRound(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite)
+	stock := reserveRound(t, "order-1")
+
+	env.RegisterDelayedCallback(func() {
+		done, err := signals.Wrap(signals.LegacyVersion, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.SignalWorkflow(signalRoundDone, done)
+	}, time.Minute)
+	env.ExecuteWorkflow(workflowCleanupRound, Cleanup{Location: "tavern-1", OrderIDs: []string{"order-1"}, Timeout: 2 * time.Minute})
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := stock.Available("tavern-1", "Beer"); count != 1 {
+		t.Fatalf("%d Beer left, want the reservation of the finished round kept", count)
+	}
+}
@@ -0,0 +1,99 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/tracing"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/cadence/activity"
+)
+
+// DiscountTiers are the discounts given to returning customers, the Worker replaces this during startup
+var DiscountTiers = []DiscountTier{{MinVisits: 10, Percent: 5}}
+
+// DiscountTier gives Percent off to customers that visited at least MinVisits times
+type DiscountTier struct {
+	MinVisits int     `json:"minVisits"`
+	Percent   float32 `json:"percent"`
+}
+
+// Discount is the discount applied to an order
+type Discount struct {
+	Percent float32 `json:"percent"`
+	// Amount is how much was taken off the price
+	Amount models.Money `json:"amount"`
+	// Price is the price after the discount
+	Price models.Money `json:"price"`
+}
+
+// ParseDiscountTiers reads tiers written as visits=percent, such as 10=5
+func ParseDiscountTiers(raw []string) ([]DiscountTier, error) {
+	tiers := make([]DiscountTier, 0, len(raw))
+	for _, r := range raw {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("discount tier %q should be visits=percent", r)
+		}
+		visits, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("discount tier %q has bad visits: %v", r, err)
+		}
+		percent, err := strconv.ParseFloat(parts[1], 32)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("discount tier %q should have a percent between 0 and 100", r)
+		}
+		tiers = append(tiers, DiscountTier{MinVisits: visits, Percent: float32(percent)})
+	}
+	return tiers, nil
+}
+
+// discountFor returns the best tier the visits qualify for
+func discountFor(tiers []DiscountTier, visits int) float32 {
+	sorted := append([]DiscountTier(nil), tiers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinVisits < sorted[j].MinVisits })
+
+	var percent float32
+	for _, tier := range sorted {
+		if visits >= tier.MinVisits {
+			percent = tier.Percent
+		}
+	}
+	return percent
+}
+
+// activityApplyDiscount looks up how often the customer visited and discounts the price
+// The discount is reported as a metric tagged with the percent
+func activityApplyDiscount(ctx context.Context, location string, name string, price models.Money) (Discount, error) {
+	span := tracing.StartActivitySpan(ctx, "applyDiscount", opentracing.Tags{"customer": name, "location": location})
+	defer span.Finish()
+
+	visitor, err := customer.Database.Get(opentracing.ContextWithSpan(ctx, span), location, name)
+	if err != nil {
+		return Discount{}, err
+	}
+
+	percent := discountFor(DiscountTiers, visitor.TimesVisited)
+	discount := Discount{
+		Percent: percent,
+		Amount:  price.Percent(float64(percent)),
+	}
+	discount.Price, err = price.Sub(discount.Amount)
+	if err != nil {
+		return Discount{}, err
+	}
+
+	if percent > 0 {
+		scope := activity.GetMetricsScope(ctx).Tagged(map[string]string{
+			"percent": strconv.FormatFloat(float64(percent), 'f', -1, 32),
+		})
+		scope.Counter("order_discounts").Inc(1)
+		scope.Counter("order_discount_cents").Inc(discount.Amount.Minor)
+	}
+	return discount, nil
+}
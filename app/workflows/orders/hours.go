@@ -0,0 +1,235 @@
+package orders
+
+import (
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// ErrReasonClosed is the reason of orders refused because the tavern is closed, the details are when it was refused
+// It is the detail of the failed event of the order, which the API shows as the status of the order
+const ErrReasonClosed = "tavern-closed"
+
+// SignalOverride opens or closes the tavern regardless of the opening hours until a time, such as for a special event
+const SignalOverride = "hours-override"
+
+// OverrideSignalVersion is the version of the payloads of the override signal
+const OverrideSignalVersion = 1
+
+// hoursChangeID marks the runs that refuse orders outside of the OpeningHours, see newOpening
+const hoursChangeID = "opening-hours"
+
+var (
+	// OpeningHours are when the taverns take orders, the Worker replaces these during startup
+	OpeningHours = Hours{}
+
+	openingHoursMu sync.RWMutex
+)
+
+// SetOpeningHours replaces the OpeningHours while workflows might be reading them, used when the configuration is reloaded
+func SetOpeningHours(hours Hours) {
+	openingHoursMu.Lock()
+	defer openingHoursMu.Unlock()
+	OpeningHours = hours
+}
+
+// Span is a day the tavern is open, the minutes are counted from midnight
+// A close at or before the open is on the day after, so 16:00-02:00 is open past midnight
+type Span struct {
+	Day   time.Weekday `json:"day"`
+	Open  int          `json:"open"`
+	Close int          `json:"close"`
+}
+
+// Hours are the opening hours of the weekdays, the tavern is always open without any spans
+type Hours struct {
+	// Zone is the IANA zone the spans are in, UTC when empty
+	Zone  string `json:"zone,omitempty"`
+	Spans []Span `json:"spans,omitempty"`
+}
+
+// weekdays are the names the days of the opening hours are written with
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseOpeningHours reads the opening hours written as days=open-close, such as mon-thu=16:00-23:00 or sat,sun=12:00-02:00
+func ParseOpeningHours(cfg config.OpeningHours) (Hours, error) {
+	hours := Hours{Zone: cfg.TimeZone}
+	if _, err := time.LoadLocation(cfg.TimeZone); err != nil {
+		return Hours{}, fmt.Errorf("opening hours time zone %q is unknown: %v", cfg.TimeZone, err)
+	}
+	for _, raw := range cfg.Hours {
+		parts := strings.SplitN(raw, "=", 2)
+		if len(parts) != 2 {
+			return Hours{}, fmt.Errorf("opening hours %q should be days=open-close", raw)
+		}
+		days, err := parseDays(parts[0])
+		if err != nil {
+			return Hours{}, fmt.Errorf("opening hours %q: %v", raw, err)
+		}
+		times := strings.SplitN(parts[1], "-", 2)
+		if len(times) != 2 {
+			return Hours{}, fmt.Errorf("opening hours %q should be days=open-close", raw)
+		}
+		open, err := parseClock(times[0])
+		if err != nil {
+			return Hours{}, fmt.Errorf("opening hours %q: %v", raw, err)
+		}
+		close, err := parseClock(times[1])
+		if err != nil {
+			return Hours{}, fmt.Errorf("opening hours %q: %v", raw, err)
+		}
+		for _, day := range days {
+			hours.Spans = append(hours.Spans, Span{Day: day, Open: open, Close: close})
+		}
+	}
+	return hours, nil
+}
+
+// parseDays reads days written as mon, mon-fri or sat,sun, a range wraps around the end of the week
+func parseDays(raw string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(strings.ToLower(raw), ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q, use sun, mon, tue, wed, thu, fri or sat", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return nil, fmt.Errorf("unknown day %q, use sun, mon, tue, wed, thu, fri or sat", bounds[1])
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock reads a time of day written as 15:04 into the minutes since midnight
+func parseClock(raw string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("time %q should be written as 15:04", raw)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Open is true when the tavern is open at t
+func (h Hours) Open(t time.Time) bool {
+	if len(h.Spans) == 0 {
+		return true
+	}
+	// The zone was loaded when the hours were parsed
+	zone, err := time.LoadLocation(h.Zone)
+	if err != nil {
+		zone = time.UTC
+	}
+	local := t.In(zone)
+	minute := local.Hour()*60 + local.Minute()
+	yesterday := (local.Weekday() + 6) % 7
+	for _, span := range h.Spans {
+		overnight := span.Close <= span.Open
+		if span.Day == local.Weekday() && minute >= span.Open && (overnight || minute < span.Close) {
+			return true
+		}
+		if overnight && span.Day == yesterday && minute < span.Close {
+			return true
+		}
+	}
+	return false
+}
+
+// Override is the payload of the override signal
+type Override struct {
+	// Open takes orders while the tavern would be closed, otherwise orders are refused while it would be open
+	Open bool `json:"open"`
+	// Until is when the opening hours apply again, an override that already ended clears the one before it
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// opening decides whether the order workflow takes orders, it lives in the order workflow
+// The override is carried over when the workflow continues as new, so a special event outlasts the run
+type opening struct {
+	enabled  bool
+	hours    Hours
+	override *Override
+}
+
+// newOpening reads the OpeningHours as a side effect, so replays use the hours the run started with
+// Runs started before the opening hours existed have no side effect in their history to replay, they are always open
+func newOpening(ctx workflow.Context, override *Override) *opening {
+	if workflow.GetVersion(ctx, hoursChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		return &opening{}
+	}
+	encoded := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		openingHoursMu.RLock()
+		defer openingHoursMu.RUnlock()
+		return OpeningHours
+	})
+	var hours Hours
+	if err := encoded.Get(&hours); err != nil {
+		hours = Hours{}
+	}
+	return &opening{enabled: true, hours: hours, override: override}
+}
+
+// allow is true when orders are taken at now, an override that has not ended wins over the opening hours
+func (o *opening) allow(now time.Time) bool {
+	if !o.enabled {
+		return true
+	}
+	if o.override != nil && now.Before(o.override.Until) {
+		return o.override.Open
+	}
+	return o.hours.Open(now)
+}
+
+// state is the override that has not ended yet, passed on to the next run
+func (o *opening) state(now time.Time) *Override {
+	if o.override == nil || !now.Before(o.override.Until) {
+		return nil
+	}
+	return o.override
+}
+
+// receive applies an override signal, runs that do not check the opening hours drop them
+func (o *opening) receive(ctx workflow.Context, c workflow.Channel) {
+	logger := workflow.GetLogger(ctx)
+	var override Override
+	if err := signals.Receive(ctx, c, nil, &override); err != nil {
+		logger.Error("Dropped override signal that could not be decoded", zap.Error(err))
+		return
+	}
+	if !o.enabled {
+		logger.Warn("Dropped override signal, this run does not check the opening hours")
+		return
+	}
+	o.override = &override
+	logger.Info("Opening hours are overridden", zap.Bool("open", override.Open), zap.Time("until", override.Until), zap.String("reason", override.Reason))
+}
+
+// closed refuses the order, it is recorded as failed with ErrReasonClosed and the callback is delivered
+// ctx needs to have ActivityOptions applied
+func closed(ctx workflow.Context, order Order) {
+	workflow.GetLogger(ctx).Info("Tavern is closed", zap.String("customer", order.By), zap.String("order", order.ID))
+	workflow.GetMetricsScope(ctx).Counter("order_closed").Inc(1)
+	recordOrderEvent(ctx, order, orderstore.EventFailed, ErrReasonClosed)
+	deliverCallback(ctx, order, cadence.NewCustomError(ErrReasonClosed, workflow.Now(ctx)))
+}
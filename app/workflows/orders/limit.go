@@ -0,0 +1,113 @@
+package orders
+
+import (
+	"programmingpercy/cadence-tavern/orderstore"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// ErrReasonCustomerCutOff is the reason of orders refused because the customer ordered too much lately
+// It is the detail of the failed event of the order, which the API shows as the status of the order
+const ErrReasonCustomerCutOff = "customer-cut-off"
+
+// limitChangeID marks the runs that read the CustomerLimit, see newLimiter
+const limitChangeID = "customer-limit"
+
+// Limit is how many orders a customer may make within the window
+type Limit struct {
+	// Orders is the most orders in the window, 0 disables the limit
+	Orders int
+	Window time.Duration
+}
+
+var (
+	// CustomerLimit is how much a customer may order, the Worker replaces this during startup
+	CustomerLimit = Limit{Orders: 10, Window: time.Hour}
+
+	customerLimitMu sync.RWMutex
+)
+
+// SetCustomerLimit replaces the CustomerLimit while workflows might be reading it, used when the configuration is reloaded
+func SetCustomerLimit(limit Limit) {
+	customerLimitMu.Lock()
+	defer customerLimitMu.Unlock()
+	CustomerLimit = limit
+}
+
+// limiter is the orders each customer made within the window, it lives in the order workflow
+// The times are carried over when the workflow continues as new, so restarting does not reset the limit
+type limiter struct {
+	limit  Limit
+	recent map[string][]time.Time
+}
+
+// newLimiter reads the CustomerLimit as a side effect, so replays use the limit the run started with
+// Runs started before the limit existed have no side effect in their history to replay, they are not limited
+func newLimiter(ctx workflow.Context, recent map[string][]time.Time) *limiter {
+	if recent == nil {
+		recent = make(map[string][]time.Time)
+	}
+	if workflow.GetVersion(ctx, limitChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		return &limiter{recent: recent}
+	}
+	encoded := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		customerLimitMu.RLock()
+		defer customerLimitMu.RUnlock()
+		return CustomerLimit
+	})
+	var limit Limit
+	if err := encoded.Get(&limit); err != nil {
+		limit = Limit{}
+	}
+	return &limiter{limit: limit, recent: recent}
+}
+
+// allow records the order of the customer at now, it is false when the customer already made too many orders
+// Refused orders are not recorded, so a customer is served again once the window has passed their earlier orders
+func (l *limiter) allow(customerName string, now time.Time) bool {
+	if l.limit.Orders <= 0 {
+		return true
+	}
+	recent := l.within(l.recent[customerName], now)
+	if len(recent) >= l.limit.Orders {
+		l.recent[customerName] = recent
+		return false
+	}
+	l.recent[customerName] = append(recent, now)
+	return true
+}
+
+// state is the orders still within the window, passed on to the next run
+func (l *limiter) state(now time.Time) map[string][]time.Time {
+	state := make(map[string][]time.Time)
+	for customerName, times := range l.recent {
+		if recent := l.within(times, now); len(recent) > 0 {
+			state[customerName] = recent
+		}
+	}
+	return state
+}
+
+// within drops the times that are older than the window
+func (l *limiter) within(times []time.Time, now time.Time) []time.Time {
+	var kept []time.Time
+	for _, t := range times {
+		if now.Sub(t) < l.limit.Window {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// cutOff refuses the order, it is recorded as failed with ErrReasonCustomerCutOff and the callback is delivered
+// ctx needs to have ActivityOptions applied
+func cutOff(ctx workflow.Context, order Order) {
+	workflow.GetLogger(ctx).Info("Customer is cut off", zap.String("customer", order.By), zap.String("order", order.ID))
+	workflow.GetMetricsScope(ctx).Counter("order_cut_off").Inc(1)
+	recordOrderEvent(ctx, order, orderstore.EventFailed, ErrReasonCustomerCutOff)
+	deliverCallback(ctx, order, cadence.NewCustomError(ErrReasonCustomerCutOff))
+}
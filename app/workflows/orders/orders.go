@@ -4,26 +4,99 @@ import (
 	"context"
 	"errors"
 	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/callback"
+	"programmingpercy/cadence-tavern/workflows/flags"
+	"programmingpercy/cadence-tavern/workflows/history"
+	"programmingpercy/cadence-tavern/workflows/leaderboard"
+	"programmingpercy/cadence-tavern/workflows/pause"
+	"programmingpercy/cadence-tavern/workflows/payments"
+	"programmingpercy/cadence-tavern/workflows/pouring"
+	"programmingpercy/cadence-tavern/workflows/pricing"
+	"programmingpercy/cadence-tavern/workflows/profiles"
+	"programmingpercy/cadence-tavern/workflows/receipts"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/retries"
+	"programmingpercy/cadence-tavern/workflows/seating"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"programmingpercy/cadence-tavern/workflows/slo"
+	"programmingpercy/cadence-tavern/workflows/sobriety"
+	"programmingpercy/cadence-tavern/workflows/tabs"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/cadence"
 	"go.uber.org/cadence/activity"
 	"go.uber.org/cadence/workflow"
 	"go.uber.org/zap"
 )
 
-// Order is a simple type to represent orders made
-type Order struct {
-	Item  string  `json:"item"`
-	Price float32 `json:"price"`
-	By    string  `json:"by"`
-}
+// Order is a simple type to represent orders made, see models.Order
+type Order = models.Order
 
 func init() {
-	workflow.Register(WorkflowOrder)
-	workflow.Register(workflowProcessOrder)
+	registry.Workflow(WorkflowOrder)
+	registry.Workflow(workflowOrderContinued)
+	registry.Workflow(workflowOrderCarried)
+	registry.Workflow(workflowProcessOrder)
+	registry.Workflow(workflowProcessRound)
+	registry.Workflow(workflowCleanupRound)
+
+	registry.Signal(SignalOrder, OrderSignalVersion, OrderSignal{})
+	// The override is left out, only admins may change the opening hours through /orders/hours/override
 
-	activity.Register(activityIsCustomerLegal)
-	activity.Register(activitiyFindCustomerByName)
+	registry.Activity("tavern.orders.isCustomerLegal", activityIsCustomerLegal)
+	registry.Activity("tavern.orders.findCustomer", activitiyFindCustomerByName)
+	registry.Activity("tavern.orders.findCustomerByLoyaltyID", activityFindCustomerByLoyaltyID)
+	registry.Activity("tavern.orders.checkBanned", activityCheckBanned)
+	registry.Activity("tavern.orders.recordEvent", activityRecordOrderEvent)
+	registry.Activity("tavern.orders.applyDiscount", activityApplyDiscount)
+	registry.Activity("tavern.orders.status", activityOrderStatus)
+	registry.Activity("tavern.orders.deadLetter", activityDeadLetterOrders)
+	registry.Activity("tavern.inventory.reserve", activityReserveStock)
+	registry.Activity("tavern.inventory.commit", activityCommitStock)
+	registry.Activity("tavern.inventory.release", activityReleaseStock)
+}
+
+// The reasons of the errors returned when an order is rejected
+// They are CustomErrors so they are never retried
+const (
+	ErrReasonCustomerBanned   = "customer-banned"
+	ErrReasonCustomerUnderage = "customer-underage"
+	ErrReasonCustomerNotFound = "customer-not-found"
+	// ErrReasonInvalidOrder is returned before anything is done for an order that could never be served
+	ErrReasonInvalidOrder = "invalid-order"
+)
+
+// validateChangeID marks the runs that validate the order before processing it, see validateOrder
+const validateChangeID = "validate-order"
+
+// leaderboardChangeID marks the runs that signal the spend of completed orders to the leaderboard, see recordSpend
+const leaderboardChangeID = "orders-leaderboard"
+
+// pausedContinueChangeID marks the runs that wait with continuing as new until they are resumed
+// The new run starts unpaused, so continuing a paused run would lose the pause
+const pausedContinueChangeID = "orders-continue-unpaused"
+
+// pricingChangeID marks the runs that validate the price of an order and convert it into the charge currency
+const pricingChangeID = "order-pricing"
+
+// roundsChangeID marks the runs that collect the orders into rounds, runs from before process every order alone
+const roundsChangeID = "order-rounds"
+
+// traceChangeID marks the runs that start a span for every order they process, see handleOrder
+const traceChangeID = "order-workflow-span"
+
+// QueryPending is the query used to look at the rounds still collecting orders
+// Open rounds are processed before the workflow continues as new, so a new run starts without any
+const QueryPending = "pending"
+
+// WorkflowID is the workflow ID of the order workflow of a location, there is only one open at a time in each tavern
+func WorkflowID(loc string) string {
+	return location.WorkflowID(loc, "orders")
 }
 
 // MaxSignalsAmount is how many signals we accept before restart
@@ -33,6 +106,23 @@ const MaxSignalsAmount = 3
 // WorkflowOrder will handle incomming Orders
 // This is exposed so we can use it in api
 func WorkflowOrder(ctx workflow.Context) error {
+	return runOrders(ctx, Carried{})
+}
+
+// workflowOrderContinued is the order workflow continued as a new run while customers have recent orders, so the
+// limit of each customer carries over. WorkflowOrder can not take them as an argument without breaking open runs
+// Runs now continue as workflowOrderCarried, it is kept registered so runs continued before that can finish
+func workflowOrderContinued(ctx workflow.Context, recent map[string][]time.Time) error {
+	return runOrders(ctx, Carried{Recent: recent})
+}
+
+// workflowOrderCarried is the order workflow continued as a new run, it carries the limits and the stats
+func workflowOrderCarried(ctx workflow.Context, carried Carried) error {
+	return runOrders(ctx, carried)
+}
+
+// runOrders is the loop of the order workflow, shared by the first and the continued runs
+func runOrders(ctx workflow.Context, carried Carried) error {
 	ao := workflow.ActivityOptions{
 		ScheduleToStartTimeout: time.Minute * 60,
 		StartToCloseTimeout:    time.Minute * 60,
@@ -45,43 +135,124 @@ func WorkflowOrder(ctx workflow.Context) error {
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Waiting for Orders")
 
+	// While the workflow is paused no rounds are started, orders keep queueing in the signal channel until it is resumed
+	paused, err := pause.Handle(ctx)
+	if err != nil {
+		return err
+	}
+	// stats are the orders of every run since the first one started
+	stats := carried.Stats
+	if stats.Since.IsZero() {
+		stats.Since = workflow.Now(ctx)
+	}
+	err = workflow.SetQueryHandler(ctx, QueryStats, func() (Stats, error) {
+		return stats, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Runs started before rounds existed started a child for every order, they keep doing so to replay the same
+	rounds := workflow.GetVersion(ctx, roundsChangeID, workflow.DefaultVersion, 1) != workflow.DefaultVersion
+	dispatch := func(round Round) {
+		if err := paused.Wait(ctx); err != nil {
+			logger.Error("Stopped waiting for resume, the round is processed anyway", zap.Error(err))
+		}
+		var failed []FailedOrder
+		if rounds {
+			failed = processRound(ctx, round)
+		} else {
+			failed = processAlone(ctx, round.Orders[0])
+		}
+		stats.record(round, failed)
+	}
+	holdWhilePaused := workflow.GetVersion(ctx, pausedContinueChangeID, workflow.DefaultVersion, 1) != workflow.DefaultVersion
+
 	// restartWorkflow
 	var restartWorkflow bool
 	// signalCounter
 	signalCount := 0
+	// open are the rounds still collecting orders, in the order they were opened
+	var open []*Round
 
-	// Preconfigure ChildWorkflow Options
-	orderWaiterCfg := workflow.ChildWorkflowOptions{
-		ExecutionStartToCloseTimeout: time.Minute * 2, // Each Order can tops take 2 min
+	// Every run answers the pending query for itself
+	err = workflow.SetQueryHandler(ctx, QueryPending, func() ([]Round, error) {
+		pending := make([]Round, 0, len(open))
+		for _, round := range open {
+			pending = append(pending, *round)
+		}
+		return pending, nil
+	})
+	if err != nil {
+		return err
 	}
+	// guard restarts the workflow early if its history grows too long before enough signals are received
+	guard := history.NewGuard(ctx)
+	// limits cuts off customers that order too much
+	limits := newLimiter(ctx, carried.Recent)
+	// hours refuses orders while the tavern is closed
+	hours := newOpening(ctx, carried.Override)
 
 	// Grab the Selector from the workflow Context,
 	selector := workflow.NewSelector(ctx)
-	// For ever running loop
-	for {
-		// Get the Signal used to identify an Event, we named our Order event into order
-		signalChan := workflow.GetSignalChannel(ctx, "order")
-
-		// We add a "Receiver" to the Selector, The receiver is a function that will trigger once a new Signal is recieved
-		selector.AddReceive(signalChan, func(c workflow.Channel, more bool) {
-			// Create the Order to marshal the Input into
-			var order Order
-			// Receive will read input data into the struct
-			c.Receive(ctx, &order)
-
-			// increment signal counter
-			signalCount++
-			// Create ctx for Child flow
-			orderCtx := workflow.WithChildOptions(ctx, orderWaiterCfg)
-			// Trigger the child workflow
-			waiter := workflow.ExecuteChildWorkflow(orderCtx, workflowProcessOrder, order)
-			if err := waiter.Get(ctx, nil); err != nil {
-				workflow.GetLogger(ctx).Error("Order has failed.", zap.Error(err))
-			}
+	// Get the Signal used to identify an Event, we named our Order event into order
+	signalChan := workflow.GetSignalChannel(ctx, SignalOrder)
+
+	// We add a "Receiver" to the Selector, The receiver is a function that will trigger once a new Signal is recieved
+	selector.AddReceive(signalChan, func(c workflow.Channel, more bool) {
+		// Create the Order to marshal the Input into
+		var order Order
+		// Receive will read input data into the struct, whatever version of the signal it was sent as
+		err := signals.Receive(ctx, c, orderDecoders, &order)
+
+		// increment signal counter
+		signalCount++
+		if err != nil {
+			logger.Error("Dropped order signal that could not be decoded", zap.Error(err))
+			return
+		}
+		if !hours.allow(workflow.Now(ctx)) {
+			closed(ctx, order)
+			return
+		}
+		if !limits.allow(order.By, workflow.Now(ctx)) {
+			cutOff(ctx, order)
+			return
+		}
 
+		if !rounds {
+			dispatch(Round{Location: order.Location, Customer: order.By, Orders: []Order{order}})
+			return
+		}
+		// Orders by a customer that already has an open round join it
+		if round := findRound(open, order); round != nil {
+			round.Orders = append(round.Orders, order)
+			return
+		}
+
+		round := &Round{Location: order.Location, Customer: order.By, Orders: []Order{order}}
+		window := roundWindow(ctx)
+		if window <= 0 {
+			dispatch(*round)
+			return
+		}
+		// The round is processed once the window has passed
+		open = append(open, round)
+		selector.AddFuture(workflow.NewTimer(ctx, window), func(f workflow.Future) {
+			open = removeRound(open, round)
+			dispatch(*round)
 		})
+	})
+
+	// Special events open or close the tavern outside of the opening hours
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalOverride), func(c workflow.Channel, more bool) {
+		hours.receive(ctx, c)
+	})
 
-		if signalCount >= MaxSignalsAmount {
+	// For ever running loop
+	for {
+		// A paused run is not continued, it continues with the first signal after it is resumed
+		if (signalCount >= MaxSignalsAmount || guard.Reached(ctx)) && !restartWorkflow && !(holdWhilePaused && paused.Paused) {
 			// We should restart
 			// Add a Default to the selector, which will make sure that this is triggered once all jobs in queue are done
 			selector.AddDefault(func() {
@@ -93,57 +264,392 @@ func WorkflowOrder(ctx workflow.Context) error {
 
 		// If its time to restart, return the ContinueAsNew
 		if restartWorkflow {
-			return workflow.NewContinueAsNewError(ctx, WorkflowOrder)
+			// Rounds that are still open would be lost by the new run, so they are processed right away
+			for _, round := range open {
+				dispatch(*round)
+			}
+			return workflow.NewContinueAsNewError(ctx, workflowOrderCarried, Carried{
+				Recent:   limits.state(workflow.Now(ctx)),
+				Stats:    stats,
+				Override: hours.state(workflow.Now(ctx)),
+			})
 		}
 
 	}
 }
 
-// workflowProcessOrder is used to handle orders and will be ran as a CHILD
+// orderActivityOptions are the activity options used while processing orders
+var orderActivityOptions = workflow.ActivityOptions{
+	ScheduleToStartTimeout: time.Minute,
+	StartToCloseTimeout:    time.Minute,
+	HeartbeatTimeout:       time.Second * 20,
+	// Here we will Add Retry policies etc later
+}
+
+// workflowProcessOrder is used to handle a single order and will be ran as a CHILD
+// Orders are processed in rounds now, runs started before rounds existed still start one for every order
 func workflowProcessOrder(ctx workflow.Context, order Order) error {
 
 	logger := workflow.GetLogger(ctx)
 	logger.Info("process order workflow started")
-	ao := workflow.ActivityOptions{
-		ScheduleToStartTimeout: time.Minute,
-		StartToCloseTimeout:    time.Minute,
-		HeartbeatTimeout:       time.Second * 20,
-		// Here we will Add Retry policies etc later
-	}
 	// Add the Options to Context to apply configurations
-	ctx = workflow.WithActivityOptions(ctx, ao)
+	ctx = workflow.WithActivityOptions(ctx, orderActivityOptions)
+
+	return handleOrder(ctx, order)
+}
+
+// handleOrder processes the order and records its lifecycle, ctx needs to have ActivityOptions applied
+func handleOrder(ctx workflow.Context, order Order) error {
+	// Continue the trace of the order in all activities, runs started before the workflow had a span of its own
+	// carry the trace of the API on as it is
+	if workflow.GetVersion(ctx, traceChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		ctx = tracing.WithTrace(ctx, order.Trace)
+	} else {
+		var finish func()
+		ctx, finish = tracing.StartWorkflowSpan(ctx, "processOrder", order.Trace, opentracing.Tags{
+			"order.id": order.ID,
+			"item":     order.Item,
+			"customer": order.By,
+		})
+		defer finish()
+	}
+
+	recordOrderEvent(ctx, order, orderstore.EventReceived, "")
+	if err := validateOrder(ctx, order); err != nil {
+		recordOrderEvent(ctx, order, orderstore.EventFailed, err.Error())
+		return err
+	}
+	started := workflow.Now(ctx)
+
+	// The item is set aside while the order is processed
+	err := reserveStock(ctx, order)
+	if err == nil {
+		err = watchOrder(ctx, &order, func(ctx workflow.Context) error {
+			return processOrder(ctx, &order)
+		})
+		settleStock(ctx, order, err == nil)
+	}
+	slo.Record(ctx, slo.Orders, started, err)
+	if err != nil {
+		// The drink was not served, so it does not count towards the intake
+		sobriety.Release(ctx, order.Location, order.By, order.ID, order.Item)
+		recordOrderEvent(ctx, order, orderstore.EventFailed, err.Error())
+		return err
+	}
+
+	recordOrderEvent(ctx, order, orderstore.EventCompleted, "")
+	recordSpend(ctx, order)
+	deliverCallback(ctx, order, nil)
+	return nil
+}
+
+// recordSpend adds what the customer paid to the leaderboard of the location
+// Failing to reach the leaderboard does not fail the order, it is only logged
+// Runs started before the leaderboard existed did not signal it, they skip it so they replay the same
+func recordSpend(ctx workflow.Context, order Order) {
+	if workflow.GetVersion(ctx, leaderboardChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		return
+	}
+	spend := leaderboard.Spend{Customer: order.By, Amount: float32(order.Price.Float64()), OrderID: order.ID}
+	if err := leaderboard.Record(ctx, order.Location, spend); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to record spend on the leaderboard", zap.String("order", order.ID), zap.Error(err))
+	}
+}
+
+// validateOrder rejects the order with ErrReasonInvalidOrder before any of its activities run
+// Runs started before orders were validated did not stop early, they are not validated so they replay the same
+func validateOrder(ctx workflow.Context, order Order) error {
+	if workflow.GetVersion(ctx, validateChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		return nil
+	}
+	if err := order.Validate(); err != nil {
+		workflow.GetLogger(ctx).Warn("Rejected invalid order", zap.String("order", order.ID), zap.Error(err))
+		return cadence.NewCustomError(ErrReasonInvalidOrder, err.Error())
+	}
+	return nil
+}
 
-	// Find Customer from Repo
+// deliverCallback pushes the outcome of the order to its CallbackURL, orders without one are skipped
+// A failed order is only delivered once it is dead lettered, since the round retries it until then
+func deliverCallback(ctx workflow.Context, order Order, orderErr error) {
+	if order.CallbackURL == "" {
+		return
+	}
+	payload := callback.Payload{
+		Type:       callback.TypeOrder,
+		ID:         order.ID,
+		Status:     orderstore.EventCompleted,
+		Result:     order,
+		OccurredAt: workflow.Now(ctx),
+	}
+	if orderErr != nil {
+		payload.Status = orderstore.EventFailed
+		payload.Error = orderErr.Error()
+	}
+	// The ID is not scoped to a location, order IDs are unique in every tavern
+	err := callback.Deliver(ctx, "callback-order-"+order.ID, order.CallbackURL, payload)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Failed to start the callback", zap.String("order", order.ID), zap.Error(err))
+	}
+}
+
+// processOrder runs the steps of an order, ctx needs to have ActivityOptions applied
+// The price of the order is updated with any discounts
+func processOrder(ctx workflow.Context, order *Order) error {
+	logger := workflow.GetLogger(ctx)
+
+	// Read the feature flags, they are recorded in history so replays see the same values
+	featureFlags, err := flags.Load(ctx)
+	if err != nil {
+		logger.Error("Failed to load feature flags", zap.Error(err))
+		return err
+	}
+
+	if featureFlags.HappyHour {
+		order.Price = order.Price.Percent(50)
+	}
+
+	// The price is charged in the currency of the payments, runs started before prices had a currency skip it
+	if workflow.GetVersion(ctx, pricingChangeID, workflow.DefaultVersion, 1) != workflow.DefaultVersion {
+		order.Price, err = pricing.ToChargeCurrency(ctx, order.Price)
+		if err != nil {
+			logger.Error("Failed to price order", zap.Error(err))
+			return err
+		}
+	}
+
+	// The checks read repositories, transient failures are retried but rejections are answered right away
+	// The lookup profile sets their timeouts, the retry policy is kept so rejections are never retried
+	checkCtx := profiles.WithActivityProfile(ctx, profiles.Lookup)
+	checkCtx = workflow.WithRetryPolicy(checkCtx, retries.ActivityPolicy(ErrReasonCustomerBanned, ErrReasonCustomerUnderage, ErrReasonCustomerNotFound))
+
+	// Find Customer from Repo, orders made with a loyalty card are looked up by it
 	var cust customer.Customer
-	err := workflow.ExecuteActivity(ctx, activitiyFindCustomerByName, order.By).Get(ctx, &cust)
+	if order.LoyaltyID != "" {
+		err = retries.Execute(checkCtx, retries.Default, &cust, activityFindCustomerByLoyaltyID, order.Location, order.LoyaltyID)
+	} else {
+		err = retries.Execute(checkCtx, retries.Default, &cust, activitiyFindCustomerByName, order.Location, order.By)
+	}
 
 	if err != nil {
 		logger.Error("Customer is not in the Tavern", zap.Error(err))
 		return err
 	}
+	order.By = cust.Name
+
+	err = retries.Execute(checkCtx, retries.Default, nil, activityCheckBanned, cust)
+	if err != nil {
+		logger.Error("Customer is banned", zap.Error(err))
+		return err
+	}
 
-	var allowed bool
-	err = workflow.ExecuteActivity(ctx, activityIsCustomerLegal, cust).Get(ctx, &allowed)
+	// The order is served at the table of the customer
+	table, err := seating.Find(ctx, order.Location, order.By)
 	if err != nil {
-		logger.Error("Customer is not of age", zap.Error(err))
+		logger.Error("Failed to find table", zap.Error(err))
 		return err
 	}
+	order.Table = table.ID
 
-	logger.Info("Order made", zap.String("item", order.Item), zap.Float32("price", order.Price))
+	// Returning customers get a discount depending on how often they visit
+	var discount Discount
+	err = workflow.ExecuteActivity(ctx, activityApplyDiscount, order.Location, order.By, order.Price).Get(ctx, &discount)
+	if err != nil {
+		logger.Error("Failed to apply discount", zap.Error(err))
+		return err
+	}
+	order.Price = discount.Price
+	order.Discount = discount.Amount
+
+	// When payment is required the customer pays upfront, the charge is compensated if the order is rejected
+	var charge *payments.Charge
+	if featureFlags.RequirePayment {
+		c, err := payments.ChargeCustomer(ctx, order.By, order.Price, chargeReference(ctx, *order))
+		if err != nil {
+			logger.Error("Failed to charge customer", zap.Error(err))
+			return err
+		}
+		charge = &c
+	}
+
+	if featureFlags.EnforceAgeCheck {
+		var allowed bool
+		err = retries.Execute(checkCtx, retries.Default, &allowed, activityIsCustomerLegal, cust)
+		if err != nil {
+			logger.Error("Customer is not of age", zap.Error(err))
+			if charge != nil {
+				if refundErr := payments.RefundCustomer(ctx, *charge); refundErr != nil {
+					logger.Error("Failed to refund customer", zap.Error(refundErr))
+				}
+			}
+			return err
+		}
+	}
+
+	// Customers that had too much are not served more alcohol until their tab is settled
+	if err := sobriety.Check(ctx, order.Location, order.By, order.ID, order.Item); err != nil {
+		logger.Error("Customer has had too much", zap.Error(err))
+		if charge != nil {
+			if refundErr := payments.RefundCustomer(ctx, *charge); refundErr != nil {
+				logger.Error("Failed to refund customer", zap.Error(refundErr))
+			}
+		}
+		return err
+	}
+
+	recordOrderEvent(ctx, *order, orderstore.EventVerified, "")
+
+	// The drink is poured by the bar equipment, which reports back once it is done
+	if _, err := pouring.Pour(ctx, order.Location, order.ID, order.Item); err != nil {
+		logger.Error("Failed to pour order", zap.Error(err))
+		if charge != nil {
+			if refundErr := payments.RefundCustomer(ctx, *charge); refundErr != nil {
+				logger.Error("Failed to refund customer", zap.Error(refundErr))
+			}
+		}
+		return err
+	}
+
+	// Orders that are not paid upfront are put on the tab of the customer
+	if charge == nil {
+		item, err := signals.Wrap(tabs.SignalVersion, tabs.Item{
+			Item:  order.Item,
+			Price: order.Price,
+		})
+		if err != nil {
+			return err
+		}
+		err = workflow.SignalExternalWorkflow(ctx, tabs.WorkflowID(order.Location, order.By), "", tabs.SignalAdd, item).Get(ctx, nil)
+		if err != nil {
+			logger.Error("Failed to put order on tab", zap.Error(err))
+			return err
+		}
+	}
+
+	// Orders paid upfront get their own receipt
+	if charge != nil {
+		url, err := receipts.Generate(ctx, receipts.Receipt{
+			ID:       charge.Reference,
+			Customer: order.By,
+			Lines:    []receipts.Line{{Item: order.Item, Price: order.Price}},
+			Total:    order.Price,
+			ChargeID: charge.ID,
+		})
+		if err != nil {
+			logger.Error("Failed to generate receipt", zap.Error(err))
+		} else {
+			logger.Info("Receipt generated", zap.String("url", url))
+			// The URL is recorded with the next events, so the order status shows it to the customer
+			order.ReceiptURL = url
+		}
+	}
+
+	recordOrderEvent(ctx, *order, orderstore.EventPrepared, "")
+
+	logger.Info("Order made", zap.String("item", order.Item), zap.Stringer("price", order.Price), zap.String("table", order.Table))
 	return nil
 
 }
 
-// activityFindCustomerByName is used to find the Customer is in the Tavern
-func activitiyFindCustomerByName(ctx context.Context, name string) (customer.Customer, error) {
-	return customer.Database.Get(name)
+// chargeReference identifies the charge of the order, the payment provider uses it as idempotency key
+// Every order of a round is processed by the same child workflow, the order ID keeps their charges and receipts apart
+func chargeReference(ctx workflow.Context, order Order) string {
+	id := workflow.GetInfo(ctx).WorkflowExecution.ID
+	if order.ID == "" {
+		return id
+	}
+	return id + "/" + order.ID
+}
+
+// recordOrderEvent appends a lifecycle event of the order to the order store
+// Failing to record an event does not fail the order, it is only logged
+func recordOrderEvent(ctx workflow.Context, order Order, eventType string, detail string) {
+	if order.ID == "" {
+		return
+	}
+	event := orderstore.Event{
+		OrderID:    order.ID,
+		Type:       eventType,
+		Detail:     detail,
+		Item:       order.Item,
+		By:         order.By,
+		Price:      float32(order.Price.Float64()),
+		Discount:   float32(order.Discount.Float64()),
+		ReceiptURL: order.ReceiptURL,
+	}
+	if err := workflow.ExecuteActivity(ctx, activityRecordOrderEvent, event).Get(ctx, nil); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to record order event", zap.String("event", eventType), zap.Error(err))
+	}
+}
+
+// activityFindCustomerByName is used to find the Customer is in the Tavern of the location
+func activitiyFindCustomerByName(ctx context.Context, location string, name string) (customer.Customer, error) {
+	span := tracing.StartActivitySpan(ctx, "findCustomerByName", opentracing.Tags{"customer": name, "location": location})
+	defer span.Finish()
+
+	// The ban of the customer is checked on what is read, so it is read past the cache
+	cust, err := customer.Database.Get(customer.Uncached(opentracing.ContextWithSpan(ctx, span)), location, name)
+	if errors.Is(err, customer.ErrNoSuchCustomer) {
+		return cust, cadence.NewCustomError(ErrReasonCustomerNotFound, name)
+	}
+	return cust, err
+}
+
+// activityFindCustomerByLoyaltyID is used to find the Customer holding the loyalty card in the Tavern of the location
+func activityFindCustomerByLoyaltyID(ctx context.Context, location string, loyaltyID string) (customer.Customer, error) {
+	span := tracing.StartActivitySpan(ctx, "findCustomerByLoyaltyID", opentracing.Tags{"loyaltyId": loyaltyID, "location": location})
+	defer span.Finish()
+
+	cust, err := customer.Database.GetByLoyaltyID(opentracing.ContextWithSpan(ctx, span), location, loyaltyID)
+	if errors.Is(err, customer.ErrNoSuchCustomer) {
+		return cust, cadence.NewCustomError(ErrReasonCustomerNotFound, loyaltyID)
+	}
+	return cust, err
+}
+
+// activityRecordOrderEvent is used to append an event to the order store
+func activityRecordOrderEvent(ctx context.Context, event orderstore.Event) error {
+	span := tracing.StartActivitySpan(ctx, "recordOrderEvent", opentracing.Tags{
+		"event":    event.Type,
+		"item":     event.Item,
+		"customer": event.By,
+	})
+	defer span.Finish()
+	// Failed orders are always worth looking at, no matter the sampling rate
+	if event.Type == orderstore.EventFailed {
+		tracing.ForceSample(span)
+	}
+
+	event.OccurredAt = time.Now()
+	if err := orderstore.Events.Append(ctx, event); err != nil {
+		return err
+	}
+	// The index is caught up by the API when this fails, retrying would append the event twice
+	err := orderstore.Index.UpdateOrderStatus(ctx, event.OrderID, event.Type, event.OccurredAt)
+	if err != nil && !errors.Is(err, orderstore.ErrNoSuchOrder) {
+		activity.GetLogger(ctx).Warn("Failed to update the order index", zap.String("order", event.OrderID), zap.Error(err))
+	}
+	return nil
+}
+
+// activityCheckBanned is used to reject orders from banned customers
+func activityCheckBanned(ctx context.Context, visitor customer.Customer) error {
+	span := tracing.StartActivitySpan(ctx, "checkBanned", opentracing.Tags{"customer": visitor.Name})
+	defer span.Finish()
+
+	if visitor.Banned {
+		return cadence.NewCustomError(ErrReasonCustomerBanned, visitor.Name)
+	}
+	return nil
 }
 
 // activityIsCustomerLegal is used to check the age of the customer
 func activityIsCustomerLegal(ctx context.Context, visitor customer.Customer) (bool, error) {
+	span := tracing.StartActivitySpan(ctx, "isCustomerLegal", opentracing.Tags{"customer": visitor.Name})
+	defer span.Finish()
 
 	if visitor.Age < 18 {
-		return false, errors.New("customer is not old enough, dont serve him")
+		return false, cadence.NewCustomError(ErrReasonCustomerUnderage, "customer is not old enough, dont serve him")
 	}
 	return true, nil
 }
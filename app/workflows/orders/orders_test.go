@@ -0,0 +1,103 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/equipment"
+	"programmingpercy/cadence-tavern/inventory"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/tables"
+	"programmingpercy/cadence-tavern/workflows/retries"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/encoded"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// newTestEnv returns a test environment with empty in memory stores holding the customers
+// The bar equipment pours right away and signals to other workflows, such as tabs and the leaderboard, are accepted
+func newTestEnv(t *testing.T, suite *testsuite.WorkflowTestSuite, customers ...customer.Customer) *testsuite.TestWorkflowEnvironment {
+	t.Helper()
+	customer.Database = customer.NewMemoryCustomers()
+	orderstore.Events = orderstore.NewMemoryStore()
+	orderstore.DeadLetters = orderstore.Events.(orderstore.DeadLetterStore)
+	orderstore.Index = orderstore.Events.(orderstore.IndexStore)
+	inventory.Database = inventory.NewMemoryInventory(nil)
+	tables.Database = tables.NewMemoryTables(nil)
+	for _, c := range customers {
+		if err := customer.Database.Update(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	suite.SetLogger(zap.NewNop())
+	env := suite.NewTestWorkflowEnvironment()
+	env.OnActivity("tavern.equipment.pour", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(equipment.Result{}, nil)
+	env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	return env
+}
+
+// testOrder is an order of a beer by the customer
+func testOrder(id, by string) Order {
+	return Order{ID: id, Item: "Beer", By: by, Price: models.NewMoney(models.Currency, 5)}
+}
+
+// signalOrder sends the order to the order workflow of the environment, like the API does
+func signalOrder(t *testing.T, env *testsuite.TestWorkflowEnvironment, order Order) {
+	t.Helper()
+	payload, err := NewOrderSignal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.SignalWorkflow(SignalOrder, payload)
+}
+
+// continuedAsNew is true when the workflow closed by continuing as a new run
+func continuedAsNew(err error) bool {
+	var continued *workflow.ContinueAsNewError
+	return errors.As(err, &continued)
+}
+
+func TestInvalidOrdersAreRejected(t *testing.T) {
+	negative := testOrder("order-1", "Percy")
+	negative.Price = models.NewMoney(models.Currency, -5)
+	for name, order := range map[string]Order{
+		"empty name":     testOrder("order-1", " "),
+		"negative price": negative,
+	} {
+		var suite testsuite.WorkflowTestSuite
+		env := newTestEnv(t, &suite, customer.Customer{Name: "Percy", Age: 30})
+		var activities []string
+		env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args encoded.Values) {
+			activities = append(activities, info.ActivityType.Name)
+		})
+
+		env.ExecuteWorkflow(workflowProcessOrder, order)
+		err := env.GetWorkflowError()
+		var custom *cadence.CustomError
+		if !errors.As(err, &custom) || custom.Reason() != ErrReasonInvalidOrder {
+			t.Errorf("%s: order returned %v, want %s", name, err, ErrReasonInvalidOrder)
+			continue
+		}
+		if retries.Classify(err) != retries.Permanent {
+			t.Errorf("%s: the rejection would be retried", name)
+		}
+		// Only the events of the order are recorded, so its status shows why it was rejected
+		if len(activities) == 0 {
+			t.Errorf("%s: recorded no events of the rejected order", name)
+		}
+		for _, ran := range activities {
+			if ran != "tavern.orders.recordEvent" {
+				t.Errorf("%s: ran %v before rejecting the order", name, activities)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/futures"
+	"programmingpercy/cadence-tavern/workflows/notify"
+	"programmingpercy/cadence-tavern/workflows/retries"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+var (
+	// RoundWindow is how long orders of the same customer are collected into one round, the Worker replaces this during startup
+	// 0 processes every order in a round of its own
+	RoundWindow = 5 * time.Second
+
+	roundWindowMu sync.RWMutex
+)
+
+// SetRoundWindow replaces the RoundWindow while workflows might be reading it, used when the configuration is reloaded
+func SetRoundWindow(window time.Duration) {
+	roundWindowMu.Lock()
+	defer roundWindowMu.Unlock()
+	RoundWindow = window
+}
+
+// RoundParallelism is how many orders of a round are processed at the same time
+const RoundParallelism = 3
+
+// The reasons of the errors returned by a failed round
+const (
+	// ErrReasonOrdersFailed is returned when an order of the round failed in a way worth retrying
+	ErrReasonOrdersFailed = "orders-failed"
+	// ErrReasonOrdersRejected is returned when every failed order of the round was rejected, it is never retried
+	ErrReasonOrdersRejected = "orders-rejected"
+)
+
+// RoundParentClosePolicy is what happens to a running round when the order workflow closes, the Worker replaces this during startup
+var RoundParentClosePolicy = client.ParentClosePolicyTerminate
+
+// roundRetryPolicy retries rounds with failed orders, orders that completed in an earlier attempt are skipped
+var roundRetryPolicy = cadence.RetryPolicy{
+	InitialInterval:          10 * time.Second,
+	BackoffCoefficient:       2,
+	MaximumInterval:          time.Minute,
+	ExpirationInterval:       15 * time.Minute,
+	MaximumAttempts:          3,
+	NonRetriableErrorReasons: []string{ErrReasonOrdersRejected},
+}
+
+// FailedOrder is an order a round could not process, they are the details of the error of a failed round
+type FailedOrder struct {
+	Order  Order  `json:"order"`
+	Reason string `json:"reason"`
+	// Attempts is the attempt of the round the order last failed in
+	Attempts int `json:"attempts"`
+}
+
+// Round is orders of one customer at a location that are processed together by a single child workflow
+type Round struct {
+	Location string  `json:"location,omitempty"`
+	Customer string  `json:"customer"`
+	Orders   []Order `json:"orders"`
+}
+
+// roundWindow reads the RoundWindow as a side effect, so replays use the same window no matter the configuration
+func roundWindow(ctx workflow.Context) time.Duration {
+	encoded := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		roundWindowMu.RLock()
+		defer roundWindowMu.RUnlock()
+		return RoundWindow
+	})
+	var window time.Duration
+	if err := encoded.Get(&window); err != nil {
+		return 0
+	}
+	return window
+}
+
+// findRound returns the open round the order belongs to
+func findRound(open []*Round, order Order) *Round {
+	for _, round := range open {
+		if round.Location == order.Location && round.Customer == order.By {
+			return round
+		}
+	}
+	return nil
+}
+
+// removeRound removes the round from the open rounds, keeping the order they were opened in
+func removeRound(open []*Round, round *Round) []*Round {
+	for i, r := range open {
+		if r == round {
+			return append(open[:i], open[i+1:]...)
+		}
+	}
+	return open
+}
+
+// processRound runs the round as a child workflow and retries it until the orders succeed or the retries are exhausted
+// Orders that still failed are moved to the dead letter store and ops is alerted, they are returned
+func processRound(ctx workflow.Context, round Round) []FailedOrder {
+	// Each Order can tops take 2 min
+	roundCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		ExecutionStartToCloseTimeout: time.Minute * 2 * time.Duration(len(round.Orders)),
+		RetryPolicy:                  &roundRetryPolicy,
+		ParentClosePolicy:            RoundParentClosePolicy,
+	})
+	err := workflow.ExecuteChildWorkflow(roundCtx, workflowProcessRound, round).Get(ctx, nil)
+	if err == nil {
+		return nil
+	}
+
+	logger := workflow.GetLogger(ctx)
+	failed := failedOrders(err, round)
+	logger.Error("Round has failed.", zap.String("customer", round.Customer), zap.Int("failed", len(failed)), zap.Error(err))
+	workflow.GetMetricsScope(ctx).Counter("order_dead_letters").Inc(int64(len(failed)))
+	if err := workflow.ExecuteActivity(ctx, activityDeadLetterOrders, failed).Get(ctx, nil); err != nil {
+		logger.Error("Failed to dead letter orders.", zap.Error(err))
+	}
+	for _, f := range failed {
+		deliverCallback(ctx, f.Order, errors.New(f.Reason))
+	}
+
+	reasons := make([]string, 0, len(failed))
+	for _, f := range failed {
+		reasons = append(reasons, f.Order.Item+": "+f.Reason)
+	}
+	alertErr := notify.Ops(ctx, map[string]interface{}{
+		"Reason":     fmt.Sprintf("%d of %d orders by %s failed: %s", len(failed), len(round.Orders), round.Customer, strings.Join(reasons, "; ")),
+		"WorkflowID": workflow.GetInfo(ctx).WorkflowExecution.ID,
+	})
+	if alertErr != nil {
+		logger.Error("Failed to alert ops.", zap.Error(alertErr))
+	}
+	return failed
+}
+
+// processAlone runs the order in a child workflow of its own, the way the order workflow did before rounds
+// A failed order is not retried or dead lettered, ops is alerted and it is returned
+func processAlone(ctx workflow.Context, order Order) []FailedOrder {
+	// Each Order can tops take 2 min
+	orderCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		ExecutionStartToCloseTimeout: time.Minute * 2,
+	})
+	err := workflow.ExecuteChildWorkflow(orderCtx, workflowProcessOrder, order).Get(ctx, nil)
+	if err == nil {
+		return nil
+	}
+
+	logger := workflow.GetLogger(ctx)
+	logger.Error("Order has failed.", zap.Error(err))
+	alertErr := notify.Ops(ctx, map[string]interface{}{
+		"Reason":     "order of " + order.Item + " by " + order.By + " failed: " + err.Error(),
+		"WorkflowID": workflow.GetInfo(ctx).WorkflowExecution.ID,
+	})
+	if alertErr != nil {
+		logger.Error("Failed to alert ops.", zap.Error(alertErr))
+	}
+	return []FailedOrder{{Order: order, Reason: err.Error(), Attempts: 1}}
+}
+
+// failedOrders reads the failed orders from the error of a round
+// Rounds that failed without saying which orders failed, such as on a timeout, fail all of their orders
+func failedOrders(err error, round Round) []FailedOrder {
+	var custom *cadence.CustomError
+	if errors.As(err, &custom) && custom.HasDetails() {
+		var failed []FailedOrder
+		if custom.Details(&failed) == nil {
+			return failed
+		}
+	}
+	failed := make([]FailedOrder, 0, len(round.Orders))
+	for _, order := range round.Orders {
+		failed = append(failed, FailedOrder{Order: order, Reason: err.Error()})
+	}
+	return failed
+}
+
+// workflowProcessRound handles all orders of a round, it is ran as a CHILD of the order workflow
+// The orders are processed in parallel and a failed order does not stop the rest of the round
+// When orders fail the error is a CustomError with the FailedOrders as details, it is retried unless all were rejected
+func workflowProcessRound(ctx workflow.Context, round Round) error {
+	logger := workflow.GetLogger(ctx)
+	attempt := int(workflow.GetInfo(ctx).Attempt) + 1
+	logger.Info("process round workflow started", zap.String("customer", round.Customer), zap.Int("orders", len(round.Orders)), zap.Int("attempt", attempt))
+	ctx = workflow.WithActivityOptions(ctx, orderActivityOptions)
+
+	scope := workflow.GetMetricsScope(ctx)
+	if attempt == 1 {
+		scope.Counter("order_rounds").Inc(1)
+		scope.Counter("order_round_orders").Inc(int64(len(round.Orders)))
+	} else {
+		scope.Counter("order_round_retries").Inc(1)
+	}
+
+	// Stock reserved by the round is put back by the cleanup if the round is terminated before it settles it
+	cleanup, err := startCleanup(ctx, round)
+	if err != nil {
+		return err
+	}
+	defer finishCleanup(ctx, cleanup)
+
+	failed := make([]*FailedOrder, len(round.Orders))
+	rejected := true
+	err = futures.Map(ctx, len(round.Orders), RoundParallelism, func(ctx workflow.Context, i int) error {
+		order := round.Orders[i]
+		if attempt > 1 && isCompleted(ctx, order) {
+			return nil
+		}
+		if err := handleOrder(ctx, order); err != nil {
+			if retries.Classify(err) == retries.Transient {
+				rejected = false
+			}
+			failed[i] = &FailedOrder{Order: order, Reason: err.Error(), Attempts: attempt}
+			return fmt.Errorf("%s: %w", order.Item, err)
+		}
+		return nil
+	})
+	failures, ok := err.(futures.Errors)
+	if !ok {
+		return err
+	}
+
+	logger.Error("Orders of round failed", zap.Int("failed", len(failures)), zap.Error(failures))
+	details := make([]FailedOrder, 0, len(failures))
+	for _, f := range failed {
+		if f != nil {
+			details = append(details, *f)
+		}
+	}
+	reason := ErrReasonOrdersFailed
+	if rejected {
+		reason = ErrReasonOrdersRejected
+	}
+	return cadence.NewCustomError(reason, details)
+}
+
+// isCompleted is true when the order completed in an earlier attempt of the round, orders without an ID are never
+func isCompleted(ctx workflow.Context, order Order) bool {
+	if order.ID == "" {
+		return false
+	}
+	var status string
+	if err := workflow.ExecuteActivity(ctx, activityOrderStatus, order.ID).Get(ctx, &status); err != nil {
+		workflow.GetLogger(ctx).Warn("Failed to read order status, processing it again", zap.String("order", order.ID), zap.Error(err))
+		return false
+	}
+	return status == orderstore.EventCompleted
+}
+
+// activityOrderStatus returns the last event of the order, empty when the order has no events
+func activityOrderStatus(ctx context.Context, orderID string) (string, error) {
+	events, err := orderstore.Events.Events(ctx, orderID)
+	if err != nil {
+		return "", err
+	}
+	status, err := orderstore.Project(events)
+	if errors.Is(err, orderstore.ErrNoSuchOrder) {
+		return "", nil
+	}
+	return status.Status, err
+}
+
+// activityDeadLetterOrders keeps the orders in the dead letter store
+func activityDeadLetterOrders(ctx context.Context, failed []FailedOrder) error {
+	span := tracing.StartActivitySpan(ctx, "deadLetterOrders", opentracing.Tags{"orders": len(failed)})
+	defer span.Finish()
+	tracing.ForceSample(span)
+
+	for _, f := range failed {
+		err := orderstore.DeadLetters.AddDeadLetter(ctx, orderstore.DeadLetter{
+			OrderID:  f.Order.ID,
+			Location: f.Order.Location,
+			Item:     f.Order.Item,
+			By:       f.Order.By,
+			Price:    float32(f.Order.Price.Float64()),
+			Reason:   f.Reason,
+			Attempts: f.Attempts,
+			FailedAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
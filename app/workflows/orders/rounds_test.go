@@ -0,0 +1,163 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/workflows/flags"
+	"programmingpercy/cadence-tavern/workflows/payments"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/cadence/workflow"
+)
+
+func TestRoundChargesEveryOrder(t *testing.T) {
+	flags.SetSource(flags.StaticProvider{Flags: flags.Flags{RequirePayment: true}})
+	defer flags.SetSource(flags.StaticProvider{Flags: flags.Flags{EnforceAgeCheck: true}})
+	provider := payments.NewMockProvider()
+	payments.Provider = provider
+
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite, customer.Customer{Name: "Percy", Age: 30})
+	env.ExecuteWorkflow(workflowProcessRound, Round{Customer: "Percy", Orders: []Order{testOrder("order-1", "Percy"), testOrder("order-2", "Percy")}})
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("round failed: %v", err)
+	}
+
+	// Both orders share the child workflow, the references are the idempotency keys of the provider
+	references := map[string]bool{}
+	for _, charge := range provider.Charges {
+		references[charge.Reference] = true
+	}
+	if len(provider.Charges) != 2 || len(references) != 2 {
+		t.Fatalf("got charges %v, want one with its own reference for each order", provider.Charges)
+	}
+
+	receipts := map[string]bool{}
+	for _, id := range []string{"order-1", "order-2"} {
+		events, err := orderstore.Events.Events(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := orderstore.Project(events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != orderstore.EventCompleted || status.ReceiptURL == "" {
+			t.Fatalf("order %s is %s with receipt %q", id, status.Status, status.ReceiptURL)
+		}
+		receipts[status.ReceiptURL] = true
+	}
+	if len(receipts) != 2 {
+		t.Errorf("orders share the receipt %v", receipts)
+	}
+}
+
+func TestOrdersOfACustomerShareARound(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite)
+	var rounds []Round
+	env.OnWorkflow(workflowProcessRound, mock.Anything, mock.Anything).Return(func(ctx workflow.Context, round Round) error {
+		rounds = append(rounds, round)
+		return nil
+	})
+	env.OnWorkflow(workflowProcessOrder, mock.Anything, mock.Anything).Return(func(ctx workflow.Context, order Order) error {
+		t.Errorf("order %s was processed alone", order.ID)
+		return nil
+	})
+
+	for i, by := range []string{"Percy", "Percy", "Ada"} {
+		order := testOrder(fmt.Sprintf("order-%d", i), by)
+		env.RegisterDelayedCallback(func() { signalOrder(t, env, order) }, time.Second)
+	}
+	env.ExecuteWorkflow(WorkflowOrder)
+
+	if !continuedAsNew(env.GetWorkflowError()) {
+		t.Fatalf("order workflow returned %v, want it to continue as new", env.GetWorkflowError())
+	}
+	if len(rounds) != 2 || len(rounds[0].Orders)+len(rounds[1].Orders) != 3 {
+		t.Fatalf("got rounds %v, want the orders of Percy in one and Ada in another", rounds)
+	}
+}
+
+func TestRunsBeforeRoundsProcessOrdersAlone(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite)
+	env.OnGetVersion(roundsChangeID, workflow.DefaultVersion, 1).Return(workflow.DefaultVersion)
+	var alone int
+	env.OnWorkflow(workflowProcessOrder, mock.Anything, mock.Anything).Return(func(ctx workflow.Context, order Order) error {
+		alone++
+		return nil
+	})
+	env.OnWorkflow(workflowProcessRound, mock.Anything, mock.Anything).Return(func(ctx workflow.Context, round Round) error {
+		t.Errorf("run from before rounds processed the round %v", round)
+		return nil
+	})
+
+	for i := 0; i < MaxSignalsAmount; i++ {
+		order := testOrder(fmt.Sprintf("order-%d", i), "Percy")
+		env.RegisterDelayedCallback(func() { signalOrder(t, env, order) }, time.Second)
+	}
+	env.ExecuteWorkflow(WorkflowOrder)
+
+	if !continuedAsNew(env.GetWorkflowError()) {
+		t.Fatalf("order workflow returned %v, want it to continue as new", env.GetWorkflowError())
+	}
+	if alone != MaxSignalsAmount {
+		t.Fatalf("processed %d orders alone, want %d", alone, MaxSignalsAmount)
+	}
+}
+
+func TestExhaustedRoundIsDeadLettered(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	var suite testsuite.WorkflowTestSuite
+	suite.SetMetricsScope(scope)
+	env := newTestEnv(t, &suite, customer.Customer{Name: "Percy", Age: 30})
+	env.OnActivity("tavern.orders.findCustomer", mock.Anything, mock.Anything, mock.Anything).Return(customer.Customer{}, errors.New("connection refused"))
+	env.OnActivity("tavern.notify.ops", mock.Anything, mock.Anything).Return(nil)
+
+	round := Round{Customer: "Percy", Orders: []Order{testOrder("order-1", "Percy")}}
+	parent := func(ctx workflow.Context, round Round) ([]FailedOrder, error) {
+		ctx = workflow.WithActivityOptions(ctx, orderActivityOptions)
+		return processRound(ctx, round), nil
+	}
+	env.RegisterWorkflow(parent)
+	env.ExecuteWorkflow(parent, round)
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+
+	var failed []FailedOrder
+	if err := env.GetWorkflowResult(&failed); err != nil {
+		t.Fatal(err)
+	}
+	// The round is retried by its retry policy, the order is only given up on once the last attempt failed
+	if len(failed) != 1 || failed[0].Attempts < 2 {
+		t.Fatalf("got failed orders %+v, want the order failed after the round was retried", failed)
+	}
+
+	letters, err := orderstore.DeadLetters.DeadLetters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].OrderID != "order-1" || letters[0].Attempts != failed[0].Attempts {
+		t.Fatalf("got dead letters %+v, want order-1 after %d attempts", letters, failed[0].Attempts)
+	}
+
+	counters := map[string]int64{}
+	for _, c := range scope.Snapshot().Counters() {
+		counters[c.Name()] += c.Value()
+	}
+	if counters["order_dead_letters"] != 1 {
+		t.Errorf("order_dead_letters is %d, want 1", counters["order_dead_letters"])
+	}
+	if counters["order_round_retries"] != int64(failed[0].Attempts-1) {
+		t.Errorf("order_round_retries is %d, want %d", counters["order_round_retries"], failed[0].Attempts-1)
+	}
+}
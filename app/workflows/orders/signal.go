@@ -0,0 +1,70 @@
+package orders
+
+import (
+	"encoding/json"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/signals"
+)
+
+// SignalOrder is the signal the API sends orders to the order workflow with
+const SignalOrder = "order"
+
+// OrderSignalVersion is the version of the order signal the API sends
+// Version 1 is the Order itself, version 2 is an OrderSignal with the price in cents so it is exact and version 3 adds
+// the callbackUrl. Workers must be able to decode a version before the API sends it
+const OrderSignalVersion = 3
+
+// OrderSignal is the version 3 payload of the order signal, version 2 is the same without the callbackUrl
+// PriceCents is the price in the minor unit of Currency, signals without a Currency are in models.Currency
+type OrderSignal struct {
+	ID          string          `json:"id"`
+	Item        string          `json:"item"`
+	PriceCents  int64           `json:"priceCents"`
+	Currency    string          `json:"currency,omitempty"`
+	By          string          `json:"by"`
+	Trace       tracing.Carrier `json:"trace,omitempty"`
+	Location    string          `json:"location,omitempty"`
+	CallbackURL string          `json:"callbackUrl,omitempty"`
+}
+
+// NewOrderSignal is the envelope the order is signalled to the order workflow in
+func NewOrderSignal(order Order) (signals.Envelope, error) {
+	return signals.Wrap(OrderSignalVersion, OrderSignal{
+		ID:          order.ID,
+		Item:        order.Item,
+		PriceCents:  order.Price.Minor,
+		Currency:    order.Price.Currency,
+		By:          order.By,
+		Trace:       order.Trace,
+		Location:    order.Location,
+		CallbackURL: order.CallbackURL,
+	})
+}
+
+// orderDecoders read every version of the order signal into an Order, version 1 is plain JSON
+var orderDecoders = signals.Decoders{
+	2: decodeOrderSignal,
+	3: decodeOrderSignal,
+}
+
+// decodeOrderSignal reads an OrderSignal into an Order, a version 2 payload has no callbackUrl to read
+func decodeOrderSignal(payload json.RawMessage, out interface{}) error {
+	var signal OrderSignal
+	if err := json.Unmarshal(payload, &signal); err != nil {
+		return err
+	}
+	if signal.Currency == "" {
+		signal.Currency = models.Currency
+	}
+	*out.(*Order) = Order{
+		ID:          signal.ID,
+		Item:        signal.Item,
+		Price:       models.Money{Currency: signal.Currency, Minor: signal.PriceCents},
+		By:          signal.By,
+		Trace:       signal.Trace,
+		Location:    signal.Location,
+		CallbackURL: signal.CallbackURL,
+	}
+	return nil
+}
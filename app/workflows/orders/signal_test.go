@@ -0,0 +1,85 @@
+package orders
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"programmingpercy/cadence-tavern/models"
+	"reflect"
+	"testing"
+)
+
+// recordedOrder is the order every recorded payload in testdata/signals was sent for
+var recordedOrder = Order{
+	ID:       "order-1",
+	Item:     "Beer",
+	Price:    models.Money{Currency: "USD", Minor: 450},
+	By:       "Percy",
+	Location: "tavern-1",
+}
+
+// TestOrderSignalCompatibility decodes order signals recorded from every version the API has sent
+// A worker has to keep decoding them, since signals of an older API can still be waiting in a workflow history
+func TestOrderSignalCompatibility(t *testing.T) {
+	withCallback := recordedOrder
+	withCallback.CallbackURL = "https://example.com/orders"
+
+	cases := []struct {
+		file string
+		want Order
+	}{
+		{"order.v1.json", recordedOrder},
+		{"order.v2.json", recordedOrder},
+		{"order.v3.json", withCallback},
+		{"order.v3-unknown-fields.json", withCallback},
+	}
+	for _, c := range cases {
+		raw, err := os.ReadFile(filepath.Join("testdata", "signals", c.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Order
+		if err := orderDecoders.Decode(raw, &got); err != nil {
+			t.Errorf("%s: failed to decode: %v", c.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: decoded %+v, want %+v", c.file, got, c.want)
+		}
+	}
+}
+
+func TestOrderSignalRoundTrip(t *testing.T) {
+	sent := recordedOrder
+	sent.CallbackURL = "https://example.com/orders"
+	envelope, err := NewOrderSignal(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Order
+	if err := orderDecoders.Decode(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, sent) {
+		t.Fatalf("decoded %+v, want %+v", got, sent)
+	}
+}
+
+func TestMalformedOrderSignal(t *testing.T) {
+	for _, raw := range []string{
+		`{"version":3,"payload":{"id":"order-1","priceCents":"four fifty"}}`,
+		`{"version":2,"payload":[1,2,3]}`,
+		`{"id":"order-1","price":"free"}`,
+		`{"version":3,`,
+	} {
+		var got Order
+		if err := orderDecoders.Decode(json.RawMessage(raw), &got); err == nil {
+			t.Errorf("decoded %s into %+v, want an error", raw, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package orders
+
+import "time"
+
+// QueryStats is the query used to look at the orders made since the order workflow started
+// The stats are carried over when the workflow continues as new, so they cover the whole chain of runs
+const QueryStats = "stats"
+
+// ItemStats is what was ordered of one item
+type ItemStats struct {
+	// Orders are the orders of the item that were served
+	Orders int `json:"orders"`
+	// Failed are the orders of the item that were rejected or failed
+	Failed int `json:"failed"`
+	// Revenue is the menu price of the orders served, discounts and happy hour are applied by the round and not seen here
+	Revenue float32 `json:"revenue"`
+}
+
+// Stats are the orders processed by the order workflow of a location
+type Stats struct {
+	Since   time.Time            `json:"since"`
+	Orders  int                  `json:"orders"`
+	Failed  int                  `json:"failed"`
+	Revenue float32              `json:"revenue"`
+	Items   map[string]ItemStats `json:"items"`
+}
+
+// Carried is what the order workflow carries over when it continues as new
+type Carried struct {
+	// Recent are the times of the recent orders of each customer, so the limit of a customer carries over
+	Recent map[string][]time.Time `json:"recent,omitempty"`
+	Stats  Stats                  `json:"stats"`
+	// Override is the override of the opening hours that has not ended yet
+	Override *Override `json:"override,omitempty"`
+}
+
+// record counts the orders of a processed round, failed are the orders of the round that did not succeed
+func (st *Stats) record(round Round, failed []FailedOrder) {
+	if st.Items == nil {
+		st.Items = make(map[string]ItemStats)
+	}
+	// Orders are matched by ID and item, counted so orders made without an ID are not all taken as failed
+	failedOrders := make(map[string]int, len(failed))
+	for _, f := range failed {
+		failedOrders[f.Order.ID+"/"+f.Order.Item]++
+	}
+
+	for _, order := range round.Orders {
+		item := st.Items[order.Item]
+		if key := order.ID + "/" + order.Item; failedOrders[key] > 0 {
+			failedOrders[key]--
+			item.Failed++
+			st.Failed++
+		} else {
+			item.Orders++
+			item.Revenue += float32(order.Price.Float64())
+			st.Orders++
+			st.Revenue += float32(order.Price.Float64())
+		}
+		st.Items[order.Item] = item
+	}
+}
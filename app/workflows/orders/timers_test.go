@@ -0,0 +1,194 @@
+package orders
+
+import (
+	"context"
+	"programmingpercy/cadence-tavern/orderstore"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/cadence/workflow"
+)
+
+// timers records the timers of a test environment
+// The test environment skips ahead to the next timer whenever the workflow is blocked, so tests can assert which
+// timers fired without waiting for them
+type timers struct {
+	scheduled map[string]time.Duration
+	fired     []time.Duration
+	cancelled []time.Duration
+}
+
+// recordTimers starts recording the timers of the environment
+func recordTimers(env *testsuite.TestWorkflowEnvironment) *timers {
+	tr := &timers{scheduled: map[string]time.Duration{}}
+	env.SetOnTimerScheduledListener(func(id string, d time.Duration) {
+		tr.scheduled[id] = d
+	})
+	env.SetOnTimerFiredListener(func(id string) {
+		tr.fired = append(tr.fired, tr.scheduled[id])
+	})
+	env.SetOnTimerCancelledListener(func(id string) {
+		tr.cancelled = append(tr.cancelled, tr.scheduled[id])
+	})
+	return tr
+}
+
+// count is how many of the durations are d
+func count(durations []time.Duration, d time.Duration) int {
+	n := 0
+	for _, duration := range durations {
+		if duration == d {
+			n++
+		}
+	}
+	return n
+}
+
+// assertFired fails the test unless n timers of d fired
+func (tr *timers) assertFired(t *testing.T, d time.Duration, n int) {
+	t.Helper()
+	if got := count(tr.fired, d); got != n {
+		t.Errorf("%d timers of %s fired, want %d, fired are %v", got, d, n, tr.fired)
+	}
+}
+
+// assertPending fails the test unless a timer of d was scheduled that neither fired nor was cancelled
+func (tr *timers) assertPending(t *testing.T, d time.Duration) {
+	t.Helper()
+	scheduled := 0
+	for _, duration := range tr.scheduled {
+		if duration == d {
+			scheduled++
+		}
+	}
+	if scheduled-count(tr.fired, d)-count(tr.cancelled, d) < 1 {
+		t.Errorf("no timer of %s is pending, fired are %v and cancelled are %v", d, tr.fired, tr.cancelled)
+	}
+}
+
+func TestReservationExpires(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite)
+	tr := recordTimers(env)
+	stock := reserveRound(t, "order-1")
+	timeout := 2 * time.Minute
+	expiry := timeout + cleanupGrace
+
+	// The reservation is held until the round had its chance to settle it
+	env.RegisterDelayedCallback(func() {
+		if count, _ := stock.Available("tavern-1", "Beer"); count != 1 {
+			t.Errorf("%d Beer left right before the reservation expired, want it still reserved", count)
+		}
+	}, expiry-time.Second)
+	started := env.Now()
+	env.ExecuteWorkflow(workflowCleanupRound, Cleanup{Location: "tavern-1", OrderIDs: []string{"order-1"}, Timeout: timeout})
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.assertFired(t, expiry, 1)
+	if took := env.Now().Sub(started); took < expiry {
+		t.Errorf("reservation was released after %s, want %s", took, expiry)
+	}
+	if count, _ := stock.Available("tavern-1", "Beer"); count != 2 {
+		t.Errorf("%d Beer left after the reservation expired, want it back", count)
+	}
+}
+
+func TestFinishedRoundStopsReservationExpiry(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite)
+	tr := recordTimers(env)
+	reserveRound(t, "order-1")
+	timeout := 2 * time.Minute
+
+	env.RegisterDelayedCallback(func() {
+		done, err := signals.Wrap(signals.LegacyVersion, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.SignalWorkflow(signalRoundDone, done)
+	}, time.Minute)
+	env.ExecuteWorkflow(workflowCleanupRound, Cleanup{Location: "tavern-1", OrderIDs: []string{"order-1"}, Timeout: timeout})
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+	tr.assertFired(t, timeout+cleanupGrace, 0)
+}
+
+func TestOrdersWithinTheWindowShareARound(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite)
+	tr := recordTimers(env)
+	var rounds []Round
+	env.OnWorkflow(workflowProcessRound, mock.Anything, mock.Anything).Return(func(ctx workflow.Context, round Round) error {
+		rounds = append(rounds, round)
+		return nil
+	})
+
+	// The second order is placed while the round of the first collects orders, the third after it was processed
+	window := RoundWindow
+	for id, at := range map[string]time.Duration{"order-1": time.Second, "order-2": time.Second + window/2, "order-3": time.Second + 2*window} {
+		order := testOrder(id, "Percy")
+		env.RegisterDelayedCallback(func() { signalOrder(t, env, order) }, at)
+	}
+	env.ExecuteWorkflow(WorkflowOrder)
+	if !continuedAsNew(env.GetWorkflowError()) {
+		t.Fatalf("order workflow returned %v, want it to continue as new", env.GetWorkflowError())
+	}
+
+	if len(rounds) != 2 || len(rounds[0].Orders) != 2 || rounds[1].Orders[0].ID != "order-3" {
+		t.Fatalf("got rounds %v, want order-1 and order-2 in the first and order-3 in the second", rounds)
+	}
+	// The round of order-3 was still open when the run continued, it was processed right away
+	tr.assertFired(t, window, 1)
+	tr.assertPending(t, window)
+}
+
+func TestOverrideOpensTheTavernUntilItEnds(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := newTestEnv(t, &suite)
+	var rounds []Round
+	env.OnWorkflow(workflowProcessRound, mock.Anything, mock.Anything).Return(func(ctx workflow.Context, round Round) error {
+		rounds = append(rounds, round)
+		return nil
+	})
+
+	// The tavern is only open a minute on a day that is days away, so only the override lets orders in
+	started := env.Now().UTC()
+	SetOpeningHours(Hours{Zone: "UTC", Spans: []Span{{Day: (started.Weekday() + 3) % 7, Open: 0, Close: 1}}})
+	defer SetOpeningHours(Hours{})
+	env.RegisterDelayedCallback(func() {
+		override, err := signals.Wrap(OverrideSignalVersion, Override{Open: true, Until: started.Add(time.Hour), Reason: "happy hour"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.SignalWorkflow(SignalOverride, override)
+	}, time.Second)
+	for id, at := range map[string]time.Duration{"order-1": 30 * time.Minute, "order-2": 50 * time.Minute, "order-3": 2 * time.Hour} {
+		order := testOrder(id, "Percy")
+		env.RegisterDelayedCallback(func() { signalOrder(t, env, order) }, at)
+	}
+	env.ExecuteWorkflow(WorkflowOrder)
+	if !continuedAsNew(env.GetWorkflowError()) {
+		t.Fatalf("order workflow returned %v, want it to continue as new", env.GetWorkflowError())
+	}
+
+	if len(rounds) != 2 {
+		t.Fatalf("got rounds %v, want the orders placed during the override", rounds)
+	}
+	events, err := orderstore.Events.Events(context.Background(), "order-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := orderstore.Project(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != orderstore.EventFailed || status.Detail != ErrReasonClosed {
+		t.Fatalf("order placed after the override is %s with %q, want it refused as %s", status.Status, status.Detail, ErrReasonClosed)
+	}
+}
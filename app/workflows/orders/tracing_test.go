@@ -0,0 +1,70 @@
+package orders
+
+import (
+	"net/http/httptest"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/tracing"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/cadence/workflow"
+)
+
+func TestOrderTrace(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	var suite testsuite.WorkflowTestSuite
+	suite.SetContextPropagators([]workflow.ContextPropagator{tracing.NewPropagator()})
+	env := newTestEnv(t, &suite, customer.Customer{Name: "Percy", Age: 30})
+
+	// The API starts the trace of the order and carries it in the signal
+	api := tracing.StartHTTPSpan(httptest.NewRequest("POST", "/order", nil), "order", opentracing.Tags{"item": "Beer", "customer": "Percy"})
+	order := testOrder("order-1", "Percy")
+	order.Trace = tracing.Inject(tracer, api)
+	api.Finish()
+
+	env.ExecuteWorkflow(workflowProcessOrder, order)
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("order failed: %v", err)
+	}
+
+	spans := map[string]*mocktracer.MockSpan{}
+	for _, span := range tracer.FinishedSpans() {
+		spans[span.OperationName] = span
+	}
+	for _, name := range []string{"order", "processOrder", "findCustomerByName", "checkBanned", "applyDiscount", "recordOrderEvent"} {
+		if spans[name] == nil {
+			t.Fatalf("span %s was not finished, got %v", name, tracer.FinishedSpans())
+		}
+	}
+
+	traceID := spans["order"].SpanContext.TraceID
+	for _, span := range tracer.FinishedSpans() {
+		if span.SpanContext.TraceID != traceID {
+			t.Errorf("span %s is in trace %d, the order is in %d", span.OperationName, span.SpanContext.TraceID, traceID)
+		}
+	}
+	if parent := spans["processOrder"].ParentID; parent != spans["order"].SpanContext.SpanID {
+		t.Errorf("workflow span is a child of %d, want the API span %d", parent, spans["order"].SpanContext.SpanID)
+	}
+	for _, name := range []string{"findCustomerByName", "checkBanned", "recordOrderEvent"} {
+		if parent := spans[name].ParentID; parent != spans["processOrder"].SpanContext.SpanID {
+			t.Errorf("activity span %s is a child of %d, want the workflow span %d", name, parent, spans["processOrder"].SpanContext.SpanID)
+		}
+	}
+
+	if got := spans["processOrder"].Tag("item"); got != "Beer" {
+		t.Errorf("workflow span has item %v", got)
+	}
+	if got := spans["findCustomerByName"].Tag("customer"); got != "Percy" {
+		t.Errorf("findCustomerByName span has customer %v", got)
+	}
+	event := spans["recordOrderEvent"]
+	if event.Tag("item") != "Beer" || event.Tag("customer") != "Percy" {
+		t.Errorf("recordOrderEvent span has item %v and customer %v", event.Tag("item"), event.Tag("customer"))
+	}
+}
@@ -0,0 +1,100 @@
+package orders
+
+import (
+	"programmingpercy/cadence-tavern/workflows/notify"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// watchdogChangeID marks the runs that watch how long orders take, see alertAfter
+const watchdogChangeID = "order-watchdog"
+
+var (
+	// AlertAfter is how long an order may be prepared before the staff is alerted, the Worker replaces this during startup
+	// 0 disables the alert
+	AlertAfter = 90 * time.Second
+
+	alertAfterMu sync.RWMutex
+)
+
+// SetAlertAfter replaces the AlertAfter while workflows might be reading it, used when the configuration is reloaded
+func SetAlertAfter(after time.Duration) {
+	alertAfterMu.Lock()
+	defer alertAfterMu.Unlock()
+	AlertAfter = after
+}
+
+// alertAfter reads the AlertAfter as a side effect, so replays use the same threshold no matter the configuration
+// Runs started before the watchdog existed have no timer in their history to replay, they are not watched
+func alertAfter(ctx workflow.Context) time.Duration {
+	if workflow.GetVersion(ctx, watchdogChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		return 0
+	}
+	encoded := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		alertAfterMu.RLock()
+		defer alertAfterMu.RUnlock()
+		return AlertAfter
+	})
+	var after time.Duration
+	if err := encoded.Get(&after); err != nil {
+		return 0
+	}
+	return after
+}
+
+// watchOrder runs prepare and alerts the staff if it takes longer than AlertAfter
+// The order keeps being prepared after the alert, the alert only makes sure someone looks at it
+// ctx needs to have ActivityOptions applied
+func watchOrder(ctx workflow.Context, order *Order, prepare func(ctx workflow.Context) error) error {
+	after := alertAfter(ctx)
+	if after <= 0 {
+		return prepare(ctx)
+	}
+
+	prepared, settle := workflow.NewFuture(ctx)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		settle.Set(nil, prepare(ctx))
+	})
+
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	var done bool
+	selector := workflow.NewSelector(ctx)
+	selector.AddFuture(prepared, func(f workflow.Future) {
+		done = true
+	})
+	selector.AddFuture(workflow.NewTimer(timerCtx, after), func(f workflow.Future) {
+		if f.Get(ctx, nil) != nil {
+			return
+		}
+		alertStaff(ctx, *order, after)
+	})
+	for !done {
+		selector.Select(ctx)
+	}
+	return prepared.Get(ctx, nil)
+}
+
+// alertStaff records the breach and notifies the staff without holding up the order
+func alertStaff(ctx workflow.Context, order Order, waiting time.Duration) {
+	logger := workflow.GetLogger(ctx)
+	logger.Warn("Order is taking too long", zap.String("order", order.ID), zap.Duration("waiting", waiting))
+	workflow.GetMetricsScope(ctx).Counter("order_sla_breached").Inc(1)
+
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		err := notify.Staff(ctx, map[string]interface{}{
+			"OrderID":  order.ID,
+			"Item":     order.Item,
+			"Customer": order.By,
+			"Table":    order.Table,
+			"Waiting":  waiting.String(),
+		})
+		if err != nil {
+			logger.Error("Failed to alert the staff", zap.String("order", order.ID), zap.Error(err))
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package pause
+
+import (
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"time"
+
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignalPause is the signal used to stop a workflow from starting new work, such as during a maintenance window
+	SignalPause = "pause"
+	// SignalResume is the signal used to let a paused workflow carry on
+	SignalResume = "resume"
+	// QueryPaused is the query used to look at the State of a workflow
+	QueryPaused = "paused"
+
+	// SignalVersion is the version of the payloads of the pause and resume signals
+	SignalVersion = 1
+)
+
+// Request is the payload of the pause signal
+type Request struct {
+	// Reason is why the workflow is paused, it is shown by the paused query
+	Reason string `json:"reason,omitempty"`
+}
+
+// State is whether a workflow is paused
+type State struct {
+	Paused bool      `json:"paused"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+}
+
+func init() {
+	registry.Signal(SignalPause, SignalVersion, Request{})
+	registry.Signal(SignalResume, SignalVersion, nil)
+}
+
+// Handle makes the workflow listen to the pause and resume signals and answer the paused query
+// The signals are received in a goroutine of their own, so they are seen while the workflow is blocked in Wait
+func Handle(ctx workflow.Context) (*State, error) {
+	state := &State{}
+	err := workflow.SetQueryHandler(ctx, QueryPaused, func() (State, error) {
+		return *state, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger := workflow.GetLogger(ctx)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(workflow.GetSignalChannel(ctx, SignalPause), func(c workflow.Channel, more bool) {
+			var req Request
+			if err := signals.Receive(ctx, c, nil, &req); err != nil {
+				logger.Error("Dropped pause signal that could not be decoded", zap.Error(err))
+				return
+			}
+			if !state.Paused {
+				state.Since = workflow.Now(ctx)
+			}
+			state.Paused = true
+			state.Reason = req.Reason
+			logger.Info("Workflow paused", zap.String("reason", req.Reason))
+		})
+		selector.AddReceive(workflow.GetSignalChannel(ctx, SignalResume), func(c workflow.Channel, more bool) {
+			if err := signals.Receive(ctx, c, nil, nil); err != nil {
+				logger.Error("Dropped resume signal that could not be decoded", zap.Error(err))
+				return
+			}
+			if state.Paused {
+				logger.Info("Workflow resumed", zap.Duration("paused", workflow.Now(ctx).Sub(state.Since)))
+			}
+			*state = State{}
+		})
+		for {
+			selector.Select(ctx)
+		}
+	})
+	return state, nil
+}
+
+// Wait blocks until the workflow is not paused, it returns right away when it is not
+// The error is only set when the workflow is canceled while waiting
+func (s *State) Wait(ctx workflow.Context) error {
+	if !s.Paused {
+		return nil
+	}
+	workflow.GetLogger(ctx).Info("Waiting for the workflow to be resumed")
+	return workflow.Await(ctx, func() bool {
+		return !s.Paused
+	})
+}
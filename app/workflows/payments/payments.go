@@ -0,0 +1,218 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/profiles"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+var (
+	// Provider is the payment provider used by the activities, the Worker replaces this during startup
+	Provider PaymentProvider = NewMockProvider()
+
+	// ErrUnknownCharge is returned when refunding a charge the provider does not know about
+	ErrUnknownCharge = errors.New("no such charge")
+)
+
+// Charge is the representation of a payment made by a customer, see models.Charge
+type Charge = models.Charge
+
+// PaymentProvider is the needed methods to be able to take payments
+type PaymentProvider interface {
+	Charge(ctx context.Context, customer string, amount models.Money, reference string) (Charge, error)
+	Refund(ctx context.Context, charge Charge) error
+}
+
+// NewProvider will create the payment provider selected in the configuration
+func NewProvider(cfg config.Payments) (PaymentProvider, error) {
+	switch cfg.Provider {
+	case "", "mock":
+		return NewMockProvider(), nil
+	case "http":
+		if cfg.URL == "" {
+			return nil, errors.New("payment provider http needs a url")
+		}
+		return &HTTPProvider{
+			URL:      strings.TrimSuffix(cfg.URL, "/"),
+			APIKey:   cfg.APIKey,
+			Currency: cfg.Currency,
+			Client:   &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider: %s", cfg.Provider)
+	}
+}
+
+// MockProvider accepts every payment and keeps them in memory
+type MockProvider struct {
+	sync.Mutex
+	Charges map[string]Charge
+	counter int
+}
+
+// NewMockProvider will init a new in memory payment provider
+func NewMockProvider() *MockProvider {
+	return &MockProvider{
+		Charges: make(map[string]Charge),
+	}
+}
+
+// Charge stores the charge in memory
+func (mp *MockProvider) Charge(ctx context.Context, customer string, amount models.Money, reference string) (Charge, error) {
+	mp.Lock()
+	defer mp.Unlock()
+
+	mp.counter++
+	charge := Charge{
+		ID:        fmt.Sprintf("ch_mock_%d", mp.counter),
+		Customer:  customer,
+		Amount:    amount,
+		Reference: reference,
+	}
+	mp.Charges[charge.ID] = charge
+	return charge, nil
+}
+
+// Refund marks the charge as refunded
+func (mp *MockProvider) Refund(ctx context.Context, charge Charge) error {
+	mp.Lock()
+	defer mp.Unlock()
+
+	stored, ok := mp.Charges[charge.ID]
+	if !ok {
+		return ErrUnknownCharge
+	}
+	stored.Refunded = true
+	mp.Charges[charge.ID] = stored
+	return nil
+}
+
+// HTTPProvider talks to a Stripe-like payment API
+// Charges are created with POST /v1/charges and refunded with POST /v1/refunds
+type HTTPProvider struct {
+	URL      string
+	APIKey   string
+	Currency string
+	Client   *http.Client
+}
+
+// Charge will create a charge at the payment API, amounts are sent in minor units
+// The amount is charged in its own currency, Currency is only used for amounts without one
+func (hp *HTTPProvider) Charge(ctx context.Context, customer string, amount models.Money, reference string) (Charge, error) {
+	currency := amount.Currency
+	if currency == "" {
+		currency = hp.Currency
+	}
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(amount.Minor, 10))
+	form.Set("currency", strings.ToLower(currency))
+	form.Set("customer", customer)
+	form.Set("metadata[reference]", reference)
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	// The reference is used as idempotency key so activity retries never charge twice
+	if err := hp.post(ctx, "/v1/charges", reference, form, &response); err != nil {
+		return Charge{}, err
+	}
+	return Charge{
+		ID:        response.ID,
+		Customer:  customer,
+		Amount:    amount,
+		Reference: reference,
+	}, nil
+}
+
+// Refund will refund the full charge at the payment API
+func (hp *HTTPProvider) Refund(ctx context.Context, charge Charge) error {
+	form := url.Values{}
+	form.Set("charge", charge.ID)
+
+	return hp.post(ctx, "/v1/refunds", "refund-"+charge.ID, form, nil)
+}
+
+// post sends the form to the payment API and decodes the response into out
+func (hp *HTTPProvider) post(ctx context.Context, path, idempotencyKey string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hp.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+hp.APIKey)
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+
+	resp, err := hp.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("payment request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("payment provider responded with %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func init() {
+	registry.Activity("tavern.payments.charge", activityChargeCustomer)
+	registry.Activity("tavern.payments.refund", activityRefundCustomer)
+}
+
+// ChargeCustomer is used by workflows to charge a customer, it runs with the payment profile
+// ctx needs to have ActivityOptions applied
+func ChargeCustomer(ctx workflow.Context, customer string, amount models.Money, reference string) (Charge, error) {
+	var charge Charge
+	ctx = profiles.WithActivityProfile(ctx, profiles.Payment)
+	err := workflow.ExecuteActivity(ctx, activityChargeCustomer, customer, amount, reference).Get(ctx, &charge)
+	return charge, err
+}
+
+// RefundCustomer is used by workflows to compensate a charge that should not have been made
+// ctx needs to have ActivityOptions applied
+func RefundCustomer(ctx workflow.Context, charge Charge) error {
+	ctx = profiles.WithActivityProfile(ctx, profiles.Payment)
+	return workflow.ExecuteActivity(ctx, activityRefundCustomer, charge).Get(ctx, nil)
+}
+
+// activityChargeCustomer is used to take a payment from the customer
+func activityChargeCustomer(ctx context.Context, customer string, amount models.Money, reference string) (Charge, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Charging customer", zap.String("customer", customer), zap.Stringer("amount", amount))
+
+	span := tracing.StartActivitySpan(ctx, "chargeCustomer", opentracing.Tags{"customer": customer, "amount": amount.String()})
+	defer span.Finish()
+
+	return Provider.Charge(ctx, customer, amount, reference)
+}
+
+// activityRefundCustomer is used to give back a payment to the customer
+func activityRefundCustomer(ctx context.Context, charge Charge) error {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Refunding customer", zap.String("customer", charge.Customer), zap.String("charge", charge.ID))
+
+	span := tracing.StartActivitySpan(ctx, "refundCustomer", opentracing.Tags{"customer": charge.Customer, "charge": charge.ID})
+	defer span.Finish()
+
+	return Provider.Refund(ctx, charge)
+}
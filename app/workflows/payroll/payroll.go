@@ -0,0 +1,303 @@
+// Package payroll pays the employees of every location for the shifts they worked
+// The Worker starts the payroll workflow on a cron schedule for every location, a run pays the last pay period that
+// ended unless the run before already did. The overtime rules are read with an activity and the wages are calculated
+// in the workflow from them, so replaying a run calculates the same wages even after the rules were changed
+package payroll
+
+import (
+	"context"
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/staff"
+	"programmingpercy/cadence-tavern/workflows/notify"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/retries"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// week is how long the weekly overtime is counted over, the weeks start with the pay period
+const week = 7 * 24 * time.Hour
+
+var (
+	// rules are the pay periods and overtime rules, the Worker replaces these during startup
+	rules = Rules{
+		Period:             2 * week,
+		Anchor:             time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		DailyHours:         8,
+		WeeklyHours:        40,
+		OvertimeMultiplier: 1.5,
+	}
+	rulesMu sync.RWMutex
+)
+
+func init() {
+	registry.Workflow(WorkflowPayroll)
+
+	registry.Activity("tavern.payroll.rules", activityRules)
+	registry.Activity("tavern.payroll.shifts", activityShifts)
+	registry.Activity("tavern.payroll.employees", activityEmployees)
+	registry.Activity("tavern.payroll.save", activitySave)
+}
+
+// SetRules replaces the rules while activities might be reading them
+func SetRules(r Rules) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules = r
+}
+
+// Rules are how long the pay periods are and when the hours of a shift are paid as overtime
+type Rules struct {
+	Period time.Duration `json:"period"`
+	// Anchor is when one of the pay periods started, the others start a whole number of periods away from it
+	Anchor time.Time `json:"anchor"`
+	// DailyHours are the hours of a day paid at the regular rate, 0 disables the daily overtime
+	DailyHours float64 `json:"dailyHours"`
+	// WeeklyHours are the regular hours of a week paid at the regular rate, 0 disables the weekly overtime
+	WeeklyHours float64 `json:"weeklyHours"`
+	// OvertimeMultiplier is how much more an hour of overtime pays than a regular one
+	OvertimeMultiplier float64 `json:"overtimeMultiplier"`
+}
+
+// NewRules reads the rules from the configuration
+func NewRules(cfg config.Payroll) (Rules, error) {
+	anchor, err := time.Parse("2006-01-02", cfg.Anchor)
+	if err != nil {
+		return Rules{}, fmt.Errorf("payroll anchor %q should be written as 2006-01-02", cfg.Anchor)
+	}
+	if cfg.Period < 24*time.Hour {
+		return Rules{}, fmt.Errorf("payroll period %s should be at least a day", cfg.Period)
+	}
+	if cfg.DailyHours < 0 || cfg.WeeklyHours < 0 {
+		return Rules{}, fmt.Errorf("payroll daily and weekly hours should not be negative")
+	}
+	if cfg.OvertimeMultiplier < 1 {
+		return Rules{}, fmt.Errorf("payroll overtime multiplier %v should be at least 1", cfg.OvertimeMultiplier)
+	}
+	return Rules{
+		Period:             cfg.Period,
+		Anchor:             anchor,
+		DailyHours:         cfg.DailyHours,
+		WeeklyHours:        cfg.WeeklyHours,
+		OvertimeMultiplier: cfg.OvertimeMultiplier,
+	}, nil
+}
+
+// lastPeriod is the pay period that ended last at now
+func (r Rules) lastPeriod(now time.Time) (time.Time, time.Time) {
+	elapsed := now.Sub(r.Anchor)
+	periods := elapsed / r.Period
+	if elapsed < 0 && elapsed%r.Period != 0 {
+		periods--
+	}
+	end := r.Anchor.Add(periods * r.Period)
+	return end.Add(-r.Period), end
+}
+
+// Run is the input of the payroll workflow
+type Run struct {
+	// Location is the tavern whose employees are paid
+	Location string `json:"location"`
+}
+
+// Report is the result of the payroll workflow, the next run of the schedule reads it to not pay a period twice
+type Report struct {
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	// Paid are the employees a payroll record was saved for
+	Paid []string `json:"paid,omitempty"`
+	// Unpaid are the employees with shifts that could not be paid, such as those without an hourly rate
+	Unpaid []string `json:"unpaid,omitempty"`
+}
+
+// WorkflowPayroll pays the employees of the location for the shifts of the last pay period that ended
+// Every employee with shifts in the period gets a payroll record and a payslip. A run that fails is paid again by the
+// next run of the schedule, saving a record again replaces it so nobody is paid twice
+func WorkflowPayroll(ctx workflow.Context, run Run) (Report, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    30 * time.Second,
+	})
+	ctx = workflow.WithRetryPolicy(ctx, retries.ActivityPolicy())
+	logger := workflow.GetLogger(ctx)
+
+	var last Report
+	if workflow.HasLastCompletionResult(ctx) {
+		if err := workflow.GetLastCompletionResult(ctx, &last); err != nil {
+			logger.Warn("Failed to read the last payroll report, the last period is paid again", zap.Error(err))
+		}
+	}
+
+	var r Rules
+	if err := workflow.ExecuteActivity(ctx, activityRules).Get(ctx, &r); err != nil {
+		return last, err
+	}
+	start, end := r.lastPeriod(workflow.Now(ctx))
+	if !end.After(last.PeriodEnd) {
+		logger.Debug("Payroll of the last period is already paid", zap.Time("periodEnd", last.PeriodEnd))
+		// The report is passed on, so the next run still knows what was paid
+		return last, nil
+	}
+
+	var shifts []staff.Shift
+	if err := workflow.ExecuteActivity(ctx, activityShifts, run.Location, start, end).Get(ctx, &shifts); err != nil {
+		return last, err
+	}
+	var employees []staff.Employee
+	if err := workflow.ExecuteActivity(ctx, activityEmployees, run.Location).Get(ctx, &employees); err != nil {
+		return last, err
+	}
+	known := make(map[string]staff.Employee, len(employees))
+	for _, employee := range employees {
+		known[employee.Name] = employee
+	}
+
+	report := Report{PeriodStart: start, PeriodEnd: end}
+	for _, name := range workedBy(shifts) {
+		employee, ok := known[name]
+		if !ok || employee.HourlyRate.Validate() != nil || employee.HourlyRate.IsZero() {
+			logger.Warn("Employee has shifts but no hourly rate", zap.String("employee", name))
+			report.Unpaid = append(report.Unpaid, name)
+			continue
+		}
+		record := calculate(r, employee, shifts, start, end)
+		if err := workflow.ExecuteActivity(ctx, activitySave, record).Get(ctx, nil); err != nil {
+			logger.Error("Failed to save the payroll record", zap.String("employee", name), zap.Error(err))
+			return last, err
+		}
+		report.Paid = append(report.Paid, name)
+		sendPayslip(ctx, employee, record)
+	}
+
+	if len(report.Unpaid) > 0 {
+		err := notify.Ops(ctx, map[string]interface{}{
+			"Reason": fmt.Sprintf("the payroll of location %q from %s could not pay %v, they have shifts but no hourly rate",
+				run.Location, start.Format("2006-01-02"), report.Unpaid),
+		})
+		if err != nil {
+			logger.Error("Failed to tell ops about the unpaid employees", zap.Error(err))
+		}
+	}
+	logger.Info("Paid the payroll", zap.Time("periodStart", start), zap.Int("paid", len(report.Paid)), zap.Int("unpaid", len(report.Unpaid)))
+	return report, nil
+}
+
+// workedBy returns the names of the employees with shifts, sorted by name
+func workedBy(shifts []staff.Shift) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, shift := range shifts {
+		if !seen[shift.Employee] {
+			seen[shift.Employee] = true
+			names = append(names, shift.Employee)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// calculate is the payroll record of the employee for their shifts within the period
+// The hours of a day past the daily hours are overtime, and so are the regular hours of a week past the weekly hours.
+// Days are the days in UTC the shifts started on and weeks are counted from the start of the period. It has to stay
+// deterministic, the workflow runs it again on every replay
+func calculate(r Rules, employee staff.Employee, shifts []staff.Shift, start, end time.Time) staff.PayrollRecord {
+	record := staff.PayrollRecord{
+		Location:    employee.Location,
+		Employee:    employee.Name,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		HourlyRate:  employee.HourlyRate,
+	}
+
+	var worked []staff.Shift
+	for _, shift := range shifts {
+		if shift.Employee == employee.Name && shift.End.After(shift.Start) {
+			worked = append(worked, shift)
+		}
+	}
+	sort.Slice(worked, func(i, j int) bool { return worked[i].Start.Before(worked[j].Start) })
+
+	daily := make(map[time.Time]float64)
+	weekly := make(map[time.Duration]float64)
+	for _, shift := range worked {
+		hours := shift.Hours()
+		regular := hours
+		if r.DailyHours > 0 {
+			day := shift.Start.UTC().Truncate(24 * time.Hour)
+			regular = clamp(r.DailyHours-daily[day], 0, hours)
+			daily[day] += hours
+		}
+		if r.WeeklyHours > 0 {
+			w := shift.Start.Sub(start) / week
+			regular = clamp(r.WeeklyHours-weekly[w], 0, regular)
+			weekly[w] += regular
+		}
+		record.Shifts++
+		record.RegularHours += regular
+		record.OvertimeHours += hours - regular
+	}
+
+	rate := employee.HourlyRate.Float64()
+	record.Regular = models.NewMoney(employee.HourlyRate.Currency, rate*record.RegularHours)
+	record.Overtime = models.NewMoney(employee.HourlyRate.Currency, rate*r.OvertimeMultiplier*record.OvertimeHours)
+	// Both are in the currency of the rate, adding them can not fail
+	record.Gross, _ = record.Regular.Add(record.Overtime)
+	return record
+}
+
+// clamp keeps v within min and max
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// sendPayslip tells the employee what they are paid, a failed payslip does not fail the payroll
+func sendPayslip(ctx workflow.Context, employee staff.Employee, record staff.PayrollRecord) {
+	data := map[string]interface{}{
+		"Employee": employee.Name,
+		"Gross":    record.Gross.String(),
+		"Hours":    fmt.Sprintf("%.2f", record.RegularHours+record.OvertimeHours),
+		"From":     record.PeriodStart.Format("2006-01-02"),
+		"To":       record.PeriodEnd.Add(-time.Nanosecond).Format("2006-01-02"),
+	}
+	if record.OvertimeHours > 0 {
+		data["Overtime"] = fmt.Sprintf("%.2f", record.OvertimeHours)
+	}
+	contact := notify.Contact{Name: employee.Name, Email: employee.Email, Phone: employee.Phone}
+	if err := notify.Employee(ctx, contact, notify.TemplatePayslip, data); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to send the payslip", zap.String("employee", employee.Name), zap.Error(err))
+	}
+}
+
+// activityRules returns the rules the payroll is paid by
+func activityRules(ctx context.Context) (Rules, error) {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	return rules, nil
+}
+
+// activityShifts returns the shifts of the location that started within the period
+func activityShifts(ctx context.Context, loc string, from, to time.Time) ([]staff.Shift, error) {
+	return staff.Database.Shifts(ctx, loc, from, to)
+}
+
+// activityEmployees returns the employees of the location
+func activityEmployees(ctx context.Context, loc string) ([]staff.Employee, error) {
+	return staff.Database.Employees(ctx, loc)
+}
+
+// activitySave keeps the payroll record
+func activitySave(ctx context.Context, record staff.PayrollRecord) error {
+	return staff.Database.SavePayroll(ctx, record)
+}
@@ -0,0 +1,80 @@
+package pouring
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"programmingpercy/cadence-tavern/equipment"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/workflow"
+)
+
+// ErrReasonTimeout is the reason of the error a pour is failed with when the machine never reported back
+// ErrReasonFailed is the reason when the machine reported that it could not pour
+const (
+	ErrReasonTimeout = "equipment-timeout"
+	ErrReasonFailed  = "equipment-failed"
+)
+
+var (
+	// Machine pours the drinks, the Worker replaces this during startup
+	Machine equipment.Machine = equipment.Manual{}
+	// Timeout is how long a machine has to pour, the Worker replaces this during startup
+	Timeout = 2 * time.Minute
+)
+
+func init() {
+	registry.Activity("tavern.equipment.pour", activityPour)
+}
+
+// Pour is used by workflows to pour the item of an order, it returns once the machine has reported back
+// The activity options of ctx are replaced, the activity stays open until the machine is done or Timeout has passed
+func Pour(ctx workflow.Context, location, orderID, item string) (equipment.Result, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    Timeout,
+	})
+	var result equipment.Result
+	err := workflow.ExecuteActivity(ctx, activityPour, location, orderID, item).Get(ctx, &result)
+	return result, err
+}
+
+// activityPour hands the pour to the machine and returns without a result
+// The task token is kept in the pour store, POST /equipment/done on the API completes the activity with it
+func activityPour(ctx context.Context, location, orderID, item string) (equipment.Result, error) {
+	span := tracing.StartActivitySpan(ctx, "pour", opentracing.Tags{"order": orderID, "item": item, "location": location})
+	defer span.Finish()
+
+	info := activity.GetInfo(ctx)
+	pour := equipment.Pour{
+		ID:       newPourID(),
+		Token:    info.TaskToken,
+		Location: location,
+		OrderID:  orderID,
+		Item:     item,
+		Machine:  equipment.MachineFor(item),
+		Deadline: info.Deadline,
+	}
+	if err := equipment.Pours.Register(ctx, pour); err != nil {
+		return equipment.Result{}, err
+	}
+	if err := Machine.Start(pour); err != nil {
+		equipment.Pours.Take(ctx, pour.ID)
+		return equipment.Result{}, err
+	}
+	return equipment.Result{}, activity.ErrResultPending
+}
+
+// newPourID generates a random ID the machine reports the pour back with
+func newPourID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
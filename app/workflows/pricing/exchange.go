@@ -0,0 +1,229 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/workflows/profiles"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/retries"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+var (
+	// Exchanges is where the rates of settlements in another currency come from, the Worker replaces this during startup
+	// Use SetExchanges to replace it once the Worker is running
+	Exchanges RateProvider = StaticRates{}
+
+	exchangesMu sync.RWMutex
+)
+
+func init() {
+	registry.Activity("tavern.pricing.paymentCurrency", activityPaymentCurrency)
+	registry.Activity("tavern.pricing.exchangeRate", activityExchangeRate)
+}
+
+// SetExchanges replaces the Exchanges while activities might be reading them, used when the configuration is reloaded
+func SetExchanges(p RateProvider) {
+	exchangesMu.Lock()
+	defer exchangesMu.Unlock()
+	Exchanges = p
+}
+
+// Quote is the rate between two currencies at the time it was published
+type Quote struct {
+	// Rate is how much one unit of the from currency is in the to currency
+	Rate float64
+	// Source is the provider of the rate
+	Source string
+	At     time.Time
+}
+
+// RateProvider is the needed methods to be a source of exchange rates
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (Quote, error)
+}
+
+// NewRateProvider will pick the provider based on configuration
+// If a rates URL is configured the rates are fetched from it and cached for the TTL, otherwise the configured rates are used
+func NewRateProvider(cfg config.Payments) RateProvider {
+	if cfg.RatesURL == "" {
+		return StaticRates{}
+	}
+	var provider RateProvider = &HTTPRates{
+		URL:    cfg.RatesURL,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+	if cfg.RatesCacheTTL > 0 {
+		provider = NewCachedRates(provider, cfg.RatesCacheTTL)
+	}
+	return provider
+}
+
+// StaticRates quotes the configured rates, see SetRates
+type StaticRates struct{}
+
+// Rate quotes the rate between the configured rates of the currencies
+func (sr StaticRates) Rate(ctx context.Context, from, to string) (Quote, error) {
+	fromRate, err := rate(from)
+	if err != nil {
+		return Quote{}, err
+	}
+	toRate, err := rate(to)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Rate: fromRate / toRate, Source: "config", At: time.Now().UTC()}, nil
+}
+
+// HTTPRates fetches the rates from an exchange rate service
+// The rate is requested with GET URL?base=EUR&symbols=USD, the answer is {"date": "2006-01-02", "rates": {"USD": 1.08}}
+type HTTPRates struct {
+	URL    string
+	Client *http.Client
+}
+
+// Rate will GET the rate from the exchange rate service
+func (hr *HTTPRates) Rate(ctx context.Context, from, to string) (Quote, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hr.URL+"?"+url.Values{"base": {from}, "symbols": {to}}.Encode(), nil)
+	if err != nil {
+		return Quote{}, err
+	}
+	resp, err := hr.Client.Do(req)
+	if err != nil {
+		return Quote{}, fmt.Errorf("failed to fetch exchange rate: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Quote{}, fmt.Errorf("exchange rate service responded with %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Date  string             `json:"date"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Quote{}, fmt.Errorf("failed to decode exchange rate: %v", err)
+	}
+	r, ok := body.Rates[to]
+	if !ok || r <= 0 {
+		return Quote{}, fmt.Errorf("exchange rate service has no rate from %s to %s", from, to)
+	}
+	at, err := time.Parse("2006-01-02", body.Date)
+	if err != nil {
+		at = time.Now().UTC()
+	}
+	return Quote{Rate: r, Source: req.URL.Host, At: at}, nil
+}
+
+// CachedRates keeps the quotes of another provider for a while, so settling a busy evening does not call it for every tab
+type CachedRates struct {
+	next RateProvider
+	ttl  time.Duration
+
+	mu     sync.Mutex
+	quotes map[string]cachedQuote
+}
+
+// cachedQuote is a quote and when it was fetched
+type cachedQuote struct {
+	quote   Quote
+	fetched time.Time
+}
+
+// NewCachedRates caches the quotes of next for the ttl
+func NewCachedRates(next RateProvider, ttl time.Duration) *CachedRates {
+	return &CachedRates{
+		next:   next,
+		ttl:    ttl,
+		quotes: make(map[string]cachedQuote),
+	}
+}
+
+// Rate returns the cached quote while it is fresh and fetches it from the provider otherwise, failures are not cached
+func (cr *CachedRates) Rate(ctx context.Context, from, to string) (Quote, error) {
+	key := from + "/" + to
+	cr.mu.Lock()
+	cached, ok := cr.quotes[key]
+	cr.mu.Unlock()
+	if ok && time.Since(cached.fetched) < cr.ttl {
+		return cached.quote, nil
+	}
+
+	quote, err := cr.next.Rate(ctx, from, to)
+	if err != nil {
+		return Quote{}, err
+	}
+	cr.mu.Lock()
+	cr.quotes[key] = cachedQuote{quote: quote, fetched: time.Now()}
+	cr.mu.Unlock()
+	return quote, nil
+}
+
+// ToPaymentCurrency converts the amount into the currency the customer pays in
+// The exchange is nil when the customer pays in the currency of the amount, the amount is then returned as it is
+// ctx needs to have ActivityOptions applied
+func ToPaymentCurrency(ctx workflow.Context, location, name string, amount models.Money) (models.Money, *models.Exchange, error) {
+	ctx = profiles.WithActivityProfile(ctx, profiles.Lookup)
+	ctx = workflow.WithRetryPolicy(ctx, retries.ActivityPolicy(ErrReasonUnknownCurrency))
+
+	var currency string
+	if err := workflow.ExecuteActivity(ctx, activityPaymentCurrency, location, name).Get(ctx, &currency); err != nil {
+		return amount, nil, err
+	}
+	if currency == "" || currency == amount.Currency || amount.IsZero() {
+		return amount, nil, nil
+	}
+	var exchange models.Exchange
+	if err := workflow.ExecuteActivity(ctx, activityExchangeRate, amount, currency).Get(ctx, &exchange); err != nil {
+		return amount, nil, err
+	}
+	return exchange.To, &exchange, nil
+}
+
+// activityPaymentCurrency returns the currency the customer pays in, empty when they pay in the currency of the menu
+// Customers that are not in the repository, such as walk ins, pay in the currency of the menu
+func activityPaymentCurrency(ctx context.Context, location, name string) (string, error) {
+	cust, err := customer.Database.Get(ctx, location, name)
+	if errors.Is(err, customer.ErrNoSuchCustomer) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return cust.Currency, nil
+}
+
+// activityExchangeRate converts the amount into the currency at the rate of the Exchanges, rounded to the minor unit
+func activityExchangeRate(ctx context.Context, amount models.Money, currency string) (models.Exchange, error) {
+	exchangesMu.RLock()
+	provider := Exchanges
+	exchangesMu.RUnlock()
+
+	quote, err := provider.Rate(ctx, amount.Currency, currency)
+	if err != nil {
+		return models.Exchange{}, err
+	}
+	exchange := models.Exchange{
+		From:   amount,
+		To:     models.NewMoney(currency, amount.Float64()*quote.Rate),
+		Rate:   quote.Rate,
+		Source: quote.Source,
+		At:     quote.At,
+	}
+	activity.GetLogger(ctx).Info("Exchanged amount", zap.Stringer("from", exchange.From), zap.Stringer("to", exchange.To),
+		zap.Float64("rate", exchange.Rate), zap.String("source", exchange.Source))
+	return exchange, nil
+}
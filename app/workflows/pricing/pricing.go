@@ -0,0 +1,121 @@
+// Package pricing validates the prices of orders and converts them into the currency customers are charged in
+// models.Currency is the currency of the payments, the rates convert every other accepted currency into it
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/workflows/profiles"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/retries"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/workflow"
+)
+
+const (
+	// ErrReasonInvalidMoney is returned for an amount that can never be charged, such as a negative price
+	ErrReasonInvalidMoney = "invalid-money"
+	// ErrReasonUnknownCurrency is returned for an amount in a currency there is no rate for
+	ErrReasonUnknownCurrency = "unknown-currency"
+)
+
+var (
+	// rates are how much one unit of a currency is in models.Currency, the Worker replaces them during startup
+	rates   = map[string]float64{}
+	ratesMu sync.RWMutex
+)
+
+func init() {
+	registry.Activity("tavern.pricing.validate", activityValidate)
+	registry.Activity("tavern.pricing.convert", activityConvert)
+}
+
+// SetRates replaces the rates while activities might be reading them
+func SetRates(r map[string]float64) {
+	ratesMu.Lock()
+	defer ratesMu.Unlock()
+	rates = r
+}
+
+// ParseRates reads rates written as currency=rate, such as eur=1.08 when one euro is 1.08 of models.Currency
+func ParseRates(raw []string) (map[string]float64, error) {
+	parsed := make(map[string]float64, len(raw))
+	for _, r := range raw {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("rate %q should be currency=rate", r)
+		}
+		currency := models.NormalizeCurrency(parts[0])
+		if len(currency) != 3 {
+			return nil, fmt.Errorf("rate %q should be for an ISO 4217 code such as eur", r)
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("rate %q should be a positive number", r)
+		}
+		parsed[currency] = rate
+	}
+	return parsed, nil
+}
+
+// ToChargeCurrency validates the amount and converts it into the currency it is charged in
+// The validation answers the charge currency, so the conversion only runs for amounts in another currency
+// ctx needs to have ActivityOptions applied
+func ToChargeCurrency(ctx workflow.Context, amount models.Money) (models.Money, error) {
+	ctx = profiles.WithActivityProfile(ctx, profiles.Lookup)
+	ctx = workflow.WithRetryPolicy(ctx, retries.ActivityPolicy(ErrReasonInvalidMoney, ErrReasonUnknownCurrency))
+
+	var currency string
+	if err := workflow.ExecuteActivity(ctx, activityValidate, amount).Get(ctx, &currency); err != nil {
+		return amount, err
+	}
+	if amount.Currency == currency || amount.IsZero() {
+		return amount, nil
+	}
+	var converted models.Money
+	err := workflow.ExecuteActivity(ctx, activityConvert, amount, currency).Get(ctx, &converted)
+	return converted, err
+}
+
+// activityValidate rejects amounts that can not be charged and returns the currency they are charged in
+func activityValidate(ctx context.Context, amount models.Money) (string, error) {
+	if err := amount.Validate(); err != nil {
+		return "", cadence.NewCustomError(ErrReasonInvalidMoney, err.Error())
+	}
+	if _, err := rate(amount.Currency); err != nil && !amount.IsZero() {
+		return "", err
+	}
+	return models.Currency, nil
+}
+
+// activityConvert converts the amount into the currency with the rates, the result is rounded to the minor unit
+func activityConvert(ctx context.Context, amount models.Money, currency string) (models.Money, error) {
+	from, err := rate(amount.Currency)
+	if err != nil {
+		return models.Money{}, err
+	}
+	to, err := rate(currency)
+	if err != nil {
+		return models.Money{}, err
+	}
+	return models.NewMoney(currency, amount.Float64()*from/to), nil
+}
+
+// rate is how much one unit of the currency is in models.Currency
+func rate(currency string) (float64, error) {
+	if currency == models.Currency {
+		return 1, nil
+	}
+	ratesMu.RLock()
+	defer ratesMu.RUnlock()
+	r, ok := rates[currency]
+	if !ok {
+		return 0, cadence.NewCustomError(ErrReasonUnknownCurrency, currency)
+	}
+	return r, nil
+}
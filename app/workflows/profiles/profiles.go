@@ -0,0 +1,169 @@
+// Package profiles gives each kind of activity its own timeouts and retries
+// A workflow applies a profile before executing the activity, such as WithActivityProfile(ctx, StoreCustomer),
+// so a slow payment gets a larger budget than a quick repository lookup. The profiles are loaded from config
+// and only change the options of activities scheduled after the change, Cadence does not compare the options on replay
+package profiles
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/workflow"
+)
+
+// The profiles used by the workflows
+const (
+	// Lookup is used by activities reading a repository
+	Lookup = "lookup"
+	// StoreCustomer is used by activities writing a customer
+	StoreCustomer = "store-customer"
+	// Payment is used by activities calling the payment provider
+	Payment = "payment"
+)
+
+// Profile is the part of the ActivityOptions a kind of activity overrides, zero fields keep the options of the context
+type Profile struct {
+	ScheduleToStartTimeout time.Duration `json:"scheduleToStartTimeout,omitempty"`
+	StartToCloseTimeout    time.Duration `json:"startToCloseTimeout,omitempty"`
+	HeartbeatTimeout       time.Duration `json:"heartbeatTimeout,omitempty"`
+	// RetryPolicy replaces the retry policy of the context, it is only set when the profile has attempts or expiration
+	RetryPolicy *cadence.RetryPolicy `json:"retryPolicy,omitempty"`
+}
+
+var (
+	// Profiles are the profiles by name, the Worker replaces these during startup
+	Profiles = Defaults()
+
+	profilesMu sync.RWMutex
+)
+
+// Defaults are the profiles used when config does not override them
+func Defaults() map[string]Profile {
+	return map[string]Profile{
+		Lookup:        {StartToCloseTimeout: 10 * time.Second},
+		StoreCustomer: {StartToCloseTimeout: 30 * time.Second},
+		Payment:       {ScheduleToStartTimeout: time.Minute, StartToCloseTimeout: 2 * time.Minute},
+	}
+}
+
+// Set replaces the Profiles while workflows might be reading them, used when the configuration is reloaded
+func Set(profiles map[string]Profile) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	Profiles = profiles
+}
+
+// Get returns the profile by name, false when there is no such profile
+func Get(name string) (Profile, bool) {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	profile, ok := Profiles[name]
+	return profile, ok
+}
+
+// WithActivityProfile applies the profile on top of the ActivityOptions of ctx
+// An unknown profile leaves ctx as it is, ctx needs to have ActivityOptions applied
+func WithActivityProfile(ctx workflow.Context, name string) workflow.Context {
+	profile, ok := Get(name)
+	if !ok {
+		return ctx
+	}
+	if profile.ScheduleToStartTimeout > 0 {
+		ctx = workflow.WithScheduleToStartTimeout(ctx, profile.ScheduleToStartTimeout)
+	}
+	if profile.StartToCloseTimeout > 0 {
+		ctx = workflow.WithStartToCloseTimeout(ctx, profile.StartToCloseTimeout)
+	}
+	if profile.HeartbeatTimeout > 0 {
+		ctx = workflow.WithHeartbeatTimeout(ctx, profile.HeartbeatTimeout)
+	}
+	if profile.RetryPolicy != nil {
+		ctx = workflow.WithRetryPolicy(ctx, *profile.RetryPolicy)
+	}
+	return ctx
+}
+
+// Parse reads profile.field=value settings on top of Defaults, such as payment.startToClose=5m or payment.attempts=3
+// The fields are scheduleToStart, startToClose, heartbeat, attempts, initialInterval, maxInterval, backoff and expiration
+func Parse(settings []string) (map[string]Profile, error) {
+	profiles := Defaults()
+	for _, setting := range settings {
+		parts := strings.SplitN(setting, "=", 2)
+		key := strings.SplitN(parts[0], ".", 2)
+		if len(parts) != 2 || len(key) != 2 || key[0] == "" {
+			return nil, fmt.Errorf("activity profile %q should be profile.field=value", setting)
+		}
+		name, field, value := strings.TrimSpace(key[0]), strings.TrimSpace(key[1]), strings.TrimSpace(parts[1])
+
+		profile := profiles[name]
+		if err := profile.set(field, value); err != nil {
+			return nil, fmt.Errorf("activity profile %s: %v", setting, err)
+		}
+		profiles[name] = profile
+	}
+	for name, profile := range profiles {
+		if profile.RetryPolicy != nil && profile.RetryPolicy.MaximumAttempts == 0 && profile.RetryPolicy.ExpirationInterval == 0 {
+			return nil, fmt.Errorf("activity profile %s needs attempts or expiration to retry", name)
+		}
+	}
+	return profiles, nil
+}
+
+// set changes one field of the profile
+func (pr *Profile) set(field, value string) error {
+	switch field {
+	case "attempts":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("attempts must be zero or a positive number")
+		}
+		pr.retryPolicy().MaximumAttempts = int32(n)
+		return nil
+	case "backoff":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 1 {
+			return fmt.Errorf("backoff must be a number of at least 1")
+		}
+		pr.retryPolicy().BackoffCoefficient = f
+		return nil
+	case "scheduleToStart", "startToClose", "heartbeat", "initialInterval", "maxInterval", "expiration":
+	default:
+		return fmt.Errorf("unknown field %s", field)
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return fmt.Errorf("%s must be a positive duration", field)
+	}
+	switch field {
+	case "scheduleToStart":
+		pr.ScheduleToStartTimeout = d
+	case "startToClose":
+		pr.StartToCloseTimeout = d
+	case "heartbeat":
+		pr.HeartbeatTimeout = d
+	case "initialInterval":
+		pr.retryPolicy().InitialInterval = d
+	case "maxInterval":
+		pr.retryPolicy().MaximumInterval = d
+	case "expiration":
+		pr.retryPolicy().ExpirationInterval = d
+	}
+	return nil
+}
+
+// retryPolicy returns the retry policy of the profile, a new one starts from the intervals of retries.ActivityPolicy
+func (pr *Profile) retryPolicy() *cadence.RetryPolicy {
+	if pr.RetryPolicy == nil {
+		pr.RetryPolicy = &cadence.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2,
+			MaximumInterval:    10 * time.Second,
+		}
+	}
+	return pr.RetryPolicy
+}
@@ -0,0 +1,76 @@
+package receipts
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// RenderPDF renders the receipt as a single page text-only PDF
+// It writes the PDF objects by hand to avoid pulling in a PDF library for a receipt
+func RenderPDF(receipt Receipt) []byte {
+	lines := []string{
+		"The Tavern",
+		fmt.Sprintf("Receipt %s for %s", receipt.ID, receipt.Customer),
+		receipt.IssuedAt.Format("2006-01-02 15:04"),
+		"",
+	}
+	for _, line := range receipt.Lines {
+		lines = append(lines, fmt.Sprintf("%-30s %12s", line.Item, line.Price))
+	}
+	lines = append(lines, "", fmt.Sprintf("%-30s %12s", "Total", receipt.Total))
+	if !receipt.Tip.IsZero() {
+		lines = append(lines, fmt.Sprintf("%-30s %12s", "Tip", receipt.Tip))
+	}
+	if ex := receipt.Exchange; ex != nil {
+		lines = append(lines, fmt.Sprintf("%-30s %12s", "Paid in "+ex.To.Currency, ex.To),
+			fmt.Sprintf("Rate %g from %s on %s", ex.Rate, ex.Source, ex.At.Format("2006-01-02")))
+	}
+	if receipt.ChargeID != "" {
+		lines = append(lines, "Paid with charge "+receipt.ChargeID)
+	}
+	for _, p := range receipt.Payments {
+		lines = append(lines, fmt.Sprintf("%-30s %12s %s", p.Payer, p.Amount, p.Charge.ID))
+		if ex := p.Exchange; ex != nil {
+			lines = append(lines, fmt.Sprintf("%-30s %12s at %g", "  paid in "+ex.To.Currency, ex.To, ex.Rate))
+		}
+	}
+
+	// Build the page content, one text line every 16 points from the top
+	var content bytes.Buffer
+	content.WriteString("BT\n/F1 12 Tf\n50 800 Td\n16 TL\n")
+	for _, line := range lines {
+		fmt.Fprintf(&content, "(%s) Tj T*\n", escapePDF(line))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes()
+}
+
+// escapePDF escapes the characters that have a meaning inside PDF strings
+func escapePDF(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(s)
+}
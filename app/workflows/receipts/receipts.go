@@ -0,0 +1,125 @@
+package receipts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"programmingpercy/cadence-tavern/blobstore"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"time"
+
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+var (
+	// Store is where rendered receipts are stored, the Worker replaces this during startup
+	Store blobstore.Store = blobstore.NewMemoryStore()
+	// Format is the output format of receipts, html or pdf
+	Format = "html"
+
+	receiptTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Receipt {{.ID}}</title></head>
+<body>
+<h1>The Tavern</h1>
+<p>Receipt {{.ID}} for {{.Customer}}</p>
+<p>{{.IssuedAt.Format "2006-01-02 15:04"}}</p>
+<table>
+{{range .Lines}}<tr><td>{{.Item}}</td><td>{{.Price}}</td></tr>
+{{end}}<tr><td><b>Total</b></td><td><b>{{.Total}}</b></td></tr>
+{{if .Tip.Minor}}<tr><td>Tip</td><td>{{.Tip}}</td></tr>
+{{end}}{{with .Exchange}}<tr><td>Paid in {{.To.Currency}} at {{.Rate}} ({{.Source}} {{.At.Format "2006-01-02"}})</td><td><b>{{.To}}</b></td></tr>
+{{end}}</table>
+{{if .ChargeID}}<p>Paid with charge {{.ChargeID}}</p>{{end}}
+{{with .Payments}}<table>
+{{range .}}<tr><td>{{.Payer}}</td><td>{{.Amount}}</td><td>{{with .Exchange}}{{.To}} at {{.Rate}}{{end}}</td><td>{{.Charge.ID}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+)
+
+// Line is a single item on a receipt
+type Line struct {
+	Item  string       `json:"item"`
+	Price models.Money `json:"price"`
+}
+
+// Receipt is the information printed on a receipt
+type Receipt struct {
+	// ID should be unique, the workflow ID works well
+	ID       string       `json:"id"`
+	Customer string       `json:"customer"`
+	Lines    []Line       `json:"lines"`
+	Total    models.Money `json:"total"`
+	// Tip is paid on top of the total
+	Tip models.Money `json:"tip,omitempty"`
+	// Exchange is set when the total was paid in another currency, the charge is in that currency
+	Exchange *models.Exchange `json:"exchange,omitempty"`
+	ChargeID string           `json:"chargeId"`
+	// Payments are the shares of a split tab, one receipt lists all of them
+	Payments []models.TabPayment `json:"payments,omitempty"`
+	IssuedAt time.Time           `json:"issuedAt"`
+}
+
+func init() {
+	registry.Activity("tavern.receipts.generate", activityGenerateReceipt)
+}
+
+// Generate is used by workflows to render and store a receipt, the URL of the receipt is returned
+// ctx needs to have ActivityOptions applied
+func Generate(ctx workflow.Context, receipt Receipt) (string, error) {
+	var url string
+	err := workflow.ExecuteActivity(ctx, activityGenerateReceipt, receipt).Get(ctx, &url)
+	return url, err
+}
+
+// activityGenerateReceipt renders the receipt in the configured Format and stores it
+func activityGenerateReceipt(ctx context.Context, receipt Receipt) (string, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Generating receipt", zap.String("receipt", receipt.ID), zap.String("format", Format))
+
+	if receipt.IssuedAt.IsZero() {
+		receipt.IssuedAt = time.Now()
+	}
+
+	var (
+		data        []byte
+		contentType string
+		err         error
+	)
+	switch Format {
+	case "pdf":
+		data = RenderPDF(receipt)
+		contentType = "application/pdf"
+	default:
+		data, err = RenderHTML(receipt)
+		contentType = "text/html"
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return Store.Put(ctx, fmt.Sprintf("receipts/%s.%s", receipt.ID, extension(contentType)), contentType, data)
+}
+
+// RenderHTML renders the receipt with the HTML template
+func RenderHTML(receipt Receipt) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := receiptTemplate.Execute(&buf, receipt); err != nil {
+		return nil, fmt.Errorf("failed to render receipt: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// extension returns the file extension used for the content type
+func extension(contentType string) string {
+	if contentType == "application/pdf" {
+		return "pdf"
+	}
+	return "html"
+}
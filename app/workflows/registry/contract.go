@@ -0,0 +1,135 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ContractsFile is where the contracts of the activities are checked in, relative to the module root
+// Workers in other languages implement activities from it, cmd/contracts keeps it in sync with the code
+const ContractsFile = "workflows/registry/contracts.json"
+
+// Contract is the language neutral description of an activity
+type Contract struct {
+	// Name is the stable name the activity is registered and scheduled as
+	Name string `json:"name"`
+	// Input are the arguments of the activity in order
+	Input []*Schema `json:"input"`
+	// Output is the result of the activity, it is empty when only an error is returned
+	Output *Schema `json:"output,omitempty"`
+}
+
+// Schema is a small subset of JSON schema describing a payload as the JSON data converter writes it
+type Schema struct {
+	Type       string             `json:"type,omitempty"`
+	Format     string             `json:"format,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	// Values is the schema of the values of a map
+	Values *Schema `json:"values,omitempty"`
+	// Required are the properties that are always written, fields tagged omitempty are left out
+	Required []string `json:"required,omitempty"`
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+	rawType     = reflect.TypeOf(json.RawMessage{})
+)
+
+// newContract describes the activity function registered as name
+func newContract(name string, fn interface{}) Contract {
+	t := reflect.TypeOf(fn)
+	contract := Contract{Name: name, Input: []*Schema{}}
+	for i := 0; i < t.NumIn(); i++ {
+		if t.In(i) == contextType {
+			continue
+		}
+		contract.Input = append(contract.Input, schemaOf(t.In(i)))
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		if t.Out(i) != errorType {
+			contract.Output = schemaOf(t.Out(i))
+		}
+	}
+	return contract
+}
+
+// SchemaOf describes the JSON encoding of the value, such as the models the API serves the schemas of
+func SchemaOf(v interface{}) *Schema {
+	return schemaOf(reflect.TypeOf(v))
+}
+
+// schemaOf describes the JSON encoding of t
+func schemaOf(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawType:
+		// Any JSON value
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", Values: schemaOf(t.Elem())}
+	case reflect.Struct:
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		addProperties(schema, t)
+		return schema
+	}
+	// interface{} can be anything
+	return &Schema{}
+}
+
+// addProperties adds the exported fields of the struct by their JSON name, embedded structs are flattened
+func addProperties(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		omitempty := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, option := range parts[1:] {
+				omitempty = omitempty || option == "omitempty"
+			}
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && name == field.Name {
+			addProperties(schema, field.Type)
+			continue
+		}
+		schema.Properties[name] = schemaOf(field.Type)
+		if !omitempty {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package registry
+
+import (
+	"sync"
+)
+
+var (
+	// running counts the executions of every activity that have not returned yet
+	running   = map[string]int{}
+	runningMu sync.Mutex
+)
+
+// started counts an execution of the activity as running, the returned func marks it as returned
+func started(name string) func() {
+	runningMu.Lock()
+	running[name]++
+	runningMu.Unlock()
+	return func() {
+		runningMu.Lock()
+		defer runningMu.Unlock()
+		running[name]--
+		if running[name] <= 0 {
+			delete(running, name)
+		}
+	}
+}
+
+// Running is how many executions of each activity are running in this process, activities are left out once none is
+// Only activities taking a context are counted, they are the ones recovering wraps
+func Running() map[string]int {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	counts := make(map[string]int, len(running))
+	for name, count := range running {
+		counts[name] = count
+	}
+	return counts
+}
@@ -0,0 +1,187 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/activity"
+	"go.uber.org/zap"
+)
+
+const (
+	// ErrReasonActivityPanic is the reason of the error an activity returns when it panicked, the details are a PanicDetails
+	ErrReasonActivityPanic = "activity-panic"
+	// ErrReasonQuarantined is the reason of the error an activity returns for a quarantined payload, it never runs again
+	ErrReasonQuarantined = "activity-quarantined"
+
+	// MetricActivityPanics is counted for every panic of an activity, tagged with the activity
+	MetricActivityPanics = "activity_panics"
+	// MetricActivityQuarantined is counted for every payload that is quarantined, tagged with the activity
+	MetricActivityQuarantined = "activity_quarantined"
+)
+
+// PanicDetails are the details of the errors of panicking activities
+type PanicDetails struct {
+	Activity string `json:"activity"`
+	Value    string `json:"value"`
+	Stack    string `json:"stack"`
+	// Panics is how many times this worker saw the activity panic on the payload
+	Panics int `json:"panics"`
+}
+
+// Quarantined is a payload an activity panicked on too many times, it is handed to Quarantine
+type Quarantined struct {
+	Activity string
+	// Payload is the JSON array of the inputs of the activity, the context left out
+	Payload string
+	PanicDetails
+}
+
+var (
+	// QuarantineAfter is how many panics of an activity on the same payload are tolerated before it is quarantined
+	// 0 never quarantines, the Worker replaces this during startup
+	QuarantineAfter = 3
+	// Quarantine keeps a quarantined payload for an operator, the Worker replaces this during startup
+	Quarantine = func(ctx context.Context, q Quarantined) error { return nil }
+	// Inject runs before the activity, an error fails the activity without running it
+	// The Worker replaces this with faultinject.Inject when fault injection is enabled
+	Inject = func(ctx context.Context, name string) error { return nil }
+
+	// panics counts the panics by payload key, quarantined are the keys that no longer run
+	panicsMu    sync.Mutex
+	panics      = map[string]int{}
+	quarantined = map[string]PanicDetails{}
+)
+
+// maxPanicKeys bounds the panic counts, they are forgotten once this many payloads panicked
+const maxPanicKeys = 10000
+
+// recovering wraps the activity function fn registered as name, panics are recovered and returned as errors
+// A payload that keeps panicking is quarantined, later executions with it fail right away instead of panicking again
+// Faults are injected by Inject before the activity runs, and every execution is counted as Running until it returns
+func recovering(name string, fn interface{}) interface{} {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+	wrapped := reflect.MakeFunc(fnType, func(args []reflect.Value) (results []reflect.Value) {
+		ctx := activityContext(args)
+		defer started(name)()
+		if details, ok := isQuarantined(name, args); ok {
+			return failWith(fnType, cadence.NewCustomError(ErrReasonQuarantined, details))
+		}
+		if err := Inject(ctx, name); err != nil {
+			return failWith(fnType, err)
+		}
+
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			results = failWith(fnType, recovered(ctx, name, args, value, string(debug.Stack())))
+		}()
+		return fnValue.Call(args)
+	})
+	return wrapped.Interface()
+}
+
+// recovered reports the panic and returns the error the activity fails with
+func recovered(ctx context.Context, name string, args []reflect.Value, value interface{}, stack string) error {
+	logger := activity.GetLogger(ctx)
+	scope := activity.GetMetricsScope(ctx).Tagged(map[string]string{"activity": name})
+	scope.Counter(MetricActivityPanics).Inc(1)
+
+	payload, key := payloadOf(name, args)
+	details := PanicDetails{Activity: name, Value: fmt.Sprint(value), Stack: stack}
+
+	panicsMu.Lock()
+	if len(panics) >= maxPanicKeys {
+		panics = map[string]int{}
+	}
+	panics[key]++
+	details.Panics = panics[key]
+	quarantine := QuarantineAfter > 0 && details.Panics >= QuarantineAfter
+	if quarantine {
+		delete(panics, key)
+		quarantined[key] = details
+	}
+	panicsMu.Unlock()
+
+	logger.Error("Activity panicked", zap.String("activity", name), zap.String("panic", details.Value),
+		zap.Int("panics", details.Panics), zap.String("stack", stack))
+	if !quarantine {
+		return cadence.NewCustomError(ErrReasonActivityPanic, details)
+	}
+
+	scope.Counter(MetricActivityQuarantined).Inc(1)
+	if err := Quarantine(ctx, Quarantined{Activity: name, Payload: payload, PanicDetails: details}); err != nil {
+		// The payload is still quarantined in this worker, only the copy for the operator is missing
+		logger.Error("Failed to keep quarantined payload", zap.String("activity", name), zap.Error(err))
+	}
+	return cadence.NewCustomError(ErrReasonQuarantined, details)
+}
+
+// isQuarantined returns the details of the quarantine when the payload is quarantined
+func isQuarantined(name string, args []reflect.Value) (PanicDetails, bool) {
+	panicsMu.Lock()
+	empty := len(quarantined) == 0
+	panicsMu.Unlock()
+	// Most workers never quarantine anything, so the payload is only encoded when there is something to compare
+	if empty {
+		return PanicDetails{}, false
+	}
+
+	_, key := payloadOf(name, args)
+	panicsMu.Lock()
+	defer panicsMu.Unlock()
+	details, ok := quarantined[key]
+	return details, ok
+}
+
+// payloadOf encodes the inputs of the activity, the key identifies the activity and payload
+func payloadOf(name string, args []reflect.Value) (string, string) {
+	inputs := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		if arg.Type() == contextType {
+			continue
+		}
+		inputs = append(inputs, arg.Interface())
+	}
+	data, err := json.Marshal(inputs)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%#v", inputs))
+	}
+	sum := sha256.Sum256(append([]byte(name+"\x00"), data...))
+	return string(data), hex.EncodeToString(sum[:])
+}
+
+// activityContext returns the context the activity was called with
+func activityContext(args []reflect.Value) context.Context {
+	for _, arg := range args {
+		if arg.Type() == contextType {
+			if ctx, ok := arg.Interface().(context.Context); ok {
+				return ctx
+			}
+		}
+	}
+	return context.Background()
+}
+
+// failWith returns err as the result of a function of type fnType, all other results are zero
+func failWith(fnType reflect.Type, err error) []reflect.Value {
+	results := make([]reflect.Value, fnType.NumOut())
+	for i := range results {
+		if fnType.Out(i) == errorType {
+			results[i] = reflect.ValueOf(&err).Elem()
+			continue
+		}
+		results[i] = reflect.Zero(fnType.Out(i))
+	}
+	return results
+}
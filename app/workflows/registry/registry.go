@@ -0,0 +1,148 @@
+package registry
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/workflow"
+)
+
+// The names of the workflows the API starts, they are the names cadence registers the functions as
+const (
+	OrderWorkflow     = "programmingpercy/cadence-tavern/workflows/orders.WorkflowOrder"
+	GreetingsWorkflow = "programmingpercy/cadence-tavern/workflows/greetings.workflowGreetings"
+	TabWorkflow       = "programmingpercy/cadence-tavern/workflows/tabs.WorkflowTab"
+	TableWorkflow     = "programmingpercy/cadence-tavern/workflows/seating.WorkflowTable"
+	CallbackWorkflow  = "programmingpercy/cadence-tavern/workflows/callback.workflowDeliver"
+	SessionWorkflow   = "programmingpercy/cadence-tavern/workflows/session.WorkflowSession"
+	// SelfTestWorkflow is started by the Worker on itself, the API never starts it so it is not in the Manifest
+	SelfTestWorkflow = "programmingpercy/cadence-tavern/workflows/selftest.WorkflowPing"
+	// CanaryWorkflow is scheduled by the Worker for every location, the API never starts it either
+	CanaryWorkflow = "programmingpercy/cadence-tavern/workflows/canary.WorkflowCanary"
+	// StockMonitorWorkflow is scheduled by the Worker for every location as well
+	StockMonitorWorkflow = "programmingpercy/cadence-tavern/workflows/stock.WorkflowStockMonitor"
+	// PayrollWorkflow is scheduled by the Worker for every location as well
+	PayrollWorkflow = "programmingpercy/cadence-tavern/workflows/payroll.WorkflowPayroll"
+)
+
+// Manifest is every workflow the API expects a worker to be able to run
+var Manifest = []string{OrderWorkflow, GreetingsWorkflow, TabWorkflow, TableWorkflow, CallbackWorkflow, SessionWorkflow}
+
+var (
+	mu         sync.Mutex
+	workflows  = map[string]bool{}
+	activities = map[string]Contract{}
+)
+
+// Workflow registers the workflow with cadence and remembers its name
+// Use it instead of workflow.Register so the registrations can be checked
+func Workflow(fn interface{}) {
+	workflow.Register(fn)
+
+	mu.Lock()
+	defer mu.Unlock()
+	workflows[functionName(fn)] = true
+}
+
+// Activity registers the activity with cadence under a stable, language neutral name such as tavern.orders.checkBanned
+// Workflows still execute it by function, cadence resolves the name
+// Use it instead of activity.Register so the registrations can be checked
+// Panics of activities taking a context are recovered and returned as errors, see recovering
+func Activity(name string, fn interface{}) {
+	activity.RegisterWithOptions(fn, activity.RegisterOptions{Name: name})
+	// Cadence resolves the function workflows execute to the name registered first, the recovering wrapper then
+	// replaces what runs under the name
+	if t := reflect.TypeOf(fn); t.NumIn() > 0 && t.In(0) == contextType {
+		activity.RegisterWithOptions(recovering(name, fn), activity.RegisterOptions{Name: name, DisableAlreadyRegisteredCheck: true})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	activities[name] = newContract(name, fn)
+}
+
+// Workflows returns the names of all registered workflows, sorted
+func Workflows() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	return sorted(workflows)
+}
+
+// Activities returns the names of all registered activities, sorted
+func Activities() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(activities))
+	for name := range activities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Contracts returns the contracts of all registered activities, sorted by name
+func Contracts() []Contract {
+	names := Activities()
+
+	mu.Lock()
+	defer mu.Unlock()
+	contracts := make([]Contract, len(names))
+	for i, name := range names {
+		contracts[i] = activities[name]
+	}
+	return contracts
+}
+
+// Resolve returns the registered workflow called name, it is either the full name or the end of it such as
+// orders.WorkflowOrder or WorkflowOrder when that only matches one workflow
+func Resolve(name string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if workflows[name] {
+		return name, true
+	}
+	var found string
+	for registered := range workflows {
+		if strings.HasSuffix(registered, "/"+name) || strings.HasSuffix(registered, "."+name) {
+			if found != "" {
+				return "", false
+			}
+			found = registered
+		}
+	}
+	return found, found != ""
+}
+
+// Missing returns the workflows in expected that are not registered
+func Missing(expected []string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var missing []string
+	for _, name := range expected {
+		if !workflows[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// functionName is the name cadence registers a function as
+func functionName(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	return strings.TrimSuffix(name, "-fm")
+}
+
+func sorted(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// SignalContract is the payload a signal is sent with, the API validates payloads against it
+type SignalContract struct {
+	Name string `json:"name"`
+	// Version is the envelope version the payload is wrapped in
+	Version int `json:"version"`
+	// Payload is empty for signals without a payload
+	Payload *Schema `json:"payload,omitempty"`
+}
+
+var signals = map[string]SignalContract{}
+
+// Signal registers the payload of a signal, payload is a value of the payload type or nil when there is none
+// Registered signals can be sent through the generic signal endpoint of the API
+func Signal(name string, version int, payload interface{}) {
+	contract := SignalContract{Name: name, Version: version}
+	if payload != nil {
+		contract.Payload = schemaOf(reflect.TypeOf(payload))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	signals[name] = contract
+}
+
+// Signals returns the contracts of all registered signals, sorted by name
+func Signals() []SignalContract {
+	mu.Lock()
+	defer mu.Unlock()
+	contracts := make([]SignalContract, 0, len(signals))
+	for _, contract := range signals {
+		contracts = append(contracts, contract)
+	}
+	sort.Slice(contracts, func(i, j int) bool { return contracts[i].Name < contracts[j].Name })
+	return contracts
+}
+
+// LookupSignal returns the contract of the signal
+func LookupSignal(name string) (SignalContract, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	contract, ok := signals[name]
+	return contract, ok
+}
+
+// Validate checks that the payload is the JSON the contract describes
+func (sc SignalContract) Validate(payload json.RawMessage) error {
+	empty := len(bytes.TrimSpace(payload)) == 0 || string(bytes.TrimSpace(payload)) == "null"
+	if sc.Payload == nil {
+		if !empty {
+			return fmt.Errorf("signal %s has no payload", sc.Name)
+		}
+		return nil
+	}
+	if empty {
+		return fmt.Errorf("signal %s needs a payload", sc.Name)
+	}
+	return sc.Payload.Validate(payload)
+}
+
+// Validate checks that data is JSON matching the schema
+func (s *Schema) Validate(data json.RawMessage) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("payload is not JSON: %v", err)
+	}
+	return s.validate("payload", value)
+}
+
+// validate checks a decoded JSON value, path names the value in errors
+func (s *Schema) validate(path string, value interface{}) error {
+	if s == nil || s.Type == "" || value == nil {
+		// Anything goes, and null is what the JSON encoding writes for nil
+		return nil
+	}
+
+	switch s.Type {
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s should be a string", path)
+		}
+		switch s.Format {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s should be a RFC 3339 time", path)
+			}
+		case "byte":
+			if _, err := base64.StdEncoding.DecodeString(str); err != nil {
+				return fmt.Errorf("%s should be base64", path)
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s should be a boolean", path)
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s should be an integer", path)
+		}
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("%s should be an integer", path)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%s should be a number", path)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s should be an array", path)
+		}
+		for i, item := range items {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s should be an object", path)
+		}
+		if s.Values != nil {
+			for key, v := range object {
+				if err := s.Values.validate(path+"."+key, v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		for key, v := range object {
+			property, ok := s.Properties[key]
+			if !ok {
+				return fmt.Errorf("%s.%s is not a known field", path, key)
+			}
+			if err := property.validate(path+"."+key, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package retries
+
+import (
+	"errors"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// Class is what should happen with a failed activity
+type Class int
+
+const (
+	// Transient errors such as a unreachable repository are worth retrying
+	Transient Class = iota
+	// Permanent errors such as a customer that is too young never succeed, retrying only delays the answer
+	Permanent
+)
+
+// Classifier decides the Class of an activity error
+type Classifier func(err error) Class
+
+// Classify is the Classifier used when the Policy has none
+// CustomErrors are business rejections and cancellations are on purpose, everything else is transient
+// A panicking activity is transient as well, so it is retried until its payload is quarantined
+func Classify(err error) Class {
+	var custom *cadence.CustomError
+	if errors.As(err, &custom) {
+		if custom.Reason() == registry.ErrReasonActivityPanic {
+			return Transient
+		}
+		return Permanent
+	}
+	var canceled *cadence.CanceledError
+	if errors.As(err, &canceled) {
+		return Permanent
+	}
+	return Transient
+}
+
+// Policy is how Execute retries an activity once the retry policy of the activity options has given up
+type Policy struct {
+	// Attempts is how many times the activity is executed at most, including the first
+	Attempts int
+	// InitialInterval is the wait before the second attempt, it grows with BackoffCoefficient up to MaxInterval
+	InitialInterval    time.Duration
+	BackoffCoefficient float64
+	MaxInterval        time.Duration
+	// Classify decides if an error is retried, Classify of this package is used when it is nil
+	Classify Classifier
+}
+
+// Default is a Policy for activities reading or writing repositories
+var Default = Policy{
+	Attempts:           3,
+	InitialInterval:    time.Second,
+	BackoffCoefficient: 2,
+	MaxInterval:        10 * time.Second,
+}
+
+// Execute runs the activity and reads the result into result, transient failures are retried according to the policy
+// Waiting is done with workflow timers so it is safe to replay, ctx needs to have ActivityOptions applied
+func Execute(ctx workflow.Context, policy Policy, result interface{}, activity interface{}, args ...interface{}) error {
+	classify := policy.Classify
+	if classify == nil {
+		classify = Classify
+	}
+
+	interval := policy.InitialInterval
+	for attempt := 1; ; attempt++ {
+		err := workflow.ExecuteActivity(ctx, activity, args...).Get(ctx, result)
+		if err == nil || attempt >= policy.Attempts || classify(err) == Permanent {
+			return err
+		}
+
+		workflow.GetLogger(ctx).Warn("Retrying activity", zap.Int("attempt", attempt), zap.Duration("wait", interval), zap.Error(err))
+		workflow.GetMetricsScope(ctx).Counter("activity_app_retries").Inc(1)
+		if err := workflow.Sleep(ctx, interval); err != nil {
+			return err
+		}
+		interval = time.Duration(float64(interval) * policy.BackoffCoefficient)
+		if policy.MaxInterval > 0 && interval > policy.MaxInterval {
+			interval = policy.MaxInterval
+		}
+	}
+}
+
+// ActivityPolicy is a cadence retry policy to apply with workflow.WithRetryPolicy, reasons are never retried by cadence
+// Execute retries on top of it once it gives up
+func ActivityPolicy(reasons ...string) cadence.RetryPolicy {
+	return cadence.RetryPolicy{
+		InitialInterval:          time.Second,
+		BackoffCoefficient:       2,
+		MaximumInterval:          10 * time.Second,
+		ExpirationInterval:       time.Minute,
+		MaximumAttempts:          3,
+		NonRetriableErrorReasons: reasons,
+	}
+}
@@ -0,0 +1,118 @@
+package retries
+
+import (
+	"context"
+	"errors"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"testing"
+	"time"
+
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// errRepository is what a repository that can not be reached fails with
+var errRepository = errors.New("connection refused")
+
+// reasonUnderage is the reason the age check rejects customers with
+const reasonUnderage = "CustomerUnderage"
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want Class
+	}{
+		{"repository", errRepository, Transient},
+		{"underage", cadence.NewCustomError(reasonUnderage, "too young"), Permanent},
+		{"panic", cadence.NewCustomError(registry.ErrReasonActivityPanic, "boom"), Transient},
+		{"canceled", cadence.NewCanceledError(), Permanent},
+	}
+	for _, c := range cases {
+		if got := Classify(c.err); got != c.want {
+			t.Errorf("%s: Classify returned %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// attempts runs the activity failing with failures before it succeeds through Execute with the policy
+// It returns how often the activity ran and the error Execute returned
+func attempts(t *testing.T, policy Policy, retry *cadence.RetryPolicy, failures ...error) (int, error) {
+	t.Helper()
+	var calls int
+	activity := func(ctx context.Context) (int, error) {
+		calls++
+		if calls <= len(failures) {
+			return 0, failures[calls-1]
+		}
+		return calls, nil
+	}
+	wf := func(ctx workflow.Context) (int, error) {
+		ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			ScheduleToStartTimeout: time.Minute,
+			StartToCloseTimeout:    time.Minute,
+			RetryPolicy:            retry,
+		})
+		var result int
+		err := Execute(ctx, policy, &result, activity)
+		return result, err
+	}
+
+	var suite testsuite.WorkflowTestSuite
+	suite.SetLogger(zap.NewNop())
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(wf)
+	env.RegisterActivity(activity)
+	env.ExecuteWorkflow(wf)
+	if !env.IsWorkflowCompleted() {
+		t.Fatal("workflow did not complete")
+	}
+	return calls, env.GetWorkflowError()
+}
+
+func TestExecuteRetriesTransientErrors(t *testing.T) {
+	calls, err := attempts(t, Default, nil, errRepository, errRepository)
+	if err != nil {
+		t.Fatalf("Execute returned %v, want the third attempt to succeed", err)
+	}
+	if calls != 3 {
+		t.Fatalf("activity ran %d times, want 3", calls)
+	}
+}
+
+func TestExecuteGivesUpAfterAttempts(t *testing.T) {
+	calls, err := attempts(t, Default, nil, errRepository, errRepository, errRepository, errRepository)
+	if err == nil {
+		t.Fatal("Execute succeeded, want the error of the last attempt")
+	}
+	if calls != Default.Attempts {
+		t.Fatalf("activity ran %d times, want %d", calls, Default.Attempts)
+	}
+}
+
+func TestExecuteReturnsUnderageRightAway(t *testing.T) {
+	// The cadence policy does not retry the reason either, so the customer is rejected after one attempt
+	retry := ActivityPolicy(reasonUnderage)
+	calls, err := attempts(t, Default, &retry, cadence.NewCustomError(reasonUnderage, "too young"))
+	var custom *cadence.CustomError
+	if !errors.As(err, &custom) || custom.Reason() != reasonUnderage {
+		t.Fatalf("Execute returned %v, want the underage rejection", err)
+	}
+	if calls != 1 {
+		t.Fatalf("activity ran %d times, want 1", calls)
+	}
+}
+
+func TestExecuteUsesClassifierOfPolicy(t *testing.T) {
+	policy := Default
+	policy.Classify = func(err error) Class { return Permanent }
+	calls, err := attempts(t, policy, nil, errRepository)
+	if err == nil {
+		t.Fatal("Execute succeeded, want the error of the first attempt")
+	}
+	if calls != 1 {
+		t.Fatalf("activity ran %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,194 @@
+package seating
+
+import (
+	"context"
+	"errors"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/tables"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignalSeat is the signal used to seat a customer at the table, the payload is the name of the customer
+	SignalSeat = "seat"
+	// SignalLeave is the signal used when a customer leaves the table, the payload is the name of the customer
+	SignalLeave = "leave"
+	// QueryOccupancy is the query used to look at who is seated at the table
+	QueryOccupancy = "occupancy"
+
+	// SignalVersion is the version of the payloads of the seat and leave signals
+	SignalVersion = 1
+)
+
+func init() {
+	registry.Workflow(WorkflowTable)
+
+	registry.Signal(SignalSeat, SignalVersion, "")
+	registry.Signal(SignalLeave, SignalVersion, "")
+
+	registry.Activity("tavern.seating.assign", activityAssignTable)
+	registry.Activity("tavern.seating.find", activityFindTable)
+	registry.Activity("tavern.seating.release", activityReleaseTable)
+}
+
+// WorkflowID is the workflow ID used for a table at a location
+func WorkflowID(loc string, tableID string) string {
+	return location.WorkflowID(loc, "table-"+tableID)
+}
+
+// WorkflowTable keeps track of who is seated at a table, it is started by the API when the first customer sits down
+// and closes once the last customer has left
+func WorkflowTable(ctx workflow.Context, table tables.Table) (tables.Table, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("Table taken", zap.String("table", table.ID))
+
+	// Allow the occupancy to be inspected while the table is taken
+	err := workflow.SetQueryHandler(ctx, QueryOccupancy, func() (tables.Table, error) {
+		return table, nil
+	})
+	if err != nil {
+		return table, err
+	}
+
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalSeat), func(c workflow.Channel, more bool) {
+		var name string
+		if err := signals.Receive(ctx, c, nil, &name); err != nil {
+			logger.Error("Dropped seat signal that could not be decoded", zap.Error(err))
+			return
+		}
+		if !isSeated(table, name) {
+			table.Seated = append(table.Seated, name)
+		}
+	})
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalLeave), func(c workflow.Channel, more bool) {
+		var name string
+		if err := signals.Receive(ctx, c, nil, &name); err != nil {
+			logger.Error("Dropped leave signal that could not be decoded", zap.Error(err))
+			return
+		}
+		seated := make([]string, 0, len(table.Seated))
+		for _, s := range table.Seated {
+			if s != name {
+				seated = append(seated, s)
+			}
+		}
+		table.Seated = seated
+	})
+
+	for {
+		selector.Select(ctx)
+		if len(table.Seated) == 0 {
+			logger.Info("Table is free", zap.String("table", table.ID))
+			return table, nil
+		}
+	}
+}
+
+// isSeated is true when the customer is seated at the table
+func isSeated(table tables.Table, name string) bool {
+	for _, s := range table.Seated {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Assign is used by workflows to seat a customer at a table with a free seat
+// ctx needs to have ActivityOptions applied
+func Assign(ctx workflow.Context, loc string, name string) (tables.Table, error) {
+	var table tables.Table
+	err := workflow.ExecuteActivity(ctx, activityAssignTable, loc, name).Get(ctx, &table)
+	return table, err
+}
+
+// Find is used by workflows to look up the table of a customer, the ID is empty when the customer is not seated
+// ctx needs to have ActivityOptions applied
+func Find(ctx workflow.Context, loc string, name string) (tables.Table, error) {
+	var table tables.Table
+	err := workflow.ExecuteActivity(ctx, activityFindTable, loc, name).Get(ctx, &table)
+	return table, err
+}
+
+// Seat is used by workflows to tell the workflow of the table the customer sits there, like the API does
+// Workflows can not signal with start, so a table without an open workflow gets one as an abandoned child
+func Seat(ctx workflow.Context, loc string, table tables.Table, name string) error {
+	seat, err := signals.Wrap(SignalVersion, name)
+	if err != nil {
+		return err
+	}
+	id := WorkflowID(loc, table.ID)
+	if err := workflow.SignalExternalWorkflow(ctx, id, "", SignalSeat, seat).Get(ctx, nil); err == nil {
+		return nil
+	}
+
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:                   id,
+		ExecutionStartToCloseTimeout: 24 * time.Hour,
+		ParentClosePolicy:            client.ParentClosePolicyAbandon,
+		WorkflowIDReusePolicy:        client.WorkflowIDReusePolicyAllowDuplicate,
+	})
+	// Another visit might have opened the table in the meantime, it is signalled again either way
+	if err := workflow.ExecuteChildWorkflow(childCtx, WorkflowTable, table).GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+		workflow.GetLogger(ctx).Info("Table was opened by someone else", zap.String("table", table.ID), zap.Error(err))
+	}
+	return workflow.SignalExternalWorkflow(ctx, id, "", SignalSeat, seat).Get(ctx, nil)
+}
+
+// Leave is used by workflows to free the seat of a customer, customers that are not seated are ignored
+// ctx needs to have ActivityOptions applied
+func Leave(ctx workflow.Context, loc string, name string) error {
+	var table tables.Table
+	if err := workflow.ExecuteActivity(ctx, activityReleaseTable, loc, name).Get(ctx, &table); err != nil {
+		return err
+	}
+	if table.ID == "" {
+		return nil
+	}
+	leave, err := signals.Wrap(SignalVersion, name)
+	if err != nil {
+		return err
+	}
+	return workflow.SignalExternalWorkflow(ctx, WorkflowID(loc, table.ID), "", SignalLeave, leave).Get(ctx, nil)
+}
+
+// activityAssignTable seats the customer in the table repository
+func activityAssignTable(ctx context.Context, loc string, name string) (tables.Table, error) {
+	span := tracing.StartActivitySpan(ctx, "assignTable", opentracing.Tags{"customer": name, "location": loc})
+	defer span.Finish()
+
+	return tables.Database.Assign(loc, name)
+}
+
+// activityFindTable looks up the table of the customer in the table repository
+func activityFindTable(ctx context.Context, loc string, name string) (tables.Table, error) {
+	span := tracing.StartActivitySpan(ctx, "findTable", opentracing.Tags{"customer": name, "location": loc})
+	defer span.Finish()
+
+	table, err := tables.Database.Find(loc, name)
+	if errors.Is(err, tables.ErrNotSeated) {
+		return tables.Table{}, nil
+	}
+	return table, err
+}
+
+// activityReleaseTable frees the seat of the customer in the table repository
+func activityReleaseTable(ctx context.Context, loc string, name string) (tables.Table, error) {
+	span := tracing.StartActivitySpan(ctx, "releaseTable", opentracing.Tags{"customer": name, "location": loc})
+	defer span.Finish()
+
+	table, err := tables.Database.Release(loc, name)
+	if errors.Is(err, tables.ErrNotSeated) {
+		return tables.Table{}, nil
+	}
+	return table, err
+}
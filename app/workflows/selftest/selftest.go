@@ -0,0 +1,101 @@
+// Package selftest is the smoke test a Worker can run on itself right after it started
+// A tiny ping workflow is started on a task list only this process polls, it runs one activity and answers with the
+// nonce it was given. Passing proves the connection, the domain and the registrations of this process in one go
+package selftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"strconv"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/workflow"
+)
+
+// The metrics of the self test
+const (
+	MetricPassed  = "selftest_passed"
+	MetricFailed  = "selftest_failed"
+	MetricLatency = "selftest_latency"
+)
+
+func init() {
+	registry.Workflow(WorkflowPing)
+
+	registry.Activity("tavern.selftest.pong", activityPong)
+}
+
+// Ping is the input of the ping workflow
+type Ping struct {
+	Nonce string `json:"nonce"`
+}
+
+// Pong is the answer of the ping workflow
+type Pong struct {
+	Nonce string `json:"nonce"`
+	// Workflows is how many workflows the worker running the activity has registered
+	Workflows int `json:"workflows"`
+}
+
+// TaskList is the task list of the self test of the worker with the identity, no other worker polls it
+func TaskList(identity string) string {
+	return "selftest-" + identity
+}
+
+// WorkflowPing runs the pong activity and returns its answer
+func WorkflowPing(ctx workflow.Context, ping Ping) (Pong, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: 10 * time.Second,
+		StartToCloseTimeout:    10 * time.Second,
+	})
+	var pong Pong
+	err := workflow.ExecuteActivity(ctx, activityPong, ping).Get(ctx, &pong)
+	return pong, err
+}
+
+// activityPong answers the ping
+func activityPong(ctx context.Context, ping Ping) (Pong, error) {
+	return Pong{Nonce: ping.Nonce, Workflows: len(registry.Workflows())}, nil
+}
+
+// Run starts the ping workflow on the task list and waits up to timeout for the pong, the outcome is reported on scope
+func Run(ctx context.Context, c engine.Client, taskList string, timeout time.Duration, scope tally.Scope) error {
+	started := time.Now()
+	err := run(ctx, c, taskList, timeout)
+	if err != nil {
+		scope.Counter(MetricFailed).Inc(1)
+		return err
+	}
+	scope.Counter(MetricPassed).Inc(1)
+	scope.Timer(MetricLatency).Record(time.Since(started))
+	return nil
+}
+
+func run(ctx context.Context, c engine.Client, taskList string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ping := Ping{Nonce: strconv.FormatInt(time.Now().UnixNano(), 36)}
+	// The ID is the task list, so restarts of the worker reuse it instead of piling up ping workflows
+	run, err := c.ExecuteWorkflow(ctx, engine.StartOptions{
+		ID:               taskList,
+		TaskList:         taskList,
+		ExecutionTimeout: timeout,
+		AllowDuplicate:   true,
+	}, registry.SelfTestWorkflow, ping)
+	if err != nil {
+		return fmt.Errorf("failed to start the ping workflow: %v", err)
+	}
+	var pong Pong
+	if err := run.Get(ctx, &pong); err != nil {
+		return fmt.Errorf("ping workflow failed: %v", err)
+	}
+	if pong.Nonce != ping.Nonce {
+		return errors.New("ping workflow answered with another nonce")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Package session models a whole visit to the tavern as one workflow
+// The API starts it when a customer is greeted, it greets them, seats them and opens their tab as children
+// and closes once the tab is settled. Orders reach the visit through the tab they are put on
+package session
+
+import (
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/workflows/greetings"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/seating"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"programmingpercy/cadence-tavern/workflows/tabs"
+	"time"
+
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignalGreet is the signal used to greet the customer, the API sends it with start so the first greeting
+	// starts the visit and later ones join it
+	SignalGreet = "greet"
+	// QueryState is the query used to look at the visit, the API reads the greeting from it
+	QueryState = "state"
+
+	// SignalVersion is the version of the payload of the greet signal
+	SignalVersion = 1
+
+	// Timeout is how long a visit may last, it outlives the tab so the settlement is always seen
+	Timeout = 25 * time.Hour
+)
+
+// Greet is the payload of the greet signal
+type Greet struct {
+	Customer customer.Customer `json:"customer"`
+}
+
+// State is what the visit has done so far
+type State struct {
+	Customer string `json:"customer"`
+	// Greeting is set once the customer is greeted, later greetings of the visit answer with the same one
+	Greeting *greetings.GreetingResult `json:"greeting,omitempty"`
+	// Error is why the greeting or the tab failed, the visit is then over
+	Error string `json:"error,omitempty"`
+	// Tab is the settled tab, it is set once the visit is over
+	Tab     *tabs.Tab `json:"tab,omitempty"`
+	Started time.Time `json:"started"`
+	// Settled is when the tab was settled
+	Settled time.Time `json:"settled,omitempty"`
+}
+
+// storedNameChangeID marks the runs that seat the customer and open their tab with the name they are stored as
+const storedNameChangeID = "session-stored-name"
+
+func init() {
+	registry.Workflow(WorkflowSession)
+
+	registry.Signal(SignalGreet, SignalVersion, Greet{})
+}
+
+// WorkflowID is the workflow ID of the visit of a customer at a location, each customer has one visit at a time
+func WorkflowID(loc string, customerName string) string {
+	return location.WorkflowID(loc, "session-"+customerName)
+}
+
+// WorkflowSession is the visit of a customer, it runs until their tab is settled
+func WorkflowSession(ctx workflow.Context, loc string, customerName string) (State, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    time.Minute,
+	})
+	logger := workflow.GetLogger(ctx)
+	state := State{Customer: customerName, Started: workflow.Now(ctx)}
+
+	err := workflow.SetQueryHandler(ctx, QueryState, func() (State, error) {
+		return state, nil
+	})
+	if err != nil {
+		return state, err
+	}
+
+	// The visit starts with the greeting it was started with
+	greetChan := workflow.GetSignalChannel(ctx, SignalGreet)
+	var greet Greet
+	if err := signals.Receive(ctx, greetChan, nil, &greet); err != nil {
+		state.Error = err.Error()
+		return state, err
+	}
+	greet.Customer.Location = loc
+
+	greetCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:                   location.WorkflowID(loc, "greeting-"+customerName),
+		ExecutionStartToCloseTimeout: 10 * time.Second,
+		WorkflowIDReusePolicy:        client.WorkflowIDReusePolicyAllowDuplicate,
+	})
+	var greeting greetings.GreetingResult
+	if err := workflow.ExecuteChildWorkflow(greetCtx, registry.GreetingsWorkflow, greet.Customer).Get(ctx, &greeting); err != nil {
+		logger.Error("Failed to greet customer", zap.String("customer", customerName), zap.Error(err))
+		state.Error = err.Error()
+		return state, err
+	}
+	state.Greeting = &greeting
+
+	// Orders reach the tab by the name the customer is stored as, greeting an alias must open the same tab
+	if workflow.GetVersion(ctx, storedNameChangeID, workflow.DefaultVersion, 1) != workflow.DefaultVersion && greeting.Customer.Name != "" {
+		customerName = greeting.Customer.Name
+		state.Customer = customerName
+	}
+
+	// The table keeps its own workflow since it is shared with the other customers seated at it
+	if greeting.Table != nil {
+		if err := seating.Seat(ctx, loc, *greeting.Table, customerName); err != nil {
+			logger.Error("Failed to seat customer", zap.String("table", greeting.Table.ID), zap.Error(err))
+		}
+	}
+
+	// The tab is a child so the visit ends when it is settled
+	tabCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:                   tabs.WorkflowID(loc, customerName),
+		ExecutionStartToCloseTimeout: 24 * time.Hour,
+		WorkflowIDReusePolicy:        client.WorkflowIDReusePolicyAllowDuplicate,
+	})
+	tab := workflow.ExecuteChildWorkflow(tabCtx, registry.TabWorkflow, loc, customerName)
+
+	var settled bool
+	selector := workflow.NewSelector(ctx)
+	// Greeting again during the visit is answered with the greeting of the visit, it is not counted as a new visit
+	selector.AddReceive(greetChan, func(c workflow.Channel, more bool) {
+		if err := signals.Receive(ctx, c, nil, nil); err != nil {
+			logger.Error("Dropped greet signal that could not be decoded", zap.Error(err))
+		}
+	})
+	selector.AddFuture(tab, func(f workflow.Future) {
+		settled = true
+		var closed tabs.Tab
+		if err = f.Get(ctx, &closed); err == nil {
+			state.Tab = &closed
+		}
+	})
+	for !settled {
+		selector.Select(ctx)
+	}
+	if err != nil {
+		// A tab that was opened outside of a visit can not be a child, the visit ends without it
+		logger.Error("Tab of the visit failed", zap.String("customer", customerName), zap.Error(err))
+		state.Error = err.Error()
+		return state, err
+	}
+
+	state.Settled = workflow.Now(ctx)
+	workflow.GetMetricsScope(ctx).Timer("session_duration").Record(state.Settled.Sub(state.Started))
+	logger.Info("Visit is over", zap.String("customer", customerName), zap.Stringer("total", state.Tab.Total))
+	return state, nil
+}
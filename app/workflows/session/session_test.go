@@ -0,0 +1,53 @@
+package session
+
+import (
+	"programmingpercy/cadence-tavern/customer"
+	"programmingpercy/cadence-tavern/workflows/greetings"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"programmingpercy/cadence-tavern/workflows/tabs"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// TestAliasOpensTheTabOfTheStoredCustomer greets a customer by another case of their name
+// Orders are put on the tab of the stored name, so the tab of the visit has to be opened with it
+func TestAliasOpensTheTabOfTheStoredCustomer(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	suite.SetLogger(zap.NewNop())
+	env := suite.NewTestWorkflowEnvironment()
+	env.OnWorkflow(registry.GreetingsWorkflow, mock.Anything, mock.Anything).Return(greetings.GreetingResult{
+		Customer: customer.Customer{Name: "Percy", Location: "tavern-1"},
+	}, nil)
+	var tabID, tabCustomer string
+	env.OnWorkflow(registry.TabWorkflow, mock.Anything, mock.Anything, mock.Anything).Return(func(ctx workflow.Context, loc string, customerName string) (tabs.Tab, error) {
+		tabID = workflow.GetInfo(ctx).WorkflowExecution.ID
+		tabCustomer = customerName
+		return tabs.Tab{Customer: customerName}, nil
+	})
+
+	greet, err := signals.Wrap(SignalVersion, Greet{Customer: customer.Customer{Name: "percy", Age: 30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.RegisterDelayedCallback(func() { env.SignalWorkflow(SignalGreet, greet) }, 0)
+	env.ExecuteWorkflow(WorkflowSession, "tavern-1", "percy")
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := tabs.WorkflowID("tavern-1", "Percy"); tabID != want || tabCustomer != "Percy" {
+		t.Fatalf("opened tab %s for %q, want %s for the stored Percy", tabID, tabCustomer, want)
+	}
+	var state State
+	if err := env.GetWorkflowResult(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Customer != "Percy" {
+		t.Fatalf("visit is of %q, want the stored Percy", state.Customer)
+	}
+}
@@ -0,0 +1,116 @@
+// Package setup applies the configuration of the Worker to the workflows, so every process running them runs them alike
+// The Worker applies it before polling, the standalone API before running the workflows in memory
+package setup
+
+import (
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/inventory"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/tables"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/faultinject"
+	"programmingpercy/cadence-tavern/workflows/flags"
+	"programmingpercy/cadence-tavern/workflows/history"
+	"programmingpercy/cadence-tavern/workflows/leaderboard"
+	"programmingpercy/cadence-tavern/workflows/metrics"
+	"programmingpercy/cadence-tavern/workflows/orders"
+	"programmingpercy/cadence-tavern/workflows/pricing"
+	"programmingpercy/cadence-tavern/workflows/profiles"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/sobriety"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/encoded"
+	"go.uber.org/cadence/interceptors"
+	"go.uber.org/cadence/worker"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// Apply applies the settings the workflows cannot run without
+// The stores of customers, orders, payments and the other activities are left to the caller, they differ per process
+func Apply(cfg config.Config) error {
+	// Apply the tables customers are seated at
+	layout, err := tables.ParseLayout(cfg.Tables)
+	if err != nil {
+		return err
+	}
+	tables.Database = tables.NewMemoryTables(layout)
+	// Apply the stock orders are reserved from
+	stock, err := inventory.ParseStock(cfg.Stock)
+	if err != nil {
+		return err
+	}
+	inventory.Database = inventory.NewMemoryInventory(stock)
+	// Apply the feature flag source used by the workflows
+	flags.Source = flags.NewProvider(cfg.Flags)
+	// Apply the discounts given to returning customers
+	orders.DiscountTiers, err = orders.ParseDiscountTiers(cfg.DiscountTiers)
+	if err != nil {
+		return err
+	}
+	// Apply how long orders are collected into rounds
+	orders.SetRoundWindow(cfg.OrderRoundWindow)
+	orders.SetAlertAfter(cfg.OrderAlertAfter)
+	// Apply how much alcohol customers are served
+	sobriety.SetLimit(float32(cfg.IntakeLimit))
+	// Apply how much a customer may order
+	orders.SetCustomerLimit(orders.Limit{Orders: cfg.CustomerOrderLimit.Orders, Window: cfg.CustomerOrderLimit.Window})
+	// Apply when the taverns take orders
+	openingHours, err := orders.ParseOpeningHours(cfg.OpeningHours)
+	if err != nil {
+		return err
+	}
+	orders.SetOpeningHours(openingHours)
+	// Apply how long the histories of long running workflows may grow
+	history.SetMaxEvents(cfg.HistoryLimit)
+	// Apply how many customers the leaderboards rank
+	leaderboard.SetSize(cfg.LeaderboardSize)
+	// Apply the timeouts and retries of each kind of activity
+	activityProfiles, err := profiles.Parse(cfg.ActivityProfiles)
+	if err != nil {
+		return err
+	}
+	profiles.Set(activityProfiles)
+	// Apply the faults injected into the activities, only when fault injection is enabled
+	faults, err := faultinject.Parse(cfg.FaultInjection.Rules)
+	if err != nil {
+		return err
+	}
+	faultinject.Set(faults)
+	if cfg.FaultInjection.Enabled {
+		registry.Inject = faultinject.Inject
+	}
+	orders.RoundParentClosePolicy, err = orders.ParseParentClosePolicy(cfg.RoundParentClosePolicy)
+	if err != nil {
+		return err
+	}
+	// Apply the currency prices are charged in and the rates of the other currencies they are accepted in
+	if cfg.Payments.Currency != "" {
+		models.Currency = models.NormalizeCurrency(cfg.Payments.Currency)
+	}
+	rates, err := pricing.ParseRates(cfg.Payments.Rates)
+	if err != nil {
+		return err
+	}
+	pricing.SetRates(rates)
+	pricing.Exchanges = pricing.NewRateProvider(cfg.Payments)
+	return nil
+}
+
+// Options are the worker options every process running the workflows needs
+// The traces are carried into the workflows and every workflow and the activities and children it runs are reported
+// by their type
+func Options(dataConverter encoded.DataConverter, scope tally.Scope, logger *zap.Logger) worker.Options {
+	return worker.Options{
+		Logger:        logger,
+		MetricsScope:  scope,
+		DataConverter: dataConverter,
+		ContextPropagators: []workflow.ContextPropagator{
+			tracing.NewPropagator(),
+		},
+		WorkflowInterceptorChainFactories: []interceptors.WorkflowInterceptorFactory{
+			metrics.Factory{},
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package signals
+
+import (
+	"encoding/json"
+	"fmt"
+	"programmingpercy/cadence-tavern/engine"
+)
+
+// LegacyVersion is the version of payloads sent before signals were wrapped in an Envelope
+const LegacyVersion = 1
+
+// Envelope wraps every signal payload with the version of its shape
+// Workflows keep decoding old versions, so signals sent by an older API still work after a payload changes
+type Envelope struct {
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Decoder reads a payload of one version into out
+type Decoder func(payload json.RawMessage, out interface{}) error
+
+// Decoders are the decoders of each version of a signal
+// Versions without a decoder are read as JSON into out, unknown fields of newer versions are ignored
+type Decoders map[int]Decoder
+
+// Wrap puts the payload into an envelope of the version
+func Wrap(version int, payload interface{}) (Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to encode signal payload: %v", err)
+	}
+	return Envelope{Version: version, Payload: data}, nil
+}
+
+// Unwrap reads a signal, payloads that are not in an envelope are LegacyVersion
+func Unwrap(raw json.RawMessage) (Envelope, error) {
+	var envelope struct {
+		Version *int            `json:"version"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if len(raw) > 0 && raw[0] == '{' {
+		if err := json.Unmarshal(raw, &envelope); err != nil {
+			return Envelope{}, fmt.Errorf("failed to decode signal: %v", err)
+		}
+	}
+	if envelope.Version == nil {
+		return Envelope{Version: LegacyVersion, Payload: raw}, nil
+	}
+	return Envelope{Version: *envelope.Version, Payload: envelope.Payload}, nil
+}
+
+// Decode unwraps raw and decodes the payload into out with the decoder of its version
+// out can be nil for signals without a payload
+func (d Decoders) Decode(raw json.RawMessage, out interface{}) error {
+	envelope, err := Unwrap(raw)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(envelope.Payload) == 0 || string(envelope.Payload) == "null" {
+		return nil
+	}
+	if decode, ok := d[envelope.Version]; ok {
+		return decode(envelope.Payload, out)
+	}
+	if err := json.Unmarshal(envelope.Payload, out); err != nil {
+		return fmt.Errorf("failed to decode version %d signal: %v", envelope.Version, err)
+	}
+	return nil
+}
+
+// Receive reads the next signal of the channel into out
+// A signal that can not be decoded is still consumed, so the caller should log the error and move on
+func Receive(ctx engine.Context, c engine.Channel, decoders Decoders, out interface{}) error {
+	var raw json.RawMessage
+	c.Receive(ctx, &raw)
+	return decoders.Decode(raw, out)
+}
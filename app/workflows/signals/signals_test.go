@@ -0,0 +1,82 @@
+package signals
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUnwrap(t *testing.T) {
+	cases := []struct {
+		raw     string
+		version int
+		payload string
+	}{
+		{`{"version":2,"payload":{"name":"Percy"}}`, 2, `{"name":"Percy"}`},
+		{`{"name":"Percy"}`, LegacyVersion, `{"name":"Percy"}`},
+		{`"Percy"`, LegacyVersion, `"Percy"`},
+		{`{"version":2}`, 2, ``},
+	}
+	for _, c := range cases {
+		envelope, err := Unwrap(json.RawMessage(c.raw))
+		if err != nil {
+			t.Errorf("%s: %v", c.raw, err)
+			continue
+		}
+		if envelope.Version != c.version || string(envelope.Payload) != c.payload {
+			t.Errorf("%s: unwrapped version %d with %s, want version %d with %s", c.raw, envelope.Version, envelope.Payload, c.version, c.payload)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type visitor struct {
+		Name string `json:"name"`
+	}
+	decoders := Decoders{
+		2: func(payload json.RawMessage, out interface{}) error {
+			var v struct {
+				FullName string `json:"fullName"`
+			}
+			if err := json.Unmarshal(payload, &v); err != nil {
+				return err
+			}
+			out.(*visitor).Name = v.FullName
+			return nil
+		},
+	}
+
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{`{"name":"Percy"}`, "Percy"},
+		{`{"version":2,"payload":{"fullName":"Percy"}}`, "Percy"},
+		{`{"version":2,"payload":{"fullName":"Percy","nickname":"P"}}`, "Percy"},
+		{`{"version":3,"payload":{"name":"Percy","age":30}}`, "Percy"},
+	}
+	for _, c := range cases {
+		var got visitor
+		if err := decoders.Decode(json.RawMessage(c.raw), &got); err != nil {
+			t.Errorf("%s: %v", c.raw, err)
+			continue
+		}
+		if got.Name != c.want {
+			t.Errorf("%s: decoded %q, want %q", c.raw, got.Name, c.want)
+		}
+	}
+
+	for _, raw := range []string{`{"version":`, `{"version":"2","payload":{}}`, `{"version":3,"payload":{"name":7}}`} {
+		var got visitor
+		if err := decoders.Decode(json.RawMessage(raw), &got); err == nil {
+			t.Errorf("%s: decoded %+v, want an error", raw, got)
+		}
+	}
+}
+
+func TestDecodeWithoutPayload(t *testing.T) {
+	for _, raw := range []string{``, `null`, `{"version":2,"payload":null}`} {
+		if err := (Decoders{}).Decode(json.RawMessage(raw), nil); err != nil {
+			t.Errorf("%q: %v", raw, err)
+		}
+	}
+}
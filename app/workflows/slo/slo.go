@@ -0,0 +1,159 @@
+package slo
+
+import (
+	"context"
+	"fmt"
+	"programmingpercy/cadence-tavern/config"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/cadence/workflow"
+)
+
+const (
+	// Greetings is the objective of greeting customers
+	Greetings = "greetings"
+	// Orders is the objective of processing orders
+	Orders = "orders"
+)
+
+var (
+	// Trackers holds the tracker of every objective, the Worker replaces this during startup
+	Trackers = NewTrackers(config.WorkerDefaults().SLO)
+
+	// windows are the burn rate windows that are exported
+	windows = map[string]time.Duration{
+		"1h": time.Hour,
+		"6h": 6 * time.Hour,
+	}
+)
+
+// Objective is what share of requests should succeed within the latency threshold
+type Objective struct {
+	// Target is the share of good requests, such as 0.99
+	Target float64
+	// Latency is the slowest a successful request can be and still count as good
+	Latency time.Duration
+}
+
+// bucket counts the requests of one minute
+type bucket struct {
+	minute int64
+	total  int
+	bad    int
+}
+
+// Tracker keeps per minute counts of the SLI for the longest window
+type Tracker struct {
+	sync.Mutex
+	Objective Objective
+	buckets   []bucket
+}
+
+// NewTracker will create a tracker for the objective
+func NewTracker(objective Objective) *Tracker {
+	return &Tracker{
+		Objective: objective,
+		buckets:   make([]bucket, int((6*time.Hour)/time.Minute)),
+	}
+}
+
+// NewTrackers creates the trackers of the greetings and orders objectives
+func NewTrackers(cfg config.SLO) map[string]*Tracker {
+	return map[string]*Tracker{
+		Greetings: NewTracker(Objective{Target: cfg.GreetingsTarget, Latency: cfg.GreetingsLatency}),
+		Orders:    NewTracker(Objective{Target: cfg.OrdersTarget, Latency: cfg.OrdersLatency}),
+	}
+}
+
+// Record adds a request to the SLI, it is bad if it failed or was too slow
+func (t *Tracker) Record(at time.Time, latency time.Duration, success bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	minute := at.Unix() / 60
+	b := &t.buckets[minute%int64(len(t.buckets))]
+	if b.minute != minute {
+		*b = bucket{minute: minute}
+	}
+	b.total++
+	if !success || latency > t.Objective.Latency {
+		b.bad++
+	}
+}
+
+// BurnRate is how fast the error budget is spent over the window
+// 1 means the budget lasts exactly the SLO period, above that it runs out early
+func (t *Tracker) BurnRate(now time.Time, window time.Duration) float64 {
+	t.Lock()
+	defer t.Unlock()
+
+	oldest := now.Add(-window).Unix() / 60
+	var total, bad int
+	for _, b := range t.buckets {
+		if b.minute > oldest {
+			total += b.total
+			bad += b.bad
+		}
+	}
+	budget := 1 - t.Objective.Target
+	if total == 0 || budget <= 0 {
+		return 0
+	}
+	return (float64(bad) / float64(total)) / budget
+}
+
+// Report updates the burn rate gauges on the scope every interval until ctx is done
+// Each replica reports its own burn rate, tagged by objective and window
+func Report(ctx context.Context, scope tally.Scope, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			for name, tracker := range Trackers {
+				for window, duration := range windows {
+					scope.Tagged(map[string]string{
+						"slo":    name,
+						"window": window,
+					}).Gauge("slo_burn_rate").Update(tracker.BurnRate(now, duration))
+				}
+			}
+		}
+	}
+}
+
+// changeID marks the runs that record their SLIs, see Record
+const changeID = "slo-sli"
+
+func init() {
+	registry.Activity("tavern.slo.recordSLI", activityRecordSLI)
+}
+
+// Record is used by workflows to add the outcome of a request started at started to the objective
+// The SLI is best effort, a failure to record it is ignored. Runs started before the SLIs were recorded skip it, so
+// the greeting and order workflows they were recorded in replay the same
+// ctx needs to have ActivityOptions applied
+func Record(ctx workflow.Context, objective string, started time.Time, err error) {
+	if workflow.GetVersion(ctx, changeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		return
+	}
+	latency := workflow.Now(ctx).Sub(started)
+	workflow.ExecuteActivity(ctx, activityRecordSLI, objective, latency, err == nil).Get(ctx, nil)
+}
+
+// activityRecordSLI adds the request to the tracker of the objective
+func activityRecordSLI(ctx context.Context, objective string, latency time.Duration, success bool) error {
+	tracker, ok := Trackers[objective]
+	if !ok {
+		return fmt.Errorf("no such objective: %s", objective)
+	}
+	tracker.Record(time.Now(), latency, success)
+	return nil
+}
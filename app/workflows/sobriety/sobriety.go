@@ -0,0 +1,117 @@
+// Package sobriety is the drunk-meter of the tavern, it refuses alcoholic orders once a customer has had too much
+// The intake counts from when the tab of the customer is opened until it is settled, orders paid upfront count too
+package sobriety
+
+import (
+	"context"
+	"errors"
+	"programmingpercy/cadence-tavern/intake"
+	"programmingpercy/cadence-tavern/menu"
+	"programmingpercy/cadence-tavern/tracing"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/retries"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/cadence"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// ErrReasonOverLimit is the reason of orders refused because the customer has had too much to drink
+const ErrReasonOverLimit = "customer-over-intake-limit"
+
+// changeID marks the runs that track the intake, workflows started before it existed have no activities to replay
+const changeID = "intake"
+
+var (
+	// Limit is the units of alcohol a customer may be served before their tab is settled, the Worker replaces this
+	// during startup. 0 disables the limit
+	Limit   float32 = 8
+	limitMu sync.RWMutex
+)
+
+// SetLimit replaces the Limit while activities might be reading it, used when the configuration is reloaded
+func SetLimit(limit float32) {
+	limitMu.Lock()
+	defer limitMu.Unlock()
+	Limit = limit
+}
+
+func init() {
+	registry.Activity("tavern.sobriety.add", activityAddIntake)
+	registry.Activity("tavern.sobriety.remove", activityRemoveIntake)
+	registry.Activity("tavern.sobriety.reset", activityResetIntake)
+}
+
+// Check adds the drink of the order to the intake of the customer, a CustomError with ErrReasonOverLimit is returned
+// when it would take them over the Limit. Drinks without alcohol are always allowed
+// ctx needs to have ActivityOptions applied
+func Check(ctx workflow.Context, loc, customerName, orderID, item string) error {
+	drink, ok := menu.Find(item)
+	if !ok || drink.Units <= 0 || untracked(ctx) {
+		return nil
+	}
+	ctx = workflow.WithRetryPolicy(ctx, retries.ActivityPolicy(ErrReasonOverLimit))
+	return retries.Execute(ctx, retries.Default, nil, activityAddIntake, loc, customerName, orderID, drink.Units)
+}
+
+// Release takes the drink of an order that was not served off the intake, failures are only logged
+// ctx needs to have ActivityOptions applied
+func Release(ctx workflow.Context, loc, customerName, orderID, item string) {
+	drink, ok := menu.Find(item)
+	if !ok || drink.Units <= 0 || untracked(ctx) {
+		return
+	}
+	if err := workflow.ExecuteActivity(ctx, activityRemoveIntake, loc, customerName, orderID).Get(ctx, nil); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to release intake", zap.String("order", orderID), zap.Error(err))
+	}
+}
+
+// Reset starts the intake of the customer over, it is called when the tab is settled
+// ctx needs to have ActivityOptions applied
+func Reset(ctx workflow.Context, loc, customerName string) error {
+	if untracked(ctx) {
+		return nil
+	}
+	return workflow.ExecuteActivity(ctx, activityResetIntake, loc, customerName).Get(ctx, nil)
+}
+
+// untracked is true for runs that were started before the intake was tracked
+func untracked(ctx workflow.Context) bool {
+	return workflow.GetVersion(ctx, changeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion
+}
+
+// activityAddIntake records the units in the intake store
+func activityAddIntake(ctx context.Context, loc, customerName, orderID string, units float32) error {
+	span := tracing.StartActivitySpan(ctx, "addIntake", opentracing.Tags{"customer": customerName, "location": loc, "units": units})
+	defer span.Finish()
+
+	limitMu.RLock()
+	limit := Limit
+	limitMu.RUnlock()
+	if limit <= 0 {
+		return nil
+	}
+	_, err := intake.Intake.Add(ctx, intake.Customer{Location: loc, Name: customerName}, orderID, units, limit)
+	if errors.Is(err, intake.ErrOverLimit) {
+		return cadence.NewCustomError(ErrReasonOverLimit, customerName)
+	}
+	return err
+}
+
+// activityRemoveIntake removes the order from the intake store
+func activityRemoveIntake(ctx context.Context, loc, customerName, orderID string) error {
+	span := tracing.StartActivitySpan(ctx, "removeIntake", opentracing.Tags{"customer": customerName, "location": loc})
+	defer span.Finish()
+
+	return intake.Intake.Remove(ctx, intake.Customer{Location: loc, Name: customerName}, orderID)
+}
+
+// activityResetIntake removes every order of the customer from the intake store
+func activityResetIntake(ctx context.Context, loc, customerName string) error {
+	span := tracing.StartActivitySpan(ctx, "resetIntake", opentracing.Tags{"customer": customerName, "location": loc})
+	defer span.Finish()
+
+	return intake.Intake.Reset(ctx, intake.Customer{Location: loc, Name: customerName})
+}
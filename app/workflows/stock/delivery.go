@@ -0,0 +1,235 @@
+package stock
+
+import (
+	"context"
+	"fmt"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/inventory"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/retries"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"time"
+
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignalStatus is sent by the supplier every time the status of a delivery changes
+	SignalStatus = "status"
+	// QueryStatus answers the DeliveryState of a delivery
+	QueryStatus = "status"
+
+	// StatusOrdered is the status of a delivery until the supplier reports on it
+	StatusOrdered = "ordered"
+	// StatusShipped is reported by the supplier once the delivery is on its way
+	StatusShipped = "shipped"
+	// StatusDelayed is reported by the supplier when the delivery will be late, ops are told about every delay
+	StatusDelayed = "delayed"
+	// StatusDelivered is reported by the supplier once the delivery arrived, it is then stocked in
+	StatusDelivered = "delivered"
+
+	// DeliveryDeadline is how long a delivery without an expected time may take before ops are told it is overdue
+	DeliveryDeadline = 7 * 24 * time.Hour
+	// DeliveryTimeout is how long the delivery workflow waits for the supplier to report it delivered
+	DeliveryTimeout = 30 * 24 * time.Hour
+)
+
+// deliveryChangeID marks the runs that track the delivery of a confirmed order
+const deliveryChangeID = "supplier-delivery"
+
+func init() {
+	registry.Workflow(WorkflowDelivery)
+	registry.Signal(SignalStatus, SignalVersion, DeliveryStatus{})
+
+	registry.Activity("tavern.inventory.stockIn", activityStockIn)
+}
+
+// DeliveryWorkflowID is the workflow ID of the delivery of the supplier order with the reference at a location
+func DeliveryWorkflowID(loc string, reference string) string {
+	return location.WorkflowID(loc, "delivery-"+reference)
+}
+
+// Delivery is the input of the delivery workflow
+type Delivery struct {
+	Location string `json:"location"`
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+	// Supplier is the name of the supplier the order was placed with
+	Supplier string `json:"supplier"`
+	// Reference is the reference of the order at the supplier
+	Reference string `json:"reference"`
+	// ExpectedAt is when the supplier confirmed to deliver, zero when they did not say
+	ExpectedAt time.Time `json:"expectedAt,omitempty"`
+}
+
+// DeliveryStatus is the payload of the status signal
+type DeliveryStatus struct {
+	// Reference is the delivery the supplier reports on, the API only takes a status for the delivery of its path
+	// The signature of the call covers the body but not the path, so a signed status can not be sent for another one
+	Reference string `json:"reference,omitempty"`
+	// Status is either shipped, delayed or delivered
+	Status string `json:"status"`
+	Note   string `json:"note,omitempty"`
+	// ExpectedAt replaces when the delivery is expected, zero keeps the time known so far
+	ExpectedAt time.Time `json:"expectedAt,omitempty"`
+	// Quantity is how many were delivered, zero when all of the order was
+	Quantity int `json:"quantity,omitempty"`
+}
+
+// Validate checks that the status is one the supplier can report
+func (ds DeliveryStatus) Validate() error {
+	switch ds.Status {
+	case StatusShipped, StatusDelayed, StatusDelivered:
+	default:
+		return fmt.Errorf("status should be %s, %s or %s, got %q", StatusShipped, StatusDelayed, StatusDelivered, ds.Status)
+	}
+	if ds.Quantity < 0 {
+		return fmt.Errorf("quantity should not be negative")
+	}
+	return nil
+}
+
+// StatusUpdate is a status the supplier reported and when the workflow received it
+type StatusUpdate struct {
+	DeliveryStatus
+	At time.Time `json:"at"`
+}
+
+// DeliveryState is the answer of the status query and the result of the delivery workflow
+type DeliveryState struct {
+	Delivery
+	Status string `json:"status"`
+	// Delays is how many times the supplier reported the delivery delayed
+	Delays int `json:"delays"`
+	// Overdue is set once the expected time passed, a new expected time from the supplier clears it
+	Overdue bool `json:"overdue,omitempty"`
+	// Delivered is how many were stocked in once the delivery arrived
+	Delivered int            `json:"delivered,omitempty"`
+	History   []StatusUpdate `json:"history,omitempty"`
+}
+
+// WorkflowDelivery tracks the delivery of a confirmed supplier order until it is stocked in
+// The supplier reports the status with the status signal, see the /deliveries routes of the API. Every delay and a
+// delivery that is still not there once it was expected are escalated to ops. Once delivered the items are added to
+// the inventory of the location and the workflow completes
+func WorkflowDelivery(ctx workflow.Context, delivery Delivery) (DeliveryState, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    10 * time.Second,
+	})
+	logger := workflow.GetLogger(ctx)
+
+	state := DeliveryState{Delivery: delivery, Status: StatusOrdered}
+	if state.ExpectedAt.IsZero() {
+		state.ExpectedAt = workflow.Now(ctx).Add(DeliveryDeadline)
+	}
+	err := workflow.SetQueryHandler(ctx, QueryStatus, func() (DeliveryState, error) {
+		return state, nil
+	})
+	if err != nil {
+		return state, err
+	}
+
+	// overdue escalates the delivery once the expected time passed without it arriving
+	overdue := func() {
+		state.Overdue = true
+		alertOps(ctx, fmt.Sprintf("delivery %s of %s from %s for location %q is overdue, it was expected at %s",
+			delivery.Reference, delivery.Item, delivery.Supplier, delivery.Location, state.ExpectedAt.Format(time.RFC3339)))
+	}
+
+	statusCh := workflow.GetSignalChannel(ctx, SignalStatus)
+	for state.Status != StatusDelivered {
+		if !state.Overdue && !workflow.Now(ctx).Before(state.ExpectedAt) {
+			overdue()
+		}
+
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(statusCh, func(c workflow.Channel, more bool) {
+			var status DeliveryStatus
+			if err := signals.Receive(ctx, c, nil, &status); err != nil {
+				logger.Error("Dropped status signal that could not be decoded", zap.Error(err))
+				return
+			}
+			if err := status.Validate(); err != nil {
+				logger.Warn("Dropped invalid delivery status", zap.Error(err))
+				return
+			}
+			updateStatus(ctx, &state, status)
+		})
+		// The timer is started again for every status, the supplier might have moved the expected time
+		if !state.Overdue {
+			selector.AddFuture(workflow.NewTimer(timerCtx, state.ExpectedAt.Sub(workflow.Now(ctx))), func(f workflow.Future) {
+				if f.Get(ctx, nil) == nil {
+					overdue()
+				}
+			})
+		}
+		selector.Select(ctx)
+		cancelTimer()
+	}
+
+	stockCtx := workflow.WithRetryPolicy(ctx, retries.ActivityPolicy())
+	if err := workflow.ExecuteActivity(stockCtx, activityStockIn, delivery.Location, delivery.Reference, delivery.Item, state.Delivered).Get(ctx, nil); err != nil {
+		logger.Error("Failed to stock in the delivery", zap.String("reference", delivery.Reference), zap.Error(err))
+		alertOps(ctx, fmt.Sprintf("delivery %s of %d %s arrived at location %q but stocking it in failed: %v", delivery.Reference, state.Delivered, delivery.Item, delivery.Location, err))
+		return state, err
+	}
+	logger.Info("Stocked in the delivery", zap.String("reference", delivery.Reference), zap.String("item", delivery.Item), zap.Int("quantity", state.Delivered))
+	return state, nil
+}
+
+// updateStatus applies the status the supplier reported, delays are escalated to ops
+func updateStatus(ctx workflow.Context, state *DeliveryState, status DeliveryStatus) {
+	state.History = append(state.History, StatusUpdate{DeliveryStatus: status, At: workflow.Now(ctx)})
+	state.Status = status.Status
+	if !status.ExpectedAt.IsZero() {
+		state.ExpectedAt = status.ExpectedAt
+		state.Overdue = false
+	}
+
+	switch status.Status {
+	case StatusDelayed:
+		state.Delays++
+		reason := fmt.Sprintf("delivery %s of %s from %s for location %q is delayed (delay %d), now expected at %s",
+			state.Reference, state.Item, state.Supplier, state.Location, state.Delays, state.ExpectedAt.Format(time.RFC3339))
+		if status.Note != "" {
+			reason += ": " + status.Note
+		}
+		alertOps(ctx, reason)
+	case StatusDelivered:
+		state.Delivered = status.Quantity
+		if state.Delivered == 0 {
+			state.Delivered = state.Quantity
+		}
+	}
+	workflow.GetLogger(ctx).Info("Delivery status changed", zap.String("reference", state.Reference), zap.String("status", state.Status))
+}
+
+// trackDelivery starts the delivery workflow of a confirmed order, a delivery that is already tracked is left running
+// The delivery is abandoned by the order, so it outlives it
+func trackDelivery(ctx workflow.Context, delivery Delivery) error {
+	deliveryCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:                   DeliveryWorkflowID(delivery.Location, delivery.Reference),
+		ExecutionStartToCloseTimeout: DeliveryTimeout,
+		WorkflowIDReusePolicy:        client.WorkflowIDReusePolicyRejectDuplicate,
+		ParentClosePolicy:            client.ParentClosePolicyAbandon,
+	})
+	err := workflow.ExecuteChildWorkflow(deliveryCtx, WorkflowDelivery, delivery).GetChildWorkflowExecution().Get(ctx, nil)
+	if engine.IsAlreadyStarted(err) {
+		workflow.GetLogger(ctx).Info("Delivery is already tracked", zap.String("reference", delivery.Reference))
+		return nil
+	}
+	return err
+}
+
+// activityStockIn adds the delivered items to the inventory of the location, the reference keeps retries from adding
+// them twice
+func activityStockIn(ctx context.Context, loc string, reference string, item string, quantity int) error {
+	activity.GetLogger(ctx).Info("Stocking in delivery", zap.String("reference", reference), zap.String("item", item), zap.Int("quantity", quantity))
+	return inventory.Database.StockIn(loc, reference, item, quantity)
+}
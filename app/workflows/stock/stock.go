@@ -0,0 +1,162 @@
+// Package stock watches the inventory of every location for items running low
+// The Worker starts the monitor workflow on a cron schedule for every location, each run compares the stock with the
+// thresholds and alerts ops about the items that went below theirs since the last run. Items with a restock quantity
+// are ordered from the supplier as well, see WorkflowSupplierOrder
+package stock
+
+import (
+	"context"
+	"fmt"
+	"programmingpercy/cadence-tavern/engine"
+	"programmingpercy/cadence-tavern/inventory"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/workflows/notify"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence/client"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+// MetricLow is the amount of items below their threshold, tagged with the location
+const MetricLow = "stock_low_items"
+
+var (
+	// thresholds are the counts items alert at once their stock is below them, items without one never alert
+	thresholds = map[string]int{}
+	// restock is how many of an item are ordered from the supplier when it is low, items without one are not ordered
+	restock  = map[string]int{}
+	levelsMu sync.RWMutex
+)
+
+func init() {
+	registry.Workflow(WorkflowStockMonitor)
+
+	registry.Activity("tavern.stock.low", activityLowStock)
+}
+
+// SetThresholds replaces the thresholds and restock quantities while activities might be reading them
+func SetThresholds(t map[string]int, r map[string]int) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	thresholds = t
+	restock = r
+}
+
+// Monitor is the input of the monitor workflow
+type Monitor struct {
+	// Location is the tavern whose stock is checked
+	Location string `json:"location"`
+}
+
+// Level is the stock of an item that is below its threshold
+type Level struct {
+	Item      string `json:"item"`
+	Available int    `json:"available"`
+	Threshold int    `json:"threshold"`
+	// Restock is how many are ordered from the supplier, zero when the item is not ordered
+	Restock int `json:"restock,omitempty"`
+}
+
+// Report is the result of the monitor workflow, the next run of the schedule reads it to not alert twice
+type Report struct {
+	Low []Level `json:"low"`
+	// Ordered are the items a supplier order was started for in this run
+	Ordered []string `json:"ordered,omitempty"`
+}
+
+// has is true when the item was low in the report
+func (r Report) has(item string) bool {
+	for _, level := range r.Low {
+		if level.Item == item {
+			return true
+		}
+	}
+	return false
+}
+
+// WorkflowStockMonitor alerts about the items of the location below their threshold
+// Items that were already low in the last completed run of the schedule were alerted then and are skipped, so an item
+// alerts once each time it dips below its threshold. A run that failed to alert fails, so the next run alerts again
+func WorkflowStockMonitor(ctx workflow.Context, monitor Monitor) (Report, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    10 * time.Second,
+	})
+	logger := workflow.GetLogger(ctx)
+
+	var last Report
+	if workflow.HasLastCompletionResult(ctx) {
+		if err := workflow.GetLastCompletionResult(ctx, &last); err != nil {
+			logger.Warn("Failed to read the last stock report, every low item alerts again", zap.Error(err))
+		}
+	}
+
+	var report Report
+	if err := workflow.ExecuteActivity(ctx, activityLowStock, monitor.Location).Get(ctx, &report.Low); err != nil {
+		return report, err
+	}
+	workflow.GetMetricsScope(ctx).Tagged(map[string]string{"location": monitor.Location}).Gauge(MetricLow).Update(float64(len(report.Low)))
+
+	for _, level := range report.Low {
+		if last.has(level.Item) {
+			continue
+		}
+		logger.Info("Stock is low", zap.String("item", level.Item), zap.Int("available", level.Available), zap.Int("threshold", level.Threshold))
+		err := notify.Ops(ctx, map[string]interface{}{
+			"Reason": fmt.Sprintf("%s is low at location %q, %d left with a threshold of %d", level.Item, monitor.Location, level.Available, level.Threshold),
+		})
+		if err != nil {
+			return report, err
+		}
+		if level.Restock == 0 {
+			continue
+		}
+		ordered, err := orderSupply(ctx, SupplyOrder{Location: monitor.Location, Item: level.Item, Quantity: level.Restock})
+		if err != nil {
+			return report, err
+		}
+		if ordered {
+			report.Ordered = append(report.Ordered, level.Item)
+		}
+	}
+	return report, nil
+}
+
+// orderSupply starts the supplier order of the item, it is false when an order of the item is still running
+// The order is abandoned by the monitor, so it outlives the run of the schedule that started it
+func orderSupply(ctx workflow.Context, order SupplyOrder) (bool, error) {
+	orderCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: location.WorkflowID(order.Location, "supplier-order-"+order.Item),
+		// The order waits for the supplier to confirm the delivery
+		ExecutionStartToCloseTimeout: order.quoteDeadline() + order.confirmDeadline() + time.Hour,
+		WorkflowIDReusePolicy:        client.WorkflowIDReusePolicyAllowDuplicate,
+		ParentClosePolicy:            client.ParentClosePolicyAbandon,
+	})
+	err := workflow.ExecuteChildWorkflow(orderCtx, WorkflowSupplierOrder, order).GetChildWorkflowExecution().Get(ctx, nil)
+	if engine.IsAlreadyStarted(err) {
+		workflow.GetLogger(ctx).Info("Supplier order is already running", zap.String("item", order.Item))
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// activityLowStock returns the items of the location below their threshold, sorted by item
+func activityLowStock(ctx context.Context, loc string) ([]Level, error) {
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+
+	var low []Level
+	for item, available := range inventory.Database.Levels(loc) {
+		threshold, ok := thresholds[item]
+		if !ok || available >= threshold {
+			continue
+		}
+		low = append(low, Level{Item: item, Available: available, Threshold: threshold, Restock: restock[item]})
+	}
+	sort.Slice(low, func(i, j int) bool { return low[i].Item < low[j].Item })
+	return low, nil
+}
@@ -0,0 +1,458 @@
+package stock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/workflows/notify"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/cadence/activity"
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignalConfirm is sent by the supplier once the delivery of the order is confirmed
+	SignalConfirm = "confirm"
+	// SignalVersion is the version of the payloads of the confirm and status signals
+	SignalVersion = 1
+
+	// QuoteDeadline is how long the suppliers have to quote an order, the cheapest quote so far is taken once it passes
+	QuoteDeadline = time.Minute
+	// ConfirmDeadline is how long the chosen supplier has to confirm the delivery before the order is cancelled
+	ConfirmDeadline = 48 * time.Hour
+)
+
+// quotesChangeID marks the runs that order from the cheapest of the suppliers and cancel orders that are never confirmed
+const quotesChangeID = "supplier-quotes"
+
+var (
+	// Suppliers are the suppliers by name that are asked for quotes, the Worker replaces these during startup
+	// Use SetSuppliers to replace them once the Worker is running
+	Suppliers = map[string]SupplierProvider{"dry-run": DryRunSupplier{}}
+
+	suppliersMu sync.RWMutex
+)
+
+func init() {
+	registry.Workflow(WorkflowSupplierOrder)
+	registry.Signal(SignalConfirm, SignalVersion, Confirmation{})
+
+	registry.Activity("tavern.stock.orderSupply", activityOrderSupply)
+	registry.Activity("tavern.stock.suppliers", activitySuppliers)
+	registry.Activity("tavern.stock.quoteSupply", activityQuoteSupply)
+	registry.Activity("tavern.stock.placeSupply", activityPlaceSupply)
+	registry.Activity("tavern.stock.cancelSupply", activityCancelSupply)
+}
+
+// SetSuppliers replaces the Suppliers while activities might be reading them, used when the configuration is reloaded
+func SetSuppliers(s map[string]SupplierProvider) {
+	suppliersMu.Lock()
+	defer suppliersMu.Unlock()
+	Suppliers = s
+}
+
+// SupplyOrder is the input of the supplier order workflow
+type SupplyOrder struct {
+	Location string `json:"location"`
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+	// QuoteDeadline replaces the QuoteDeadline for this order when it is set
+	QuoteDeadline time.Duration `json:"quoteDeadline,omitempty"`
+	// ConfirmDeadline replaces the ConfirmDeadline for this order when it is set
+	ConfirmDeadline time.Duration `json:"confirmDeadline,omitempty"`
+}
+
+// quoteDeadline is how long the suppliers have to quote the order
+func (so SupplyOrder) quoteDeadline() time.Duration {
+	if so.QuoteDeadline > 0 {
+		return so.QuoteDeadline
+	}
+	return QuoteDeadline
+}
+
+// confirmDeadline is how long the supplier has to confirm the delivery of the order
+func (so SupplyOrder) confirmDeadline() time.Duration {
+	if so.ConfirmDeadline > 0 {
+		return so.ConfirmDeadline
+	}
+	return ConfirmDeadline
+}
+
+// SupplyQuote is the price a supplier asks for an order
+type SupplyQuote struct {
+	Supplier string       `json:"supplier"`
+	Price    models.Money `json:"price"`
+}
+
+// Confirmation is the payload of the confirm signal
+type Confirmation struct {
+	// Reference is the reference of the order the supplier answered when it was placed
+	Reference string `json:"reference"`
+	// ExpectedAt is when the supplier expects to deliver, zero when they did not say
+	ExpectedAt time.Time `json:"expectedAt,omitempty"`
+}
+
+// SupplierProvider is the needed methods to order supplies from a supplier
+type SupplierProvider interface {
+	// Quote is the price of the order, in models.Currency
+	Quote(ctx context.Context, order SupplyOrder) (models.Money, error)
+	// Order places the order and returns its reference, the idempotency key keeps retries from ordering twice
+	Order(ctx context.Context, order SupplyOrder, idempotencyKey string) (string, error)
+	// Cancel cancels the order with the reference
+	Cancel(ctx context.Context, reference string) error
+}
+
+// NewSuppliers will create the suppliers written as name=url, such as brewery=https://brewery.example/api
+// Without any suppliers the orders go to a dry run supplier that only logs them
+func NewSuppliers(raw []string) (map[string]SupplierProvider, error) {
+	if len(raw) == 0 {
+		return map[string]SupplierProvider{"dry-run": DryRunSupplier{}}, nil
+	}
+	suppliers := make(map[string]SupplierProvider, len(raw))
+	for _, r := range raw {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("supplier %q should be name=url", r)
+		}
+		suppliers[parts[0]] = &HTTPSupplier{
+			URL:    strings.TrimSuffix(parts[1], "/"),
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return suppliers, nil
+}
+
+// DryRunSupplier accepts every order without ordering anything
+type DryRunSupplier struct {
+	// Price is what every order is quoted, nothing by default
+	Price models.Money
+}
+
+// Quote answers the Price
+func (ds DryRunSupplier) Quote(ctx context.Context, order SupplyOrder) (models.Money, error) {
+	if ds.Price.Currency == "" {
+		return models.Money{Currency: models.Currency}, nil
+	}
+	return ds.Price, nil
+}
+
+// Order logs the order and answers a reference that is never delivered
+func (ds DryRunSupplier) Order(ctx context.Context, order SupplyOrder, idempotencyKey string) (string, error) {
+	activity.GetLogger(ctx).Info("Dry run supplier order", zap.String("item", order.Item), zap.Int("quantity", order.Quantity))
+	return "dry-run-" + idempotencyKey, nil
+}
+
+// Cancel logs the cancellation
+func (ds DryRunSupplier) Cancel(ctx context.Context, reference string) error {
+	activity.GetLogger(ctx).Info("Dry run supplier cancel", zap.String("reference", reference))
+	return nil
+}
+
+// HTTPSupplier talks to the ordering API of a supplier with JSON
+// Quotes are requested with POST /quotes and answered with {"price": {"currency": "USD", "minor": 4800}}, orders are
+// placed with POST /orders and answered with {"reference": "..."} and cancelled with POST /orders/{reference}/cancel
+type HTTPSupplier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Quote will ask the supplier for the price of the order
+func (hs *HTTPSupplier) Quote(ctx context.Context, order SupplyOrder) (models.Money, error) {
+	var response struct {
+		Price models.Money `json:"price"`
+	}
+	err := hs.post(ctx, "/quotes", "", order, &response)
+	return response.Price, err
+}
+
+// Order will place the order at the supplier
+func (hs *HTTPSupplier) Order(ctx context.Context, order SupplyOrder, idempotencyKey string) (string, error) {
+	var response struct {
+		Reference string `json:"reference"`
+	}
+	err := hs.post(ctx, "/orders", idempotencyKey, order, &response)
+	return response.Reference, err
+}
+
+// Cancel will cancel the order at the supplier
+func (hs *HTTPSupplier) Cancel(ctx context.Context, reference string) error {
+	return hs.post(ctx, "/orders/"+url.PathEscape(reference)+"/cancel", "cancel-"+reference, nil, nil)
+}
+
+// post sends the body as JSON to the supplier and decodes the response into out, out can be nil
+func (hs *HTTPSupplier) post(ctx context.Context, path, idempotencyKey string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hs.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := hs.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("supplier request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("supplier responded with %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode supplier response: %v", err)
+	}
+	return nil
+}
+
+// WorkflowSupplierOrder orders the supply of an item from the cheapest supplier, the reference of the order is returned
+// Every supplier is asked for a quote at once and the cheapest answer within the quote deadline is ordered from. The
+// supplier then has until the confirm deadline to signal that the delivery is confirmed, otherwise the order is
+// cancelled again. The stock is not refilled by the order, a confirmed order starts WorkflowDelivery which stocks it in
+// once it arrives
+func WorkflowSupplierOrder(ctx workflow.Context, order SupplyOrder) (string, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    time.Minute,
+	})
+	logger := workflow.GetLogger(ctx)
+
+	// The run ID keeps a later order of the same item apart, retries of this one reuse it
+	key := workflow.GetInfo(ctx).WorkflowExecution.ID + "-" + workflow.GetInfo(ctx).WorkflowExecution.RunID
+
+	// Runs started before there were several suppliers order from the first one without waiting for a confirmation
+	if workflow.GetVersion(ctx, quotesChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		var reference string
+		if err := workflow.ExecuteActivity(ctx, activityOrderSupply, order, key).Get(ctx, &reference); err != nil {
+			logger.Error("Failed to order supply", zap.String("item", order.Item), zap.Error(err))
+			return "", err
+		}
+		alertOps(ctx, fmt.Sprintf("ordered %d of %s for location %q from the supplier, reference %s", order.Quantity, order.Item, order.Location, reference))
+		return reference, nil
+	}
+
+	quote, err := cheapestQuote(ctx, order)
+	if err != nil {
+		logger.Error("Failed to get a quote for supply", zap.String("item", order.Item), zap.Error(err))
+		alertOps(ctx, fmt.Sprintf("could not order %s for location %q: %v", order.Item, order.Location, err))
+		return "", err
+	}
+
+	var reference string
+	if err := workflow.ExecuteActivity(ctx, activityPlaceSupply, quote.Supplier, order, key).Get(ctx, &reference); err != nil {
+		logger.Error("Failed to order supply", zap.String("item", order.Item), zap.String("supplier", quote.Supplier), zap.Error(err))
+		return "", err
+	}
+	alertOps(ctx, fmt.Sprintf("ordered %d of %s for location %q from %s for %s, reference %s", order.Quantity, order.Item, order.Location, quote.Supplier, quote.Price, reference))
+
+	confirmation, confirmed := awaitConfirmation(ctx, reference, order.confirmDeadline())
+	if confirmed {
+		logger.Info("Supplier confirmed the delivery", zap.String("reference", reference), zap.Time("expectedAt", confirmation.ExpectedAt))
+		// Runs started before deliveries were tracked leave counting in the delivery to the staff
+		if workflow.GetVersion(ctx, deliveryChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+			return reference, nil
+		}
+		err := trackDelivery(ctx, Delivery{
+			Location:   order.Location,
+			Item:       order.Item,
+			Quantity:   order.Quantity,
+			Supplier:   quote.Supplier,
+			Reference:  reference,
+			ExpectedAt: confirmation.ExpectedAt,
+		})
+		if err != nil {
+			logger.Error("Failed to start tracking the delivery", zap.String("reference", reference), zap.Error(err))
+		}
+		return reference, err
+	}
+
+	// The order is cancelled even when the workflow itself was cancelled while waiting
+	cancelCtx, _ := workflow.NewDisconnectedContext(ctx)
+	if err := workflow.ExecuteActivity(cancelCtx, activityCancelSupply, quote.Supplier, reference).Get(cancelCtx, nil); err != nil {
+		logger.Error("Failed to cancel the supplier order", zap.String("reference", reference), zap.Error(err))
+		alertOps(cancelCtx, fmt.Sprintf("%s never confirmed order %s and cancelling it failed: %v", quote.Supplier, reference, err))
+		return reference, err
+	}
+	alertOps(cancelCtx, fmt.Sprintf("%s never confirmed order %s of %s, it is cancelled", quote.Supplier, reference, order.Item))
+	return reference, fmt.Errorf("%s did not confirm order %s within %s", quote.Supplier, reference, order.confirmDeadline())
+}
+
+// cheapestQuote asks every supplier for a quote at once and returns the cheapest one answered within the deadline
+// Suppliers that fail to quote or quote in another currency are left out, quotes still running at the deadline are
+// cancelled
+func cheapestQuote(ctx workflow.Context, order SupplyOrder) (SupplyQuote, error) {
+	logger := workflow.GetLogger(ctx)
+
+	var suppliers []string
+	if err := workflow.ExecuteActivity(ctx, activitySuppliers).Get(ctx, &suppliers); err != nil {
+		return SupplyQuote{}, err
+	}
+
+	quoteCtx, cancelQuotes := workflow.WithCancel(ctx)
+	defer cancelQuotes()
+
+	var quotes []SupplyQuote
+	pending := len(suppliers)
+	var expired bool
+	selector := workflow.NewSelector(ctx)
+	for _, name := range suppliers {
+		name := name
+		selector.AddFuture(workflow.ExecuteActivity(quoteCtx, activityQuoteSupply, name, order), func(f workflow.Future) {
+			pending--
+			var quote SupplyQuote
+			if err := f.Get(ctx, &quote); err != nil {
+				logger.Warn("Supplier did not quote", zap.String("supplier", name), zap.Error(err))
+				return
+			}
+			quotes = append(quotes, quote)
+		})
+	}
+	selector.AddFuture(workflow.NewTimer(quoteCtx, order.quoteDeadline()), func(f workflow.Future) {
+		expired = f.Get(ctx, nil) == nil
+	})
+	for pending > 0 && !expired {
+		selector.Select(ctx)
+	}
+
+	if len(quotes) == 0 {
+		return SupplyQuote{}, errors.New("none of the suppliers quoted the order in time")
+	}
+	cheapest := quotes[0]
+	for _, quote := range quotes[1:] {
+		// Equal quotes go to the supplier first by name, so the choice does not depend on who answered first
+		if quote.Price.Minor < cheapest.Price.Minor || (quote.Price.Minor == cheapest.Price.Minor && quote.Supplier < cheapest.Supplier) {
+			cheapest = quote
+		}
+	}
+	logger.Info("Picked the cheapest supplier", zap.String("supplier", cheapest.Supplier), zap.Stringer("price", cheapest.Price), zap.Int("quotes", len(quotes)))
+	return cheapest, nil
+}
+
+// awaitConfirmation waits for the supplier to confirm the order with the reference, it is false once the deadline passes
+// Confirmations of other orders are ignored
+func awaitConfirmation(ctx workflow.Context, reference string, deadline time.Duration) (Confirmation, bool) {
+	logger := workflow.GetLogger(ctx)
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	var confirmation Confirmation
+	var confirmed, expired bool
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalConfirm), func(c workflow.Channel, more bool) {
+		var received Confirmation
+		if err := signals.Receive(ctx, c, nil, &received); err != nil {
+			logger.Error("Dropped confirm signal that could not be decoded", zap.Error(err))
+			return
+		}
+		if received.Reference != reference {
+			logger.Warn("Dropped confirmation of another order", zap.String("reference", received.Reference))
+			return
+		}
+		confirmation = received
+		confirmed = true
+	})
+	selector.AddFuture(workflow.NewTimer(timerCtx, deadline), func(f workflow.Future) {
+		expired = true
+	})
+	for !confirmed && !expired {
+		selector.Select(ctx)
+	}
+	return confirmation, confirmed
+}
+
+// alertOps tells ops about the order or its delivery, a failed alert does not fail the workflow
+func alertOps(ctx workflow.Context, reason string) {
+	if err := notify.Ops(ctx, map[string]interface{}{"Reason": reason}); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to tell ops about the supplier order", zap.Error(err))
+	}
+}
+
+// supplier returns the supplier by name
+func supplier(name string) (SupplierProvider, error) {
+	suppliersMu.RLock()
+	defer suppliersMu.RUnlock()
+	s, ok := Suppliers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown supplier: %s", name)
+	}
+	return s, nil
+}
+
+// activitySuppliers returns the names of the suppliers, sorted by name
+func activitySuppliers(ctx context.Context) ([]string, error) {
+	suppliersMu.RLock()
+	defer suppliersMu.RUnlock()
+	names := make([]string, 0, len(Suppliers))
+	for name := range Suppliers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// activityQuoteSupply asks the supplier for the price of the order, quotes are compared in models.Currency
+func activityQuoteSupply(ctx context.Context, name string, order SupplyOrder) (SupplyQuote, error) {
+	s, err := supplier(name)
+	if err != nil {
+		return SupplyQuote{}, err
+	}
+	price, err := s.Quote(ctx, order)
+	if err != nil {
+		return SupplyQuote{}, err
+	}
+	if err := price.Validate(); err != nil {
+		return SupplyQuote{}, fmt.Errorf("invalid quote: %v", err)
+	}
+	if price.Currency != models.Currency {
+		return SupplyQuote{}, fmt.Errorf("quoted in %s, quotes are compared in %s", price.Currency, models.Currency)
+	}
+	return SupplyQuote{Supplier: name, Price: price}, nil
+}
+
+// activityPlaceSupply places the order with the supplier
+func activityPlaceSupply(ctx context.Context, name string, order SupplyOrder, idempotencyKey string) (string, error) {
+	activity.GetLogger(ctx).Info("Ordering supply", zap.String("supplier", name), zap.String("item", order.Item), zap.Int("quantity", order.Quantity))
+	s, err := supplier(name)
+	if err != nil {
+		return "", err
+	}
+	return s.Order(ctx, order, idempotencyKey)
+}
+
+// activityCancelSupply cancels the order with the supplier
+func activityCancelSupply(ctx context.Context, name string, reference string) error {
+	activity.GetLogger(ctx).Info("Cancelling supply", zap.String("supplier", name), zap.String("reference", reference))
+	s, err := supplier(name)
+	if err != nil {
+		return err
+	}
+	return s.Cancel(ctx, reference)
+}
+
+// activityOrderSupply places the order with the first of the suppliers, it is kept for the runs started before quotes
+func activityOrderSupply(ctx context.Context, order SupplyOrder, idempotencyKey string) (string, error) {
+	names, _ := activitySuppliers(ctx)
+	if len(names) == 0 {
+		return "", errors.New("no suppliers are configured")
+	}
+	return activityPlaceSupply(ctx, names[0], order, idempotencyKey)
+}
@@ -0,0 +1,373 @@
+package tabs
+
+import (
+	"fmt"
+	"programmingpercy/cadence-tavern/location"
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/workflows/history"
+	"programmingpercy/cadence-tavern/workflows/notify"
+	"programmingpercy/cadence-tavern/workflows/pause"
+	"programmingpercy/cadence-tavern/workflows/payments"
+	"programmingpercy/cadence-tavern/workflows/pricing"
+	"programmingpercy/cadence-tavern/workflows/receipts"
+	"programmingpercy/cadence-tavern/workflows/registry"
+	"programmingpercy/cadence-tavern/workflows/seating"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"programmingpercy/cadence-tavern/workflows/sobriety"
+	"time"
+
+	"go.uber.org/cadence/workflow"
+	"go.uber.org/zap"
+)
+
+const (
+	// SignalAdd is the signal used to put an item on the tab
+	SignalAdd = "add"
+	// SignalSettle is the signal used to pay the tab and close it
+	SignalSettle = "settle"
+	// QueryBalance is the query used to look at the current tab
+	QueryBalance = "balance"
+
+	// SignalVersion is the version of the payloads of the add signal
+	SignalVersion = 1
+	// SettleSignalVersion is the version of the payloads of the settle signal
+	// Version 1 had no payload, it settles the total without a tip like the zero Settlement
+	SettleSignalVersion = 2
+)
+
+// exchangeChangeID marks the runs that charge the tab in the currency the customer pays in
+const exchangeChangeID = "tab-exchange"
+
+// splitChangeID marks the runs that charge the tip and every share of a split tab, see planPayments
+const splitChangeID = "tab-split"
+
+// visitChangeID marks the runs that reference their payments with the visit, see paymentReference
+const visitChangeID = "tab-visit-reference"
+
+// Item is something that has been put on the tab, see models.TabItem
+type Item = models.TabItem
+
+// Tab is the bill of a customer, see models.Tab
+type Tab = models.Tab
+
+// Settlement is the payload of the settle signal, see models.Settlement
+type Settlement = models.Settlement
+
+func init() {
+	registry.Workflow(WorkflowTab)
+	registry.Workflow(workflowTabContinued)
+
+	registry.Signal(SignalAdd, SignalVersion, Item{})
+	registry.Signal(SignalSettle, SettleSignalVersion, Settlement{})
+}
+
+// WorkflowID is the workflow ID used for the tab of a customer at a location
+// Each customer only has one open tab at a time in each tavern
+func WorkflowID(loc string, customerName string) string {
+	return location.WorkflowID(loc, "tab-"+customerName)
+}
+
+// WorkflowTab keeps the tab of a customer open until it is settled
+// Items are added with the add signal and the tab is paid with the settle signal
+// loc is the location of the customer, it is needed to look up their contact details
+func WorkflowTab(ctx workflow.Context, loc string, customerName string) (Tab, error) {
+	workflow.GetLogger(ctx).Info("Tab opened", zap.String("customer", customerName))
+	return runTab(ctx, loc, customerName, Tab{Customer: customerName})
+}
+
+// workflowTabContinued is the tab continued as a new run when its history got too long, it starts from the tab so far
+// WorkflowTab can not take the tab as an argument without breaking the runs open when it was added
+func workflowTabContinued(ctx workflow.Context, loc string, customerName string, tab Tab) (Tab, error) {
+	return runTab(ctx, loc, customerName, tab)
+}
+
+// runTab is the loop of the tab, shared by the first and the continued runs
+func runTab(ctx workflow.Context, loc string, customerName string, tab Tab) (Tab, error) {
+	ao := workflow.ActivityOptions{
+		ScheduleToStartTimeout: time.Minute,
+		StartToCloseTimeout:    time.Minute,
+		HeartbeatTimeout:       time.Second * 20,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	logger := workflow.GetLogger(ctx)
+
+	// Tabs carried by runs from before the visit was kept start theirs from the run they continued into
+	if tab.Visit == "" {
+		tab.Visit = workflow.GetInfo(ctx).WorkflowExecution.RunID
+	}
+
+	// Allow the tab to be inspected while it is open
+	err := workflow.SetQueryHandler(ctx, QueryBalance, func() (Tab, error) {
+		return tab, nil
+	})
+	if err != nil {
+		return tab, err
+	}
+
+	// A paused tab still takes items, settling it waits until it is resumed
+	paused, err := pause.Handle(ctx)
+	if err != nil {
+		return tab, err
+	}
+
+	var settle bool
+	// settlement is how the last settle signal asked for the tab to be paid
+	var settlement Settlement
+	// continued is set once the history is too long and no signals are waiting, the tab then continues as a new run
+	var continued bool
+	guard := history.NewGuard(ctx)
+
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalAdd), func(c workflow.Channel, more bool) {
+		var item Item
+		if err := signals.Receive(ctx, c, nil, &item); err != nil {
+			logger.Error("Dropped item that could not be decoded", zap.Error(err))
+			return
+		}
+
+		// The total is kept in minor units, so a long tab adds up to exactly what was ordered
+		total, err := tab.Total.Add(item.Price)
+		if err != nil {
+			logger.Error("Dropped item in another currency than the tab", zap.String("item", item.Item), zap.Error(err))
+			return
+		}
+		tab.Items = append(tab.Items, item)
+		tab.Total = total
+	})
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalSettle), func(c workflow.Channel, more bool) {
+		var requested Settlement
+		if err := signals.Receive(ctx, c, nil, &requested); err != nil {
+			logger.Error("Dropped settle signal that could not be decoded", zap.Error(err))
+			return
+		}
+		if err := requested.Validate(); err != nil {
+			logger.Error("Dropped settle signal that can not be paid", zap.Error(err))
+			return
+		}
+		settlement = requested
+		settle = true
+	})
+
+	for {
+		// A paused tab is not continued, the new run would not know it was paused
+		if guard.Reached(ctx) && !paused.Paused && !continued {
+			selector.AddDefault(func() {
+				continued = true
+			})
+		}
+		selector.Select(ctx)
+		if continued {
+			return tab, workflow.NewContinueAsNewError(ctx, workflowTabContinued, loc, customerName, tab)
+		}
+		if !settle {
+			continue
+		}
+		settle = false
+		if err := paused.Wait(ctx); err != nil {
+			return tab, err
+		}
+
+		if tab.Total.IsZero() {
+			logger.Info("Tab closed without any items", zap.String("customer", customerName))
+			leaveTable(ctx, loc, customerName)
+			return tab, nil
+		}
+
+		// Runs started before tips and splits existed charge the total to the customer of the tab
+		if workflow.GetVersion(ctx, splitChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+			settlement = Settlement{}
+		}
+		// Runs started before the visit was kept reference the payments with the workflow ID of the tab
+		reference := workflow.GetInfo(ctx).WorkflowExecution.ID
+		if workflow.GetVersion(ctx, visitChangeID, workflow.DefaultVersion, 1) != workflow.DefaultVersion {
+			reference = paymentReference(reference, tab)
+		}
+		if err := planPayments(&tab, settlement, reference); err != nil {
+			logger.Error("Dropped settlement that can not be paid", zap.Error(err))
+			continue
+		}
+		if !payShares(ctx, loc, &tab) {
+			// Keep the tab open so the shares that failed can be settled again, the paid shares are not charged twice
+			logger.Error("Failed to settle tab", zap.String("customer", customerName), zap.Int("shares", len(tab.Payments)))
+			continue
+		}
+		if len(tab.Payments) == 1 {
+			tab.Charge = tab.Payments[0].Charge
+			tab.Exchange = tab.Payments[0].Exchange
+		}
+
+		// A missing receipt should not undo the payment, so only log failures
+		tab.ReceiptURL, err = receipts.Generate(ctx, tabReceipt(tab, workflow.GetInfo(ctx).WorkflowExecution))
+		if err != nil {
+			logger.Error("Failed to generate receipt", zap.Error(err))
+		}
+
+		data := map[string]interface{}{
+			"Customer":   customerName,
+			"Total":      tab.Total,
+			"ReceiptURL": tab.ReceiptURL,
+		}
+		if tab.Exchange != nil {
+			data["Paid"] = tab.Exchange.To
+		}
+		err = notify.Customer(ctx, loc, customerName, notify.TemplateTabReceipt, data)
+		if err != nil {
+			logger.Error("Failed to send receipt", zap.Error(err))
+		}
+
+		logger.Info("Tab settled", zap.String("customer", customerName), zap.Stringer("total", tab.Total), zap.Stringer("tip", tab.Tip))
+		leaveTable(ctx, loc, customerName)
+		return tab, nil
+	}
+}
+
+// leaveTable frees the table of the customer and starts their intake over once the tab is closed
+// Failing to do so does not reopen the tab
+func leaveTable(ctx workflow.Context, loc string, customerName string) {
+	if err := seating.Leave(ctx, loc, customerName); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to free table", zap.String("customer", customerName), zap.Error(err))
+	}
+	if err := sobriety.Reset(ctx, loc, customerName); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to reset intake", zap.String("customer", customerName), zap.Error(err))
+	}
+}
+
+// paymentReference identifies the payments of the tab at the payment provider, it is sent as the idempotency key
+// The workflow ID is the same on every visit of the customer, so the visit is part of it
+func paymentReference(workflowID string, tab Tab) string {
+	return workflowID + "-" + tab.Visit
+}
+
+// planPayments shares the total and the tip of the tab between the payers of the settlement
+// Once a share is paid the plan is kept, so settling again only charges the shares left. Items added since are
+// charged to the customer of the tab as one more share. Every share is referenced from the reference of the visit
+func planPayments(tab *Tab, settlement Settlement, reference string) error {
+	var paid bool
+	for _, p := range tab.Payments {
+		paid = paid || p.Paid()
+	}
+	if !paid {
+		due, err := tab.Total.Add(settlement.Tip)
+		if err != nil {
+			return fmt.Errorf("tip: %v", err)
+		}
+		tab.Tip = settlement.Tip
+		tab.Payments = shares(tab.Customer, due, settlement.Split, reference)
+		return nil
+	}
+
+	// The shares left keep their amounts, the total is compared with what was planned when the first share was paid
+	planned, err := tab.Total.Add(tab.Tip)
+	if err != nil {
+		return err
+	}
+	for _, p := range tab.Payments {
+		if planned, err = planned.Sub(p.Amount); err != nil {
+			return err
+		}
+	}
+	if planned.Minor > 0 {
+		tab.Payments = append(tab.Payments, models.TabPayment{
+			Payer:     tab.Customer,
+			Amount:    planned,
+			Reference: fmt.Sprintf("%s-share-%d", reference, len(tab.Payments)+1),
+		})
+	}
+	return nil
+}
+
+// shares splits what is due between the payers, the customer pays all of it when there is no split
+// The only share of a tab that is not split is referenced like the charges made before splits, the shares of a split
+// count up from it, so the shares of every visit are apart too, see paymentReference
+func shares(customerName string, due models.Money, split []models.Share, reference string) []models.TabPayment {
+	if len(split) == 0 {
+		return []models.TabPayment{{Payer: customerName, Amount: due, Reference: reference}}
+	}
+	parts := make([]int, 0, len(split))
+	for _, share := range split {
+		if share.Parts == 0 {
+			share.Parts = 1
+		}
+		parts = append(parts, share.Parts)
+	}
+	amounts := due.Allocate(parts)
+	payments := make([]models.TabPayment, 0, len(split))
+	for i, share := range split {
+		if amounts[i].IsZero() {
+			continue
+		}
+		payments = append(payments, models.TabPayment{
+			Payer:     share.Payer,
+			Amount:    amounts[i],
+			Reference: fmt.Sprintf("%s-share-%d", reference, i+1),
+		})
+	}
+	return payments
+}
+
+// payShares charges every share of the tab that is not paid yet, one payment activity for each share
+// A share that fails keeps the error and the others are still charged, it is true once every share is paid
+func payShares(ctx workflow.Context, loc string, tab *Tab) bool {
+	logger := workflow.GetLogger(ctx)
+	paid := true
+	for i := range tab.Payments {
+		share := &tab.Payments[i]
+		if share.Paid() {
+			continue
+		}
+
+		// Payers in another currency than the menu are charged their share at the current rate
+		amount := share.Amount
+		var err error
+		if workflow.GetVersion(ctx, exchangeChangeID, workflow.DefaultVersion, 1) != workflow.DefaultVersion {
+			amount, share.Exchange, err = pricing.ToPaymentCurrency(ctx, loc, share.Payer, share.Amount)
+			if err != nil {
+				logger.Error("Failed to exchange share into the payment currency", zap.String("payer", share.Payer), zap.Error(err))
+				share.Error = err.Error()
+				paid = false
+				continue
+			}
+		}
+		if share.Exchange != nil {
+			logger.Info("Share exchanged into the payment currency", zap.String("payer", share.Payer),
+				zap.Stringer("from", share.Exchange.From), zap.Stringer("to", share.Exchange.To),
+				zap.Float64("rate", share.Exchange.Rate), zap.String("source", share.Exchange.Source), zap.Time("rateAt", share.Exchange.At))
+		}
+
+		charge, err := payments.ChargeCustomer(ctx, share.Payer, amount, share.Reference)
+		if err != nil {
+			logger.Error("Failed to charge share", zap.String("payer", share.Payer), zap.Stringer("amount", amount), zap.Error(err))
+			share.Error = err.Error()
+			paid = false
+			continue
+		}
+		share.Charge = charge
+		share.Error = ""
+	}
+	return paid
+}
+
+// tabReceipt converts the tab into one receipt for all of its shares, the run ID keeps receipts from several visits apart
+func tabReceipt(t Tab, execution workflow.Execution) receipts.Receipt {
+	lines := make([]receipts.Line, 0, len(t.Items))
+	for _, item := range t.Items {
+		lines = append(lines, receipts.Line{
+			Item:  item.Item,
+			Price: item.Price,
+		})
+	}
+	receipt := receipts.Receipt{
+		ID:       execution.ID + "-" + execution.RunID,
+		Customer: t.Customer,
+		Lines:    lines,
+		Total:    t.Total,
+		Tip:      t.Tip,
+		Exchange: t.Exchange,
+		ChargeID: t.Charge.ID,
+	}
+	if len(t.Payments) > 1 {
+		receipt.Payments = t.Payments
+	}
+	return receipt
+}
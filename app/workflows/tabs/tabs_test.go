@@ -0,0 +1,149 @@
+package tabs
+
+import (
+	"programmingpercy/cadence-tavern/models"
+	"programmingpercy/cadence-tavern/workflows/payments"
+	"programmingpercy/cadence-tavern/workflows/signals"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/cadence/testsuite"
+	"go.uber.org/zap"
+)
+
+// TestBalanceAnswersTheCarriedTab starts a run the way the tab continues as new, from the tab so far
+// The test environment does not report the length of the history, so the guard never asks a tab to continue there
+func TestBalanceAnswersTheCarriedTab(t *testing.T) {
+	payments.Provider = payments.NewMockProvider()
+	var suite testsuite.WorkflowTestSuite
+	suite.SetLogger(zap.NewNop())
+	env := suite.NewTestWorkflowEnvironment()
+	env.OnActivity("tavern.notify.customer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	beer := Item{Item: "Beer", Price: models.NewMoney(models.Currency, 5)}
+	carried := Tab{Customer: "Percy", Items: []Item{beer}, Total: beer.Price}
+	env.RegisterDelayedCallback(func() {
+		item, err := signals.Wrap(SignalVersion, beer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.SignalWorkflow(SignalAdd, item)
+	}, time.Second)
+	env.RegisterDelayedCallback(func() {
+		value, err := env.QueryWorkflow(QueryBalance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tab Tab
+		if err := value.Get(&tab); err != nil {
+			t.Fatal(err)
+		}
+		if len(tab.Items) != 2 || tab.Total != models.NewMoney(models.Currency, 10) {
+			t.Errorf("balance query answered %+v, want the carried Beer and the one added since", tab)
+		}
+
+		settle, err := signals.Wrap(SettleSignalVersion, Settlement{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.SignalWorkflow(SignalSettle, settle)
+	}, 2*time.Second)
+	env.ExecuteWorkflow(workflowTabContinued, "", "Percy", carried)
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tab Tab
+	if err := env.GetWorkflowResult(&tab); err != nil {
+		t.Fatal(err)
+	}
+	if tab.Total != models.NewMoney(models.Currency, 10) || tab.Charge.Amount != tab.Total {
+		t.Fatalf("settled %+v, want the carried and added Beer charged", tab)
+	}
+}
+
+// settleTab settles the carried tab and returns it, the payments are charged with the mock provider
+func settleTab(t *testing.T, carried Tab) Tab {
+	t.Helper()
+	var suite testsuite.WorkflowTestSuite
+	suite.SetLogger(zap.NewNop())
+	env := suite.NewTestWorkflowEnvironment()
+	env.OnActivity("tavern.notify.customer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.RegisterDelayedCallback(func() {
+		settle, err := signals.Wrap(SettleSignalVersion, Settlement{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.SignalWorkflow(SignalSettle, settle)
+	}, time.Second)
+	env.ExecuteWorkflow(workflowTabContinued, "", carried.Customer, carried)
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+	var tab Tab
+	if err := env.GetWorkflowResult(&tab); err != nil {
+		t.Fatal(err)
+	}
+	if len(tab.Payments) != 1 || !tab.Payments[0].Paid() {
+		t.Fatalf("settled %+v, want the tab paid in one share", tab)
+	}
+	return tab
+}
+
+// TestVisitsAreChargedApart settles the tabs of two visits of the same customer, they share the workflow ID
+// The test environment runs every workflow with the same run ID, so the visits are carried in as the runs they
+// were opened in
+func TestVisitsAreChargedApart(t *testing.T) {
+	payments.Provider = payments.NewMockProvider()
+	beer := Item{Item: "Beer", Price: models.NewMoney(models.Currency, 5)}
+
+	first := settleTab(t, Tab{Customer: "Percy", Items: []Item{beer}, Total: beer.Price, Visit: "run-1"})
+	second := settleTab(t, Tab{Customer: "Percy", Items: []Item{beer}, Total: beer.Price, Visit: "run-2"})
+	if first.Payments[0].Reference == second.Payments[0].Reference {
+		t.Fatalf("both visits were charged with reference %s, the provider would return the first charge", first.Payments[0].Reference)
+	}
+
+	// A tab opened without a visit takes the run it is opened in
+	opened := settleTab(t, Tab{Customer: "Percy", Items: []Item{beer}, Total: beer.Price})
+	if opened.Visit == "" || !strings.HasSuffix(opened.Payments[0].Reference, "-"+opened.Visit) {
+		t.Fatalf("tab of visit %q was charged with reference %s, want it referenced with its run", opened.Visit, opened.Payments[0].Reference)
+	}
+}
+
+// TestSplitVisitsAreChargedApart plans the split tabs of two visits, every share is referenced from its visit
+func TestSplitVisitsAreChargedApart(t *testing.T) {
+	beer := Item{Item: "Beer", Price: models.NewMoney(models.Currency, 6)}
+	split := Settlement{Split: []models.Share{{Payer: "Percy"}, {Payer: "Rachel", Parts: 2}}}
+	workflowID := WorkflowID("", "Percy")
+
+	references := map[string]string{}
+	for _, visit := range []string{"run-1", "run-2"} {
+		tab := Tab{Customer: "Percy", Items: []Item{beer}, Total: beer.Price, Visit: visit}
+		if err := planPayments(&tab, split, paymentReference(workflowID, tab)); err != nil {
+			t.Fatal(err)
+		}
+		// The first share is paid, the Beer added since is charged to the customer as one more share
+		tab.Payments[0].Charge = models.Charge{ID: "ch_" + visit}
+		tab.Items = append(tab.Items, beer)
+		total, err := tab.Total.Add(beer.Price)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tab.Total = total
+		if err := planPayments(&tab, split, paymentReference(workflowID, tab)); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(tab.Payments) != 3 {
+			t.Fatalf("visit %s planned %+v, want the two shares and the Beer added since", visit, tab.Payments)
+		}
+		for _, p := range tab.Payments {
+			if other, ok := references[p.Reference]; ok {
+				t.Errorf("share of visit %s was referenced %s like a share of visit %s", visit, p.Reference, other)
+			}
+			references[p.Reference] = visit
+		}
+	}
+}